@@ -0,0 +1,215 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/service"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// Parses the limit and offset query parameters, applying the default and maximum page sizes.
+func parsePagination(c *gin.Context) (int, int, bool) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit <= 0 {
+		respondError(c, http.StatusBadRequest, "invalid limit")
+		return 0, 0, false
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		respondError(c, http.StatusBadRequest, "invalid offset")
+		return 0, 0, false
+	}
+	return limit, offset, true
+}
+
+// Records an administrative action on the subject user, if any, in the audit log.
+func (s *Server) auditAdminAction(c *gin.Context, action string, subject *types.User, details map[string]interface{}) {
+	event := audit.Event{
+		Action:  action,
+		Details: details,
+	}
+	if subject != nil {
+		event.SubjectID = subject.ID
+		event.Target = service.UserTarget(subject)
+	}
+	s.recordEvent(c, event)
+}
+
+func (s *Server) handleAdminListUsers(c *gin.Context) {
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	filter := storage.UserFilter{
+		Query:  c.Query("q"),
+		Role:   c.Query("role"),
+		Offset: offset,
+		Limit:  limit,
+	}
+
+	// Optionally filter by the disabled state.
+	if disabledParam, ok := c.GetQuery("disabled"); ok {
+		disabled, err := strconv.ParseBool(disabledParam)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "invalid disabled filter")
+			return
+		}
+		filter.Disabled = &disabled
+	}
+
+	users, total, err := s.storage(c).ListUsers(filter)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to list users", err))
+		return
+	}
+
+	s.auditAdminAction(c, "admin.users.list", nil, map[string]interface{}{"query": filter.Query, "role": filter.Role})
+
+	results := make([]types.User, 0, len(*users))
+	for _, user := range *users {
+		results = append(results, service.WithoutSecrets(user))
+	}
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, gin.H{"users": results, "total": total, "limit": limit, "offset": offset})
+}
+
+func (s *Server) handleAdminGetUser(c *gin.Context) {
+
+	// Get the authorized user from the context.
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	s.auditAdminAction(c, "admin.user.view", fetchedUser, nil)
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, service.WithoutSecrets(*fetchedUser))
+}
+
+func (s *Server) handleAdminDisableUser(c *gin.Context) {
+	s.setUserDisabled(c, true)
+}
+
+func (s *Server) handleAdminEnableUser(c *gin.Context) {
+	s.setUserDisabled(c, false)
+}
+
+func (s *Server) setUserDisabled(c *gin.Context, disabled bool) {
+	currentUser := c.MustGet("currentUser").(*types.User)
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	// Administrators cannot lock themselves out.
+	if disabled && fetchedUser.ID == currentUser.ID {
+		respondError(c, http.StatusBadRequest, "you cannot disable your own account")
+		return
+	}
+
+	fetchedUser.Disabled = disabled
+	fetchedUser.UpdatedAt = time.Now()
+
+	updatedUser, err := s.storage(c).UpdateUser(fetchedUser)
+	if err != nil {
+		respondStorageError(c, err, "failed to update user")
+		return
+	}
+
+	action := "admin.user.enable"
+	if disabled {
+		action = "admin.user.disable"
+	}
+	s.auditAdminAction(c, action, updatedUser, nil)
+	s.PublishUser(c.Request.Context(), events.UserUpdated, updatedUser)
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, service.WithoutSecrets(*updatedUser))
+}
+
+func (s *Server) handleAdminForcePasswordReset(c *gin.Context) {
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	fetchedUser.PasswordResetRequired = true
+	fetchedUser.UpdatedAt = time.Now()
+
+	updatedUser, err := s.storage(c).UpdateUser(fetchedUser)
+	if err != nil {
+		respondStorageError(c, err, "failed to update user")
+		return
+	}
+
+	s.auditAdminAction(c, "admin.user.force_password_reset", updatedUser, nil)
+	s.PublishUser(c.Request.Context(), events.UserUpdated, updatedUser)
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, service.WithoutSecrets(*updatedUser))
+}
+
+func (s *Server) handleAdminSetUserRole(c *gin.Context) {
+	currentUser := c.MustGet("currentUser").(*types.User)
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	var requestBody struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&requestBody); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	if !rbac.IsRole(requestBody.Role) {
+		respondError(c, http.StatusBadRequest, "unknown role")
+		return
+	}
+
+	// Administrators cannot demote themselves.
+	if fetchedUser.ID == currentUser.ID && requestBody.Role != currentUser.Role {
+		respondError(c, http.StatusBadRequest, "you cannot change your own role")
+		return
+	}
+
+	previousRole := fetchedUser.Role
+	fetchedUser.Role = requestBody.Role
+	fetchedUser.UpdatedAt = time.Now()
+
+	updatedUser, err := s.storage(c).UpdateUser(fetchedUser)
+	if err != nil {
+		respondStorageError(c, err, "failed to update user")
+		return
+	}
+
+	s.auditAdminAction(c, "admin.user.set_role", updatedUser, map[string]interface{}{
+		"from": previousRole,
+		"to":   updatedUser.Role,
+	})
+	s.PublishUser(c.Request.Context(), events.UserUpdated, updatedUser)
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, service.WithoutSecrets(*updatedUser))
+}
+
+func (s *Server) handleAdminGetStats(c *gin.Context) {
+	stats, err := s.storage(c).GetStats()
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to fetch stats", err))
+		return
+	}
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, stats)
+}
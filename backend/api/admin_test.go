@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminHandlers(t *testing.T) {
+	server, store := newMemoryServer(t)
+	auditor := server.Auditor.(*recordingAuditor)
+
+	admin := createTestUser(t, store, "root", rbac.RoleAdmin)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	createTestUser(t, store, "fuzz", rbac.RoleUser)
+
+	_, err := store.CreateBook(&types.Book{Title: "foo", Author: "bar", PagesCount: 10, PagesRead: 10, OwnerID: foo.ID})
+	assert.NoError(t, err)
+
+	adminToken := accessTokenFor(t, store, admin)
+	fooToken := accessTokenFor(t, store, foo)
+
+	t.Run("TestListUsers", func(t *testing.T) {
+		w := doRequest(server, "GET", "/admin/users?q=FU&limit=10", nil, adminToken)
+		assert.Equal(t, 200, w.Code)
+
+		var response struct {
+			Users []types.User `json:"users"`
+			Total int64        `json:"total"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, int64(1), response.Total)
+		assert.Equal(t, "fuzz", response.Users[0].Username)
+		assert.Empty(t, response.Users[0].Password)
+
+		w = doRequest(server, "GET", "/admin/users?limit=invalid", nil, adminToken)
+		assert.Equal(t, 400, w.Code)
+
+		// Regular users cannot use the admin API.
+		w = doRequest(server, "GET", "/admin/users", nil, fooToken)
+		assert.Equal(t, 403, w.Code)
+	})
+
+	t.Run("TestDisableAndEnableUser", func(t *testing.T) {
+		w := doRequest(server, "POST", fmt.Sprintf("/admin/users/%d/disable", foo.ID), nil, adminToken)
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, auditor.actions(), "admin.user.disable")
+
+		// Disabled users are rejected.
+		w = doRequest(server, "GET", "/books/", nil, fooToken)
+		assert.Equal(t, 403, w.Code)
+
+		w = doRequest(server, "POST", fmt.Sprintf("/admin/users/%d/enable", foo.ID), nil, adminToken)
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, auditor.actions(), "admin.user.enable")
+
+		w = doRequest(server, "GET", "/books/", nil, fooToken)
+		assert.Equal(t, 200, w.Code)
+
+		// Admins cannot disable themselves.
+		w = doRequest(server, "POST", fmt.Sprintf("/admin/users/%d/disable", admin.ID), nil, adminToken)
+		assert.Equal(t, 400, w.Code)
+	})
+
+	t.Run("TestForcePasswordReset", func(t *testing.T) {
+		w := doRequest(server, "POST", fmt.Sprintf("/admin/users/%d/password-reset", foo.ID), nil, adminToken)
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, auditor.actions(), "admin.user.force_password_reset")
+
+		// Until the password is changed, nothing else is allowed.
+		w = doRequest(server, "GET", "/books/", nil, fooToken)
+		assert.Equal(t, 403, w.Code)
+
+		w = doRequest(server, "PATCH", fmt.Sprintf("/users/%d", foo.ID), map[string]interface{}{"password": "new-Orbit-57-lantern"}, fooToken)
+		assert.Equal(t, 200, w.Code)
+
+		w = doRequest(server, "GET", "/books/", nil, fooToken)
+		assert.Equal(t, 200, w.Code)
+
+		// The reset can be completed through the versioned routes too.
+		w = doRequest(server, "POST", fmt.Sprintf("/v1/admin/users/%d/password-reset", foo.ID), nil, adminToken)
+		assert.Equal(t, 200, w.Code)
+
+		w = doRequest(server, "GET", "/v1/books/", nil, fooToken)
+		assert.Equal(t, 403, w.Code)
+
+		w = doRequest(server, "PATCH", fmt.Sprintf("/v1/users/%d", foo.ID), map[string]interface{}{"password": "quartz-Meadow-83-violin"}, fooToken)
+		assert.Equal(t, 200, w.Code)
+
+		w = doRequest(server, "GET", "/v1/books/", nil, fooToken)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	t.Run("TestSetUserRole", func(t *testing.T) {
+		w := doRequest(server, "PUT", fmt.Sprintf("/admin/users/%d/role", foo.ID), map[string]string{"role": "superuser"}, adminToken)
+		assert.Equal(t, 400, w.Code)
+
+		w = doRequest(server, "PUT", fmt.Sprintf("/admin/users/%d/role", admin.ID), map[string]string{"role": rbac.RoleUser}, adminToken)
+		assert.Equal(t, 400, w.Code)
+
+		w = doRequest(server, "PUT", fmt.Sprintf("/admin/users/%d/role", foo.ID), map[string]string{"role": rbac.RoleAdmin}, adminToken)
+		assert.Equal(t, 200, w.Code)
+		assert.Contains(t, auditor.actions(), "admin.user.set_role")
+
+		// The promoted user can now use the admin API.
+		w = doRequest(server, "GET", "/admin/users", nil, fooToken)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	t.Run("TestGetStats", func(t *testing.T) {
+		w := doRequest(server, "GET", "/admin/stats", nil, adminToken)
+		assert.Equal(t, 200, w.Code)
+
+		var stats types.Stats
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+		assert.Equal(t, int64(3), stats.Users)
+		assert.Equal(t, int64(2), stats.AdminUsers)
+		assert.Equal(t, int64(1), stats.Books)
+		assert.Equal(t, int64(1), stats.FinishedBooks)
+	})
+}
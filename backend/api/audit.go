@@ -0,0 +1,113 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/service"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+)
+
+// Returns the current user, if any, and the details of the request, who the service performs operations for.
+func actorOf(c *gin.Context) service.Actor {
+	actor := service.Actor{
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		RequestID: c.GetString("requestID"),
+	}
+	if currentUser, ok := c.Get("currentUser"); ok {
+		actor.User, _ = currentUser.(*types.User)
+	}
+	return actor
+}
+
+// Records an audit event, filling in the actor and request details from the context.
+// Audit logging never fails the request.
+func (s *Server) recordEvent(c *gin.Context, event audit.Event) {
+	s.Record(actorOf(c), event)
+}
+
+// Parses the audit log filters shared by the activity and audit log queries.
+func parseAuditFilter(c *gin.Context) (audit.Filter, bool) {
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return audit.Filter{}, false
+	}
+
+	filter := audit.Filter{
+		Action: c.Query("action"),
+		Target: c.Query("target"),
+		Offset: offset,
+		Limit:  limit,
+	}
+
+	for param, dest := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := c.Query(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, "invalid "+param+", expected an RFC 3339 time")
+				return audit.Filter{}, false
+			}
+			*dest = parsed
+		}
+	}
+
+	for param, dest := range map[string]*int{"user_id": &filter.UserID, "actor_id": &filter.ActorID, "subject_id": &filter.SubjectID} {
+		if value := c.Query(param); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, "invalid "+param)
+				return audit.Filter{}, false
+			}
+			*dest = parsed
+		}
+	}
+	return filter, true
+}
+
+func (s *Server) handleGetActivity(c *gin.Context) {
+
+	// Get the authorized user from the context.
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	filter, ok := parseAuditFilter(c)
+	if !ok {
+		return
+	}
+
+	// Only the events performed by or concerning the user.
+	filter.UserID = fetchedUser.ID
+	filter.ActorID = 0
+	filter.SubjectID = 0
+
+	events, total, err := s.AuditLog.Query(filter)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to fetch activity", err))
+		return
+	}
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, gin.H{"events": events, "total": total})
+}
+
+func (s *Server) handleAdminQueryAuditLog(c *gin.Context) {
+	filter, ok := parseAuditFilter(c)
+	if !ok {
+		return
+	}
+
+	events, total, err := s.AuditLog.Query(filter)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to query audit log", err))
+		return
+	}
+
+	s.auditAdminAction(c, "admin.audit.query", nil, map[string]interface{}{"query": c.Request.URL.RawQuery})
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, gin.H{"events": events, "total": total})
+}
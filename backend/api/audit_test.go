@@ -0,0 +1,104 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type auditResponse struct {
+	Events []audit.Event `json:"events"`
+	Total  int64         `json:"total"`
+}
+
+func TestAuditLog(t *testing.T) {
+	server, store := newMemoryServer(t)
+
+	// Store the events like the server does by default.
+	logger := audit.NewAsyncLogger(server.AuditLog, audit.NewSlogLogger(slog.New(slog.NewTextHandler(io.Discard, nil))), 64)
+	defer logger.Close()
+	server.Auditor = logger
+
+	admin := createTestUser(t, store, "root", rbac.RoleAdmin)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fuzz := createTestUser(t, store, "fuzz", rbac.RoleUser)
+	adminToken := accessTokenFor(t, store, admin)
+	fooToken := accessTokenFor(t, store, foo)
+	fuzzToken := accessTokenFor(t, store, fuzz)
+
+	// Create and update a book, tagging the update with a request id.
+	w := doRequest(server, "POST", "/books/", map[string]interface{}{"title": "foo", "author": "bar", "pages_count": 10, "pages_read": 1}, fooToken)
+	assert.Equal(t, 201, w.Code)
+
+	var book types.Book
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &book))
+
+	req := httptest.NewRequest("PATCH", fmt.Sprintf("/books/%d", book.ID), bytes.NewBufferString(`{"title": "foo", "author": "bar", "pages_count": 10, "pages_read": 5}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+fooToken)
+	req.Header.Set("X-Request-ID", "request-1")
+	w = httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "request-1", w.Header().Get("X-Request-ID"))
+
+	w = doRequest(server, "PATCH", fmt.Sprintf("/users/%d", foo.ID), map[string]interface{}{"password": "quartz-Meadow-83-violin"}, fooToken)
+	assert.Equal(t, 200, w.Code)
+
+	logger.Sync()
+
+	t.Run("TestGetActivity", func(t *testing.T) {
+		w := doRequest(server, "GET", fmt.Sprintf("/users/%d/activity", foo.ID), nil, fooToken)
+		assert.Equal(t, 200, w.Code)
+
+		var response auditResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, int64(4), response.Total)
+
+		actions := []string{}
+		for _, event := range response.Events {
+			actions = append(actions, event.Action)
+		}
+		assert.Equal(t, []string{"user.password_change", "user.update", "book.update", "book.create"}, actions)
+
+		// The update records the changed fields along with the request details.
+		bookUpdate := response.Events[2]
+		assert.Equal(t, foo.ID, bookUpdate.ActorID)
+		assert.Equal(t, "request-1", bookUpdate.RequestID)
+		assert.Equal(t, map[string]interface{}{"from": float64(1), "to": float64(5)}, bookUpdate.Details["pages_read"])
+		assert.NotContains(t, bookUpdate.Details, "title")
+
+		// Password values never reach the audit log.
+		assert.Equal(t, map[string]interface{}{"from": audit.Redacted, "to": audit.Redacted}, response.Events[1].Details["password"])
+
+		// Users cannot see each other's activity.
+		w = doRequest(server, "GET", fmt.Sprintf("/users/%d/activity", foo.ID), nil, fuzzToken)
+		assert.Equal(t, 401, w.Code)
+	})
+
+	t.Run("TestAdminQueryAuditLog", func(t *testing.T) {
+		w := doRequest(server, "GET", fmt.Sprintf("/admin/audit?actor_id=%d&action=book.create", foo.ID), nil, adminToken)
+		assert.Equal(t, 200, w.Code)
+
+		var response auditResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, int64(1), response.Total)
+		assert.Equal(t, fmt.Sprintf("book:%d", book.ID), response.Events[0].Target)
+
+		w = doRequest(server, "GET", "/admin/audit?since=yesterday", nil, adminToken)
+		assert.Equal(t, 400, w.Code)
+
+		// Regular users cannot query the audit log.
+		w = doRequest(server, "GET", "/admin/audit", nil, fooToken)
+		assert.Equal(t, 403, w.Code)
+	})
+}
@@ -18,12 +18,22 @@ func NewAuth(secretKey string) *Auth {
 	return &Auth{secretKey: []byte(secretKey)}
 }
 
-// Creates a JWT access token using an authenticated user id, returns the encoded access token.
-func (a *Auth) GenerateAccessToken(userID int) (string, error) {
+// How long an access token, and the login session it belongs to, remains valid.
+const AccessTokenLifetime = time.Minute * 45
+
+// AccessTokenClaims are the claims carried by a validated access token.
+type AccessTokenClaims struct {
+	UserID    int
+	SessionID string
+}
+
+// Creates a JWT access token using an authenticated user id and the id of their login session, returns the encoded access token.
+func (a *Auth) GenerateAccessToken(userID int, sessionID string) (string, error) {
 
 	claims := jwt.MapClaims{
 		"user_id": strconv.Itoa(userID),
-		"exp":     time.Now().Add(time.Minute * 45).Unix(),
+		"sid":     sessionID,
+		"exp":     time.Now().Add(AccessTokenLifetime).Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -37,40 +47,50 @@ func (a *Auth) GenerateAccessToken(userID int) (string, error) {
 }
 
 func (a *Auth) ValidateAccessToken(tokenString string) (int, error) {
+	claims, err := a.ParseAccessToken(tokenString)
+	if err != nil {
+		return 0, err
+	}
+	return claims.UserID, nil
+}
+
+// Validates the access token and extracts the user and session ids from it.
+func (a *Auth) ParseAccessToken(tokenString string) (*AccessTokenClaims, error) {
 	// Parse the token
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		// Validate the signing method
 		if token.Method != jwt.SigningMethodHS256 {
-			fmt.Println("error is HERE at signing method")
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return a.secretKey, nil
 	})
 
 	if err != nil {
-		fmt.Println("error parsing token")
-		return 0, err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return 0, fmt.Errorf("invalid token")
+		return nil, fmt.Errorf("invalid token")
 	}
 
 	// Extract the user id claim from the token
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return 0, fmt.Errorf("invalid token claims")
+		return nil, fmt.Errorf("invalid token claims")
 	}
 	userID, ok := claims["user_id"].(string)
 
 	if !ok {
-		return 0, fmt.Errorf("invalid user id claim")
+		return nil, fmt.Errorf("invalid user id claim")
 	}
 
 	id, err := strconv.Atoi(userID)
 	if err != nil {
-		return 0, fmt.Errorf("invalid token claims")
+		return nil, fmt.Errorf("invalid token claims")
 	}
 
-	return id, nil
+	// Tokens issued before sessions were tracked carry no session id and are rejected by the caller.
+	sessionID, _ := claims["sid"].(string)
+
+	return &AccessTokenClaims{UserID: id, SessionID: sessionID}, nil
 }
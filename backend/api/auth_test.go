@@ -10,14 +10,20 @@ func TestAuthFunctions(t *testing.T) {
 	auth := NewAuth("mock-secret-key")
 
 	userID := 1
+	sessionID := "mock-session-id"
 
-	accessToken, err := auth.GenerateAccessToken(userID)
+	accessToken, err := auth.GenerateAccessToken(userID, sessionID)
 	assert.NoError(t, err, "expected no error generating access token, got %v.", err)
 
 	validatedID, err := auth.ValidateAccessToken(accessToken)
 	assert.NoError(t, err, "expected no error validating mock access token, got: %v.", err)
 	assert.Equal(t, userID, validatedID)
 
+	claims, err := auth.ParseAccessToken(accessToken)
+	assert.NoError(t, err, "expected no error parsing mock access token, got: %v.", err)
+	assert.Equal(t, userID, claims.UserID)
+	assert.Equal(t, sessionID, claims.SessionID)
+
 	invalidAccessToken := "invalid-access-token"
 	invalidUserID, err := auth.ValidateAccessToken(invalidAccessToken)
 
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/service"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware to require that the current user's role grants the permission.
+func (s *Server) RequirePermission(permission rbac.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get the authenticated user from the context.
+		currentUser, _ := c.MustGet("currentUser").(*types.User)
+
+		if currentUser == nil {
+			respondError(c, http.StatusUnauthorized, "unauthorized")
+			c.Abort()
+			return
+		}
+
+		if !rbac.Can(currentUser.Role, permission) {
+			respondError(c, http.StatusForbidden, "you do not have permission to perform this action")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Middleware to load the user identified by the id path parameter and check that the current user may act on it.
+// Users may always act on themselves; acting on anyone else requires the permission.
+// The loaded user is set in the context as "targetUser".
+func (s *Server) AuthorizeUser(action string, permission rbac.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get the authenticated user from the context.
+		currentUser, _ := c.MustGet("currentUser").(*types.User)
+
+		if currentUser == nil {
+			respondError(c, http.StatusUnauthorized, "unauthorized")
+			c.Abort()
+			return
+		}
+
+		// Extract the id param from the URL request path.
+		userID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "invalid user id")
+			c.Abort()
+			return
+		}
+
+		// Fetch the user and check that the client is authorized to act on them.
+		fetchedUser, problemErr := s.Service.AuthorizeUser(c.Request.Context(), actorOf(c), userID, action, permission)
+		if problemErr != nil {
+			respondProblem(c, problemErr)
+			return
+		}
+
+		// Add the user to the context.
+		c.Set("targetUser", fetchedUser)
+
+		c.Next()
+	}
+}
+
+// Middleware to load the book identified by the id path parameter and check that the current user may act on it.
+// Owners may always act on their books; acting on anyone else's requires the permission.
+// The loaded book is set in the context as "targetBook".
+func (s *Server) AuthorizeBook(action string, permission rbac.Permission) gin.HandlerFunc {
+	return s.authorizeBookParam(storage.Storage.GetBook, action, permission)
+}
+
+// Middleware like AuthorizeBook for books in the trash.
+func (s *Server) AuthorizeDeletedBook(action string, permission rbac.Permission) gin.HandlerFunc {
+	return s.authorizeBookParam(storage.Storage.GetDeletedBook, action, permission)
+}
+
+// Returns the middleware loading the book identified by the id path parameter with the getter and authorizing the current user.
+func (s *Server) authorizeBookParam(get service.BookGetter, action string, permission rbac.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Get the authenticated user from the context.
+		currentUser, _ := c.MustGet("currentUser").(*types.User)
+
+		if currentUser == nil {
+			respondError(c, http.StatusUnauthorized, "unauthorized")
+			c.Abort()
+			return
+		}
+
+		// Extract the id param from the URL request path.
+		bookID, err := strconv.Atoi(c.Param("id"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, "invalid book id")
+			c.Abort()
+			return
+		}
+
+		fetchedBook, problemErr := service.AuthorizeBook(s.storage(c), get, currentUser, bookID, action, permission)
+		if problemErr != nil {
+			respondProblem(c, problemErr)
+			return
+		}
+
+		// Add the book to the context.
+		c.Set("targetBook", fetchedBook)
+
+		c.Next()
+	}
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/passwords"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Constructs a server backed by in-memory storage with its routes registered.
+// Secret the test servers sign access tokens with.
+const testSecretKey = "test-secret-key-that-is-long-enough"
+
+func newMemoryServer(t *testing.T) (*Server, *storage.MemoryStorage) {
+	store := storage.NewMemoryStorage()
+	server := NewServer(":8080", store)
+	server.AccessTokenSecretKey = testSecretKey
+	server.Auditor = &recordingAuditor{}
+	server.PasswordHasher = passwords.Hasher{Cost: bcrypt.MinCost}
+	server.RegisterRoutes()
+	return server, store
+}
+
+// Stores a user with a cheaply hashed password equal to the username.
+func createTestUser(t *testing.T, store storage.Storage, username string, role string) *types.User {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(username), bcrypt.MinCost)
+	assert.NoError(t, err)
+
+	user, err := store.CreateUser(&types.User{
+		Username: username,
+		Email:    username + "@bar.com",
+		Password: string(hashedPassword),
+		Role:     role,
+	})
+	assert.NoError(t, err, "expected no error creating test user, got: %v.", err)
+	return user
+}
+
+// Starts a session for the user and returns a valid access token for it.
+func accessTokenFor(t *testing.T, store storage.Storage, user *types.User) string {
+	sessionID, err := newSessionID()
+	assert.NoError(t, err)
+
+	now := time.Now()
+	_, err = store.CreateSession(&types.Session{
+		ID:         sessionID,
+		UserID:     user.ID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(AccessTokenLifetime),
+	})
+	assert.NoError(t, err)
+
+	accessToken, err := NewAuth(testSecretKey).GenerateAccessToken(user.ID, sessionID)
+	assert.NoError(t, err)
+	return accessToken
+}
+
+// Sends a request through the server's router, encoding body as JSON if it is not nil.
+func doRequest(server *Server, method string, path string, body interface{}, accessToken string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		encoded, _ := json.Marshal(body)
+		reader = bytes.NewBuffer(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthorizationMiddlewares(t *testing.T) {
+	server, store := newMemoryServer(t)
+
+	owner := createTestUser(t, store, "foo", rbac.RoleUser)
+	other := createTestUser(t, store, "fuzz", rbac.RoleUser)
+	admin := createTestUser(t, store, "root", rbac.RoleAdmin)
+
+	book, err := store.CreateBook(&types.Book{Title: "foo", Author: "bar", PagesCount: 10, OwnerID: owner.ID})
+	assert.NoError(t, err)
+
+	ownerToken := accessTokenFor(t, store, owner)
+	otherToken := accessTokenFor(t, store, other)
+	adminToken := accessTokenFor(t, store, admin)
+
+	t.Run("TestAuthorizeUser", func(t *testing.T) {
+		// Users can view themselves.
+		w := doRequest(server, "GET", fmt.Sprintf("/users/%d", owner.ID), nil, ownerToken)
+		assert.Equal(t, 200, w.Code)
+
+		// Users cannot view other users.
+		w = doRequest(server, "GET", fmt.Sprintf("/users/%d", owner.ID), nil, otherToken)
+		assert.Equal(t, 401, w.Code)
+
+		// Admins can view any user.
+		w = doRequest(server, "GET", fmt.Sprintf("/users/%d", owner.ID), nil, adminToken)
+		assert.Equal(t, 200, w.Code)
+
+		// Invalid and unknown ids are reported before authorization.
+		w = doRequest(server, "GET", "/users/invalid", nil, otherToken)
+		assert.Equal(t, 400, w.Code)
+		w = doRequest(server, "GET", "/users/1000", nil, otherToken)
+		assert.Equal(t, 404, w.Code)
+	})
+
+	t.Run("TestAuthorizeBook", func(t *testing.T) {
+		w := doRequest(server, "GET", fmt.Sprintf("/books/%d", book.ID), nil, ownerToken)
+		assert.Equal(t, 200, w.Code)
+
+		w = doRequest(server, "GET", fmt.Sprintf("/books/%d", book.ID), nil, otherToken)
+		assert.Equal(t, 401, w.Code)
+
+		w = doRequest(server, "DELETE", fmt.Sprintf("/books/%d", book.ID), nil, otherToken)
+		assert.Equal(t, 401, w.Code)
+
+		w = doRequest(server, "GET", fmt.Sprintf("/books/%d", book.ID), nil, adminToken)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	t.Run("TestRequirePermission", func(t *testing.T) {
+		w := doRequest(server, "GET", "/admin/stats", nil, ownerToken)
+		assert.Equal(t, 403, w.Code)
+
+		w = doRequest(server, "GET", "/admin/stats", nil, adminToken)
+		assert.Equal(t, 200, w.Code)
+	})
+
+	t.Run("TestUsersCannotElevateThemselves", func(t *testing.T) {
+		w := doRequest(server, "PATCH", fmt.Sprintf("/users/%d", other.ID), map[string]interface{}{
+			"username": "fuzzier",
+			"role":     rbac.RoleAdmin,
+		}, otherToken)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, []problem.FieldError{{Field: "role", Code: "read_only", Message: "can't be changed"}}, decodeProblem(t, w).Errors)
+
+		// Nothing is changed, not even the fields that may be.
+		updatedUser, err := store.GetUser(other.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "fuzz", updatedUser.Username)
+		assert.Equal(t, rbac.RoleUser, updatedUser.Role)
+	})
+}
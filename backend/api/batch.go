@@ -0,0 +1,211 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/declanl482/go-book-tracker-app/backend/patch"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/service"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// BatchRequest is a list of book operations applied in one request.
+type BatchRequest struct {
+	// Whether the operations are applied all or nothing, in one transaction.
+	// Otherwise each operation is applied on its own, whether the others fail or not.
+	Atomic     bool             `json:"atomic"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation creates, updates or deletes a book.
+type BatchOperation struct {
+	// "create", "update" or "delete".
+	Op string `json:"op"`
+	// Id of the book to update or delete.
+	ID int `json:"id,omitempty"`
+	// ETag of the book the update or delete is based on, checked like the If-Match header.
+	IfMatch string `json:"if_match,omitempty"`
+	// The book to create.
+	Book json.RawMessage `json:"book,omitempty"`
+	// The JSON Merge Patch to update the book with.
+	Patch json.RawMessage `json:"patch,omitempty"`
+}
+
+// BatchResponse holds the results of the operations of a batch, in the order of the operations.
+type BatchResponse struct {
+	// Whether the changes of the successful operations were saved. They aren't if an atomic batch fails.
+	Committed bool          `json:"committed"`
+	Results   []BatchResult `json:"results"`
+}
+
+// BatchResult is the outcome of one operation, with the status and body a single request for it would have responded with.
+type BatchResult struct {
+	Status int `json:"status"`
+	// The created or updated book and its entity tag.
+	Book *types.Book `json:"book,omitempty"`
+	ETag string      `json:"etag,omitempty"`
+	// Why the operation failed.
+	Error *problem.Document `json:"error,omitempty"`
+}
+
+func (s *Server) handleBatchBooks(c *gin.Context) {
+	// Get the authenticated user from the context.
+	currentUser := c.MustGet("currentUser").(*types.User)
+
+	if currentUser == nil {
+		respondError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	var request BatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+	if len(request.Operations) == 0 {
+		respondProblem(c, problem.Validation(problem.FieldError{Field: "operations", Code: "min", Message: "must have at least 1 operation"}))
+		return
+	}
+	if len(request.Operations) > s.MaxBatchOperations {
+		respondProblem(c, problem.Validation(problem.FieldError{Field: "operations", Code: "max", Message: "must have at most " + strconv.Itoa(s.MaxBatchOperations) + " operations"}))
+		return
+	}
+
+	response := BatchResponse{Committed: true, Results: make([]BatchResult, len(request.Operations))}
+
+	if !request.Atomic {
+		// Apply each operation on its own.
+		for i, operation := range request.Operations {
+			result, err := s.applyBatchOperation(c, s.Service, operation)
+			if err != nil {
+				result = BatchResult{Status: err.Status, Error: s.batchProblemDocument(c, i, err)}
+			}
+			response.Results[i] = result
+		}
+	} else {
+		// Apply the operations in a transaction, rolling all of them back if one fails.
+		// Their changes are only audited and published once the transaction commits.
+		failed := -1
+		err := s.InTransaction(c.Request.Context(), func(tx *service.Service) error {
+			for i, operation := range request.Operations {
+				result, err := s.applyBatchOperation(c, tx, operation)
+				if err != nil {
+					failed = i
+					response.Results[i] = BatchResult{Status: err.Status, Error: s.batchProblemDocument(c, i, err)}
+					return err
+				}
+				response.Results[i] = result
+			}
+			return nil
+		})
+
+		if err != nil && failed < 0 {
+			// The operations succeeded, but committing them did not.
+			respondStorageError(c, err, "failed to apply batch")
+			return
+		}
+		if failed >= 0 {
+			response.Committed = false
+			for i := range response.Results {
+				if i == failed {
+					continue
+				}
+				aborted := problem.New(http.StatusFailedDependency, problem.CodeBatchAborted, fmt.Sprintf("not applied because operation %d failed", failed))
+				response.Results[i] = BatchResult{Status: aborted.Status, Error: s.batchProblemDocument(c, i, aborted)}
+			}
+		}
+	}
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, response)
+}
+
+// Applies one operation of a batch with the service, which may be running in a transaction,
+// returning its result or the problem a single request for the operation would have responded with.
+func (s *Server) applyBatchOperation(c *gin.Context, svc *service.Service, operation BatchOperation) (BatchResult, *problem.Error) {
+	ctx, actor := c.Request.Context(), actorOf(c)
+
+	switch operation.Op {
+	case "create":
+		if len(operation.Book) == 0 {
+			return BatchResult{}, requiredProblem("book")
+		}
+		var newBook types.Book
+		if err := json.Unmarshal(operation.Book, &newBook); err != nil {
+			return BatchResult{}, problem.FromBinding(err)
+		}
+		if err := binding.Validator.ValidateStruct(&newBook); err != nil {
+			return BatchResult{}, problem.FromBinding(err)
+		}
+
+		createdBook, err := svc.CreateBook(ctx, actor, &newBook)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		return BatchResult{Status: http.StatusCreated, Book: createdBook, ETag: bookETag(createdBook)}, nil
+
+	case "update":
+		fetchedBook, err := svc.GetBook(ctx, actor, operation.ID, "update", rbac.PermissionWriteAnyBook)
+		if err != nil {
+			return BatchResult{}, err
+		}
+
+		// The update must be based on the book as it is now.
+		if err := s.ifMatchProblem(operation.IfMatch, "if_match", bookETag(fetchedBook)); err != nil {
+			return BatchResult{}, err
+		}
+
+		// Apply the patch to the book.
+		if len(operation.Patch) == 0 {
+			return BatchResult{}, requiredProblem("patch")
+		}
+		var patchedBook types.Book
+		if err := patchResource(patch.MergePatchContentType, operation.Patch, fetchedBook, &patchedBook, bookPatchFields); err != nil {
+			return BatchResult{}, err
+		}
+
+		updatedBook, err := svc.UpdateBook(ctx, actor, fetchedBook, patchedBook)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		return BatchResult{Status: http.StatusOK, Book: updatedBook, ETag: bookETag(updatedBook)}, nil
+
+	case "delete":
+		fetchedBook, err := svc.GetBook(ctx, actor, operation.ID, "delete", rbac.PermissionWriteAnyBook)
+		if err != nil {
+			return BatchResult{}, err
+		}
+
+		// The deletion must be based on the book as it is now.
+		if err := s.ifMatchProblem(operation.IfMatch, "if_match", bookETag(fetchedBook)); err != nil {
+			return BatchResult{}, err
+		}
+
+		if err := svc.DeleteBook(ctx, actor, fetchedBook); err != nil {
+			return BatchResult{}, err
+		}
+		return BatchResult{Status: http.StatusNoContent}, nil
+	}
+
+	return BatchResult{}, problem.Validation(problem.FieldError{Field: "op", Code: "oneof", Message: "must be one of create, update, delete"})
+}
+
+// Returns the problem details document for the failed operation of a batch, logging the internal error behind it.
+func (s *Server) batchProblemDocument(c *gin.Context, index int, err *problem.Error) *problem.Document {
+	if err.Status >= http.StatusInternalServerError {
+		s.Logger.ErrorContext(c.Request.Context(), "batch operation failed", "operation", index, "error", err)
+	}
+	document := err.Document(c.Request.URL.Path+"#/operations/"+strconv.Itoa(index), c.GetString("requestID"))
+	return &document
+}
+
+// Returns the validation problem of a required field that is missing.
+func requiredProblem(field string) *problem.Error {
+	return problem.Validation(problem.FieldError{Field: field, Code: "required", Message: "is required"})
+}
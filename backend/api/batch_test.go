@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchBooks(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	bar := createTestUser(t, store, "bar", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+
+	// Creates a book owned by the user.
+	createBook := func(t *testing.T, ownerID int) *types.Book {
+		book, err := store.CreateBook(&types.Book{Title: "Foo", Author: "Bar", PagesCount: 100, PagesRead: 10, OwnerID: ownerID})
+		assert.NoError(t, err)
+		return book
+	}
+
+	// Decodes the batch response.
+	decodeBatch := func(t *testing.T, body []byte) BatchResponse {
+		var response BatchResponse
+		assert.NoError(t, json.Unmarshal(body, &response))
+		return response
+	}
+
+	t.Run("TestPerItem", func(t *testing.T) {
+		updated := createBook(t, foo.ID)
+		deleted := createBook(t, foo.ID)
+		notOwned := createBook(t, bar.ID)
+
+		w := doRequest(server, "POST", "/v1/books/batch", gin.H{"operations": []gin.H{
+			{"op": "create", "book": gin.H{"title": "Fuzz", "author": "Buzz", "pages_count": 50, "pages_read": 1}},
+			{"op": "update", "id": updated.ID, "if_match": bookETag(updated), "patch": gin.H{"pages_read": 20}},
+			{"op": "update", "id": notOwned.ID, "patch": gin.H{"pages_read": 20}},
+			{"op": "delete", "id": deleted.ID},
+			{"op": "delete", "id": 1000},
+		}}, fooToken)
+		assert.Equal(t, 200, w.Code)
+
+		response := decodeBatch(t, w.Body.Bytes())
+		assert.True(t, response.Committed)
+		assert.Len(t, response.Results, 5)
+
+		assert.Equal(t, 201, response.Results[0].Status)
+		assert.Equal(t, "Fuzz", response.Results[0].Book.Title)
+		assert.Equal(t, foo.ID, response.Results[0].Book.OwnerID)
+		assert.Equal(t, bookETag(response.Results[0].Book), response.Results[0].ETag)
+
+		assert.Equal(t, 200, response.Results[1].Status)
+		assert.Equal(t, 20, response.Results[1].Book.PagesRead)
+
+		// Ownership is checked for every item.
+		assert.Equal(t, 401, response.Results[2].Status)
+		assert.Equal(t, "/v1/books/batch#/operations/2", response.Results[2].Error.Instance)
+
+		assert.Equal(t, 204, response.Results[3].Status)
+		assert.Nil(t, response.Results[3].Book)
+
+		assert.Equal(t, 404, response.Results[4].Status)
+		assert.Equal(t, problem.CodeNotFound, response.Results[4].Error.Code)
+
+		// The successful operations were applied, and the failed ones weren't.
+		fetchedBook, err := store.GetBook(updated.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 20, fetchedBook.PagesRead)
+		fetchedBook, err = store.GetBook(notOwned.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 10, fetchedBook.PagesRead)
+		fetchedBook, err = store.GetBook(deleted.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, fetchedBook)
+	})
+
+	t.Run("TestAtomic", func(t *testing.T) {
+		book := createBook(t, foo.ID)
+		books, err := store.GetBooks(foo.ID)
+		assert.NoError(t, err)
+
+		// A stale if_match fails the batch, rolling back the operations before it.
+		w := doRequest(server, "POST", "/v1/books/batch", gin.H{"atomic": true, "operations": []gin.H{
+			{"op": "create", "book": gin.H{"title": "Fuzz", "author": "Buzz", "pages_count": 50, "pages_read": 1}},
+			{"op": "update", "id": book.ID, "patch": gin.H{"pages_read": 20}},
+			{"op": "delete", "id": book.ID, "if_match": bookETag(book)},
+			{"op": "update", "id": book.ID, "patch": gin.H{"pages_read": 30}},
+		}}, fooToken)
+		assert.Equal(t, 200, w.Code)
+
+		response := decodeBatch(t, w.Body.Bytes())
+		assert.False(t, response.Committed)
+		assert.Equal(t, 412, response.Results[2].Status)
+		assert.Equal(t, problem.CodePreconditionFailed, response.Results[2].Error.Code)
+		for _, i := range []int{0, 1, 3} {
+			assert.Equal(t, 424, response.Results[i].Status)
+			assert.Equal(t, problem.CodeBatchAborted, response.Results[i].Error.Code)
+			assert.Nil(t, response.Results[i].Book)
+		}
+
+		unchangedBooks, err := store.GetBooks(foo.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, books, unchangedBooks)
+
+		// Once every operation succeeds, all of them are saved.
+		w = doRequest(server, "POST", "/v1/books/batch", gin.H{"atomic": true, "operations": []gin.H{
+			{"op": "create", "book": gin.H{"title": "Fuzz", "author": "Buzz", "pages_count": 50, "pages_read": 1}},
+			{"op": "update", "id": book.ID, "patch": gin.H{"pages_read": 20}},
+		}}, fooToken)
+		assert.Equal(t, 200, w.Code)
+
+		response = decodeBatch(t, w.Body.Bytes())
+		assert.True(t, response.Committed)
+		assert.Equal(t, 201, response.Results[0].Status)
+		assert.Equal(t, 200, response.Results[1].Status)
+
+		fetchedBook, err := store.GetBook(response.Results[0].Book.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Fuzz", fetchedBook.Title)
+		fetchedBook, err = store.GetBook(book.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 20, fetchedBook.PagesRead)
+	})
+
+	t.Run("TestInvalidOperations", func(t *testing.T) {
+		book := createBook(t, foo.ID)
+
+		w := doRequest(server, "POST", "/v1/books/batch", gin.H{"operations": []gin.H{
+			{"op": "move", "id": book.ID},
+			{"op": "create", "book": gin.H{"title": "Fuzz", "author": "Buzz", "pages_count": 5, "pages_read": 10}},
+			{"op": "create"},
+			{"op": "update", "id": book.ID, "patch": gin.H{"owner_id": bar.ID}},
+		}}, fooToken)
+		assert.Equal(t, 200, w.Code)
+
+		response := decodeBatch(t, w.Body.Bytes())
+		assert.Equal(t, []problem.FieldError{{Field: "op", Code: "oneof", Message: "must be one of create, update, delete"}}, response.Results[0].Error.Errors)
+		assert.Equal(t, "pages_read", response.Results[1].Error.Errors[0].Field)
+		assert.Equal(t, "book", response.Results[2].Error.Errors[0].Field)
+		assert.Equal(t, []problem.FieldError{{Field: "owner_id", Code: "read_only", Message: "can't be changed"}}, response.Results[3].Error.Errors)
+		for _, result := range response.Results {
+			assert.Equal(t, 400, result.Status)
+		}
+	})
+
+	t.Run("TestSizeLimit", func(t *testing.T) {
+		server.MaxBatchOperations = 2
+		defer func() { server.MaxBatchOperations = 100 }()
+
+		operation := gin.H{"op": "delete", "id": 1000}
+		w := doRequest(server, "POST", "/v1/books/batch", gin.H{"operations": []gin.H{operation, operation, operation}}, fooToken)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, "operations", decodeProblem(t, w).Errors[0].Field)
+
+		w = doRequest(server, "POST", "/v1/books/batch", gin.H{"operations": []gin.H{}}, fooToken)
+		assert.Equal(t, 400, w.Code)
+	})
+
+	t.Run("TestRequireIfMatch", func(t *testing.T) {
+		server.RequireIfMatch = true
+		defer func() { server.RequireIfMatch = false }()
+
+		book := createBook(t, foo.ID)
+		w := doRequest(server, "POST", "/v1/books/batch", gin.H{"operations": []gin.H{
+			{"op": "delete", "id": book.ID},
+		}}, fooToken)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, 428, decodeBatch(t, w.Body.Bytes()).Results[0].Status)
+	})
+}
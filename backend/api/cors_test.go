@@ -0,0 +1,113 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/cors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	policy := cors.Policy{
+		AllowedOrigins:   []string{"https://books.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowCredentials: true,
+		MaxAge:           5 * time.Minute,
+	}
+
+	for _, test := range []struct {
+		name             string
+		origin           string
+		method           string
+		headers          string
+		status           int
+		allowOrigin      string
+		allowCredentials string
+		allowMethods     string
+		maxAge           string
+	}{
+		{"allowed origin", "https://books.example.com", "GET", "", http.StatusNoContent, "https://books.example.com", "true", "GET, POST, PATCH, DELETE", "300"},
+		{"allowed origin and headers", "https://books.example.com", "PATCH", "authorization, content-type", http.StatusNoContent, "https://books.example.com", "true", "GET, POST, PATCH, DELETE", "300"},
+		{"wildcard subdomain", "https://app.example.org", "DELETE", "Authorization", http.StatusNoContent, "https://app.example.org", "true", "GET, POST, PATCH, DELETE", "300"},
+		{"bare domain of wildcard", "https://example.org", "GET", "", http.StatusForbidden, "", "", "", ""},
+		{"disallowed origin", "https://evil.example.com", "GET", "", http.StatusForbidden, "", "", "", ""},
+		{"disallowed method", "https://books.example.com", "PUT", "", http.StatusMethodNotAllowed, "https://books.example.com", "true", "", ""},
+		{"disallowed header", "https://books.example.com", "POST", "X-Custom-Header", http.StatusForbidden, "https://books.example.com", "true", "", ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newMemoryServer(t)
+			server.CORSPolicy = policy
+
+			req := httptest.NewRequest(http.MethodOptions, "/books/", nil)
+			req.Header.Set("Origin", test.origin)
+			req.Header.Set("Access-Control-Request-Method", test.method)
+			if test.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", test.headers)
+			}
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+
+			assert.Equal(t, test.status, w.Code)
+			assert.Equal(t, test.allowOrigin, w.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, test.allowCredentials, w.Header().Get("Access-Control-Allow-Credentials"))
+			assert.Equal(t, test.allowMethods, w.Header().Get("Access-Control-Allow-Methods"))
+			assert.Equal(t, test.maxAge, w.Header().Get("Access-Control-Max-Age"))
+			if test.status == http.StatusNoContent {
+				assert.Equal(t, "Authorization, Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+			}
+			assert.Contains(t, w.Header().Values("Vary"), "Origin")
+		})
+	}
+}
+
+func TestCORSRequests(t *testing.T) {
+	server, store := newMemoryServer(t)
+	server.CORSPolicy = cors.Policy{
+		AllowedOrigins:   []string{"https://books.example.com"},
+		AllowedMethods:   []string{"GET"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		AllowCredentials: true,
+	}
+	foo := createTestUser(t, store, "foo", "user")
+	fooToken := accessTokenFor(t, store, foo)
+
+	request := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/books/", nil)
+		req.Header.Set("Authorization", "Bearer "+fooToken)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The allowed origin is reflected, never a wildcard alongside credentials.
+	w := request("https://books.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "https://books.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "X-Request-ID", w.Header().Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "Origin", w.Header().Get("Vary"))
+
+	// Other origins are served without CORS headers, so the browser withholds the response.
+	w = request("https://evil.example.com")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+
+	// Same-origin and non-browser requests are unaffected.
+	w = request("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+
+	// By default any origin is allowed without credentials.
+	server.CORSPolicy = cors.DefaultPolicy()
+	w = request("https://anywhere.example.net")
+	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/service"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Report validation failures under the names clients send the fields as.
+	if validate, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		validate.RegisterTagNameFunc(requestFieldName)
+	}
+}
+
+// Returns the name of the struct field in JSON bodies, or in forms if it has no JSON name.
+func requestFieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// Aborts the request with an error whose code is the generic one for the status.
+// The detail is shown to the client, so it must not carry internal error messages.
+func respondError(c *gin.Context, status int, detail string) {
+	respondProblem(c, problem.New(status, problem.CodeForStatus(status), detail))
+}
+
+// Aborts the request with the error, which ErrorMiddleware renders once the handlers have returned.
+func respondProblem(c *gin.Context, err *problem.Error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// Aborts the request with the error from binding its body or form.
+func respondBindingError(c *gin.Context, err error) {
+	respondProblem(c, problem.FromBinding(err))
+}
+
+// Aborts the request with the error returned by the storage, see service.StorageProblem.
+func respondStorageError(c *gin.Context, err error, detail string) {
+	respondProblem(c, service.StorageProblem(err, detail))
+}
+
+// Middleware to render the error a request was aborted with as a problem details document.
+// Errors that aren't problems are rendered as internal errors, without their messages.
+func (s *Server) ErrorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		err := problem.From(c.Errors.Last().Err)
+
+		body, marshalErr := json.Marshal(err.Document(c.Request.URL.Path, c.GetString("requestID")))
+		if marshalErr != nil {
+			s.Logger.ErrorContext(c.Request.Context(), "failed to render error", "error", marshalErr)
+			c.Status(err.Status)
+			return
+		}
+		c.Data(err.Status, problem.ContentType, body)
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// Decodes the problem details document of an error response.
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder) problem.Document {
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+
+	var document problem.Document
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &document))
+	return document
+}
+
+// failingStorage fails to update books, as a database would on a lost connection.
+type failingStorage struct {
+	*storage.MemoryStorage
+}
+
+func (s failingStorage) UpdateBook(book *types.Book) (*types.Book, error) {
+	return nil, errors.New("pq: connection to 10.0.0.5:5432 refused")
+}
+
+func TestProblemResponses(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+
+	t.Run("TestValidationFields", func(t *testing.T) {
+		w := doRequest(server, "POST", "/auth/register", map[string]interface{}{"username": "bar", "email": "not-an-email"}, "")
+		assert.Equal(t, 400, w.Code)
+
+		document := decodeProblem(t, w)
+		assert.Equal(t, problem.CodeValidationFailed, document.Code)
+		assert.Equal(t, "/problems/validation_failed", document.Type)
+		assert.Equal(t, "Bad Request", document.Title)
+		assert.Equal(t, 400, document.Status)
+		assert.Equal(t, "/auth/register", document.Instance)
+		assert.ElementsMatch(t, []problem.FieldError{
+			{Field: "email", Code: "email", Message: "must be an email address"},
+			{Field: "password", Code: "required", Message: "is required"},
+		}, document.Errors)
+	})
+
+	t.Run("TestMalformedJSON", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/books/", bytes.NewBufferString(`{"title": `))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+fooToken)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code)
+
+		document := decodeProblem(t, w)
+		assert.Equal(t, problem.CodeInvalidRequest, document.Code)
+		assert.Equal(t, "request body is not valid JSON", document.Detail)
+
+		w = doRequest(server, "POST", "/books/", map[string]interface{}{"title": "Dune", "author": "Frank Herbert", "pages_count": "many", "pages_read": 1}, fooToken)
+		document = decodeProblem(t, w)
+		assert.Equal(t, problem.CodeValidationFailed, document.Code)
+		assert.Equal(t, []problem.FieldError{{Field: "pages_count", Code: "type", Message: "must be a number"}}, document.Errors)
+	})
+
+	t.Run("TestPagesValidation", func(t *testing.T) {
+		w := doRequest(server, "POST", "/books/", map[string]interface{}{"title": "Dune", "author": "Frank Herbert", "pages_count": 10, "pages_read": 11}, fooToken)
+		assert.Equal(t, 400, w.Code)
+
+		document := decodeProblem(t, w)
+		assert.Equal(t, problem.CodeValidationFailed, document.Code)
+		assert.Equal(t, "pages_read", document.Errors[0].Field)
+	})
+
+	t.Run("TestStableCodes", func(t *testing.T) {
+		// Login failures share a code regardless of which credential was wrong.
+		for _, email := range []string{foo.Email, "nobody@example.com"} {
+			req := httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString("username="+email+"&password=wrong"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+			assert.Equal(t, 403, w.Code)
+
+			document := decodeProblem(t, w)
+			assert.Equal(t, problem.CodeInvalidCredentials, document.Code)
+			assert.Equal(t, "invalid credentials", document.Detail)
+		}
+
+		w := doRequest(server, "POST", "/auth/register", map[string]interface{}{"username": "foo", "email": foo.Email, "password": "correct horse battery staple"}, "")
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, problem.CodeEmailTaken, decodeProblem(t, w).Code)
+
+		w = doRequest(server, "GET", "/books/1000", nil, fooToken)
+		assert.Equal(t, 404, w.Code)
+		assert.Equal(t, problem.CodeNotFound, decodeProblem(t, w).Code)
+
+		w = doRequest(server, "GET", "/books/", nil, "not-a-token")
+		assert.Equal(t, 401, w.Code)
+		assert.Equal(t, problem.CodeInvalidToken, decodeProblem(t, w).Code)
+	})
+
+	t.Run("TestUnknownRoute", func(t *testing.T) {
+		w := doRequest(server, "GET", "/no/such/route", nil, fooToken)
+		assert.Equal(t, 404, w.Code)
+		assert.Equal(t, problem.CodeNotFound, decodeProblem(t, w).Code)
+	})
+
+	t.Run("TestInternalErrorsAreHidden", func(t *testing.T) {
+		book, err := store.CreateBook(&types.Book{Title: "Dune", Author: "Frank Herbert", PagesCount: 10, PagesRead: 1, OwnerID: foo.ID})
+		assert.NoError(t, err)
+
+		failingServer := NewServer(":8080", failingStorage{store})
+		failingServer.AccessTokenSecretKey = testSecretKey
+		failingServer.Auditor = &recordingAuditor{}
+		failingServer.RegisterRoutes()
+
+		w := doRequest(failingServer, "PATCH", fmt.Sprintf("/books/%d", book.ID), map[string]interface{}{"pages_read": 2}, fooToken)
+		assert.Equal(t, 500, w.Code)
+
+		document := decodeProblem(t, w)
+		assert.Equal(t, problem.CodeInternal, document.Code)
+		assert.Equal(t, "failed to update book", document.Detail)
+		assert.NotContains(t, w.Body.String(), "10.0.0.5")
+	})
+
+	t.Run("TestPanicsAreHidden", func(t *testing.T) {
+		panicking := NewServer(":8080", store)
+		panicking.AccessTokenSecretKey = testSecretKey
+		panicking.RegisterRoutes()
+		panicking.router.GET("/panic", func(c *gin.Context) { panic("secret internal state") })
+
+		w := doRequest(panicking, "GET", "/panic", nil, fooToken)
+		assert.Equal(t, 500, w.Code)
+		assert.Equal(t, problem.CodeInternal, decodeProblem(t, w).Code)
+		assert.NotContains(t, w.Body.String(), "secret internal state")
+	})
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+)
+
+// Returns the entity tag of the book, which changes with every update of it.
+func bookETag(book *types.Book) string {
+	return entityTag(fmt.Sprintf("book:%d.%d", book.ID, book.Version))
+}
+
+// Returns the entity tag of the books, which changes whenever one of them is added, updated or removed.
+func booksETag(books []types.Book) string {
+	versions := make([]string, 0, len(books))
+	for _, book := range books {
+		versions = append(versions, fmt.Sprintf("book:%d.%d", book.ID, book.Version))
+	}
+	return entityTag(versions...)
+}
+
+// Returns the entity tag of the user. Users are represented with their books, so it changes with them too.
+func userETag(user *types.User) string {
+	versions := []string{fmt.Sprintf("user:%d.%d", user.ID, user.Version)}
+	for _, book := range user.Books {
+		versions = append(versions, fmt.Sprintf("book:%d.%d", book.ID, book.Version))
+	}
+	return entityTag(versions...)
+}
+
+// Returns a strong entity tag derived from the versions of everything in a representation.
+func entityTag(versions ...string) string {
+	hash := sha256.Sum256([]byte(strings.Join(versions, ",")))
+	return `"` + hex.EncodeToString(hash[:8]) + `"`
+}
+
+// Reports whether the entity tag is in the list of an If-Match or If-None-Match header, or the list is "*".
+// If-None-Match compares tags weakly, ignoring the W/ prefix, and If-Match compares them strongly.
+func etagListed(header string, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the If-Match header of a write against the entity tag of the resource as it is now.
+// Responds with 412 if the client's copy is out of date, or with 428 if the header is required but missing, and returns false.
+func (s *Server) checkIfMatch(c *gin.Context, etag string) bool {
+	if err := s.ifMatchProblem(c.GetHeader("If-Match"), "an If-Match header", etag); err != nil {
+		respondProblem(c, err)
+		return false
+	}
+	return true
+}
+
+// Returns the problem with a write whose If-Match list doesn't match the entity tag, or nil if there is none.
+// The name describes where the list is sent, for the problem of a missing list.
+func (s *Server) ifMatchProblem(ifMatch string, name string, etag string) *problem.Error {
+	if ifMatch == "" {
+		if s.RequireIfMatch {
+			return problem.New(http.StatusPreconditionRequired, problem.CodePreconditionRequired, name+" with the resource's ETag is required")
+		}
+		return nil
+	}
+
+	if !etagListed(ifMatch, etag, false) {
+		return problem.New(http.StatusPreconditionFailed, problem.CodePreconditionFailed, "the resource was changed since it was read")
+	}
+	return nil
+}
+
+// Returns the problem with a write based on the version of a resource whose current version is given, or nil if there is none.
+// The gRPC and GraphQL APIs expose versions rather than entity tags, and send version 0 when the client didn't send one.
+func (s *Server) versionProblem(version int, current int) *problem.Error {
+	if version == 0 {
+		if s.RequireIfMatch {
+			return problem.New(http.StatusPreconditionRequired, problem.CodePreconditionRequired, "the version of the resource is required")
+		}
+		return nil
+	}
+
+	if version != current {
+		return problem.New(http.StatusPreconditionFailed, problem.CodePreconditionFailed, "the resource was changed since it was read")
+	}
+	return nil
+}
+
+// Responds with the representation and its entity tag.
+// Reads respond with 304 and no body instead if the If-None-Match header lists the tag, as the client's copy is current.
+func respondWithETag(c *gin.Context, status int, etag string, representation interface{}) {
+	c.Header("ETag", etag)
+
+	isRead := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead
+	if isRead && etagListed(c.GetHeader("If-None-Match"), etag, true) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.IndentedJSON(status, representation)
+}
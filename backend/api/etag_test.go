@@ -0,0 +1,152 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// Sends a request like doRequest, with the additional headers.
+func doRequestWithHeaders(server *Server, method string, path string, body interface{}, accessToken string, headers map[string]string) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		encoded, _ := json.Marshal(body)
+		reader = bytes.NewBuffer(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestConditionalRequests(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+
+	book, err := store.CreateBook(&types.Book{Title: "Foo", Author: "Bar", PagesCount: 100, PagesRead: 10, OwnerID: foo.ID})
+	assert.NoError(t, err)
+	bookPath := fmt.Sprintf("/v1/books/%d", book.ID)
+
+	w := doRequest(server, "GET", bookPath, nil, fooToken)
+	assert.Equal(t, 200, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	t.Run("TestNotModified", func(t *testing.T) {
+		for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+			w := doRequestWithHeaders(server, "GET", bookPath, nil, fooToken, map[string]string{"If-None-Match": ifNoneMatch})
+			assert.Equal(t, 304, w.Code, ifNoneMatch)
+			assert.Empty(t, w.Body.String())
+			assert.Equal(t, etag, w.Header().Get("ETag"))
+		}
+
+		w := doRequestWithHeaders(server, "GET", bookPath, nil, fooToken, map[string]string{"If-None-Match": `"other"`})
+		assert.Equal(t, 200, w.Code)
+	})
+
+	t.Run("TestStaleUpdate", func(t *testing.T) {
+		// The first device updates the book...
+		w := doRequestWithHeaders(server, "PATCH", bookPath, gin.H{"pages_read": 20}, fooToken, map[string]string{"If-Match": etag})
+		assert.Equal(t, 200, w.Code)
+		newETag := w.Header().Get("ETag")
+		assert.NotEqual(t, etag, newETag)
+
+		var updatedBook types.Book
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &updatedBook))
+		assert.Equal(t, book.Version+1, updatedBook.Version)
+
+		// ...so the second device's update, based on the same copy, is rejected.
+		w = doRequestWithHeaders(server, "PATCH", bookPath, gin.H{"pages_read": 30}, fooToken, map[string]string{"If-Match": etag})
+		assert.Equal(t, 412, w.Code)
+		assert.Equal(t, problem.CodePreconditionFailed, decodeProblem(t, w).Code)
+
+		// Weak tags never match If-Match.
+		w = doRequestWithHeaders(server, "PATCH", bookPath, gin.H{"pages_read": 30}, fooToken, map[string]string{"If-Match": "W/" + newETag})
+		assert.Equal(t, 412, w.Code)
+
+		storedBook, err := store.GetBook(book.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 20, storedBook.PagesRead)
+
+		// The client can't set the version itself.
+		w = doRequestWithHeaders(server, "PATCH", bookPath, gin.H{"pages_read": 30, "version": 100}, fooToken, map[string]string{"If-Match": newETag})
+		assert.Equal(t, 400, w.Code)
+
+		w = doRequestWithHeaders(server, "PATCH", bookPath, gin.H{"pages_read": 30}, fooToken, map[string]string{"If-Match": newETag})
+		assert.Equal(t, 200, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &updatedBook))
+		assert.Equal(t, book.Version+2, updatedBook.Version)
+		etag = w.Header().Get("ETag")
+
+		w = doRequestWithHeaders(server, "DELETE", bookPath, nil, fooToken, map[string]string{"If-Match": newETag})
+		assert.Equal(t, 412, w.Code)
+	})
+
+	t.Run("TestUserETagFollowsBooks", func(t *testing.T) {
+		userPath := fmt.Sprintf("/v1/users/%d", foo.ID)
+		w := doRequest(server, "GET", userPath, nil, fooToken)
+		assert.Equal(t, 200, w.Code)
+		userETag := w.Header().Get("ETag")
+
+		w = doRequestWithHeaders(server, "GET", userPath, nil, fooToken, map[string]string{"If-None-Match": userETag})
+		assert.Equal(t, 304, w.Code)
+
+		// The user is represented with their books, so a new book changes the user's tag.
+		w = doRequest(server, "POST", "/v1/books/", gin.H{"title": "Fuzz", "author": "Buzz", "pages_count": 10, "pages_read": 1}, fooToken)
+		assert.Equal(t, 201, w.Code)
+		assert.NotEmpty(t, w.Header().Get("ETag"))
+
+		w = doRequestWithHeaders(server, "GET", userPath, nil, fooToken, map[string]string{"If-None-Match": userETag})
+		assert.Equal(t, 200, w.Code)
+
+		w = doRequestWithHeaders(server, "PATCH", userPath, gin.H{"username": "fooer"}, fooToken, map[string]string{"If-Match": userETag})
+		assert.Equal(t, 412, w.Code)
+
+		w = doRequestWithHeaders(server, "GET", "/v1/books/", nil, fooToken, nil)
+		assert.Equal(t, 200, w.Code)
+		booksETag := w.Header().Get("ETag")
+		w = doRequestWithHeaders(server, "GET", "/v1/books/", nil, fooToken, map[string]string{"If-None-Match": booksETag})
+		assert.Equal(t, 304, w.Code)
+	})
+
+	t.Run("TestRequireIfMatch", func(t *testing.T) {
+		// Without If-Match, writes apply unless the header is required.
+		w := doRequest(server, "PATCH", bookPath, gin.H{"pages_read": 40}, fooToken)
+		assert.Equal(t, 200, w.Code)
+		etag = w.Header().Get("ETag")
+
+		server.RequireIfMatch = true
+		defer func() { server.RequireIfMatch = false }()
+
+		w = doRequest(server, "PATCH", bookPath, gin.H{"pages_read": 50}, fooToken)
+		assert.Equal(t, 428, w.Code)
+		assert.Equal(t, problem.CodePreconditionRequired, decodeProblem(t, w).Code)
+
+		w = doRequest(server, "DELETE", bookPath, nil, fooToken)
+		assert.Equal(t, 428, w.Code)
+
+		w = doRequestWithHeaders(server, "DELETE", bookPath, nil, fooToken, map[string]string{"If-Match": etag})
+		assert.Equal(t, 204, w.Code)
+	})
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) handleEvents(c *gin.Context) {
+	// Get the authenticated user from the context.
+	currentUser := c.MustGet("currentUser").(*types.User)
+
+	if currentUser == nil {
+		respondError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	// The stream ends once the access token it was opened with expires, its session is revoked, or the account
+	// is disabled; the access token is checked again on each heartbeat.
+	accessToken := strings.Replace(c.GetHeader("Authorization"), "Bearer ", "", 1)
+
+	// Subscribe before replaying, so that no event is missed in between.
+	subscription, replay, complete, resumeID := s.Events.Subscribe(currentUser.ID, c.GetHeader("Last-Event-ID"))
+	defer s.Events.Unsubscribe(subscription)
+
+	// The stream outlives the server's write timeout. Test recorders can't have deadlines, hence the ignored error.
+	http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// Clients that missed events no longer kept for replay must fetch their books again.
+	if !complete {
+		if writeServerSentEvent(c.Writer, resumeID, "reset", []byte("{}")) != nil {
+			return
+		}
+	}
+	for _, event := range replay {
+		if writeServerSentEvent(c.Writer, event.ID, event.Type, event.Data) != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(s.EventHeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-s.streams.Done():
+			return
+		case event, ok := <-subscription.C:
+			// The client fell too far behind, it resumes with the Last-Event-ID header.
+			if !ok {
+				return
+			}
+			if writeServerSentEvent(c.Writer, event.ID, event.Type, event.Data) != nil {
+				return
+			}
+			c.Writer.Flush()
+
+			// Deleted accounts are signed out everywhere, their streams end with the deletion.
+			if event.Type == events.UserDeleted {
+				return
+			}
+		case <-heartbeat.C:
+			if _, _, problemErr := s.authenticate(c.Request.Context(), accessToken); problemErr != nil {
+				return
+			}
+			if _, err := io.WriteString(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// Writes an event in the text/event-stream format. The data must be on one line, as JSON encoded by Go is.
+func writeServerSentEvent(w io.Writer, id string, eventType string, data []byte) error {
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, data)
+	return err
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// An event, or a heartbeat comment, read from a stream.
+type streamedEvent struct {
+	ID   string
+	Type string
+	Data string
+}
+
+// Opens an event stream, returning the events read from it until it ends.
+func openEventStream(t *testing.T, url string, accessToken string, lastEventID string) <-chan streamedEvent {
+	req, err := http.NewRequest("GET", url+"/v1/events", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	streamed := make(chan streamedEvent, 16)
+	go func() {
+		defer close(streamed)
+		var event streamedEvent
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == "":
+				streamed <- event
+				event = streamedEvent{}
+			case strings.HasPrefix(line, ":"):
+				event.Type = "heartbeat"
+			case strings.HasPrefix(line, "id: "):
+				event.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				event.Type = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				event.Data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return streamed
+}
+
+// Returns the next event of the stream other than a heartbeat, failing if there is none.
+func nextEvent(t *testing.T, stream <-chan streamedEvent) streamedEvent {
+	t.Helper()
+	for {
+		select {
+		case event, ok := <-stream:
+			if !assert.True(t, ok, "stream ended") {
+				t.FailNow()
+			}
+			if event.Type != "heartbeat" {
+				return event
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("no event streamed")
+		}
+	}
+}
+
+// Waits for the stream to end, failing if it doesn't.
+func waitForStreamEnd(t *testing.T, stream <-chan streamedEvent) {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-stream:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("stream didn't end")
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	bar := createTestUser(t, store, "bar", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+	barToken := accessTokenFor(t, store, bar)
+
+	// Idle streams send heartbeats often, the events are read past them.
+	server.EventHeartbeatInterval = 10 * time.Millisecond
+	httpServer := httptest.NewServer(server.router)
+	defer httpServer.Close()
+
+	stream := openEventStream(t, httpServer.URL, fooToken, "")
+
+	// Changes to the user's books are streamed to them, and only to them.
+	w := doRequest(server, "POST", "/v1/books/", gin.H{"title": "Foo", "author": "Bar", "pages_count": 100, "pages_read": 1}, barToken)
+	assert.Equal(t, 201, w.Code)
+	w = doRequest(server, "POST", "/v1/books/", gin.H{"title": "Foo", "author": "Bar", "pages_count": 100, "pages_read": 1}, fooToken)
+	assert.Equal(t, 201, w.Code)
+	var book types.Book
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &book))
+
+	created := nextEvent(t, stream)
+	assert.Equal(t, events.BookCreated, created.Type)
+	assert.NotEmpty(t, created.ID)
+	var streamedBook types.Book
+	assert.NoError(t, json.Unmarshal([]byte(created.Data), &streamedBook))
+	assert.Equal(t, book.ID, streamedBook.ID)
+
+	bookPath := fmt.Sprintf("/v1/books/%d", book.ID)
+	w = doRequest(server, "PATCH", bookPath, gin.H{"pages_read": 50}, fooToken)
+	assert.Equal(t, 200, w.Code)
+	updated := nextEvent(t, stream)
+	assert.Equal(t, events.BookUpdated, updated.Type)
+	assert.Contains(t, updated.Data, `"pages_read":50`)
+
+	w = doRequest(server, "DELETE", bookPath, nil, fooToken)
+	assert.Equal(t, 204, w.Code)
+	deleted := nextEvent(t, stream)
+	assert.Equal(t, events.BookDeleted, deleted.Type)
+	assert.Contains(t, deleted.Data, `"deleted_at":"`)
+
+	// Batches are streamed once they are saved.
+	w = doRequest(server, "POST", "/v1/books/batch", gin.H{"operations": []gin.H{
+		{"op": "create", "book": gin.H{"title": "Fuzz", "author": "Buzz", "pages_count": 50, "pages_read": 1}},
+	}}, fooToken)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, events.BookCreated, nextEvent(t, stream).Type)
+
+	t.Run("TestResume", func(t *testing.T) {
+		// Reconnecting with the last event ID replays the events after it.
+		resumed := openEventStream(t, httpServer.URL, fooToken, created.ID)
+		assert.Equal(t, updated, nextEvent(t, resumed))
+		assert.Equal(t, deleted, nextEvent(t, resumed))
+
+		// Clients that missed events no longer kept are told to fetch their books again.
+		reset := openEventStream(t, httpServer.URL, fooToken, "unknown")
+		event := nextEvent(t, reset)
+		assert.Equal(t, "reset", event.Type)
+		assert.NotEmpty(t, event.ID)
+	})
+
+	t.Run("TestHeartbeat", func(t *testing.T) {
+		heartbeats := openEventStream(t, httpServer.URL, barToken, "")
+		select {
+		case event := <-heartbeats:
+			assert.Equal(t, "heartbeat", event.Type)
+		case <-time.After(2 * time.Second):
+			t.Fatal("no heartbeat streamed")
+		}
+	})
+
+	t.Run("TestAccountDeletion", func(t *testing.T) {
+		// The stream ends with the deletion of the account.
+		w := doRequest(server, "DELETE", fmt.Sprintf("/v1/users/%d", foo.ID), nil, fooToken)
+		assert.Equal(t, 204, w.Code)
+
+		event := nextEvent(t, stream)
+		assert.Equal(t, events.UserDeleted, event.Type)
+		assert.Contains(t, event.Data, `"password":""`)
+		waitForStreamEnd(t, stream)
+	})
+
+	t.Run("TestShutdown", func(t *testing.T) {
+		// Shutting down ends the streams.
+		streaming := openEventStream(t, httpServer.URL, barToken, "")
+		assert.NoError(t, server.Shutdown(context.Background()))
+		waitForStreamEnd(t, streaming)
+	})
+}
+
+func TestEventsAuthentication(t *testing.T) {
+	server, store := newMemoryServer(t)
+	admin := createTestUser(t, store, "admin", rbac.RoleAdmin)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	bar := createTestUser(t, store, "bar", rbac.RoleUser)
+	adminToken := accessTokenFor(t, store, admin)
+
+	// The access token is checked again on each heartbeat.
+	server.EventHeartbeatInterval = 10 * time.Millisecond
+	httpServer := httptest.NewServer(server.router)
+	defer httpServer.Close()
+
+	t.Run("TestSessionRevoked", func(t *testing.T) {
+		fooToken := accessTokenFor(t, store, foo)
+		stream := openEventStream(t, httpServer.URL, fooToken, "")
+
+		claims, err := NewAuth(testSecretKey).ParseAccessToken(fooToken)
+		assert.NoError(t, err)
+		assert.NoError(t, store.RevokeSession(&types.Session{ID: claims.SessionID}))
+		waitForStreamEnd(t, stream)
+	})
+
+	t.Run("TestAccountDisabled", func(t *testing.T) {
+		stream := openEventStream(t, httpServer.URL, accessTokenFor(t, store, foo), "")
+
+		w := doRequest(server, "POST", fmt.Sprintf("/v1/admin/users/%d/disable", foo.ID), nil, adminToken)
+		assert.Equal(t, 200, w.Code)
+		waitForStreamEnd(t, stream)
+	})
+
+	t.Run("TestTokenExpired", func(t *testing.T) {
+		// A token expiring in a second, for a session that doesn't.
+		sessionID, err := newSessionID()
+		assert.NoError(t, err)
+		now := time.Now()
+		_, err = store.CreateSession(&types.Session{ID: sessionID, UserID: bar.ID, CreatedAt: now, LastSeenAt: now, ExpiresAt: now.Add(time.Hour)})
+		assert.NoError(t, err)
+		barToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"user_id": strconv.Itoa(bar.ID),
+			"sid":     sessionID,
+			"exp":     now.Add(time.Second).Unix(),
+		}).SignedString([]byte(testSecretKey))
+		assert.NoError(t, err)
+
+		stream := openEventStream(t, httpServer.URL, barToken, "")
+		time.Sleep(time.Second)
+		waitForStreamEnd(t, stream)
+	})
+}
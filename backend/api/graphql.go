@@ -0,0 +1,488 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/service"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+// Largest GraphQL request body accepted, in bytes. Queries this long are well past the complexity limit anyway.
+const graphqlMaxBodySize = 64 << 10
+
+// Body of a GraphQL request, see https://graphql.org/learn/serving-over-http/.
+type graphqlRequestBody struct {
+	Query         string                 `json:"query" binding:"required"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Context key of the state of the GraphQL request being executed.
+type graphqlRequestKey struct{}
+
+// State of a GraphQL request: who it is made by, and the loaders batching its storage calls.
+type graphqlRequest struct {
+	actor        service.Actor
+	booksByOwner *graphqlLoader[int, []*types.Book]
+	users        *graphqlLoader[int, *types.User]
+}
+
+func graphqlRequestOf(ctx context.Context) *graphqlRequest {
+	return ctx.Value(graphqlRequestKey{}).(*graphqlRequest)
+}
+
+// Returns the handler of /graphql, which executes queries and mutations of the users and books.
+// The access token is optional, but only registering succeeds without one.
+func (s *Server) handleGraphQL() gin.HandlerFunc {
+	schema, err := newGraphQLSchema(&graphqlResolvers{server: s})
+	if err != nil {
+		// The schema is fixed, it only fails to build if it is wrong.
+		panic(err)
+	}
+
+	return func(c *gin.Context) {
+		var body graphqlRequestBody
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, graphqlMaxBodySize)
+		if err := c.ShouldBindJSON(&body); err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				respondProblem(c, problem.New(http.StatusRequestEntityTooLarge, problem.CodeInvalidRequest,
+					fmt.Sprintf("request body must be at most %d bytes", graphqlMaxBodySize)))
+				return
+			}
+			respondBindingError(c, err)
+			return
+		}
+
+		// The same checks as RequireValidAccessToken apply to the access token, if one is sent.
+		if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+			user, session, problemErr := s.authenticate(c.Request.Context(), strings.Replace(authHeader, "Bearer ", "", 1))
+			if problemErr != nil {
+				respondProblem(c, problemErr)
+				return
+			}
+
+			// Forced password resets are completed over REST.
+			if user.PasswordResetRequired {
+				respondProblem(c, passwordResetRequired())
+				return
+			}
+
+			s.Sessions.Touch(session.ID, time.Now())
+			c.Set("currentUser", user)
+			c.Set("currentSession", session)
+		}
+
+		c.JSON(http.StatusOK, s.executeGraphQL(c, &schema, body))
+	}
+}
+
+// Parses, validates and executes the request. Queries nesting deeper or costing more than allowed aren't executed.
+func (s *Server) executeGraphQL(c *gin.Context, schema *graphql.Schema, body graphqlRequestBody) *graphql.Result {
+	document, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(body.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+
+	if validation := graphql.ValidateDocument(schema, document, nil); !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}
+	}
+
+	if problemErr := s.checkGraphQLLimits(schema, document, body.OperationName, body.Variables); problemErr != nil {
+		limitErr := &graphqlError{problem: problemErr}
+		return &graphql.Result{Errors: []gqlerrors.FormattedError{{
+			Message:    limitErr.Error(),
+			Locations:  []location.SourceLocation{},
+			Extensions: limitErr.Extensions(),
+		}}}
+	}
+
+	store := s.storage(c)
+	request := &graphqlRequest{
+		actor:        actorOf(c),
+		booksByOwner: newBooksByOwnerLoader(store),
+		users:        newUserLoader(store),
+	}
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        *schema,
+		AST:           document,
+		OperationName: body.OperationName,
+		Args:          body.Variables,
+		Context:       context.WithValue(c.Request.Context(), graphqlRequestKey{}, request),
+	})
+}
+
+// graphqlError reports a problem as a GraphQL error, with its code and invalid fields as extensions.
+type graphqlError struct {
+	problem *problem.Error
+}
+
+func (e *graphqlError) Error() string {
+	return e.problem.Detail
+}
+
+func (e *graphqlError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"code": e.problem.Code}
+	if len(e.problem.Fields) > 0 {
+		// Name the fields as the schema does.
+		fields := make([]problem.FieldError, len(e.problem.Fields))
+		for i, field := range e.problem.Fields {
+			field.Field = graphqlName(field.Field)
+			fields[i] = field
+		}
+		extensions["fields"] = fields
+	}
+	return extensions
+}
+
+// Returns the camel case GraphQL name of the snake case JSON name, e.g. "pagesRead" for "pages_read".
+func graphqlName(name string) string {
+	words := strings.Split(name, "_")
+	for i := 1; i < len(words); i++ {
+		if words[i] != "" {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+	}
+	return strings.Join(words, "")
+}
+
+// Resolves the fields of the schema with the operations of the service.
+type graphqlResolvers struct {
+	server *Server
+}
+
+// Returns the problem as a GraphQL error, logging the cause of internal ones, which isn't sent to the client.
+func (r *graphqlResolvers) fail(ctx context.Context, problemErr *problem.Error) error {
+	if problemErr.Status >= http.StatusInternalServerError {
+		r.server.Logger.ErrorContext(ctx, "failed to resolve GraphQL field", "code", problemErr.Code, "error", problemErr)
+	}
+
+	// The REST API answers acting on others' records with 401, but the caller is authenticated, they just may not.
+	if problemErr.Status == http.StatusUnauthorized && graphqlRequestOf(ctx).actor.User != nil {
+		denied := *problemErr
+		denied.Status = http.StatusForbidden
+		denied.Code = problem.CodeForbidden
+		problemErr = &denied
+	}
+	return &graphqlError{problem: problemErr}
+}
+
+// Checks that the actor may see the books of the user: their own, or anyone's with the permission to read any book.
+func (r *graphqlResolvers) authorizeBooksOf(ctx context.Context, user *types.User) error {
+	actor := graphqlRequestOf(ctx).actor
+	if actor.User == nil {
+		return r.fail(ctx, problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "unauthorized"))
+	}
+	if user.ID != actor.User.ID && !rbac.Can(actor.User.Role, rbac.PermissionReadAnyBook) {
+		return r.fail(ctx, problem.New(http.StatusForbidden, problem.CodeForbidden, "you cannot view the books of this user"))
+	}
+	return nil
+}
+
+// Returns a thunk resolving the books of the user, loaded in one batch with those of the other users of the query.
+func (r *graphqlResolvers) loadBooks(ctx context.Context, user *types.User, resolve func(books []*types.Book) interface{}) (interface{}, error) {
+	if err := r.authorizeBooksOf(ctx, user); err != nil {
+		return nil, err
+	}
+
+	load := graphqlRequestOf(ctx).booksByOwner.load(user.ID)
+	return func() (interface{}, error) {
+		books, err := load()
+		if err != nil {
+			return nil, r.fail(ctx, problem.Internal("failed to fetch books", err))
+		}
+		return resolve(books), nil
+	}, nil
+}
+
+func (r *graphqlResolvers) userBooks(p graphql.ResolveParams) (interface{}, error) {
+	return r.loadBooks(p.Context, p.Source.(*types.User), func(books []*types.Book) interface{} { return books })
+}
+
+func (r *graphqlResolvers) userProgress(p graphql.ResolveParams) (interface{}, error) {
+	return r.loadBooks(p.Context, p.Source.(*types.User), func(books []*types.Book) interface{} { return newReadingProgress(books) })
+}
+
+// Resolves the owner of the book, loaded in one batch with the other owners of the query.
+// Owners may see themselves, seeing the owners of others' books requires the permission to read any user.
+func (r *graphqlResolvers) bookOwner(p graphql.ResolveParams) (interface{}, error) {
+	book := p.Source.(*types.Book)
+	request := graphqlRequestOf(p.Context)
+	if book.OwnerID != request.actor.User.ID && !rbac.Can(request.actor.User.Role, rbac.PermissionReadAnyUser) {
+		return nil, r.fail(p.Context, problem.New(http.StatusForbidden, problem.CodeForbidden, "you cannot view the owner of this book"))
+	}
+
+	load := request.users.load(book.OwnerID)
+	return func() (interface{}, error) {
+		owner, err := load()
+		if err != nil {
+			return nil, r.fail(p.Context, problem.Internal("failed to fetch user", err))
+		}
+		if owner == nil {
+			return nil, nil
+		}
+		return owner, nil
+	}, nil
+}
+
+func (r *graphqlResolvers) me(p graphql.ResolveParams) (interface{}, error) {
+	actor := graphqlRequestOf(p.Context).actor
+	if actor.User == nil {
+		return nil, r.fail(p.Context, problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "unauthorized"))
+	}
+	return actor.User, nil
+}
+
+func (r *graphqlResolvers) user(p graphql.ResolveParams) (interface{}, error) {
+	fetchedUser, problemErr := r.server.Service.AuthorizeUser(p.Context, graphqlRequestOf(p.Context).actor, p.Args["id"].(int), "view", rbac.PermissionReadAnyUser)
+	if problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+	return fetchedUser, nil
+}
+
+// Lists the users, like GET /admin/users, to those who may manage them.
+func (r *graphqlResolvers) users(p graphql.ResolveParams) (interface{}, error) {
+	actor := graphqlRequestOf(p.Context).actor
+	if actor.User == nil {
+		return nil, r.fail(p.Context, problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "unauthorized"))
+	}
+	if !rbac.Can(actor.User.Role, rbac.PermissionManageUsers) {
+		return nil, r.fail(p.Context, problem.New(http.StatusForbidden, problem.CodeForbidden, "you do not have permission to perform this action"))
+	}
+
+	filter := storage.UserFilter{Offset: p.Args["offset"].(int), Limit: p.Args["limit"].(int)}
+	filter.Query, _ = p.Args["query"].(string)
+	filter.Role, _ = p.Args["role"].(string)
+	if disabled, ok := p.Args["disabled"].(bool); ok {
+		filter.Disabled = &disabled
+	}
+	if filter.Limit <= 0 || filter.Offset < 0 {
+		return nil, r.fail(p.Context, problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "invalid pagination"))
+	}
+	if filter.Limit > maxPageSize {
+		filter.Limit = maxPageSize
+	}
+
+	users, _, err := storage.WithContext(r.server.Storer, p.Context).ListUsers(filter)
+	if err != nil {
+		return nil, r.fail(p.Context, problem.Internal("failed to list users", err))
+	}
+
+	r.server.Record(actor, audit.Event{
+		Action:  "admin.users.list",
+		Details: map[string]interface{}{"query": filter.Query, "role": filter.Role},
+	})
+
+	results := make([]*types.User, len(*users))
+	for i := range *users {
+		results[i] = &(*users)[i]
+	}
+	return results, nil
+}
+
+func (r *graphqlResolvers) book(p graphql.ResolveParams) (interface{}, error) {
+	fetchedBook, problemErr := r.server.GetBook(p.Context, graphqlRequestOf(p.Context).actor, p.Args["id"].(int), "view", rbac.PermissionReadAnyBook)
+	if problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+	return fetchedBook, nil
+}
+
+func (r *graphqlResolvers) books(p graphql.ResolveParams) (interface{}, error) {
+	books, problemErr := r.server.ListBooks(p.Context, graphqlRequestOf(p.Context).actor)
+	if problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+
+	results := make([]*types.Book, len(*books))
+	for i := range *books {
+		results[i] = &(*books)[i]
+	}
+	return results, nil
+}
+
+func (r *graphqlResolvers) registerUser(p graphql.ResolveParams) (interface{}, error) {
+	input := p.Args["input"].(map[string]interface{})
+	newUser := types.User{}
+	newUser.Username, _ = input["username"].(string)
+	newUser.Email, _ = input["email"].(string)
+	newUser.Password, _ = input["password"].(string)
+
+	createdUser, problemErr := r.server.RegisterUser(p.Context, graphqlRequestOf(p.Context).actor, &newUser)
+	if problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+	return createdUser, nil
+}
+
+func (r *graphqlResolvers) updateUser(p graphql.ResolveParams) (interface{}, error) {
+	actor := graphqlRequestOf(p.Context).actor
+	fetchedUser, problemErr := r.server.Service.AuthorizeUser(p.Context, actor, p.Args["id"].(int), "update", rbac.PermissionWriteAnyUser)
+	if problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+
+	// The update must be based on the user as it is now.
+	version, _ := p.Args["version"].(int)
+	if problemErr := r.server.versionProblem(version, fetchedUser.Version); problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+
+	// Apply the fields given in the input, the same ones clients may patch over REST.
+	input := p.Args["input"].(map[string]interface{})
+	patchedUser := *fetchedUser
+	if username, ok := input["username"].(string); ok {
+		patchedUser.Username = username
+	}
+	if email, ok := input["email"].(string); ok {
+		patchedUser.Email = email
+	}
+	if password, ok := input["password"].(string); ok {
+		patchedUser.Password = password
+	}
+
+	updatedUser, problemErr := r.server.UpdateUser(p.Context, actor, fetchedUser, patchedUser)
+	if problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+	return updatedUser, nil
+}
+
+func (r *graphqlResolvers) deleteUser(p graphql.ResolveParams) (interface{}, error) {
+	actor := graphqlRequestOf(p.Context).actor
+	fetchedUser, problemErr := r.server.Service.AuthorizeUser(p.Context, actor, p.Args["id"].(int), "delete", rbac.PermissionWriteAnyUser)
+	if problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+
+	// The deletion must be based on the user as it is now.
+	version, _ := p.Args["version"].(int)
+	if problemErr := r.server.versionProblem(version, fetchedUser.Version); problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+
+	if problemErr := r.server.DeleteUser(p.Context, actor, fetchedUser); problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+	return true, nil
+}
+
+func (r *graphqlResolvers) createBook(p graphql.ResolveParams) (interface{}, error) {
+	input := p.Args["input"].(map[string]interface{})
+	newBook := types.Book{}
+	newBook.Title, _ = input["title"].(string)
+	newBook.Edition, _ = input["edition"].(int)
+	newBook.Author, _ = input["author"].(string)
+	newBook.PagesCount, _ = input["pagesCount"].(int)
+	newBook.PagesRead, _ = input["pagesRead"].(int)
+
+	createdBook, problemErr := r.server.CreateBook(p.Context, graphqlRequestOf(p.Context).actor, &newBook)
+	if problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+	return createdBook, nil
+}
+
+func (r *graphqlResolvers) updateBook(p graphql.ResolveParams) (interface{}, error) {
+	actor := graphqlRequestOf(p.Context).actor
+	fetchedBook, problemErr := r.server.GetBook(p.Context, actor, p.Args["id"].(int), "update", rbac.PermissionWriteAnyBook)
+	if problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+
+	// The update must be based on the book as it is now.
+	version, _ := p.Args["version"].(int)
+	if problemErr := r.server.versionProblem(version, fetchedBook.Version); problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+
+	// Apply the fields given in the input, the same ones clients may patch over REST.
+	input := p.Args["input"].(map[string]interface{})
+	patchedBook := *fetchedBook
+	if title, ok := input["title"].(string); ok {
+		patchedBook.Title = title
+	}
+	if edition, ok := input["edition"].(int); ok {
+		patchedBook.Edition = edition
+	}
+	if author, ok := input["author"].(string); ok {
+		patchedBook.Author = author
+	}
+	if pagesCount, ok := input["pagesCount"].(int); ok {
+		patchedBook.PagesCount = pagesCount
+	}
+	if pagesRead, ok := input["pagesRead"].(int); ok {
+		patchedBook.PagesRead = pagesRead
+	}
+
+	updatedBook, problemErr := r.server.UpdateBook(p.Context, actor, fetchedBook, patchedBook)
+	if problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+	return updatedBook, nil
+}
+
+func (r *graphqlResolvers) deleteBook(p graphql.ResolveParams) (interface{}, error) {
+	actor := graphqlRequestOf(p.Context).actor
+	fetchedBook, problemErr := r.server.GetBook(p.Context, actor, p.Args["id"].(int), "delete", rbac.PermissionWriteAnyBook)
+	if problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+
+	// The deletion must be based on the book as it is now.
+	version, _ := p.Args["version"].(int)
+	if problemErr := r.server.versionProblem(version, fetchedBook.Version); problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+
+	if problemErr := r.server.DeleteBook(p.Context, actor, fetchedBook); problemErr != nil {
+		return nil, r.fail(p.Context, problemErr)
+	}
+	return true, nil
+}
+
+// How far along a user is with all of their books.
+type readingProgress struct {
+	Books         int
+	FinishedBooks int
+	PagesCount    int
+	PagesRead     int
+}
+
+func newReadingProgress(books []*types.Book) readingProgress {
+	progress := readingProgress{Books: len(books)}
+	for _, book := range books {
+		progress.PagesCount += book.PagesCount
+		progress.PagesRead += book.PagesRead
+		if book.PagesRead >= book.PagesCount {
+			progress.FinishedBooks++
+		}
+	}
+	return progress
+}
+
+// Returns the share of the pages that were read, in percent.
+func percentComplete(pagesRead int, pagesCount int) float64 {
+	if pagesCount <= 0 {
+		return 0
+	}
+	return float64(pagesRead) * 100 / float64(pagesCount)
+}
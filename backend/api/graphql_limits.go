@@ -0,0 +1,176 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// How many items a list field is assumed to return when it has no limit argument, nor a default for it.
+const graphqlListSizeEstimate = 20
+
+// Measures how deep an operation nests its fields and how costly it is to resolve.
+// Every field costs 1, plus the cost of its own fields, multiplied by the size of the list for list fields.
+// Introspection fields count like any other, its types nest as deep as the schema's and anyone may query them.
+type graphqlCost struct {
+	schema    *graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// Returns the problem if the operation of the document nests deeper or costs more than the server allows.
+// The document must have been validated, so that its fragments don't form cycles.
+func (s *Server) checkGraphQLLimits(schema *graphql.Schema, document *ast.Document, operationName string, variables map[string]interface{}) *problem.Error {
+	cost := graphqlCost{schema: schema, fragments: make(map[string]*ast.FragmentDefinition), variables: variables}
+
+	var operation *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		switch definition := definition.(type) {
+		case *ast.FragmentDefinition:
+			cost.fragments[definition.Name.Value] = definition
+		case *ast.OperationDefinition:
+			if operationName == "" || (definition.Name != nil && definition.Name.Value == operationName) {
+				operation = definition
+			}
+		}
+	}
+	// Let the executor report the missing operation.
+	if operation == nil {
+		return nil
+	}
+
+	root := schema.QueryType()
+	if operation.Operation == ast.OperationTypeMutation {
+		root = schema.MutationType()
+	}
+
+	depth, complexity := cost.selectionSet(root, operation.SelectionSet, 0)
+	if depth > s.GraphQLMaxDepth {
+		return problem.New(http.StatusBadRequest, problem.CodeQueryTooComplex,
+			fmt.Sprintf("query depth %d exceeds the limit of %d", depth, s.GraphQLMaxDepth))
+	}
+	if complexity > s.GraphQLMaxComplexity {
+		return problem.New(http.StatusBadRequest, problem.CodeQueryTooComplex,
+			fmt.Sprintf("query complexity %d exceeds the limit of %d", complexity, s.GraphQLMaxComplexity))
+	}
+	return nil
+}
+
+// Returns the depth and complexity of the selections on the parent type, nested at the depth.
+func (c *graphqlCost) selectionSet(parent *graphql.Object, selectionSet *ast.SelectionSet, depth int) (int, int) {
+	if parent == nil || selectionSet == nil {
+		return depth, 0
+	}
+
+	maxDepth, complexity := depth, 0
+	for _, selection := range selectionSet.Selections {
+		var selectionDepth, selectionComplexity int
+		switch selection := selection.(type) {
+		case *ast.Field:
+			selectionDepth, selectionComplexity = c.field(parent, selection, depth)
+		case *ast.InlineFragment:
+			selectionDepth, selectionComplexity = c.selectionSet(c.fragmentType(parent, selection.TypeCondition), selection.SelectionSet, depth)
+		case *ast.FragmentSpread:
+			if fragment, ok := c.fragments[selection.Name.Value]; ok {
+				selectionDepth, selectionComplexity = c.selectionSet(c.fragmentType(parent, fragment.TypeCondition), fragment.SelectionSet, depth)
+			}
+		}
+
+		if selectionDepth > maxDepth {
+			maxDepth = selectionDepth
+		}
+		complexity += selectionComplexity
+	}
+	return maxDepth, complexity
+}
+
+// Returns the depth and complexity of the field of the parent type.
+func (c *graphqlCost) field(parent *graphql.Object, field *ast.Field, depth int) (int, int) {
+	definition, ok := parent.Fields()[field.Name.Value]
+	if !ok {
+		definition, ok = c.metaField(parent, field.Name.Value)
+	}
+	if !ok {
+		return depth, 0
+	}
+
+	// Unwrap the type to the object the field's own fields are selected on, noting whether it is a list.
+	fieldType, isList := definition.Type, false
+	for {
+		if nonNull, ok := fieldType.(*graphql.NonNull); ok {
+			fieldType = nonNull.OfType
+		} else if list, ok := fieldType.(*graphql.List); ok {
+			fieldType, isList = list.OfType, true
+		} else {
+			break
+		}
+	}
+
+	object, _ := fieldType.(*graphql.Object)
+	fieldDepth, complexity := c.selectionSet(object, field.SelectionSet, depth+1)
+	if isList {
+		complexity *= c.listSize(definition, field)
+	}
+	return fieldDepth, 1 + complexity
+}
+
+// Returns the definition of the introspection field, which isn't among the fields of the parent type.
+func (c *graphqlCost) metaField(parent *graphql.Object, name string) (*graphql.FieldDefinition, bool) {
+	switch {
+	case name == graphql.TypeNameMetaFieldDef.Name:
+		return graphql.TypeNameMetaFieldDef, true
+	case name == graphql.SchemaMetaFieldDef.Name && parent == c.schema.QueryType():
+		return graphql.SchemaMetaFieldDef, true
+	case name == graphql.TypeMetaFieldDef.Name && parent == c.schema.QueryType():
+		return graphql.TypeMetaFieldDef, true
+	}
+	return nil, false
+}
+
+// Returns the type of the fragment, or the parent's if it has no type condition.
+func (c *graphqlCost) fragmentType(parent *graphql.Object, condition *ast.Named) *graphql.Object {
+	if condition == nil {
+		return parent
+	}
+	object, _ := c.schema.Type(condition.Name.Value).(*graphql.Object)
+	return object
+}
+
+// Returns the number of items the list field returns at most: its limit argument, or the default of the argument.
+func (c *graphqlCost) listSize(definition *graphql.FieldDefinition, field *ast.Field) int {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != "limit" {
+			continue
+		}
+
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			if limit, err := strconv.Atoi(value.Value); err == nil && limit > 0 {
+				return limit
+			}
+		case *ast.Variable:
+			// Variables decoded from JSON are numbers.
+			switch limit := c.variables[value.Name.Value].(type) {
+			case float64:
+				if limit > 0 {
+					return int(limit)
+				}
+			case int:
+				if limit > 0 {
+					return limit
+				}
+			}
+		}
+	}
+
+	for _, argument := range definition.Args {
+		if limit, ok := argument.DefaultValue.(int); ok && argument.Name() == "limit" && limit > 0 {
+			return limit
+		}
+	}
+	return graphqlListSizeEstimate
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"sync"
+
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+)
+
+// Batches the loads of the keys requested while resolving a GraphQL query into as few fetches as possible.
+// Resolvers call load, which returns a thunk; the executor calls the thunks of a level of the query only once
+// every field of the level was resolved, so the first thunk called fetches the keys of the whole level at once.
+type graphqlLoader[K comparable, V any] struct {
+	// Fetches the values of the keys. Keys without a value get the zero value.
+	fetch func(keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	pending []K
+	values  map[K]V
+	errs    map[K]error
+}
+
+func newGraphQLLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *graphqlLoader[K, V] {
+	return &graphqlLoader[K, V]{fetch: fetch, values: make(map[K]V), errs: make(map[K]error)}
+}
+
+// Queues the key for the next fetch, and returns a thunk returning its value.
+func (l *graphqlLoader[K, V]) load(key K) func() (V, error) {
+	l.mu.Lock()
+	if !l.loaded(key) && !l.isPending(key) {
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (V, error) { return l.get(key) }
+}
+
+// Returns the value of the key, fetching it along with every other pending key if it wasn't yet.
+func (l *graphqlLoader[K, V]) get(key K) (V, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.loaded(key) {
+		keys := l.pending
+		if !l.isPending(key) {
+			keys = append(keys, key)
+		}
+		l.pending = nil
+
+		values, err := l.fetch(keys)
+		for _, k := range keys {
+			if err != nil {
+				l.errs[k] = err
+				continue
+			}
+			l.values[k] = values[k]
+		}
+	}
+	return l.values[key], l.errs[key]
+}
+
+func (l *graphqlLoader[K, V]) loaded(key K) bool {
+	_, hasValue := l.values[key]
+	_, hasErr := l.errs[key]
+	return hasValue || hasErr
+}
+
+func (l *graphqlLoader[K, V]) isPending(key K) bool {
+	for _, k := range l.pending {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Loads the books of owners, see Storage.GetBooksByOwners.
+func newBooksByOwnerLoader(store storage.Storage) *graphqlLoader[int, []*types.Book] {
+	return newGraphQLLoader(func(ownerIDs []int) (map[int][]*types.Book, error) {
+		books, err := store.GetBooksByOwners(ownerIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		booksByOwner := make(map[int][]*types.Book, len(ownerIDs))
+		for _, id := range ownerIDs {
+			booksByOwner[id] = []*types.Book{}
+		}
+		for i := range *books {
+			book := &(*books)[i]
+			booksByOwner[book.OwnerID] = append(booksByOwner[book.OwnerID], book)
+		}
+		return booksByOwner, nil
+	})
+}
+
+// Loads users by id, see Storage.GetUsersByIDs. Users that don't exist are loaded as nil.
+func newUserLoader(store storage.Storage) *graphqlLoader[int, *types.User] {
+	return newGraphQLLoader(func(ids []int) (map[int]*types.User, error) {
+		users, err := store.GetUsersByIDs(ids)
+		if err != nil {
+			return nil, err
+		}
+
+		usersByID := make(map[int]*types.User, len(*users))
+		for i := range *users {
+			usersByID[(*users)[i].ID] = &(*users)[i]
+		}
+		return usersByID, nil
+	})
+}
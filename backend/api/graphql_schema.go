@@ -0,0 +1,250 @@
+package api
+
+import (
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/graphql-go/graphql"
+)
+
+// Returns the field resolving to the value the function gets from the user.
+func userField(fieldType graphql.Output, get func(user *types.User) interface{}) *graphql.Field {
+	return &graphql.Field{Type: fieldType, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return get(p.Source.(*types.User)), nil
+	}}
+}
+
+// Returns the field resolving to the value the function gets from the book.
+func bookField(fieldType graphql.Output, description string, get func(book *types.Book) interface{}) *graphql.Field {
+	return &graphql.Field{Type: fieldType, Description: description, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return get(p.Source.(*types.Book)), nil
+	}}
+}
+
+// Returns the field resolving to the value the function gets from the reading progress.
+func progressField(fieldType graphql.Output, description string, get func(progress readingProgress) interface{}) *graphql.Field {
+	return &graphql.Field{Type: fieldType, Description: description, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+		return get(p.Source.(readingProgress)), nil
+	}}
+}
+
+// Builds the schema of the users and books, resolved by the resolvers.
+func newGraphQLSchema(r *graphqlResolvers) (graphql.Schema, error) {
+	nonNullInt := graphql.NewNonNull(graphql.Int)
+	nonNullString := graphql.NewNonNull(graphql.String)
+
+	bookType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Book",
+		Description: "A book and the reading progress made in it.",
+		Fields: graphql.Fields{
+			"id":    bookField(nonNullInt, "", func(b *types.Book) interface{} { return b.ID }),
+			"title": bookField(nonNullString, "", func(b *types.Book) interface{} { return b.Title }),
+			"edition": bookField(graphql.Int, "Null if the edition isn't known.", func(b *types.Book) interface{} {
+				if b.Edition == 0 {
+					return nil
+				}
+				return b.Edition
+			}),
+			"author":     bookField(nonNullString, "", func(b *types.Book) interface{} { return b.Author }),
+			"pagesCount": bookField(nonNullInt, "", func(b *types.Book) interface{} { return b.PagesCount }),
+			"pagesRead":  bookField(nonNullInt, "", func(b *types.Book) interface{} { return b.PagesRead }),
+			"pagesRemaining": bookField(nonNullInt, "How many pages are left to read.", func(b *types.Book) interface{} {
+				return b.PagesCount - b.PagesRead
+			}),
+			"percentComplete": bookField(graphql.NewNonNull(graphql.Float), "The share of the pages that were read, from 0 to 100.", func(b *types.Book) interface{} {
+				return percentComplete(b.PagesRead, b.PagesCount)
+			}),
+			"finished": bookField(graphql.NewNonNull(graphql.Boolean), "Whether every page was read.", func(b *types.Book) interface{} {
+				return b.PagesRead >= b.PagesCount
+			}),
+			"ownerId":   bookField(nonNullInt, "", func(b *types.Book) interface{} { return b.OwnerID }),
+			"version":   bookField(nonNullInt, "Incremented by every update.", func(b *types.Book) interface{} { return b.Version }),
+			"createdAt": bookField(graphql.NewNonNull(graphql.DateTime), "", func(b *types.Book) interface{} { return b.CreatedAt }),
+			"updatedAt": bookField(graphql.NewNonNull(graphql.DateTime), "", func(b *types.Book) interface{} { return b.UpdatedAt }),
+		},
+	})
+
+	progressType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "ReadingProgress",
+		Description: "How far along a user is with all of their books.",
+		Fields: graphql.Fields{
+			"books": progressField(nonNullInt, "How many books the user has.", func(p readingProgress) interface{} { return p.Books }),
+			"finishedBooks": progressField(nonNullInt, "How many of them were read to the last page.", func(p readingProgress) interface{} {
+				return p.FinishedBooks
+			}),
+			"pagesCount": progressField(nonNullInt, "The pages of all the books.", func(p readingProgress) interface{} { return p.PagesCount }),
+			"pagesRead":  progressField(nonNullInt, "The pages read of all the books.", func(p readingProgress) interface{} { return p.PagesRead }),
+			"pagesRemaining": progressField(nonNullInt, "The pages left to read of all the books.", func(p readingProgress) interface{} {
+				return p.PagesCount - p.PagesRead
+			}),
+			"percentComplete": progressField(graphql.NewNonNull(graphql.Float), "The share of all the pages that were read, from 0 to 100.", func(p readingProgress) interface{} {
+				return percentComplete(p.PagesRead, p.PagesCount)
+			}),
+		},
+	})
+
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "User",
+		Description: "A user account. Password hashes are never sent.",
+		Fields: graphql.Fields{
+			"id":                    userField(nonNullInt, func(u *types.User) interface{} { return u.ID }),
+			"username":              userField(nonNullString, func(u *types.User) interface{} { return u.Username }),
+			"email":                 userField(nonNullString, func(u *types.User) interface{} { return u.Email }),
+			"role":                  userField(nonNullString, func(u *types.User) interface{} { return u.Role }),
+			"disabled":              userField(graphql.NewNonNull(graphql.Boolean), func(u *types.User) interface{} { return u.Disabled }),
+			"passwordResetRequired": userField(graphql.NewNonNull(graphql.Boolean), func(u *types.User) interface{} { return u.PasswordResetRequired }),
+			"version":               userField(nonNullInt, func(u *types.User) interface{} { return u.Version }),
+			"createdAt":             userField(graphql.NewNonNull(graphql.DateTime), func(u *types.User) interface{} { return u.CreatedAt }),
+			"updatedAt":             userField(graphql.NewNonNull(graphql.DateTime), func(u *types.User) interface{} { return u.UpdatedAt }),
+			"books": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(bookType)),
+				Description: "The books of the user. Users may see their own, seeing others' requires the permission to read any book.",
+				Resolve:     r.userBooks,
+			},
+			"progress": &graphql.Field{
+				Type:        progressType,
+				Description: "The reading progress of the user across their books, visible to those who may see the books.",
+				Resolve:     r.userProgress,
+			},
+		},
+	})
+
+	bookType.AddFieldConfig("owner", &graphql.Field{
+		Type:        userType,
+		Description: "The owner of the book. Owners may see themselves, seeing others requires the permission to read any user.",
+		Resolve:     r.bookOwner,
+	})
+
+	idArgs := graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: nonNullInt}}
+	versionArg := &graphql.ArgumentConfig{
+		Type:        graphql.Int,
+		Description: "The version the change is based on, rejected if it isn't current. Required if the server requires If-Match.",
+	}
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": &graphql.Field{
+				Type:        userType,
+				Description: "The authenticated user.",
+				Resolve:     r.me,
+			},
+			"user": &graphql.Field{
+				Type:        userType,
+				Description: "The user with the id. Users may see themselves, seeing others requires the permission to read any user.",
+				Args:        idArgs,
+				Resolve:     r.user,
+			},
+			"users": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(userType)),
+				Description: "A page of the users, for those who may manage them.",
+				Args: graphql.FieldConfigArgument{
+					"query":    &graphql.ArgumentConfig{Type: graphql.String, Description: "Case-insensitive substring of the usernames and emails."},
+					"role":     &graphql.ArgumentConfig{Type: graphql.String},
+					"disabled": &graphql.ArgumentConfig{Type: graphql.Boolean},
+					"offset":   &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+					"limit":    &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultPageSize, Description: "At most 200."},
+				},
+				Resolve: r.users,
+			},
+			"book": &graphql.Field{
+				Type:        bookType,
+				Description: "The book with the id. Owners may see their books, seeing others' requires the permission to read any book.",
+				Args:        idArgs,
+				Resolve:     r.book,
+			},
+			"books": &graphql.Field{
+				Type:        graphql.NewList(graphql.NewNonNull(bookType)),
+				Description: "The books of the authenticated user.",
+				Resolve:     r.books,
+			},
+		},
+	})
+
+	registerUserInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "RegisterUserInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"username": &graphql.InputObjectFieldConfig{Type: nonNullString},
+			"email":    &graphql.InputObjectFieldConfig{Type: nonNullString},
+			"password": &graphql.InputObjectFieldConfig{Type: nonNullString},
+		},
+	})
+	updateUserInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "UpdateUserInput",
+		Description: "The fields to update, those left out are kept.",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"username": &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"email":    &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"password": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		},
+	})
+	createBookInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "CreateBookInput",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"title":      &graphql.InputObjectFieldConfig{Type: nonNullString},
+			"edition":    &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"author":     &graphql.InputObjectFieldConfig{Type: nonNullString},
+			"pagesCount": &graphql.InputObjectFieldConfig{Type: nonNullInt},
+			"pagesRead":  &graphql.InputObjectFieldConfig{Type: graphql.Int, DefaultValue: 0},
+		},
+	})
+	updateBookInput := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name:        "UpdateBookInput",
+		Description: "The fields to update, those left out are kept.",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"title":      &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"edition":    &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"author":     &graphql.InputObjectFieldConfig{Type: graphql.String},
+			"pagesCount": &graphql.InputObjectFieldConfig{Type: graphql.Int},
+			"pagesRead":  &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"registerUser": &graphql.Field{
+				Type:        userType,
+				Description: "Creates the account of a new user. Needs no access token.",
+				Args:        graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(registerUserInput)}},
+				Resolve:     r.registerUser,
+			},
+			"updateUser": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: nonNullInt},
+					"input":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(updateUserInput)},
+					"version": versionArg,
+				},
+				Resolve: r.updateUser,
+			},
+			"deleteUser": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Deletes the user, who is purged with their books once the grace period is over.",
+				Args:        graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: nonNullInt}, "version": versionArg},
+				Resolve:     r.deleteUser,
+			},
+			"createBook": &graphql.Field{
+				Type:        bookType,
+				Description: "Creates a book owned by the authenticated user.",
+				Args:        graphql.FieldConfigArgument{"input": &graphql.ArgumentConfig{Type: graphql.NewNonNull(createBookInput)}},
+				Resolve:     r.createBook,
+			},
+			"updateBook": &graphql.Field{
+				Type: bookType,
+				Args: graphql.FieldConfigArgument{
+					"id":      &graphql.ArgumentConfig{Type: nonNullInt},
+					"input":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(updateBookInput)},
+					"version": versionArg,
+				},
+				Resolve: r.updateBook,
+			},
+			"deleteBook": &graphql.Field{
+				Type:        graphql.Boolean,
+				Description: "Moves the book to the trash, it is purged once the trash retention is over.",
+				Args:        graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: nonNullInt}, "version": versionArg},
+				Resolve:     r.deleteBook,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStorage counts the calls made to fetch books and users.
+type countingStorage struct {
+	*storage.MemoryStorage
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (s *countingStorage) count(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls[method]++
+}
+
+func (s *countingStorage) GetBooks(id int) (*[]types.Book, error) {
+	s.count("GetBooks")
+	return s.MemoryStorage.GetBooks(id)
+}
+
+func (s *countingStorage) GetBooksByOwners(ownerIDs []int) (*[]types.Book, error) {
+	s.count("GetBooksByOwners")
+	return s.MemoryStorage.GetBooksByOwners(ownerIDs)
+}
+
+func (s *countingStorage) GetUsersByIDs(ids []int) (*[]types.User, error) {
+	s.count("GetUsersByIDs")
+	return s.MemoryStorage.GetUsersByIDs(ids)
+}
+
+type graphqlResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Path       []interface{}          `json:"path"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+// Executes the query with the variables, and returns the decoded response.
+func doGraphQL(t *testing.T, server *Server, accessToken string, query string, variables map[string]interface{}) graphqlResponse {
+	w := doRequest(server, "POST", "/graphql", gin.H{"query": query, "variables": variables}, accessToken)
+	assert.Equal(t, 200, w.Code, w.Body.String())
+
+	var response graphqlResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return response
+}
+
+// Returns the codes of the errors of the response.
+func (r graphqlResponse) errorCodes() []interface{} {
+	codes := []interface{}{}
+	for _, err := range r.Errors {
+		codes = append(codes, err.Extensions["code"])
+	}
+	return codes
+}
+
+func TestGraphQL(t *testing.T) {
+	server, store := newMemoryServer(t)
+	admin := createTestUser(t, store, "admin", rbac.RoleAdmin)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	bar := createTestUser(t, store, "bar", rbac.RoleUser)
+	adminToken := accessTokenFor(t, store, admin)
+	fooToken := accessTokenFor(t, store, foo)
+	barToken := accessTokenFor(t, store, bar)
+
+	for _, book := range []*types.Book{
+		{Title: "Foo", Author: "Bar", PagesCount: 200, PagesRead: 50, OwnerID: foo.ID},
+		{Title: "Fizz", Author: "Buzz", PagesCount: 100, PagesRead: 100, OwnerID: foo.ID},
+		{Title: "Bar", Author: "Foo", PagesCount: 100, OwnerID: bar.ID},
+	} {
+		_, err := store.CreateBook(book)
+		assert.NoError(t, err)
+	}
+	fooBooks, err := store.GetBooks(foo.ID)
+	assert.NoError(t, err)
+	fooBook := (*fooBooks)[0]
+
+	t.Run("TestQueries", func(t *testing.T) {
+		response := doGraphQL(t, server, fooToken, `{
+			me {
+				username
+				progress { books finishedBooks pagesCount pagesRead pagesRemaining percentComplete }
+				books { title edition percentComplete pagesRemaining finished owner { username } }
+			}
+		}`, nil)
+		assert.Empty(t, response.Errors)
+
+		me := response.Data["me"].(map[string]interface{})
+		assert.Equal(t, "foo", me["username"])
+		assert.Equal(t, map[string]interface{}{
+			"books": 2.0, "finishedBooks": 1.0, "pagesCount": 300.0, "pagesRead": 150.0, "pagesRemaining": 150.0, "percentComplete": 50.0,
+		}, me["progress"])
+		assert.Equal(t, []interface{}{
+			map[string]interface{}{"title": "Foo", "edition": nil, "percentComplete": 25.0, "pagesRemaining": 150.0, "finished": false, "owner": map[string]interface{}{"username": "foo"}},
+			map[string]interface{}{"title": "Fizz", "edition": nil, "percentComplete": 100.0, "pagesRemaining": 0.0, "finished": true, "owner": map[string]interface{}{"username": "foo"}},
+		}, me["books"])
+
+		response = doGraphQL(t, server, fooToken, `query Book($id: Int!) { book(id: $id) { title } }`, map[string]interface{}{"id": fooBook.ID})
+		assert.Empty(t, response.Errors)
+		assert.Equal(t, map[string]interface{}{"title": "Foo"}, response.Data["book"])
+
+		// Introspection is available to tools.
+		response = doGraphQL(t, server, "", `{ __schema { queryType { name } mutationType { name } } }`, nil)
+		assert.Empty(t, response.Errors)
+	})
+
+	t.Run("TestBatching", func(t *testing.T) {
+		counter := &countingStorage{MemoryStorage: store, calls: make(map[string]int)}
+		server.Storer = counter
+		defer func() { server.Storer = store }()
+
+		// The books of every user are fetched at once, and then the owners of every book.
+		response := doGraphQL(t, server, adminToken, `{ users(limit: 5) { username books { title owner { username } } progress { books } } }`, nil)
+		assert.Empty(t, response.Errors)
+		assert.Len(t, response.Data["users"], 3)
+		assert.Equal(t, map[string]int{"GetBooksByOwners": 1, "GetUsersByIDs": 1}, counter.calls)
+	})
+
+	t.Run("TestAuthorization", func(t *testing.T) {
+		// Users can't see others, nor their books.
+		response := doGraphQL(t, server, barToken, fmt.Sprintf(`{ user(id: %d) { username } book(id: %d) { title } }`, foo.ID, fooBook.ID), nil)
+		assert.Equal(t, map[string]interface{}{"user": nil, "book": nil}, response.Data)
+		assert.Equal(t, []interface{}{"forbidden", "forbidden"}, response.errorCodes())
+
+		response = doGraphQL(t, server, barToken, `{ users { username } }`, nil)
+		assert.Equal(t, []interface{}{"forbidden"}, response.errorCodes())
+
+		// Administrators can see anyone's books, and their owners.
+		response = doGraphQL(t, server, adminToken, fmt.Sprintf(`{ user(id: %d) { books { title } } book(id: %d) { owner { username } } }`, foo.ID, fooBook.ID), nil)
+		assert.Empty(t, response.Errors)
+		assert.Equal(t, map[string]interface{}{"username": "foo"}, response.Data["book"].(map[string]interface{})["owner"])
+
+		// Without an access token, only registering succeeds.
+		response = doGraphQL(t, server, "", `{ me { username } books { title } }`, nil)
+		assert.Equal(t, []interface{}{"unauthenticated", "unauthenticated"}, response.errorCodes())
+
+		// Invalid access tokens are rejected like over REST.
+		w := doRequest(server, "POST", "/graphql", gin.H{"query": "{ me { username } }"}, "invalid")
+		assert.Equal(t, 401, w.Code)
+		w = doRequest(server, "POST", "/graphql", gin.H{}, fooToken)
+		assert.Equal(t, 400, w.Code)
+	})
+
+	t.Run("TestMutations", func(t *testing.T) {
+		response := doGraphQL(t, server, "", `mutation Register($input: RegisterUserInput!) { registerUser(input: $input) { username role } }`,
+			map[string]interface{}{"input": map[string]interface{}{"username": "buzz", "email": "buzz@bar.com", "password": "correct horse battery staple"}})
+		assert.Empty(t, response.Errors)
+		assert.Equal(t, map[string]interface{}{"username": "buzz", "role": "user"}, response.Data["registerUser"])
+
+		response = doGraphQL(t, server, barToken, `mutation { createBook(input: {title: "Fizz", author: "Buzz", pagesCount: 10}) { id pagesRead ownerId } }`, nil)
+		assert.Empty(t, response.Errors)
+		created := response.Data["createBook"].(map[string]interface{})
+		assert.Equal(t, float64(bar.ID), created["ownerId"])
+		bookID := int(created["id"].(float64))
+
+		response = doGraphQL(t, server, barToken, fmt.Sprintf(`mutation { updateBook(id: %d, input: {pagesRead: 10}) { title finished } }`, bookID), nil)
+		assert.Empty(t, response.Errors)
+		assert.Equal(t, map[string]interface{}{"title": "Fizz", "finished": true}, response.Data["updateBook"])
+
+		// Invalid fields are named as in the schema.
+		response = doGraphQL(t, server, barToken, fmt.Sprintf(`mutation { updateBook(id: %d, input: {pagesRead: 11}) { title } }`, bookID), nil)
+		assert.Equal(t, []interface{}{"validation_failed"}, response.errorCodes())
+		assert.Equal(t, "pagesRead", response.Errors[0].Extensions["fields"].([]interface{})[0].(map[string]interface{})["field"])
+
+		response = doGraphQL(t, server, fooToken, fmt.Sprintf(`mutation { deleteBook(id: %d) }`, bookID), nil)
+		assert.Equal(t, []interface{}{"forbidden"}, response.errorCodes())
+
+		// Changes based on an outdated version are rejected.
+		response = doGraphQL(t, server, barToken, fmt.Sprintf(`mutation { updateBook(id: %d, input: {title: "Fuzz"}, version: 1) { title } }`, bookID), nil)
+		assert.Equal(t, []interface{}{"precondition_failed"}, response.errorCodes())
+		response = doGraphQL(t, server, barToken, fmt.Sprintf(`mutation { updateUser(id: %d, input: {username: "fizz"}, version: 1000) { username } }`, bar.ID), nil)
+		assert.Equal(t, []interface{}{"precondition_failed"}, response.errorCodes())
+
+		// The version must be sent if the server requires If-Match.
+		server.RequireIfMatch = true
+		defer func() { server.RequireIfMatch = false }()
+		response = doGraphQL(t, server, barToken, fmt.Sprintf(`mutation { deleteBook(id: %d) }`, bookID), nil)
+		assert.Equal(t, []interface{}{"precondition_required"}, response.errorCodes())
+
+		response = doGraphQL(t, server, barToken, fmt.Sprintf(`mutation { deleteBook(id: %d, version: 2) }`, bookID), nil)
+		assert.Empty(t, response.Errors)
+		assert.Equal(t, true, response.Data["deleteBook"])
+		deletedBook, err := store.GetBook(bookID)
+		assert.NoError(t, err)
+		assert.Nil(t, deletedBook)
+	})
+
+	t.Run("TestLimits", func(t *testing.T) {
+		// Nine levels deep, one more than allowed.
+		response := doGraphQL(t, server, fooToken, `{ me { books { owner { books { owner { books { owner { books { title } } } } } } } } }`, nil)
+		assert.Nil(t, response.Data)
+		assert.Equal(t, []interface{}{"query_too_complex"}, response.errorCodes())
+		assert.Contains(t, response.Errors[0].Message, "depth 9")
+
+		// Fragments count as much as the fields they spread.
+		response = doGraphQL(t, server, fooToken, `
+			query { me { ...books } }
+			fragment books on User { books { owner { books { owner { books { owner { books { title } } } } } } } }`, nil)
+		assert.Equal(t, []interface{}{"query_too_complex"}, response.errorCodes())
+
+		// Lists multiply the cost of their fields by their size.
+		response = doGraphQL(t, server, adminToken, `query Users($limit: Int) { users(limit: $limit) { books { title author } } }`, map[string]interface{}{"limit": 30})
+		assert.Equal(t, []interface{}{"query_too_complex"}, response.errorCodes())
+		assert.Contains(t, response.Errors[0].Message, "complexity 1231")
+
+		response = doGraphQL(t, server, adminToken, `{ users(limit: 10) { books { title author } } }`, nil)
+		assert.Empty(t, response.Errors)
+
+		// Introspection counts like any other query, so nesting it can't get around the limits.
+		response = doGraphQL(t, server, "", `{ __schema { types { a: fields { type { fields { type { fields { type { name } } } } } } } } }`, nil)
+		assert.Nil(t, response.Data)
+		assert.Equal(t, []interface{}{"query_too_complex"}, response.errorCodes())
+		response = doGraphQL(t, server, "", `{ __schema { types { name fields { name type { name } } } } }`, nil)
+		assert.Equal(t, []interface{}{"query_too_complex"}, response.errorCodes())
+		assert.Contains(t, response.Errors[0].Message, "complexity 1242")
+
+		// Bodies are limited in size.
+		w := doRequest(server, "POST", "/graphql", gin.H{"query": "{ me { username } }" + strings.Repeat(" ", graphqlMaxBodySize)}, fooToken)
+		assert.Equal(t, 413, w.Code)
+	})
+}
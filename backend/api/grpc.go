@@ -0,0 +1,452 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/booktrackerpb"
+	"github.com/declanl482/go-book-tracker-app/backend/logging"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/service"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Methods that may be called without an access token.
+var publicGRPCMethods = map[string]bool{
+	booktrackerpb.UserService_RegisterUser_FullMethodName: true,
+}
+
+// Context key of the user authenticated by the access token of a gRPC call.
+type grpcUserKey struct{}
+
+// Listens on the gRPC listen address and serves gRPC calls until the server is shut down.
+func (s *Server) StartGRPC() error {
+	listener, err := net.Listen("tcp", s.GRPCListenAddress)
+	if err != nil {
+		return err
+	}
+	return s.ServeGRPC(listener)
+}
+
+// Serves the gRPC API on the listener until the server is shut down, returns nil once it has been.
+// The background work is run by Serve, which must be serving the REST API alongside.
+func (s *Server) ServeGRPC(listener net.Listener) error {
+	s.mu.Lock()
+	if s.grpcServer != nil {
+		s.mu.Unlock()
+		return errors.New("gRPC server already started")
+	}
+	grpcServer, err := s.NewGRPCServer()
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.grpcServer = grpcServer
+	s.mu.Unlock()
+
+	s.Logger.Info("listening for gRPC calls", "address", listener.Addr().String(), "tls", s.TLSCertFile != "" && s.TLSKeyFile != "")
+	if err := grpcServer.Serve(listener); !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// Returns a gRPC server with the user and book services and server reflection registered.
+// It serves TLS with the same certificate as the REST API if one is configured.
+func (s *Server) NewGRPCServer() (*grpc.Server, error) {
+	options := []grpc.ServerOption{grpc.ChainUnaryInterceptor(s.grpcLoggingInterceptor, s.grpcAuthInterceptor)}
+	if s.TLSCertFile != "" && s.TLSKeyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(s.TLSCertFile, s.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+
+	grpcServer := grpc.NewServer(options...)
+	booktrackerpb.RegisterUserServiceServer(grpcServer, &grpcUserService{server: s})
+	booktrackerpb.RegisterBookServiceServer(grpcServer, &grpcBookService{server: s})
+
+	// Let clients such as grpcurl discover the services.
+	reflection.Register(grpcServer)
+	return grpcServer, nil
+}
+
+// Interceptor to assign every call a request id, log it once it has been handled,
+// and translate the problems returned by the handlers into statuses.
+func (s *Server) grpcLoggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (response interface{}, err error) {
+	start := time.Now()
+
+	requestID := firstMetadataValue(ctx, strings.ToLower(requestIDHeader))
+	if !isValidRequestID(requestID) {
+		requestID = newRequestID()
+	}
+	ctx = logging.WithRequestID(ctx, requestID)
+	grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(requestIDHeader), requestID))
+
+	defer func() {
+		// Panics in handlers fail the call rather than the server.
+		if recovered := recover(); recovered != nil {
+			s.Logger.ErrorContext(ctx, "panic while handling gRPC call", "panic", recovered, "method", info.FullMethod)
+			err = problem.Internal("internal server error", fmt.Errorf("panic: %v", recovered))
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", info.FullMethod),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		}
+		level := slog.LevelInfo
+		if err != nil {
+			// Log the error with its cause, which isn't sent to the client.
+			attrs = append(attrs, slog.String("error", err.Error()))
+			var problemErr *problem.Error
+			if errors.As(err, &problemErr) {
+				err = grpcStatus(problemErr).Err()
+			}
+			switch status.Code(err) {
+			case codes.Internal, codes.Unknown, codes.Unavailable:
+				level = slog.LevelError
+			default:
+				level = slog.LevelWarn
+			}
+		}
+		attrs = append(attrs, slog.String("code", status.Code(err).String()))
+		s.Logger.LogAttrs(ctx, level, "grpc call", attrs...)
+	}()
+
+	return handler(ctx, req)
+}
+
+// Interceptor to validate the access token sent in the authorization metadata and set the current user in the context.
+// The same checks as RequireValidAccessToken apply.
+func (s *Server) grpcAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if publicGRPCMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	authorization := firstMetadataValue(ctx, "authorization")
+	if authorization == "" {
+		return nil, problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "access token required")
+	}
+	accessToken := strings.TrimPrefix(authorization, "Bearer ")
+
+	user, session, problemErr := s.authenticate(ctx, accessToken)
+	if problemErr != nil {
+		return nil, problemErr
+	}
+
+	// Until a forced password reset is completed, the user may only change their own account.
+	if update, ok := req.(*booktrackerpb.UpdateUserRequest); user.PasswordResetRequired && (!ok || update.Id != int64(user.ID)) {
+		return nil, passwordResetRequired()
+	}
+
+	// Record the session activity; it is written to the database in batches.
+	s.Sessions.Touch(session.ID, time.Now())
+
+	response, err := handler(context.WithValue(ctx, grpcUserKey{}, user), req)
+
+	// The REST API answers acting on others' records with 401, but the caller is authenticated, they just may not.
+	if errors.As(err, &problemErr) && problemErr.Status == http.StatusUnauthorized {
+		denied := *problemErr
+		denied.Status = http.StatusForbidden
+		denied.Code = problem.CodeForbidden
+		err = &denied
+	}
+	return response, err
+}
+
+// Returns the first value of the incoming metadata key, or "" if it wasn't sent.
+func firstMetadataValue(ctx context.Context, key string) string {
+	if values := metadata.ValueFromIncomingContext(ctx, key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Returns the current user, if any, and the details of the call, who the service performs operations for.
+func grpcActor(ctx context.Context) service.Actor {
+	actor := service.Actor{
+		UserAgent: firstMetadataValue(ctx, "user-agent"),
+		RequestID: logging.RequestID(ctx),
+	}
+	actor.User, _ = ctx.Value(grpcUserKey{}).(*types.User)
+	if p, ok := peer.FromContext(ctx); ok {
+		actor.IP, _, _ = net.SplitHostPort(p.Addr.String())
+	}
+	return actor
+}
+
+// Returns the status of the problem. Validation problems carry the invalid fields as BadRequest details.
+func grpcStatus(problemErr *problem.Error) *status.Status {
+	var code codes.Code
+	switch {
+	case problemErr.Code == problem.CodeEmailTaken || problemErr.Status == http.StatusConflict:
+		code = codes.AlreadyExists
+	case problemErr.Status == http.StatusPreconditionFailed:
+		code = codes.Aborted
+	case problemErr.Status == http.StatusPreconditionRequired:
+		code = codes.FailedPrecondition
+	case problemErr.Status == http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case problemErr.Status == http.StatusForbidden:
+		code = codes.PermissionDenied
+	case problemErr.Status == http.StatusNotFound:
+		code = codes.NotFound
+	case problemErr.Status == http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case problemErr.Status == http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case problemErr.Status >= http.StatusInternalServerError:
+		code = codes.Internal
+	default:
+		code = codes.InvalidArgument
+	}
+
+	grpcStatus := status.New(code, problemErr.Detail)
+	if len(problemErr.Fields) == 0 {
+		return grpcStatus
+	}
+	badRequest := &errdetails.BadRequest{}
+	for _, field := range problemErr.Fields {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field.Field,
+			Description: field.Message,
+		})
+	}
+	if detailed, err := grpcStatus.WithDetails(badRequest); err == nil {
+		return detailed
+	}
+	return grpcStatus
+}
+
+// Returns the problem of an update mask path that can't be updated.
+func updateMaskProblem(path string) *problem.Error {
+	return problem.Validation(problem.FieldError{Field: "update_mask", Code: "oneof", Message: "cannot update " + path})
+}
+
+// Returns the message of the user, without their password hash.
+func userMessage(user *types.User) *booktrackerpb.User {
+	return &booktrackerpb.User{
+		Id:                    int64(user.ID),
+		Username:              user.Username,
+		Email:                 user.Email,
+		Role:                  user.Role,
+		Disabled:              user.Disabled,
+		PasswordResetRequired: user.PasswordResetRequired,
+		Version:               int64(user.Version),
+		CreatedAt:             timestamppb.New(user.CreatedAt),
+		UpdatedAt:             timestamppb.New(user.UpdatedAt),
+	}
+}
+
+// Returns the message of the book.
+func bookMessage(book *types.Book) *booktrackerpb.Book {
+	return &booktrackerpb.Book{
+		Id:         int64(book.ID),
+		Title:      book.Title,
+		Edition:    int32(book.Edition),
+		Author:     book.Author,
+		PagesCount: int32(book.PagesCount),
+		PagesRead:  int32(book.PagesRead),
+		OwnerId:    int64(book.OwnerID),
+		Version:    int64(book.Version),
+		CreatedAt:  timestamppb.New(book.CreatedAt),
+		UpdatedAt:  timestamppb.New(book.UpdatedAt),
+	}
+}
+
+// Implements the UserService with the operations of the service.
+type grpcUserService struct {
+	booktrackerpb.UnimplementedUserServiceServer
+	server *Server
+}
+
+func (g *grpcUserService) RegisterUser(ctx context.Context, req *booktrackerpb.RegisterUserRequest) (*booktrackerpb.User, error) {
+	newUser := types.User{Username: req.Username, Email: req.Email, Password: req.Password}
+	createdUser, problemErr := g.server.RegisterUser(ctx, grpcActor(ctx), &newUser)
+	if problemErr != nil {
+		return nil, problemErr
+	}
+	return userMessage(createdUser), nil
+}
+
+func (g *grpcUserService) GetUser(ctx context.Context, req *booktrackerpb.GetUserRequest) (*booktrackerpb.User, error) {
+	fetchedUser, problemErr := g.server.Service.AuthorizeUser(ctx, grpcActor(ctx), int(req.Id), "view", rbac.PermissionReadAnyUser)
+	if problemErr != nil {
+		return nil, problemErr
+	}
+	return userMessage(fetchedUser), nil
+}
+
+func (g *grpcUserService) UpdateUser(ctx context.Context, req *booktrackerpb.UpdateUserRequest) (*booktrackerpb.User, error) {
+	actor := grpcActor(ctx)
+	fetchedUser, problemErr := g.server.Service.AuthorizeUser(ctx, actor, int(req.Id), "update", rbac.PermissionWriteAnyUser)
+	if problemErr != nil {
+		return nil, problemErr
+	}
+
+	// The update must be based on the user as it is now.
+	if problemErr := g.server.versionProblem(int(req.Version), fetchedUser.Version); problemErr != nil {
+		return nil, problemErr
+	}
+
+	// Apply the fields named by the update mask, the same ones clients may patch over REST.
+	patchedUser := *fetchedUser
+	if len(req.GetUpdateMask().GetPaths()) == 0 {
+		return nil, problem.Validation(problem.FieldError{Field: "update_mask", Code: "required", Message: "is required"})
+	}
+	for _, path := range req.UpdateMask.Paths {
+		switch path {
+		case "username":
+			patchedUser.Username = req.Username
+		case "email":
+			patchedUser.Email = req.Email
+		case "password":
+			patchedUser.Password = req.Password
+		default:
+			return nil, updateMaskProblem(path)
+		}
+	}
+
+	updatedUser, problemErr := g.server.UpdateUser(ctx, actor, fetchedUser, patchedUser)
+	if problemErr != nil {
+		return nil, problemErr
+	}
+	return userMessage(updatedUser), nil
+}
+
+func (g *grpcUserService) DeleteUser(ctx context.Context, req *booktrackerpb.DeleteUserRequest) (*emptypb.Empty, error) {
+	actor := grpcActor(ctx)
+	fetchedUser, problemErr := g.server.Service.AuthorizeUser(ctx, actor, int(req.Id), "delete", rbac.PermissionWriteAnyUser)
+	if problemErr != nil {
+		return nil, problemErr
+	}
+
+	// The deletion must be based on the user as it is now.
+	if problemErr := g.server.versionProblem(int(req.Version), fetchedUser.Version); problemErr != nil {
+		return nil, problemErr
+	}
+
+	if problemErr := g.server.DeleteUser(ctx, actor, fetchedUser); problemErr != nil {
+		return nil, problemErr
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Implements the BookService with the operations of the service.
+type grpcBookService struct {
+	booktrackerpb.UnimplementedBookServiceServer
+	server *Server
+}
+
+func (g *grpcBookService) CreateBook(ctx context.Context, req *booktrackerpb.CreateBookRequest) (*booktrackerpb.Book, error) {
+	newBook := types.Book{
+		Title:      req.Title,
+		Edition:    int(req.Edition),
+		Author:     req.Author,
+		PagesCount: int(req.PagesCount),
+		PagesRead:  int(req.PagesRead),
+	}
+	createdBook, problemErr := g.server.CreateBook(ctx, grpcActor(ctx), &newBook)
+	if problemErr != nil {
+		return nil, problemErr
+	}
+	return bookMessage(createdBook), nil
+}
+
+func (g *grpcBookService) ListBooks(ctx context.Context, req *booktrackerpb.ListBooksRequest) (*booktrackerpb.ListBooksResponse, error) {
+	books, problemErr := g.server.ListBooks(ctx, grpcActor(ctx))
+	if problemErr != nil {
+		return nil, problemErr
+	}
+
+	response := &booktrackerpb.ListBooksResponse{Books: make([]*booktrackerpb.Book, 0, len(*books))}
+	for i := range *books {
+		response.Books = append(response.Books, bookMessage(&(*books)[i]))
+	}
+	return response, nil
+}
+
+func (g *grpcBookService) GetBook(ctx context.Context, req *booktrackerpb.GetBookRequest) (*booktrackerpb.Book, error) {
+	fetchedBook, problemErr := g.server.GetBook(ctx, grpcActor(ctx), int(req.Id), "view", rbac.PermissionReadAnyBook)
+	if problemErr != nil {
+		return nil, problemErr
+	}
+	return bookMessage(fetchedBook), nil
+}
+
+func (g *grpcBookService) UpdateBook(ctx context.Context, req *booktrackerpb.UpdateBookRequest) (*booktrackerpb.Book, error) {
+	actor := grpcActor(ctx)
+	fetchedBook, problemErr := g.server.GetBook(ctx, actor, int(req.Id), "update", rbac.PermissionWriteAnyBook)
+	if problemErr != nil {
+		return nil, problemErr
+	}
+
+	// The update must be based on the book as it is now.
+	if problemErr := g.server.versionProblem(int(req.Version), fetchedBook.Version); problemErr != nil {
+		return nil, problemErr
+	}
+
+	// Apply the fields named by the update mask, the same ones clients may patch over REST.
+	patchedBook := *fetchedBook
+	if len(req.GetUpdateMask().GetPaths()) == 0 {
+		return nil, problem.Validation(problem.FieldError{Field: "update_mask", Code: "required", Message: "is required"})
+	}
+	for _, path := range req.UpdateMask.Paths {
+		switch path {
+		case "title":
+			patchedBook.Title = req.Title
+		case "edition":
+			patchedBook.Edition = int(req.Edition)
+		case "author":
+			patchedBook.Author = req.Author
+		case "pages_count":
+			patchedBook.PagesCount = int(req.PagesCount)
+		case "pages_read":
+			patchedBook.PagesRead = int(req.PagesRead)
+		default:
+			return nil, updateMaskProblem(path)
+		}
+	}
+
+	updatedBook, problemErr := g.server.UpdateBook(ctx, actor, fetchedBook, patchedBook)
+	if problemErr != nil {
+		return nil, problemErr
+	}
+	return bookMessage(updatedBook), nil
+}
+
+func (g *grpcBookService) DeleteBook(ctx context.Context, req *booktrackerpb.DeleteBookRequest) (*emptypb.Empty, error) {
+	actor := grpcActor(ctx)
+	fetchedBook, problemErr := g.server.GetBook(ctx, actor, int(req.Id), "delete", rbac.PermissionWriteAnyBook)
+	if problemErr != nil {
+		return nil, problemErr
+	}
+
+	// The deletion must be based on the book as it is now.
+	if problemErr := g.server.versionProblem(int(req.Version), fetchedBook.Version); problemErr != nil {
+		return nil, problemErr
+	}
+
+	if problemErr := g.server.DeleteBook(ctx, actor, fetchedBook); problemErr != nil {
+		return nil, problemErr
+	}
+	return &emptypb.Empty{}, nil
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/booktrackerpb"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// Serves the server's gRPC API in memory and returns a connection to it.
+func dialGRPC(t *testing.T, server *Server) *grpc.ClientConn {
+	listener := bufconn.Listen(1 << 20)
+	grpcServer, err := server.NewGRPCServer()
+	assert.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Returns a context sending the access token with the calls made with it.
+func withAccessToken(accessToken string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+accessToken)
+}
+
+func TestGRPC(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	bar := createTestUser(t, store, "bar", rbac.RoleUser)
+	fooCtx := withAccessToken(accessTokenFor(t, store, foo))
+	barCtx := withAccessToken(accessTokenFor(t, store, bar))
+	auditor := &recordingAuditor{}
+	server.Auditor = auditor
+
+	conn := dialGRPC(t, server)
+	users := booktrackerpb.NewUserServiceClient(conn)
+	books := booktrackerpb.NewBookServiceClient(conn)
+
+	// The same books are managed over gRPC as over REST.
+	book, err := books.CreateBook(fooCtx, &booktrackerpb.CreateBookRequest{Title: "Foo", Author: "Bar", PagesCount: 100, PagesRead: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(foo.ID), book.OwnerId)
+	bookPath := fmt.Sprintf("/v1/books/%d", book.Id)
+	assert.Equal(t, 200, doRequest(server, "GET", bookPath, nil, accessTokenFor(t, store, foo)).Code)
+
+	book, err = books.UpdateBook(fooCtx, &booktrackerpb.UpdateBookRequest{
+		Id: book.Id, Title: "ignored", PagesRead: 50,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"pages_read"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(50), book.PagesRead)
+	assert.Equal(t, "Foo", book.Title)
+
+	listed, err := books.ListBooks(fooCtx, &booktrackerpb.ListBooksRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, listed.Books, 1)
+	assert.Equal(t, []string{"book.create", "book.update"}, auditor.actions())
+
+	t.Run("TestAuthentication", func(t *testing.T) {
+		_, err := books.ListBooks(context.Background(), &booktrackerpb.ListBooksRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		_, err = books.ListBooks(withAccessToken("invalid"), &booktrackerpb.ListBooksRequest{})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		// Registering needs no access token.
+		registered, err := users.RegisterUser(context.Background(), &booktrackerpb.RegisterUserRequest{
+			Username: "baz", Email: "baz@example.com", Password: "correct horse battery staple",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, rbac.RoleUser, registered.Role)
+
+		_, err = users.RegisterUser(context.Background(), &booktrackerpb.RegisterUserRequest{
+			Username: "baz", Email: "baz@example.com", Password: "correct horse battery staple",
+		})
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	})
+
+	t.Run("TestAuthorization", func(t *testing.T) {
+		// Users can't see or change the books and accounts of others.
+		_, err := books.GetBook(barCtx, &booktrackerpb.GetBookRequest{Id: book.Id})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = books.DeleteBook(barCtx, &booktrackerpb.DeleteBookRequest{Id: book.Id})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		_, err = users.GetUser(barCtx, &booktrackerpb.GetUserRequest{Id: int64(foo.ID)})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		_, err = books.GetBook(barCtx, &booktrackerpb.GetBookRequest{Id: 1000})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+
+		fetched, err := users.GetUser(barCtx, &booktrackerpb.GetUserRequest{Id: int64(bar.ID)})
+		assert.NoError(t, err)
+		assert.Equal(t, "bar", fetched.Username)
+	})
+
+	t.Run("TestValidation", func(t *testing.T) {
+		// Invalid fields are reported in the status details.
+		_, err := books.UpdateBook(fooCtx, &booktrackerpb.UpdateBookRequest{
+			Id: book.Id, PagesRead: 101,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"pages_read"}},
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		details := status.Convert(err).Details()
+		assert.Len(t, details, 1)
+		badRequest, ok := details[0].(*errdetails.BadRequest)
+		assert.True(t, ok)
+		assert.Equal(t, "pages_read", badRequest.FieldViolations[0].Field)
+
+		_, err = books.UpdateBook(fooCtx, &booktrackerpb.UpdateBookRequest{
+			Id:         book.Id,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"owner_id"}},
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = books.CreateBook(fooCtx, &booktrackerpb.CreateBookRequest{Author: "Bar", PagesCount: 10})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		_, err = users.UpdateUser(fooCtx, &booktrackerpb.UpdateUserRequest{
+			Id: int64(foo.ID), Password: "foo",
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"password"}},
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("TestVersions", func(t *testing.T) {
+		// Changes based on an outdated version are aborted.
+		_, err := books.UpdateBook(fooCtx, &booktrackerpb.UpdateBookRequest{
+			Id: book.Id, PagesRead: 60, Version: book.Version - 1,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"pages_read"}},
+		})
+		assert.Equal(t, codes.Aborted, status.Code(err))
+		_, err = books.DeleteBook(fooCtx, &booktrackerpb.DeleteBookRequest{Id: book.Id, Version: book.Version - 1})
+		assert.Equal(t, codes.Aborted, status.Code(err))
+		_, err = users.UpdateUser(fooCtx, &booktrackerpb.UpdateUserRequest{
+			Id: int64(foo.ID), Username: "fizz", Version: 1000,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"username"}},
+		})
+		assert.Equal(t, codes.Aborted, status.Code(err))
+		_, err = users.DeleteUser(fooCtx, &booktrackerpb.DeleteUserRequest{Id: int64(foo.ID), Version: 1000})
+		assert.Equal(t, codes.Aborted, status.Code(err))
+
+		// The version must be sent if the server requires If-Match.
+		server.RequireIfMatch = true
+		defer func() { server.RequireIfMatch = false }()
+		_, err = books.UpdateBook(fooCtx, &booktrackerpb.UpdateBookRequest{
+			Id: book.Id, PagesRead: 60,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"pages_read"}},
+		})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		_, err = users.DeleteUser(fooCtx, &booktrackerpb.DeleteUserRequest{Id: int64(foo.ID)})
+		assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+		updated, err := books.UpdateBook(fooCtx, &booktrackerpb.UpdateBookRequest{
+			Id: book.Id, PagesRead: 60, Version: book.Version,
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"pages_read"}},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, book.Version+1, updated.Version)
+	})
+
+	t.Run("TestDelete", func(t *testing.T) {
+		_, err := books.DeleteBook(fooCtx, &booktrackerpb.DeleteBookRequest{Id: book.Id})
+		assert.NoError(t, err)
+		_, err = books.GetBook(fooCtx, &booktrackerpb.GetBookRequest{Id: book.Id})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		assert.Equal(t, 404, doRequest(server, "GET", bookPath, nil, accessTokenFor(t, store, foo)).Code)
+	})
+
+	t.Run("TestReflection", func(t *testing.T) {
+		stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+		assert.NoError(t, err)
+		assert.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+		}))
+		response, err := stream.Recv()
+		assert.NoError(t, err)
+
+		services := []string{}
+		for _, service := range response.GetListServicesResponse().GetService() {
+			services = append(services, service.Name)
+		}
+		assert.Contains(t, services, "booktracker.v1.UserService")
+		assert.Contains(t, services, "booktracker.v1.BookService")
+	})
+}
+
+func TestGRPCTLS(t *testing.T) {
+	server, _ := newMemoryServer(t)
+
+	// Issue a self-signed certificate for the in-memory listener.
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"bufnet"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	server.TLSCertFile = filepath.Join(dir, "cert.pem")
+	server.TLSKeyFile = filepath.Join(dir, "key.pem")
+	assert.NoError(t, os.WriteFile(server.TLSCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0o600))
+	assert.NoError(t, os.WriteFile(server.TLSKeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+
+	listener := bufconn.Listen(1 << 20)
+	grpcServer, err := server.NewGRPCServer()
+	assert.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	dial := func(creds credentials.TransportCredentials) booktrackerpb.BookServiceClient {
+		conn, err := grpc.Dial("bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+			grpc.WithTransportCredentials(creds))
+		assert.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return booktrackerpb.NewBookServiceClient(conn)
+	}
+
+	// Clients trusting the certificate get through to the handlers, plaintext clients don't.
+	certificate, err := x509.ParseCertificate(certDER)
+	assert.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	_, err = dial(credentials.NewClientTLSFromCert(roots, "bufnet")).ListBooks(context.Background(), &booktrackerpb.ListBooksRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = dial(insecure.NewCredentials()).ListBooks(context.Background(), &booktrackerpb.ListBooksRequest{})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	// A certificate that can't be loaded fails to create the server.
+	server.TLSCertFile = filepath.Join(dir, "missing.pem")
+	_, err = server.NewGRPCServer()
+	assert.Error(t, err)
+}
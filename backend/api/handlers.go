@@ -1,17 +1,13 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
-	"strconv"
 	"strings"
-	"time"
 
-	"github.com/declanl482/go-book-tracker-app/backend/config"
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
 	"github.com/declanl482/go-book-tracker-app/backend/types"
 	"github.com/gin-gonic/gin"
-	"github.com/mitchellh/mapstructure"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func (s *Server) handleLoginUser(c *gin.Context) {
@@ -19,241 +15,194 @@ func (s *Server) handleLoginUser(c *gin.Context) {
 
 	// Bind the form data to the credentials.
 	if err := c.ShouldBind(&credentials); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondBindingError(c, err)
 		return
 	}
 
-	// Verify that there exists a record with the given email.
-	user, err := s.Storer.GetUserByEmail(credentials.Email)
-	if err != nil || user == nil {
-		fmt.Println("failed to fetch!")
-		c.JSON(http.StatusForbidden, gin.H{"error": "invalid credentials"})
+	// Reject the attempt if the client IP or the account is being throttled.
+	// Otherwise it counts as a failure until it is settled, so that concurrent guesses are throttled too.
+	ipKey, accountKey := loginAttemptKeys(c, credentials.Email)
+	if !s.allowLoginAttempt(c, ipKey, accountKey) {
+		s.Metrics.ObserveLogin("password", "throttled")
 		return
 	}
 
-	fmt.Println("database user password:", user.Password)
-	fmt.Println("client credentials password:", credentials.Password)
-
-	// Verify the provided password.
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(strings.TrimSpace(credentials.Password))); err != nil {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid credentials"})
-		return
+	// Verify that there exists a record with the given email.
+	user, err := s.storage(c).GetUserByEmail(credentials.Email)
+	if err == nil && user == nil {
+		// Deleted accounts can still log in during the grace period, which cancels their deletion.
+		user, err = s.storage(c).GetDeletedUserByEmail(credentials.Email)
+		if !s.canCancelDeletion(user) {
+			user = nil
+		}
 	}
-
-	// Create a JWT access token using the authenticated user ID.
-	auth := NewAuth(config.Config.AccessTokenSecretKey) // Replace with your secret key.
-	tokenString, err := auth.GenerateAccessToken(user.ID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate access token"})
+	if err != nil || user == nil {
+		if err != nil {
+			s.Logger.ErrorContext(c.Request.Context(), "failed to fetch user for login", "error", err)
+		}
+		s.auditLoginFailure(c, credentials.Email, nil, "unknown email")
+		s.confirmLoginFailure(c, ipKey, accountKey)
+		respondProblem(c, problem.New(http.StatusForbidden, problem.CodeInvalidCredentials, "invalid credentials"))
 		return
 	}
 
-	// Send the access token in the response.
-	c.JSON(http.StatusOK, gin.H{"access_token": tokenString})
-
-}
-
-func (s *Server) handleCreateUser(c *gin.Context) {
-	// Declare a new user variable.
-	var newUser types.User
-
-	// Bind the JSON request body to the new user variable.
-	if err := c.ShouldBindJSON(&newUser); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Verify the provided password.
+	if err := s.VerifyPassword(user.Password, strings.TrimSpace(credentials.Password)); err != nil {
+		s.auditLoginFailure(c, credentials.Email, user, "invalid password")
+		s.confirmLoginFailure(c, ipKey, accountKey)
+		respondProblem(c, problem.New(http.StatusForbidden, problem.CodeInvalidCredentials, "invalid credentials"))
 		return
 	}
 
-	// Check if the new user's email is already in use.
-
-	emailTaken, err := s.Storer.IsEmailTaken(newUser.Email)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	// Disabled accounts cannot log in.
+	if user.Disabled {
+		s.auditLoginFailure(c, credentials.Email, user, "account disabled")
+		s.refundLoginAttempt(c, ipKey, accountKey)
+		respondProblem(c, problem.New(http.StatusForbidden, problem.CodeAccountDisabled, "account disabled"))
 		return
 	}
-	if emailTaken {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "email is taken"})
+
+	if user.DeletedAt.Valid && !s.cancelAccountDeletion(c, user) {
 		return
 	}
 
-	// Hash the new user's password.
-
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), 14)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	// Upgrade hashes made with weaker parameters while the plaintext password is at hand.
+	if s.PasswordHasher.NeedsRehash(user.Password) {
+		s.RehashPassword(c.Request.Context(), actorOf(c), user, strings.TrimSpace(credentials.Password))
 	}
-	newUser.Password = string(hashedPassword)
 
-	// Create the new user in the database.
-	createdUser, err := s.Storer.CreateUser(&newUser)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	// The account's failed attempts are forgiven after a successful login. The client IP's are not,
+	// as others may be guessing from it, but this attempt no longer counts against it.
+	if err := s.LoginLimiter.Reset(accountKey); err != nil {
+		s.Logger.ErrorContext(c.Request.Context(), "failed to reset login attempts", "user_id", user.ID, "error", err)
 	}
+	s.refundLoginAttempt(c, ipKey)
 
-	// SUCCESS.
-	c.IndentedJSON(http.StatusCreated, createdUser)
+	s.issueAccessToken(c, user, "password")
 }
 
-func (s *Server) handleGetUser(c *gin.Context) {
-
-	// Get the authenticated user from the context.
-	currentUser := c.MustGet("currentUser").(*types.User)
-
-	if currentUser == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-
-	// Extract the id param from the URL request path.
-	userID, err := strconv.Atoi(c.Param("id"))
+// Starts a session for the authenticated user and responds with an access token for it.
+func (s *Server) issueAccessToken(c *gin.Context, user *types.User, method string) {
+	// Record the login as a new session for the requesting device.
+	session, err := s.createSession(c, user)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		respondProblem(c, problem.Internal("failed to create session", err))
 		return
 	}
 
-	// Fetch the user from the database.
-	fetchedUser, err := s.Storer.GetUser(userID)
+	// Create a JWT access token using the authenticated user ID and session ID.
+	auth := NewAuth(s.AccessTokenSecretKey)
+	tokenString, err := auth.GenerateAccessToken(user.ID, session.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
-		return
-	}
-
-	// There is no user with the requested id.
-	if fetchedUser == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-		return
-	}
-	// Check that the client is authorized to view the fetched user.
-	if userID != currentUser.ID {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "you cannot view this user"})
+		respondProblem(c, problem.Internal("failed to generate access token", err))
 		return
 	}
 
-	// Return the user data in the response.
-	c.IndentedJSON(http.StatusOK, fetchedUser)
-}
+	s.recordEvent(c, audit.Event{
+		Action:    "auth.login",
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Details:   map[string]interface{}{"method": method},
+	})
+	s.recordEvent(c, audit.Event{
+		Action:    "token.issue",
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Target:    "session:" + session.ID,
+		Details:   map[string]interface{}{"expires_at": session.ExpiresAt},
+	})
 
-func (s *Server) handleUpdateUser(c *gin.Context) {
+	s.Metrics.ObserveLogin(method, "success")
 
-	// Get the authenticated user from the context.
-	currentUser := c.MustGet("currentUser").(*types.User)
+	// Send the access token in the response.
+	c.JSON(http.StatusOK, gin.H{"access_token": tokenString})
+}
 
-	if currentUser == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
+// Records a failed login attempt for the email, and the matching user if there is one.
+func (s *Server) auditLoginFailure(c *gin.Context, email string, user *types.User, reason string) {
+	event := audit.Event{
+		Action:  "auth.login_failed",
+		Target:  "account:" + strings.ToLower(strings.TrimSpace(email)),
+		Details: map[string]interface{}{"reason": reason},
 	}
-
-	// Extract the id param from the URL request path.
-	userID, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
-		return
+	if user != nil {
+		event.SubjectID = user.ID
 	}
+	s.recordEvent(c, event)
+	s.Metrics.ObserveLogin("password", strings.ReplaceAll(reason, " ", "_"))
+}
 
-	// Fetch the user from the database.
-	fetchedUser, err := s.Storer.GetUser(userID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
-		return
-	}
+func (s *Server) handleCreateUser(c *gin.Context) {
+	// Declare a new user variable.
+	var newUser types.User
 
-	// There is no user with the requested id.
-	if fetchedUser == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-		return
-	}
-	// Check that the client is authorized to update the fetched user.
-	if userID != currentUser.ID {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "you cannot update this user"})
+	// Bind the JSON request body to the new user variable.
+	if err := c.ShouldBindJSON(&newUser); err != nil {
+		respondBindingError(c, err)
 		return
 	}
 
-	// Check if the password field is included in the JSON request.
-	var requestBody map[string]interface{}
-	if err := c.ShouldBindJSON(&requestBody); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	// Create the new user in the database.
+	createdUser, problemErr := s.RegisterUser(c.Request.Context(), actorOf(c), &newUser)
+	if problemErr != nil {
+		respondProblem(c, problemErr)
 		return
 	}
 
-	if _, ok := requestBody["password"]; ok {
-		// Password is newly updated, rehash it.
+	// SUCCESS.
+	c.IndentedJSON(http.StatusCreated, createdUser)
+}
 
-		fmt.Println("The password is newly updated.")
+func (s *Server) handleGetUser(c *gin.Context) {
 
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(requestBody["password"].(string)), 14)
+	// Get the authorized user from the context.
+	fetchedUser := c.MustGet("targetUser").(*types.User)
 
-		fmt.Println("Hashed the new password:", hashedPassword)
+	// Return the user data in the response.
+	respondWithETag(c, http.StatusOK, userETag(fetchedUser), fetchedUser)
+}
 
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		requestBody["password"] = string(hashedPassword)
+func (s *Server) handleUpdateUser(c *gin.Context) {
 
-		fmt.Println("Just checking the request body is hashed:", requestBody["password"])
-	}
+	// Get the authorized user from the context.
+	fetchedUser := c.MustGet("targetUser").(*types.User)
 
-	// Update the updated_at time stamp.
-	requestBody["updated_at"] = time.Now()
+	// The update must be based on the user as it is now.
+	if !s.checkIfMatch(c, userETag(fetchedUser)) {
+		return
+	}
 
-	// convert the map to the fetchedUser struct of type *types.User
-	if err := mapstructure.Decode(requestBody, &fetchedUser); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user"})
+	// Apply the patch to the user, keeping only the fields users may change themselves.
+	var changes userPatch
+	if !applyPatch(c, fetchedUser, &changes, userPatchFields) {
 		return
 	}
+	patchedUser := *fetchedUser
+	patchedUser.Username, patchedUser.Email, patchedUser.Password = changes.Username, changes.Email, changes.Password
 
-	// Update the fetched user in the database.
-	updatedUser, err := s.Storer.UpdateUser(fetchedUser)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update user"})
+	// Update the patched user in the database.
+	updatedUser, problemErr := s.UpdateUser(c.Request.Context(), actorOf(c), fetchedUser, patchedUser)
+	if problemErr != nil {
+		respondProblem(c, problemErr)
 		return
 	}
 
 	// SUCCESS.
-	c.IndentedJSON(http.StatusOK, updatedUser)
+	respondWithETag(c, http.StatusOK, userETag(updatedUser), updatedUser)
 }
 
 func (s *Server) handleDeleteUser(c *gin.Context) {
 
-	// Get the authenticated user from the context.
-	currentUser := c.MustGet("currentUser").(*types.User)
-
-	if currentUser == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-
-	// Extract the id param from the URL request path.
-	userID, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
-		return
-	}
-	// Fetch the user from the database.
-	fetchedUser, err := s.Storer.GetUser(userID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get user"})
-		return
-	}
-
-	// There is no user with the requested id.
-	if fetchedUser == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-		return
-	}
+	// Get the authorized user from the context.
+	fetchedUser := c.MustGet("targetUser").(*types.User)
 
-	// Check that the client is authorized to delete the fetched user.
-	if userID != currentUser.ID {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "you cannot delete this user"})
+	// The deletion must be based on the user as it is now.
+	if !s.checkIfMatch(c, userETag(fetchedUser)) {
 		return
 	}
 
-	// Delete the user from the database.
-	err = s.Storer.DeleteUser(fetchedUser)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete user"})
+	if problemErr := s.DeleteUser(c.Request.Context(), actorOf(c), fetchedUser); problemErr != nil {
+		respondProblem(c, problemErr)
 		return
 	}
 
@@ -262,198 +211,87 @@ func (s *Server) handleDeleteUser(c *gin.Context) {
 }
 
 func (s *Server) handleCreateBook(c *gin.Context) {
-
-	// Get the authenticated user from the context.
-	currentUser := c.MustGet("currentUser").(*types.User)
-
-	if currentUser == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-
 	var newBook *types.Book
 
 	// Bind the request body to the new book variable.
 	if err := c.ShouldBindJSON(&newBook); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	newBook.OwnerID = currentUser.ID
-
-	// invalid pages count / pages read.
-
-	if newBook.PagesCount <= 0 || newBook.PagesRead < 0 || newBook.PagesRead > newBook.PagesCount {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pages data"})
+		respondBindingError(c, err)
 		return
 	}
 
 	// Create the book in the database.
-	createdBook, err := s.Storer.CreateBook(newBook)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create book"})
+	createdBook, problemErr := s.CreateBook(c.Request.Context(), actorOf(c), newBook)
+	if problemErr != nil {
+		respondProblem(c, problemErr)
 		return
 	}
 
 	// SUCCESS.
-	c.IndentedJSON(http.StatusCreated, createdBook)
+	respondWithETag(c, http.StatusCreated, bookETag(createdBook), createdBook)
 }
 
 func (s *Server) handleGetBooks(c *gin.Context) {
-	// Get the authenticated user from the context.
-	currentUser := c.MustGet("currentUser").(*types.User)
-
-	if currentUser == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-
-	books, err := s.Storer.GetBooks(currentUser.ID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch books"})
+	books, problemErr := s.ListBooks(c.Request.Context(), actorOf(c))
+	if problemErr != nil {
+		respondProblem(c, problemErr)
 		return
 	}
 
 	// SUCCESS.
-	c.IndentedJSON(http.StatusOK, books)
+	respondWithETag(c, http.StatusOK, booksETag(*books), books)
 }
 
 func (s *Server) handleGetBook(c *gin.Context) {
 
-	// Get the authenticated user from the context.
-	currentUser := c.MustGet("currentUser").(*types.User)
-
-	if currentUser == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
-		return
-	}
-
-	// Extract the id param from the URL request path.
-	bookID, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid book id"})
-		return
-	}
-
-	// Fetch the book from the database
-	book, err := s.Storer.GetBook(bookID)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch book"})
-		return
-	}
-
-	if book == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
-		return
-	}
-
-	// Verify that the user can access the fetched book.
-	if book.OwnerID != currentUser.ID {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "you cannot view this book"})
-		return
-	}
+	// Get the authorized book from the context.
+	book := c.MustGet("targetBook").(*types.Book)
 
 	// SUCCESS.
-	c.IndentedJSON(http.StatusOK, book)
+	respondWithETag(c, http.StatusOK, bookETag(book), book)
 
 }
 
 func (s *Server) handleUpdateBook(c *gin.Context) {
 
-	// Get the authenticated user from the context.
-	currentUser := c.MustGet("currentUser").(*types.User)
+	// Get the authorized book from the context.
+	fetchedBook := c.MustGet("targetBook").(*types.Book)
 
-	if currentUser == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	// The update must be based on the book as it is now.
+	if !s.checkIfMatch(c, bookETag(fetchedBook)) {
 		return
 	}
 
-	// Extract the id param from the URL request path.
-	bookID, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid book id"})
-		return
-	}
-
-	// Fetch the book from the database.
-	fetchedBook, err := s.Storer.GetBook(bookID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get book"})
-		return
-	}
-
-	// There is no book with the requested id.
-	if fetchedBook == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
-		return
-	}
-	// Check that the client is authorized to update the fetched book.
-	if fetchedBook.OwnerID != currentUser.ID {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "you cannot update this book"})
+	// Apply the patch to the book.
+	var patchedBook types.Book
+	if !applyPatch(c, fetchedBook, &patchedBook, bookPatchFields) {
 		return
 	}
 
-	// Bind the JSON request body to the fetched book variable.
-	if err := c.ShouldBindJSON(&fetchedBook); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
-
-	// Update the updated_at time stamp.
-	fetchedBook.UpdatedAt = time.Now()
-
-	// Update the fetched book in the database.
-	updatedBook, err := s.Storer.UpdateBook(fetchedBook)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update book"})
+	// Update the patched book in the database.
+	updatedBook, problemErr := s.UpdateBook(c.Request.Context(), actorOf(c), fetchedBook, patchedBook)
+	if problemErr != nil {
+		respondProblem(c, problemErr)
 		return
 	}
 
 	// SUCCESS.
-	c.IndentedJSON(http.StatusOK, updatedBook)
+	respondWithETag(c, http.StatusOK, bookETag(updatedBook), updatedBook)
 
 }
 
 func (s *Server) handleDeleteBook(c *gin.Context) {
 
-	// Get the authenticated user from the context.
-	currentUser := c.MustGet("currentUser").(*types.User)
+	// Get the authorized book from the context.
+	fetchedBook := c.MustGet("targetBook").(*types.Book)
 
-	if currentUser == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+	// The deletion must be based on the book as it is now.
+	if !s.checkIfMatch(c, bookETag(fetchedBook)) {
 		return
 	}
 
-	// Extract the id param from the URL request path.
-	bookID, err := strconv.Atoi(c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid book id"})
-		return
-	}
-
-	// Fetch the book from the database.
-	fetchedBook, err := s.Storer.GetBook(bookID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get book"})
-		return
-	}
-
-	// There is no book with the requested id.
-	if fetchedBook == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "book not found"})
-		return
-	}
-	// Check that the client is authorized to delete the fetched book.
-	if fetchedBook.OwnerID != currentUser.ID {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "you cannot delete this book"})
-		return
-	}
-
-	// Delete the book from the database.
-	err = s.Storer.DeleteBook(fetchedBook)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete book"})
+	// Move the book to the trash, it is purged once the trash retention is over.
+	if problemErr := s.DeleteBook(c.Request.Context(), actorOf(c), fetchedBook); problemErr != nil {
+		respondProblem(c, problemErr)
 		return
 	}
 
@@ -15,6 +15,12 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// Passwords of the test users, strong enough to satisfy the default password policy.
+const (
+	fooPassword  = "plum-Orbit-57-lantern"
+	fuzzPassword = "quartz-Meadow-83-violin"
+)
+
 func TestHandlerFunctions(t *testing.T) {
 	// create a new postgres storage (test db), and use it to create a new server
 	testConfig, err := config.LoadTestConfigurationVariables()
@@ -84,7 +90,7 @@ func TestHandlerFunctions(t *testing.T) {
 		ID:        1,
 		Username:  "foo",
 		Email:     "foo@bar.com",
-		Password:  "foo",
+		Password:  fooPassword,
 		Books:     []types.Book{},
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -94,7 +100,7 @@ func TestHandlerFunctions(t *testing.T) {
 		ID:        2,
 		Username:  "fuzz",
 		Email:     "fuzz@buzz.com",
-		Password:  "fuzz",
+		Password:  fuzzPassword,
 		Books:     []types.Book{},
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -171,7 +177,7 @@ func TestHandlerFunctions(t *testing.T) {
 
 		credentials := url.Values{}
 		credentials.Set("username", "")
-		credentials.Set("password", "foo")
+		credentials.Set("password", fooPassword)
 
 		req = httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
 
@@ -227,7 +233,7 @@ func TestHandlerFunctions(t *testing.T) {
 
 		credentials = url.Values{}
 		credentials.Set("username", "foo@bar.com")
-		credentials.Set("password", "foo")
+		credentials.Set("password", fooPassword)
 
 		req = httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -256,7 +262,7 @@ func TestHandlerFunctions(t *testing.T) {
 
 		credentials = url.Values{}
 		credentials.Set("username", "foo@bar.com")
-		credentials.Set("password", "foo")
+		credentials.Set("password", fooPassword)
 
 		req = httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -293,7 +299,7 @@ func TestHandlerFunctions(t *testing.T) {
 
 		credentials := url.Values{}
 		credentials.Set("username", "foo@bar.com")
-		credentials.Set("password", "foo")
+		credentials.Set("password", fooPassword)
 
 		req = httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -350,7 +356,7 @@ func TestHandlerFunctions(t *testing.T) {
 		updateData := map[string]interface{}{
 			"username": "newusername",
 			"email":    "newemail@newemail.com",
-			"password": "newpassword",
+			"password": "new-Orbit-57-lantern",
 		}
 
 		// Marshal the updateData into a JSON string
@@ -436,7 +442,7 @@ func TestHandlerFunctions(t *testing.T) {
 
 		credentials = url.Values{}
 		credentials.Set("username", "fuzz@buzz.com")
-		credentials.Set("password", "fuzz")
+		credentials.Set("password", fuzzPassword)
 
 		req = httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -581,7 +587,7 @@ func TestHandlerFunctions(t *testing.T) {
 
 		credentials := url.Values{}
 		credentials.Set("username", "foo@bar.com")
-		credentials.Set("password", "foo")
+		credentials.Set("password", fooPassword)
 
 		req = httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -692,7 +698,7 @@ func TestHandlerFunctions(t *testing.T) {
 
 		credentials = url.Values{}
 		credentials.Set("username", "fuzz@buzz.com")
-		credentials.Set("password", "fuzz")
+		credentials.Set("password", fuzzPassword)
 
 		req = httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -878,7 +884,7 @@ func TestHandlerFunctions(t *testing.T) {
 		// Log in User 1 (success).
 		credentials = url.Values{}
 		credentials.Set("username", "foo@bar.com")
-		credentials.Set("password", "foo")
+		credentials.Set("password", fooPassword)
 
 		req = httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ReadinessCheck returns an error if a dependency of the server cannot be used.
+type ReadinessCheck func(ctx context.Context) error
+
+// How long the readiness checks may take altogether.
+const readinessTimeout = 2 * time.Second
+
+// Reports that the process is alive, without checking its dependencies.
+func (s *Server) handleHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Reports whether the server can handle requests, running every readiness check.
+func (s *Server) handleReadyz(c *gin.Context) {
+	if s.shuttingDown.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	names := make([]string, 0, len(s.ReadinessChecks))
+	for name := range s.ReadinessChecks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	status := http.StatusOK
+	checks := make(map[string]string, len(names))
+	for _, name := range names {
+		if err := s.ReadinessChecks[name](ctx); err != nil {
+			// The error is only logged, it may describe the infrastructure to anyone who can reach the endpoint.
+			s.Logger.WarnContext(c.Request.Context(), "readiness check failed", "check", name, "error", err)
+			checks[name] = "failed"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		checks[name] = "ok"
+	}
+
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(status, gin.H{"status": "ready", "checks": checks})
+}
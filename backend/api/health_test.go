@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthChecks(t *testing.T) {
+	server, _ := newMemoryServer(t)
+
+	w := doRequest(server, "GET", "/healthz", nil, "")
+	assert.Equal(t, 200, w.Code)
+
+	// Ready without any dependencies to check.
+	w = doRequest(server, "GET", "/readyz", nil, "")
+	assert.Equal(t, 200, w.Code)
+
+	server.ReadinessChecks["database"] = func(ctx context.Context) error { return nil }
+	w = doRequest(server, "GET", "/readyz", nil, "")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, `{"status": "ready", "checks": {"database": "ok"}}`, w.Body.String())
+
+	// A failing check makes the server unready, while it stays alive.
+	server.ReadinessChecks["database"] = func(ctx context.Context) error { return errors.New("connection refused") }
+	w = doRequest(server, "GET", "/readyz", nil, "")
+	assert.Equal(t, 503, w.Code)
+	assert.JSONEq(t, `{"status": "unavailable", "checks": {"database": "failed"}}`, w.Body.String())
+
+	w = doRequest(server, "GET", "/healthz", nil, "")
+	assert.Equal(t, 200, w.Code)
+}
+
+// Sends an authenticated GET request to the listener.
+func getWithToken(t *testing.T, listener net.Listener, path string, token string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", "http://"+listener.Addr().String()+path, nil)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return http.DefaultClient.Do(req)
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+
+	// A request that is in flight when shutdown begins.
+	started, release := make(chan struct{}), make(chan struct{})
+	server.router.GET("/slow", func(c *gin.Context) {
+		close(started)
+		<-release
+		c.String(http.StatusOK, "done")
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(listener) }()
+
+	response := make(chan *http.Response, 1)
+	go func() {
+		resp, err := getWithToken(t, listener, "/slow", fooToken)
+		assert.NoError(t, err)
+		response <- resp
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+
+	// Readiness fails as soon as shutdown begins.
+	assert.Eventually(t, func() bool {
+		return doRequest(server, "GET", "/readyz", nil, "").Code == 503
+	}, time.Second, 10*time.Millisecond)
+
+	// The in-flight request completes before shutdown returns.
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned before the in-flight request completed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	resp := <-response
+	assert.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+	assert.NoError(t, <-shutdown)
+	assert.NoError(t, <-served)
+
+	// No new connections are accepted.
+	_, err = http.Get("http://" + listener.Addr().String() + "/healthz")
+	assert.Error(t, err)
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+
+	started := make(chan struct{})
+	server.router.GET("/stuck", func(c *gin.Context) {
+		close(started)
+		<-c.Request.Context().Done()
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go server.Serve(listener)
+
+	go getWithToken(t, listener, "/stuck", fooToken)
+	<-started
+
+	// Shutdown gives up on requests that outlast the drain deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, server.Shutdown(ctx), context.DeadlineExceeded)
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/idempotency"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+)
+
+// Headers of recorded responses that are replayed along with their status and body.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// Writer recording the response body while writing it.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// Middleware to make retries of POST requests with the same Idempotency-Key header safe.
+// The other methods are idempotent already, or in the case of PATCH guarded by If-Match.
+// The first request with a key runs, and its response is recorded for the idempotency TTL and replayed to the retries,
+// marked by the Idempotent-Replayed header. Reusing a key for a different request is rejected with 422, and retrying
+// while the first request is in progress with 409. Requests that fail are not recorded, so they can be retried with the key.
+// Keys are scoped to the authenticated user, if any, so that clients can't see each other's responses.
+func (s *Server) IdempotentRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("Idempotency-Key")
+		if key == "" || c.Request.Method != http.MethodPost {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			respondError(c, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		// Read the body to fingerprint the request, then put it back for the handler.
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			respondBindingError(c, err)
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := "anonymous"
+		if currentUser, ok := c.Get("currentUser"); ok {
+			scope = "user:" + strconv.Itoa(currentUser.(*types.User).ID)
+		}
+
+		now := time.Now()
+		record := idempotency.Record{
+			Key:         scope + ":" + key,
+			Fingerprint: idempotency.Fingerprint(c.Request.Method, c.Request.URL.Path, body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(s.IdempotencyTTL),
+		}
+		existing, err := s.Idempotency.Claim(record)
+		if err != nil {
+			respondProblem(c, problem.Internal("failed to check idempotency key", err))
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != record.Fingerprint:
+				respondProblem(c, problem.New(http.StatusUnprocessableEntity, problem.CodeIdempotencyKeyReused, "Idempotency-Key was used for a different request"))
+			case existing.Response == nil:
+				respondProblem(c, problem.New(http.StatusConflict, problem.CodeIdempotencyKeyInUse, "a request with this Idempotency-Key is in progress"))
+			default:
+				for _, name := range replayedHeaders {
+					if value := existing.Response.Header.Get(name); value != "" {
+						c.Header(name, value)
+					}
+				}
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(existing.Response.Status, existing.Response.Header.Get("Content-Type"), existing.Response.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		// Give up the key if the request fails, including by panicking.
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := s.Idempotency.Release(record.Key); err != nil {
+				s.Logger.ErrorContext(c.Request.Context(), "failed to release idempotency key", "error", err)
+			}
+		}()
+
+		writer := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		// Errors are rendered once the handlers have returned, so failed requests have no response yet.
+		status := c.Writer.Status()
+		if len(c.Errors) > 0 || !c.Writer.Written() || status >= http.StatusInternalServerError {
+			return
+		}
+
+		response := idempotency.Response{Status: status, Header: make(http.Header), Body: writer.body.Bytes()}
+		for _, name := range replayedHeaders {
+			if value := c.Writer.Header().Get(name); value != "" {
+				response.Header.Set(name, value)
+			}
+		}
+		if err := s.Idempotency.Complete(record.Key, response); err != nil {
+			s.Logger.ErrorContext(c.Request.Context(), "failed to record idempotent response", "error", err)
+			return
+		}
+		completed = true
+	}
+}
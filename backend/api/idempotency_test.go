@@ -0,0 +1,153 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/idempotency"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotentRequests(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	bar := createTestUser(t, store, "bar", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+	barToken := accessTokenFor(t, store, bar)
+
+	newBook := gin.H{"title": "Foo", "author": "Bar", "pages_count": 100, "pages_read": 10}
+
+	// Returns how many books the user has.
+	countBooks := func(t *testing.T, userID int) int {
+		books, err := store.GetBooks(userID)
+		assert.NoError(t, err)
+		return len(*books)
+	}
+
+	t.Run("TestReplay", func(t *testing.T) {
+		headers := map[string]string{"Idempotency-Key": "create-book-1"}
+		first := doRequestWithHeaders(server, "POST", "/v1/books/", newBook, fooToken, headers)
+		assert.Equal(t, 201, first.Code)
+		assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+		// The retry gets the same response, without creating another book.
+		retry := doRequestWithHeaders(server, "POST", "/v1/books/", newBook, fooToken, headers)
+		assert.Equal(t, 201, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, first.Header().Get("ETag"), retry.Header().Get("ETag"))
+		assert.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+		assert.Equal(t, 1, countBooks(t, foo.ID))
+
+		// The same key sent by another user is another key.
+		w := doRequestWithHeaders(server, "POST", "/v1/books/", newBook, barToken, headers)
+		assert.Equal(t, 201, w.Code)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, 1, countBooks(t, bar.ID))
+
+		// Requests without a key aren't deduplicated.
+		w = doRequest(server, "POST", "/v1/books/", newBook, fooToken)
+		assert.Equal(t, 201, w.Code)
+		assert.Equal(t, 2, countBooks(t, foo.ID))
+	})
+
+	t.Run("TestKeyReused", func(t *testing.T) {
+		headers := map[string]string{"Idempotency-Key": "create-book-2"}
+		w := doRequestWithHeaders(server, "POST", "/v1/books/", newBook, fooToken, headers)
+		assert.Equal(t, 201, w.Code)
+
+		otherBook := gin.H{"title": "Fuzz", "author": "Buzz", "pages_count": 100, "pages_read": 10}
+		w = doRequestWithHeaders(server, "POST", "/v1/books/", otherBook, fooToken, headers)
+		assert.Equal(t, 422, w.Code)
+		assert.Equal(t, problem.CodeIdempotencyKeyReused, decodeProblem(t, w).Code)
+	})
+
+	t.Run("TestInProgress", func(t *testing.T) {
+		// Claim the key as a request that hasn't completed yet would have.
+		body, err := json.Marshal(newBook)
+		assert.NoError(t, err)
+		now := time.Now()
+		existing, err := server.Idempotency.Claim(idempotency.Record{
+			Key:         fmt.Sprintf("user:%d:create-book-3", foo.ID),
+			Fingerprint: idempotency.Fingerprint("POST", "/v1/books/", body),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(time.Hour),
+		})
+		assert.NoError(t, err)
+		assert.Nil(t, existing)
+
+		books := countBooks(t, foo.ID)
+		w := doRequestWithHeaders(server, "POST", "/v1/books/", newBook, fooToken, map[string]string{"Idempotency-Key": "create-book-3"})
+		assert.Equal(t, 409, w.Code)
+		assert.Equal(t, problem.CodeIdempotencyKeyInUse, decodeProblem(t, w).Code)
+		assert.Equal(t, books, countBooks(t, foo.ID))
+	})
+
+	t.Run("TestFailedRequestsAreRetried", func(t *testing.T) {
+		headers := map[string]string{"Idempotency-Key": "create-book-4"}
+		invalidBook := gin.H{"title": "Foo", "author": "Bar", "pages_count": 10, "pages_read": 20}
+		w := doRequestWithHeaders(server, "POST", "/v1/books/", invalidBook, fooToken, headers)
+		assert.Equal(t, 400, w.Code)
+
+		// The failure isn't recorded, so the key can be used for the corrected request.
+		w = doRequestWithHeaders(server, "POST", "/v1/books/", newBook, fooToken, headers)
+		assert.Equal(t, 201, w.Code)
+		assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+	})
+
+	t.Run("TestRegister", func(t *testing.T) {
+		headers := map[string]string{"Idempotency-Key": "register-1"}
+		newUser := gin.H{"username": "fuzz", "email": "fuzz@bar.com", "password": "quartz-Meadow-83-violin"}
+		first := doRequestWithHeaders(server, "POST", "/v1/auth/register", newUser, "", headers)
+		assert.Equal(t, 201, first.Code)
+
+		// Without the key, the retry would fail because the email is taken.
+		retry := doRequestWithHeaders(server, "POST", "/v1/auth/register", newUser, "", headers)
+		assert.Equal(t, 201, retry.Code)
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+	})
+
+	t.Run("TestLogin", func(t *testing.T) {
+		// Logins are never recorded, the record would keep a fingerprint of the credentials and the access token.
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("POST", "/v1/auth/login", strings.NewReader("username=fuzz@bar.com&password=quartz-Meadow-83-violin"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Idempotency-Key", "login-1")
+			w := httptest.NewRecorder()
+			server.router.ServeHTTP(w, req)
+			assert.Equal(t, 200, w.Code)
+			assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+		}
+
+		existing, err := server.Idempotency.Claim(idempotency.Record{Key: "anonymous:login-1", ExpiresAt: time.Now().Add(time.Hour)})
+		assert.NoError(t, err)
+		assert.Nil(t, existing)
+	})
+
+	t.Run("TestExpiry", func(t *testing.T) {
+		server.IdempotencyTTL = 0
+		defer func() { server.IdempotencyTTL = 24 * time.Hour }()
+
+		books := countBooks(t, foo.ID)
+		headers := map[string]string{"Idempotency-Key": "create-book-5"}
+		for i := 0; i < 2; i++ {
+			w := doRequestWithHeaders(server, "POST", "/v1/books/", newBook, fooToken, headers)
+			assert.Equal(t, 201, w.Code)
+			assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+		}
+		assert.Equal(t, books+2, countBooks(t, foo.ID))
+	})
+
+	t.Run("TestInvalidKey", func(t *testing.T) {
+		w := doRequestWithHeaders(server, "POST", "/v1/books/", newBook, fooToken, map[string]string{"Idempotency-Key": strings.Repeat("a", 256)})
+		assert.Equal(t, 400, w.Code)
+	})
+}
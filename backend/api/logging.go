@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware to log every request once it has been handled.
+func (s *Server) RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		// The route template keeps ids out of the route, and the query string is left out as it may carry secrets.
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("user_agent", c.Request.UserAgent()),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+		s.Logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Middleware to recover from panics in handlers, logging them and responding with 500.
+func (s *Server) Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, recovered any) {
+		s.Logger.ErrorContext(c.Request.Context(), "panic while handling request", "panic", recovered, "route", c.FullPath())
+		respondProblem(c, problem.Internal("internal server error", fmt.Errorf("panic: %v", recovered)))
+	})
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/logging"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLogging(t *testing.T) {
+	server, _ := newMemoryServer(t)
+
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, logging.DefaultConfig())
+	assert.NoError(t, err)
+	server.Logger = logger
+
+	// Error responses carry the request id given by the client.
+	req := httptest.NewRequest("GET", "/users/1", nil)
+	req.Header.Set("X-Request-ID", "request-1")
+	req.Header.Set("Authorization", "Bearer not-a-token")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+
+	var response problem.Document
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "request-1", response.RequestID)
+	assert.Equal(t, problem.CodeInvalidToken, response.Code)
+
+	// The request is logged with the request id and the matched route, but without the token.
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &record))
+	assert.Equal(t, "request-1", record["request_id"])
+	assert.Equal(t, "/users/:id", record["route"])
+	assert.Equal(t, float64(401), record["status"])
+	assert.Equal(t, "WARN", record["level"])
+	assert.NotContains(t, buf.String(), "not-a-token")
+
+	// A request id is generated when the client does not send one.
+	w = doRequest(server, "GET", "/users/1", nil, "")
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.NotEmpty(t, response.RequestID)
+	assert.Equal(t, response.RequestID, w.Header().Get("X-Request-ID"))
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+
+	login := func(password string) int {
+		credentials := url.Values{}
+		credentials.Set("username", "foo@bar.com")
+		credentials.Set("password", password)
+
+		req := httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, 200, login("foo"))
+	assert.Equal(t, 403, login("bar"))
+
+	w := doRequest(server, "POST", "/books/", map[string]interface{}{"title": "foo", "author": "bar", "pages_count": 10, "pages_read": 10}, fooToken)
+	assert.Equal(t, 201, w.Code)
+	for id := 1; id <= 3; id++ {
+		doRequest(server, "GET", fmt.Sprintf("/books/%d", id), nil, fooToken)
+	}
+	doRequest(server, "PROPFIND", "/books/", nil, fooToken)
+
+	// Metrics are public so that they can be scraped without credentials.
+	w = doRequest(server, "GET", "/metrics", nil, "")
+	assert.Equal(t, 200, w.Code)
+	body := w.Body.String()
+
+	// Requests are labelled by route template rather than path.
+	assert.Contains(t, body, `booktracker_http_requests_total{method="GET",route="/books/:id",status="200"} 1`)
+	assert.Contains(t, body, `booktracker_http_requests_total{method="GET",route="/books/:id",status="404"} 2`)
+	assert.NotContains(t, body, `route="/books/1"`)
+
+	// Non-standard methods share a label, so that clients can't add series at will.
+	assert.Contains(t, body, `method="other"`)
+	assert.NotContains(t, body, `method="PROPFIND"`)
+
+	assert.Contains(t, body, `booktracker_auth_logins_total{method="password",result="success"} 1`)
+	assert.Contains(t, body, `booktracker_auth_logins_total{method="password",result="invalid_password"} 1`)
+	assert.Contains(t, body, `booktracker_password_hash_duration_seconds_count{operation="verify"} 2`)
+	assert.Contains(t, body, "booktracker_users 1")
+	assert.Contains(t, body, "booktracker_finished_books 1")
+}
@@ -1,10 +1,18 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/declanl482/go-book-tracker-app/backend/config"
+	"github.com/declanl482/go-book-tracker-app/backend/logging"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
 	"github.com/gin-gonic/gin"
 )
 
@@ -14,43 +22,151 @@ func (s *Server) RequireValidAccessToken() gin.HandlerFunc {
 		// Get the access token from the request header.
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "access token required"})
+			respondError(c, http.StatusUnauthorized, "access token required")
 			c.Abort()
 			return
 		}
 
 		accessToken := strings.Replace(authHeader, "Bearer ", "", 1)
 
-		// Validate the access token and get the user details.
-		auth := NewAuth(config.Config.AccessTokenSecretKey)
-		userID, err := auth.ValidateAccessToken(accessToken)
-
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid access token"})
-			c.Abort()
+		user, session, problemErr := s.authenticate(c.Request.Context(), accessToken)
+		if problemErr != nil {
+			respondProblem(c, problemErr)
 			return
 		}
 
-		// Retrieve the user from the database using the userID.
-		user, err := s.Storer.GetUser(userID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch user details"})
-			c.Abort()
+		// Until a forced password reset is completed, the user may only change their own password.
+		if user.PasswordResetRequired && !s.isOwnPasswordChange(c, user) {
+			respondProblem(c, passwordResetRequired())
 			return
 		}
 
-		// Add the user to the context.
+		// Record the session activity; it is written to the database in batches.
+		s.Sessions.Touch(session.ID, time.Now())
+
+		// Add the user and their session to the context.
 		c.Set("currentUser", user)
+		c.Set("currentSession", session)
 
 		// Continue to the next handler.
 		c.Next()
 	}
 }
 
+// Validates the access token, and returns the user it was issued to and the session it was issued for.
+// Returns the problem if the token is invalid, its session was revoked, or the user may no longer use the API.
+func (s *Server) authenticate(ctx context.Context, accessToken string) (*types.User, *types.Session, *problem.Error) {
+	// Validate the access token and get the user details.
+	auth := NewAuth(s.AccessTokenSecretKey)
+	claims, err := auth.ParseAccessToken(accessToken)
+
+	if err != nil || claims.SessionID == "" {
+		return nil, nil, problem.New(http.StatusUnauthorized, problem.CodeInvalidToken, "invalid access token")
+	}
+
+	// Check that the login session the token was issued for is still active.
+	store := storage.WithContext(s.Storer, ctx)
+	session, err := store.GetSession(claims.SessionID)
+	if err != nil {
+		return nil, nil, problem.Internal("failed to fetch session details", err)
+	}
+
+	if session == nil || session.UserID != claims.UserID || !session.IsActive(time.Now()) {
+		return nil, nil, problem.New(http.StatusUnauthorized, problem.CodeSessionRevoked, "session revoked")
+	}
+
+	// Retrieve the user from the database using the userID.
+	user, err := store.GetUser(claims.UserID)
+	if err != nil {
+		return nil, nil, problem.Internal("failed to fetch user details", err)
+	}
+
+	// The user no longer exists.
+	if user == nil {
+		return nil, nil, problem.New(http.StatusUnauthorized, problem.CodeInvalidToken, "invalid access token")
+	}
+
+	// Disabled accounts cannot use the API.
+	if user.Disabled {
+		return nil, nil, problem.New(http.StatusForbidden, problem.CodeAccountDisabled, "account disabled")
+	}
+	return user, session, nil
+}
+
+// Returns the problem answering requests of users who must reset their password first.
+func passwordResetRequired() *problem.Error {
+	return problem.New(http.StatusForbidden, problem.CodePasswordResetRequired, "password reset required")
+}
+
+// Reports whether the request updates the user's own account, which is how a forced password reset is completed.
+func (s *Server) isOwnPasswordChange(c *gin.Context, user *types.User) bool {
+	return c.Request.Method == http.MethodPatch && s.unversionedRoute(c) == "/users/:id" && c.Param("id") == strconv.Itoa(user.ID)
+}
+
+// Returns the route of the request without the prefix of its API version, e.g. "/users/:id" for "/v1/users/:id".
+func (s *Server) unversionedRoute(c *gin.Context) string {
+	route := c.FullPath()
+	for _, version := range s.APIVersions {
+		if unversioned, ok := strings.CutPrefix(route, "/"+version.Name); ok && strings.HasPrefix(unversioned, "/") {
+			return unversioned
+		}
+	}
+	return route
+}
+
+// Header carrying the id that correlates a request across logs, audit events and services.
+const requestIDHeader = "X-Request-ID"
+
+// Middleware to assign every request an id, reusing the client's if it is well-formed, and echo it in the response.
+func (s *Server) RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		c.Set("requestID", requestID)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), requestID))
+		c.Header(requestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// Reports whether a client-supplied request id is safe to log.
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > 128 {
+		return false
+	}
+	for _, r := range requestID {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a new random request id.
+func newRequestID() string {
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(buffer)
+}
+
+// Returns a new random key to sign access tokens with.
+func newSecretKey() string {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		panic("failed to generate secret key: " + err.Error())
+	}
+	return hex.EncodeToString(buffer)
+}
+
 func (s *Server) DBConnectionMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if s.Storer == nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database connection error"})
+			respondError(c, http.StatusInternalServerError, "database connection error")
 			c.Abort()
 			return
 		}
@@ -58,32 +174,64 @@ func (s *Server) DBConnectionMiddleware() gin.HandlerFunc {
 	}
 }
 
+// Middleware to apply the CORS policy. Preflight requests are answered here; other cross-origin
+// requests are served with the allowed origin echoed back, or without CORS headers if it isn't allowed.
 func (s *Server) CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Authorization, Content-Type, X-Custom-Header")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
-
-		if c.Request.Method == "OPTIONS" {
-			requestedMethod := c.GetHeader("Access-Control-Request-Method")
-			if !IsMethodSupported(requestedMethod) {
-				c.AbortWithStatus(http.StatusMethodNotAllowed)
+		origin := c.GetHeader("Origin")
+		requestedMethod := c.GetHeader("Access-Control-Request-Method")
+		preflight := c.Request.Method == http.MethodOptions && requestedMethod != ""
+
+		// Which origin is allowed, if any, depends on the request's.
+		header := c.Writer.Header()
+		header.Add("Vary", "Origin")
+		if preflight {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		// Requests from the same origin, or not from a browser, aren't subject to the policy.
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		if !s.CORSPolicy.AllowsOrigin(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
 				return
 			}
-			c.AbortWithStatus(http.StatusNoContent) // Return empty response on success
+			c.Next()
+			return
+		}
+
+		header.Set("Access-Control-Allow-Origin", s.CORSPolicy.AllowOriginHeader(origin))
+		if s.CORSPolicy.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(s.CORSPolicy.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(s.CORSPolicy.ExposedHeaders, ", "))
+			}
+			c.Next()
+			return
+		}
+
+		if !s.CORSPolicy.AllowsMethod(requestedMethod) {
+			c.AbortWithStatus(http.StatusMethodNotAllowed)
+			return
+		}
+		if !s.CORSPolicy.AllowsHeaders(c.GetHeader("Access-Control-Request-Headers")) {
+			c.AbortWithStatus(http.StatusForbidden)
 			return
 		}
-	}
-}
 
-func IsMethodSupported(method string) bool {
-	// check if the method is supported
-	supportedMethods := []string{"GET", "POST", "PATCH", "DELETE", "OPTIONS"}
-	for _, supportedMethod := range supportedMethods {
-		if method == supportedMethod {
-			return true
+		header.Set("Access-Control-Allow-Methods", strings.Join(s.CORSPolicy.AllowedMethods, ", "))
+		header.Set("Access-Control-Allow-Headers", strings.Join(s.CORSPolicy.AllowedHeaders, ", "))
+		if s.CORSPolicy.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", s.CORSPolicy.MaxAgeHeader())
 		}
+		c.AbortWithStatus(http.StatusNoContent)
 	}
-	return false
 }
@@ -4,13 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
 
 	"github.com/declanl482/go-book-tracker-app/backend/config"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
 	"github.com/declanl482/go-book-tracker-app/backend/storage"
 	"github.com/declanl482/go-book-tracker-app/backend/types"
 	"github.com/gin-gonic/gin"
@@ -49,7 +49,7 @@ func TestMiddlewareFunctions(t *testing.T) {
 		ID:        1,
 		Username:  "foo",
 		Email:     "foo@bar.com",
-		Password:  "foo",
+		Password:  fooPassword,
 		Books:     []types.Book{},
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -58,7 +58,7 @@ func TestMiddlewareFunctions(t *testing.T) {
 	t.Run("TestRequireValidAccessToken", func(t *testing.T) {
 
 		// Generate an invalid mock access token.
-		invalidAccessToken, err := invalidAuth.GenerateAccessToken(-1)
+		invalidAccessToken, err := invalidAuth.GenerateAccessToken(-1, "")
 		assert.NoError(t, err, "expected no error generating invalid access token, got: %v.", err)
 
 		// Create a new HTTP request with the required headers.
@@ -72,8 +72,8 @@ func TestMiddlewareFunctions(t *testing.T) {
 		c.Request = req
 		// Call the RequireValidAccessToken middleware function passing the mock context.
 		server.RequireValidAccessToken()(c)
-		// successfully got books, meaning access token was successfully required.
-		assert.Equal(t, 401, w.Code)
+		// the request was aborted with an invalid token error, meaning access token was successfully required.
+		assert.Equal(t, 401, problem.From(c.Errors.Last()).Status)
 
 		// Create a valid user
 		validUserJSON, err := json.Marshal(validUser1)
@@ -88,7 +88,7 @@ func TestMiddlewareFunctions(t *testing.T) {
 		// Log the user in, collect the valid access token:
 		credentials := url.Values{}
 		credentials.Set("username", "foo@bar.com")
-		credentials.Set("password", "foo")
+		credentials.Set("password", fooPassword)
 
 		req = httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -149,7 +149,7 @@ func TestMiddlewareFunctions(t *testing.T) {
 		// Log the user in, collect the valid access token:
 		credentials := url.Values{}
 		credentials.Set("username", "foo@bar.com")
-		credentials.Set("password", "foo")
+		credentials.Set("password", fooPassword)
 
 		req = httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -201,76 +201,6 @@ func TestMiddlewareFunctions(t *testing.T) {
 		serverInstance.DBConnectionMiddleware()(c)
 
 		// internal server error, meaning database connection unsuccessful.
-		assert.Equal(t, 500, w.Code)
-	})
-
-	t.Run("TestCORSMiddleware", func(t *testing.T) {
-		// Create a new test context.
-		w := httptest.NewRecorder()
-		c, _ := gin.CreateTestContext(w)
-		c.Request = httptest.NewRequest("GET", "/books/", nil)
-
-		// Call the CORSMiddleware function passing the mock context.
-		server.CORSMiddleware()(c)
-
-		// Check the response headers.
-		assert.Equal(t, "*", c.Writer.Header().Get("Access-Control-Allow-Origin"))
-		assert.Equal(t, "true", c.Writer.Header().Get("Access-Control-Allow-Credentials"))
-		assert.Equal(t, "GET, POST, PATCH, DELETE, OPTIONS", c.Writer.Header().Get("Access-Control-Allow-Methods"))
-		assert.Equal(t, "Origin, Authorization, Content-Type, X-Custom-Header", c.Writer.Header().Get("Access-Control-Allow-Headers"))
-
-		w = httptest.NewRecorder()
-		c, _ = gin.CreateTestContext(w)
-		c.Request = httptest.NewRequest("GET", "/books/", nil)
-
-		// Test OPTIONS GET method.
-		c.Request.Method = "OPTIONS"
-		c.Request.Header.Set("Access-Control-Request-Method", "GET")
-		server.CORSMiddleware()(c)
-		assert.Equal(t, http.StatusNoContent, w.Code)
-
-		// Create a new mock Gin context.
-		w = httptest.NewRecorder()
-		c, _ = gin.CreateTestContext(w)
-		c.Request = httptest.NewRequest("POST", "/books/", nil)
-
-		// Test OPTIONS POST method.
-		c.Request.Method = "OPTIONS"
-		c.Request.Header.Set("Access-Control-Request-Method", "POST")
-		server.CORSMiddleware()(c)
-		assert.Equal(t, http.StatusNoContent, w.Code)
-
-		// new mock context.
-		w = httptest.NewRecorder()
-		c, _ = gin.CreateTestContext(w)
-		c.Request = httptest.NewRequest("PATCH", "/books/:id", nil)
-
-		// Test OPTIONS PATCH method.
-		c.Request.Method = "OPTIONS"
-		c.Request.Header.Set("Access-Control-Request-Method", "PATCH")
-		server.CORSMiddleware()(c)
-		assert.Equal(t, http.StatusNoContent, w.Code)
-
-		// new mock context
-		w = httptest.NewRecorder()
-		c, _ = gin.CreateTestContext(w)
-		c.Request = httptest.NewRequest("PATCH", "/books/:id", nil)
-
-		// Test OPTIONS PUT method.
-		c.Request.Method = "OPTIONS"
-		c.Request.Header.Set("Access-Control-Request-Method", "PUT")
-		server.CORSMiddleware()(c)
-		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
-
-		// Test OPTIONS DELETE method.
-		w = httptest.NewRecorder()
-		c, _ = gin.CreateTestContext(w)
-		c.Request = httptest.NewRequest("DELETE", "/books/:id", nil)
-
-		// Test OPTIONS PUT method.
-		c.Request.Method = "OPTIONS"
-		c.Request.Header.Set("Access-Control-Request-Method", "DELETE")
-		server.CORSMiddleware()(c)
-		assert.Equal(t, http.StatusNoContent, w.Code)
+		assert.Equal(t, 500, problem.From(c.Errors.Last()).Status)
 	})
 }
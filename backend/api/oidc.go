@@ -0,0 +1,393 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/sso"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+)
+
+// Name of the cookie holding the signed flow state between the redirect to the provider and the callback.
+const flowCookieName = "oidc_flow"
+
+// Returns the key flow states are signed with. It is derived from the access token key rather than being that key,
+// so that a signed flow state can never pass for an access token or the other way around.
+func (s *Server) flowStateKey() []byte {
+	mac := hmac.New(sha256.New, []byte(s.AccessTokenSecretKey))
+	mac.Write([]byte("oidc-flow"))
+	return mac.Sum(nil)
+}
+
+// Returns the configured identity provider named in the request path, or responds with 404.
+func (s *Server) identityProvider(c *gin.Context) (*sso.Provider, bool) {
+	provider, ok := s.IdentityProviders[c.Param("provider")]
+	if !ok {
+		respondError(c, http.StatusNotFound, "identity provider not found")
+		return nil, false
+	}
+	return provider, true
+}
+
+// Starts an authorization flow with the provider, returns the URL the user must visit.
+func (s *Server) beginFlow(c *gin.Context, provider *sso.Provider, linkUserID int) (string, bool) {
+	flow, err := sso.NewFlowState(provider.Name, linkUserID)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to start login", err))
+		return "", false
+	}
+
+	encodedFlow, err := sso.EncodeFlowState(s.flowStateKey(), flow)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to start login", err))
+		return "", false
+	}
+
+	// Lax so that the cookie is sent on the top-level redirect back from the provider.
+	// Scoped to the callback, which may be any version of the route.
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(flowCookieName, encodedFlow, int(sso.FlowLifetime.Seconds()), provider.CallbackPath(), "", c.Request.TLS != nil, true)
+	return provider.AuthCodeURL(flow), true
+}
+
+func (s *Server) handleOIDCLogin(c *gin.Context) {
+	provider, ok := s.identityProvider(c)
+	if !ok {
+		return
+	}
+
+	authorizationURL, ok := s.beginFlow(c, provider, 0)
+	if !ok {
+		return
+	}
+	c.Redirect(http.StatusFound, authorizationURL)
+}
+
+func (s *Server) handleOIDCCallback(c *gin.Context) {
+	provider, ok := s.identityProvider(c)
+	if !ok {
+		return
+	}
+
+	// The flow state can only be used once.
+	encodedFlow, err := c.Cookie(flowCookieName)
+	c.SetCookie(flowCookieName, "", -1, provider.CallbackPath(), "", c.Request.TLS != nil, true)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "missing login state")
+		return
+	}
+
+	flow, err := sso.DecodeFlowState(s.flowStateKey(), encodedFlow)
+	if err != nil || flow.Provider != provider.Name || flow.State != c.Query("state") {
+		respondError(c, http.StatusBadRequest, "invalid login state")
+		return
+	}
+
+	// The provider reports errors, such as the user declining, through the callback.
+	if errorCode := c.Query("error"); errorCode != "" {
+		s.Metrics.ObserveLogin("oidc:"+provider.Name, "provider_error")
+		respondError(c, http.StatusUnauthorized, "login failed: "+errorCode)
+		return
+	}
+
+	claims, err := provider.Exchange(c.Request.Context(), c.Query("code"), flow)
+	if err != nil {
+		s.Metrics.ObserveLogin("oidc:"+provider.Name, "invalid_identity")
+		respondError(c, http.StatusUnauthorized, "failed to verify identity")
+		return
+	}
+
+	if flow.LinkUserID != 0 {
+		s.linkIdentity(c, provider, flow.LinkUserID, claims)
+		return
+	}
+	s.loginWithIdentity(c, provider, claims)
+}
+
+// Links the verified identity to the user who started the flow.
+func (s *Server) linkIdentity(c *gin.Context, provider *sso.Provider, userID int, claims *sso.Claims) {
+	existingIdentity, err := s.storage(c).GetIdentity(provider.Name, claims.Subject)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to fetch identity", err))
+		return
+	}
+	if existingIdentity != nil {
+		if existingIdentity.UserID != userID {
+			respondError(c, http.StatusConflict, "identity is linked to another user")
+			return
+		}
+		// SUCCESS, already linked.
+		c.IndentedJSON(http.StatusOK, existingIdentity)
+		return
+	}
+
+	user, err := s.storage(c).GetUser(userID)
+	if err != nil || user == nil {
+		respondError(c, http.StatusNotFound, "user not found")
+		return
+	}
+
+	identity, err := s.storage(c).CreateIdentity(&types.Identity{
+		UserID:   user.ID,
+		Provider: provider.Name,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		respondStorageError(c, err, "failed to link identity")
+		return
+	}
+
+	s.recordEvent(c, audit.Event{
+		Action:    "identity.link",
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Target:    "identity:" + provider.Name,
+		Details:   map[string]interface{}{"subject": claims.Subject},
+	})
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusCreated, identity)
+}
+
+// Logs in the user linked to the verified identity, provisioning a new user on first login.
+func (s *Server) loginWithIdentity(c *gin.Context, provider *sso.Provider, claims *sso.Claims) {
+	identity, err := s.storage(c).GetIdentity(provider.Name, claims.Subject)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to fetch identity", err))
+		return
+	}
+
+	if identity == nil {
+		user, ok := s.provisionUser(c, provider, claims)
+		if ok {
+			s.completeIdentityLogin(c, provider, user)
+		}
+		return
+	}
+
+	user, err := s.storage(c).GetUser(identity.UserID)
+	if err == nil && user == nil {
+		// Deleted accounts can still log in during the grace period, which cancels their deletion.
+		user, err = s.storage(c).GetDeletedUser(identity.UserID)
+		if !s.canCancelDeletion(user) {
+			user = nil
+		}
+	}
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to fetch user", err))
+		return
+	}
+
+	// The account was deleted too long ago to be restored, but hasn't been purged yet.
+	if user == nil {
+		s.recordEvent(c, audit.Event{
+			Action:    "auth.login_failed",
+			SubjectID: identity.UserID,
+			Target:    "identity:" + provider.Name,
+			Details:   map[string]interface{}{"reason": "account deleted"},
+		})
+		respondProblem(c, problem.New(http.StatusForbidden, problem.CodeInvalidCredentials, "invalid credentials"))
+		return
+	}
+	s.completeIdentityLogin(c, provider, user)
+}
+
+// Creates a user for an identity that has not been seen before and links the identity to it.
+func (s *Server) provisionUser(c *gin.Context, provider *sso.Provider, claims *sso.Claims) (*types.User, bool) {
+	// Only trust the email if the provider has verified it.
+	if claims.Email == "" || !claims.EmailVerified || !types.ValidateEmail(claims.Email) {
+		respondError(c, http.StatusForbidden, "a verified email is required")
+		return nil, false
+	}
+
+	// Existing accounts must be linked explicitly, otherwise anyone controlling the email at the provider could take them over.
+	emailTaken, err := s.storage(c).IsEmailTaken(claims.Email)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to check email", err))
+		return nil, false
+	}
+	if emailTaken {
+		respondProblem(c, problem.New(http.StatusConflict, problem.CodeEmailTaken, "email is taken, log in and link the identity instead"))
+		return nil, false
+	}
+
+	// The user logs in through the provider, so give them a random password nobody knows.
+	password, err := s.unusablePassword()
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to create user", err))
+		return nil, false
+	}
+
+	username := claims.Name
+	if username == "" {
+		username = strings.Split(claims.Email, "@")[0]
+	}
+
+	// The account and its identity are saved together, so that a failure can't leave an account nobody can log in to.
+	var user *types.User
+	err = storage.Transaction(s.storage(c), func(tx storage.Storage) error {
+		var err error
+		user, err = tx.CreateUser(&types.User{
+			Username: username,
+			Email:    claims.Email,
+			Password: password,
+			Role:     rbac.RoleUser,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateIdentity(&types.Identity{
+			UserID:   user.ID,
+			Provider: provider.Name,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		})
+		return err
+	})
+	if err != nil {
+		respondStorageError(c, err, "failed to create user")
+		return nil, false
+	}
+
+	s.recordEvent(c, audit.Event{
+		Action:    "user.register",
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Target:    "identity:" + provider.Name,
+		Details:   map[string]interface{}{"subject": claims.Subject},
+	})
+	return user, true
+}
+
+// Issues an access token for a user authenticated by an identity provider.
+func (s *Server) completeIdentityLogin(c *gin.Context, provider *sso.Provider, user *types.User) {
+	// Disabled accounts cannot log in.
+	if user.Disabled {
+		s.recordEvent(c, audit.Event{
+			Action:    "auth.login_failed",
+			SubjectID: user.ID,
+			Target:    "identity:" + provider.Name,
+			Details:   map[string]interface{}{"reason": "account disabled"},
+		})
+		respondProblem(c, problem.New(http.StatusForbidden, problem.CodeAccountDisabled, "account disabled"))
+		return
+	}
+
+	if user.DeletedAt.Valid && !s.cancelAccountDeletion(c, user) {
+		return
+	}
+
+	s.issueAccessToken(c, user, "oidc:"+provider.Name)
+}
+
+func (s *Server) handleGetIdentities(c *gin.Context) {
+
+	// Get the authorized user from the context.
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	identities, err := s.storage(c).GetIdentities(fetchedUser.ID)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to fetch identities", err))
+		return
+	}
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, identities)
+}
+
+func (s *Server) handleLinkIdentity(c *gin.Context) {
+	provider, ok := s.identityProvider(c)
+	if !ok {
+		return
+	}
+
+	// Get the authorized user from the context.
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	// The client sends the user to the returned URL; the callback completes the link.
+	authorizationURL, ok := s.beginFlow(c, provider, fetchedUser.ID)
+	if !ok {
+		return
+	}
+
+	// SUCCESS.
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authorizationURL})
+}
+
+func (s *Server) handleUnlinkIdentity(c *gin.Context) {
+
+	// Get the authenticated and authorized users from the context.
+	currentUser := c.MustGet("currentUser").(*types.User)
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	identities, err := s.storage(c).GetIdentities(fetchedUser.ID)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to fetch identities", err))
+		return
+	}
+
+	var identity *types.Identity
+	for i := range *identities {
+		if (*identities)[i].Provider == c.Param("provider") {
+			identity = &(*identities)[i]
+		}
+	}
+	if identity == nil {
+		respondError(c, http.StatusNotFound, "identity not found")
+		return
+	}
+
+	// Users without a password log in with their identities, the last one can't be removed.
+	if len(*identities) == 1 && !hasUsablePassword(fetchedUser) {
+		respondProblem(c, problem.New(http.StatusConflict, problem.CodeConflict, "the last identity can't be unlinked until a password is set"))
+		return
+	}
+
+	if err := s.storage(c).DeleteIdentity(identity); err != nil {
+		respondStorageError(c, err, "failed to unlink identity")
+		return
+	}
+
+	s.recordEvent(c, audit.Event{
+		Action:    "identity.unlink",
+		ActorID:   currentUser.ID,
+		SubjectID: fetchedUser.ID,
+		Target:    "identity:" + identity.Provider,
+		Details:   map[string]interface{}{"subject": identity.Subject},
+	})
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusNoContent, nil)
+}
+
+// Marks the passwords of users provisioned by an identity provider, which no password can match.
+const unusablePasswordPrefix = "!"
+
+// Returns the hash of a random secret, marked as unusable so that password login is impossible until the user sets a password.
+func (s *Server) unusablePassword() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	hashedPassword, err := s.HashPassword(hex.EncodeToString(buffer))
+	if err != nil {
+		return "", err
+	}
+	return unusablePasswordPrefix + hashedPassword, nil
+}
+
+// Reports whether the user can log in with a password, rather than only with their identities.
+func hasUsablePassword(user *types.User) bool {
+	return !strings.HasPrefix(user.Password, unusablePasswordPrefix)
+}
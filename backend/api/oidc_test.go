@@ -0,0 +1,256 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/sso"
+	"github.com/declanl482/go-book-tracker-app/backend/sso/ssotest"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Registers a provider named "mock" backed by a local mock OpenID Connect provider.
+func addMockIdentityProvider(t *testing.T, server *Server) *ssotest.MockProvider {
+	mock := ssotest.NewMockProvider("client", "secret")
+	t.Cleanup(mock.Close)
+
+	provider, err := sso.NewProvider(context.Background(), sso.ProviderConfig{
+		Name:         "mock",
+		IssuerURL:    mock.Issuer(),
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/auth/oidc/mock/callback",
+	})
+	assert.NoError(t, err)
+
+	server.IdentityProviders[provider.Name] = provider
+	return mock
+}
+
+// Completes the authorization at the mock provider and sends the resulting callback with the flow cookie.
+func completeFlow(t *testing.T, server *Server, mock *ssotest.MockProvider, authorizationURL string, flowCookie *http.Cookie) *httptest.ResponseRecorder {
+	callback, err := mock.Authorize(authorizationURL)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", callback.RequestURI(), nil)
+	req.AddCookie(flowCookie)
+
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+// Runs a full login through the mock provider.
+func loginWithMockProvider(t *testing.T, server *Server, mock *ssotest.MockProvider) *httptest.ResponseRecorder {
+	w := doRequest(server, "GET", "/auth/oidc/mock/login", nil, "")
+	assert.Equal(t, http.StatusFound, w.Code)
+
+	flowCookie := w.Result().Cookies()[0]
+	assert.Equal(t, flowCookieName, flowCookie.Name)
+	assert.True(t, flowCookie.HttpOnly)
+
+	return completeFlow(t, server, mock, w.Header().Get("Location"), flowCookie)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	server, store := newMemoryServer(t)
+	mock := addMockIdentityProvider(t, server)
+	createTestUser(t, store, "foo", rbac.RoleUser)
+
+	t.Run("TestProvisionOnFirstLogin", func(t *testing.T) {
+		mock.SetUser(ssotest.User{Subject: "1", Email: "fuzz@bar.com", EmailVerified: true, Name: "fuzz"})
+
+		w := loginWithMockProvider(t, server, mock)
+		assert.Equal(t, 200, w.Code)
+
+		var response map[string]string
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+		// The token works, and logging in again signs into the same user.
+		w = doRequest(server, "GET", "/books/", nil, response["access_token"])
+		assert.Equal(t, 200, w.Code)
+
+		user, err := store.GetUserByEmail("fuzz@bar.com")
+		assert.NoError(t, err)
+		assert.Equal(t, "fuzz", user.Username)
+
+		w = loginWithMockProvider(t, server, mock)
+		assert.Equal(t, 200, w.Code)
+
+		users, total, err := store.ListUsers(storage.UserFilter{})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), total)
+		assert.Len(t, *users, 2)
+	})
+
+	t.Run("TestRejectsExistingEmail", func(t *testing.T) {
+		mock.SetUser(ssotest.User{Subject: "2", Email: "foo@bar.com", EmailVerified: true})
+
+		w := loginWithMockProvider(t, server, mock)
+		assert.Equal(t, 409, w.Code)
+	})
+
+	t.Run("TestRejectsUnverifiedEmail", func(t *testing.T) {
+		mock.SetUser(ssotest.User{Subject: "3", Email: "buzz@bar.com"})
+
+		w := loginWithMockProvider(t, server, mock)
+		assert.Equal(t, 403, w.Code)
+	})
+
+	t.Run("TestRejectsInvalidState", func(t *testing.T) {
+		mock.SetUser(ssotest.User{Subject: "1", Email: "fuzz@bar.com", EmailVerified: true})
+
+		w := doRequest(server, "GET", "/auth/oidc/mock/login", nil, "")
+		flowCookie := w.Result().Cookies()[0]
+
+		callback, err := mock.Authorize(w.Header().Get("Location"))
+		assert.NoError(t, err)
+
+		// Tampered state.
+		query := callback.Query()
+		query.Set("state", "forged")
+		callback.RawQuery = query.Encode()
+
+		req := httptest.NewRequest("GET", callback.RequestURI(), nil)
+		req.AddCookie(flowCookie)
+		w = httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code)
+
+		// Missing flow cookie.
+		w = doRequest(server, "GET", "/auth/oidc/mock/callback?code=code&state=state", nil, "")
+		assert.Equal(t, 400, w.Code)
+
+		// Flow state signed with the access token key.
+		flow, err := sso.NewFlowState("mock", 0)
+		assert.NoError(t, err)
+		encodedFlow, err := sso.EncodeFlowState([]byte(testSecretKey), flow)
+		assert.NoError(t, err)
+		req = httptest.NewRequest("GET", "/auth/oidc/mock/callback?code=code&state="+flow.State, nil)
+		req.AddCookie(&http.Cookie{Name: flowCookieName, Value: encodedFlow})
+		w = httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code)
+	})
+
+	t.Run("TestDeletedAccount", func(t *testing.T) {
+		mock.SetUser(ssotest.User{Subject: "1", Email: "fuzz@bar.com", EmailVerified: true, Name: "fuzz"})
+		fuzz, err := store.GetUserByEmail("fuzz@bar.com")
+		assert.NoError(t, err)
+		assert.NoError(t, store.DeleteUser(fuzz))
+
+		// Past the grace period, the account can't be logged into until it is purged.
+		server.AccountDeletionGracePeriod = time.Nanosecond
+		defer func() { server.AccountDeletionGracePeriod = 14 * 24 * time.Hour }()
+		w := loginWithMockProvider(t, server, mock)
+		assert.Equal(t, 403, w.Code)
+		assert.Equal(t, problem.CodeInvalidCredentials, decodeProblem(t, w).Code)
+
+		// During the grace period, logging in restores it.
+		server.AccountDeletionGracePeriod = 14 * 24 * time.Hour
+		w = loginWithMockProvider(t, server, mock)
+		assert.Equal(t, 200, w.Code)
+		restoredUser, err := store.GetUser(fuzz.ID)
+		assert.NoError(t, err)
+		assert.NotNil(t, restoredUser)
+	})
+
+	t.Run("TestUnknownProvider", func(t *testing.T) {
+		w := doRequest(server, "GET", "/auth/oidc/unknown/login", nil, "")
+		assert.Equal(t, 404, w.Code)
+	})
+}
+
+func TestIdentityLinking(t *testing.T) {
+	server, store := newMemoryServer(t)
+	auditor := server.Auditor.(*recordingAuditor)
+	mock := addMockIdentityProvider(t, server)
+
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fuzz := createTestUser(t, store, "fuzz", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+	fuzzToken := accessTokenFor(t, store, fuzz)
+
+	link := func(user *types.User, accessToken string) *httptest.ResponseRecorder {
+		w := doRequest(server, "POST", fmt.Sprintf("/users/%d/identities/mock", user.ID), nil, accessToken)
+		assert.Equal(t, 200, w.Code)
+
+		var response map[string]string
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return completeFlow(t, server, mock, response["authorization_url"], w.Result().Cookies()[0])
+	}
+
+	mock.SetUser(ssotest.User{Subject: "42", Email: "foo@corp.com", EmailVerified: true})
+
+	// Link the identity to foo, then log in with it.
+	w := link(foo, fooToken)
+	assert.Equal(t, 201, w.Code)
+	assert.Contains(t, auditor.actions(), "identity.link")
+
+	w = loginWithMockProvider(t, server, mock)
+	assert.Equal(t, 200, w.Code)
+
+	var response map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	claims, err := NewAuth(testSecretKey).ParseAccessToken(response["access_token"])
+	assert.NoError(t, err)
+	assert.Equal(t, foo.ID, claims.UserID)
+
+	// The identity cannot be linked to another user.
+	w = link(fuzz, fuzzToken)
+	assert.Equal(t, 409, w.Code)
+
+	// Other users can neither see nor change foo's identities.
+	w = doRequest(server, "GET", fmt.Sprintf("/users/%d/identities", foo.ID), nil, fuzzToken)
+	assert.Equal(t, 401, w.Code)
+
+	w = doRequest(server, "DELETE", fmt.Sprintf("/users/%d/identities/mock", foo.ID), nil, fuzzToken)
+	assert.Equal(t, 401, w.Code)
+
+	w = doRequest(server, "GET", fmt.Sprintf("/users/%d/identities", foo.ID), nil, fooToken)
+	assert.Equal(t, 200, w.Code)
+
+	var identities []types.Identity
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &identities))
+	assert.Len(t, identities, 1)
+	assert.Equal(t, "42", identities[0].Subject)
+
+	// Unlink the identity.
+	w = doRequest(server, "DELETE", fmt.Sprintf("/users/%d/identities/mock", foo.ID), nil, fooToken)
+	assert.Equal(t, 204, w.Code)
+	assert.Contains(t, auditor.actions(), "identity.unlink")
+
+	w = doRequest(server, "DELETE", fmt.Sprintf("/users/%d/identities/mock", foo.ID), nil, fooToken)
+	assert.Equal(t, 404, w.Code)
+
+	t.Run("TestLastIdentity", func(t *testing.T) {
+		// Users provisioned by the provider have no password, they can't unlink the identity they log in with.
+		mock.SetUser(ssotest.User{Subject: "43", Email: "fizz@corp.com", EmailVerified: true, Name: "fizz"})
+		w := loginWithMockProvider(t, server, mock)
+		assert.Equal(t, 200, w.Code)
+		var response map[string]string
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		fizz, err := store.GetUserByEmail("fizz@corp.com")
+		assert.NoError(t, err)
+		fizzPath := fmt.Sprintf("/v1/users/%d", fizz.ID)
+
+		w = doRequest(server, "DELETE", fizzPath+"/identities/mock", nil, response["access_token"])
+		assert.Equal(t, 409, w.Code)
+		assert.Equal(t, problem.CodeConflict, decodeProblem(t, w).Code)
+
+		// Once they set a password, they can.
+		w = doRequest(server, "PATCH", fizzPath, map[string]string{"password": "correct horse battery staple"}, response["access_token"])
+		assert.Equal(t, 200, w.Code)
+		w = doRequest(server, "DELETE", fizzPath+"/identities/mock", nil, response["access_token"])
+		assert.Equal(t, 204, w.Code)
+	})
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The OpenAPI document describing the routes, and a page rendering it.
+// The contract tests fail when a route is missing from the document or responds differently.
+//
+//go:embed openapi/openapi.json openapi/docs.html
+var openAPIFiles embed.FS
+
+// Serves the OpenAPI document.
+func (s *Server) handleOpenAPI(c *gin.Context) {
+	document, err := openAPIFiles.ReadFile("openapi/openapi.json")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to read the API description")
+		return
+	}
+	c.Data(http.StatusOK, "application/json", document)
+}
+
+// Serves the page browsing the OpenAPI document.
+func (s *Server) handleDocs(c *gin.Context) {
+	page, err := openAPIFiles.ReadFile("openapi/docs.html")
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, "failed to read the API docs")
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+}
@@ -0,0 +1,361 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/sso/ssotest"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/declanl482/go-book-tracker-app/backend/webhooks"
+	"github.com/gin-gonic/gin"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/stretchr/testify/assert"
+)
+
+// Name the OpenAPI document is registered under with the schema compiler.
+const openAPILocation = "openapi.json"
+
+// contract checks responses against the OpenAPI document.
+type contract struct {
+	document map[string]interface{}
+	compiler *jsonschema.Compiler
+	schemas  map[string]*jsonschema.Schema
+	// The operations a response was checked for, keyed by method and path.
+	checked map[string]bool
+}
+
+func loadContract(t *testing.T) *contract {
+	data, err := openAPIFiles.ReadFile("openapi/openapi.json")
+	assert.NoError(t, err)
+
+	var document map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &document))
+
+	resource, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	assert.NoError(t, err)
+
+	compiler := jsonschema.NewCompiler()
+	compiler.DefaultDraft(jsonschema.Draft2020)
+	assert.NoError(t, compiler.AddResource(openAPILocation, resource))
+
+	return &contract{document: document, compiler: compiler, schemas: make(map[string]*jsonschema.Schema), checked: make(map[string]bool)}
+}
+
+// Returns the operations of the document keyed by method and path, e.g. "GET /users/{id}".
+func (c *contract) operations() map[string]map[string]interface{} {
+	operations := make(map[string]map[string]interface{})
+	for path, item := range c.document["paths"].(map[string]interface{}) {
+		for method, operation := range item.(map[string]interface{}) {
+			if operation, ok := operation.(map[string]interface{}); ok && method != "parameters" {
+				operations[strings.ToUpper(method)+" "+path] = operation
+			}
+		}
+	}
+	return operations
+}
+
+// Resolves a local reference, returning the value and the tokens of its JSON pointer.
+func (c *contract) resolve(pointer []string, value map[string]interface{}) ([]string, map[string]interface{}) {
+	ref, ok := value["$ref"].(string)
+	if !ok {
+		return pointer, value
+	}
+
+	pointer = nil
+	var node interface{} = c.document
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		pointer = append(pointer, token)
+		node = node.(map[string]interface{})[token]
+	}
+	return pointer, node.(map[string]interface{})
+}
+
+// Compiles the schema at the JSON pointer into the document.
+func (c *contract) schema(t *testing.T, pointer ...string) *jsonschema.Schema {
+	location := openAPILocation + "#"
+	for _, token := range pointer {
+		location += "/" + url.PathEscape(strings.NewReplacer("~", "~0", "/", "~1").Replace(token))
+	}
+	if schema, ok := c.schemas[location]; ok {
+		return schema
+	}
+
+	schema, err := c.compiler.Compile(location)
+	if !assert.NoError(t, err, "compiling the schema at %s", location) {
+		t.FailNow()
+	}
+
+	c.schemas[location] = schema
+	return schema
+}
+
+// Checks that the response is documented for the operation and that its body validates against the documented schema.
+func (c *contract) check(t *testing.T, method string, path string, w *httptest.ResponseRecorder) {
+	t.Helper()
+
+	key := method + " " + path
+	operation, ok := c.operations()[key]
+	if !assert.True(t, ok, "%s is not documented", key) {
+		return
+	}
+
+	status := strconv.Itoa(w.Code)
+	response, ok := operation["responses"].(map[string]interface{})[status].(map[string]interface{})
+	if !assert.True(t, ok, "%s responded with undocumented status %s: %s", key, status, w.Body.String()) {
+		return
+	}
+	c.checked[key] = true
+
+	pointer, response := c.resolve([]string{"paths", path, strings.ToLower(method), "responses", status}, response)
+	content, ok := response["content"].(map[string]interface{})
+	if !ok {
+		assert.Empty(t, w.Body.String(), "%s %s is documented without a body", key, status)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if !assert.NoError(t, err, "%s %s has no content type", key, status) {
+		return
+	}
+	if _, ok := content[mediaType]; !assert.True(t, ok, "%s %s responded with undocumented content type %s", key, status, mediaType) {
+		return
+	}
+	if !strings.HasSuffix(mediaType, "json") {
+		return
+	}
+
+	body, err := jsonschema.UnmarshalJSON(bytes.NewReader(w.Body.Bytes()))
+	if !assert.NoError(t, err, "%s %s responded with invalid JSON", key, status) {
+		return
+	}
+	err = c.schema(t, append(pointer, "content", mediaType, "schema")...).Validate(body)
+	assert.NoError(t, err, "%s %s doesn't match the documented schema: %s", key, status, w.Body.String())
+}
+
+// Matches the parameters of gin route paths, e.g. ":id".
+var routeParameter = regexp.MustCompile(`[:*](\w+)`)
+
+func TestOpenAPIRoutes(t *testing.T) {
+	server, _ := newMemoryServer(t)
+	contract := loadContract(t)
+	operations := contract.operations()
+
+	// Every registered route is documented...
+	registered := make(map[string]bool)
+	for _, route := range server.router.Routes() {
+		path := routeParameter.ReplaceAllString(route.Path, "{$1}")
+		registered[route.Method+" "+path] = true
+
+		key := route.Method + " " + strings.TrimPrefix(path, "/v1")
+		assert.Contains(t, operations, key, "%s %s is registered but not documented", route.Method, route.Path)
+	}
+
+	// ...and every documented route is registered, under /v1 and as a deprecated alias unless the path has its own servers.
+	paths := contract.document["paths"].(map[string]interface{})
+	for key := range operations {
+		method, path, _ := strings.Cut(key, " ")
+		if _, ok := paths[path].(map[string]interface{})["servers"]; ok {
+			assert.True(t, registered[key], "%s is documented but not registered", key)
+			continue
+		}
+		assert.True(t, registered[method+" /v1"+path], "%s is documented but not registered under /v1", key)
+		assert.True(t, registered[key], "%s is documented but has no deprecated alias", key)
+	}
+
+	w := doRequest(server, "GET", "/openapi.json", nil, "")
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, mustReadFile(t, "openapi/openapi.json"), w.Body.String())
+
+	w = doRequest(server, "GET", "/docs", nil, "")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "openapi.json")
+}
+
+func mustReadFile(t *testing.T, name string) string {
+	data, err := openAPIFiles.ReadFile(name)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestOpenAPIResponses(t *testing.T) {
+	server, store := newMemoryServer(t)
+	mock := addMockIdentityProvider(t, server)
+	contract := loadContract(t)
+
+	admin := createTestUser(t, store, "admin", rbac.RoleAdmin)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	bar := createTestUser(t, store, "bar", rbac.RoleUser)
+	buzz := createTestUser(t, store, "buzz", rbac.RoleUser)
+	adminToken := accessTokenFor(t, store, admin)
+	fooToken := accessTokenFor(t, store, foo)
+	barToken := accessTokenFor(t, store, bar)
+
+	book, err := store.CreateBook(&types.Book{Title: "Foo", Author: "Bar", PagesCount: 100, PagesRead: 10, OwnerID: foo.ID})
+	assert.NoError(t, err)
+	trashedBook, err := store.CreateBook(&types.Book{Title: "Foo", Author: "Bar", PagesCount: 100, PagesRead: 10, OwnerID: foo.ID})
+	assert.NoError(t, err)
+	assert.NoError(t, store.DeleteBook(trashedBook))
+
+	// A webhook endpoint with a delivery in its log.
+	webhook := webhooks.Endpoint{UserID: foo.ID, URL: "https://example.com/hook", Secret: "secret", EventTypes: []string{events.BookCreated}}
+	assert.NoError(t, server.Webhooks.Store.CreateEndpoint(&webhook))
+	event, err := events.New(events.BookCreated, foo.ID, book)
+	assert.NoError(t, err)
+	deliveries, err := server.Webhooks.Enqueue(event)
+	assert.NoError(t, err)
+
+	login := func(email string, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/auth/login", strings.NewReader(url.Values{"username": {email}, "password": {password}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	fooPath := fmt.Sprintf("/v1/users/%d", foo.ID)
+	bookPath := fmt.Sprintf("/v1/books/%d", book.ID)
+	buzzAdminPath := fmt.Sprintf("/v1/admin/users/%d", buzz.ID)
+	webhookPath := fmt.Sprintf("%s/webhooks/%d", fooPath, webhook.ID)
+
+	tests := []struct {
+		method string
+		route  string
+		path   string
+		body   interface{}
+		token  string
+		status int
+	}{
+		{"POST", "/auth/register", "/v1/auth/register", gin.H{"username": "fuzz", "email": "fuzz@bar.com", "password": "correct horse battery staple"}, "", 201},
+		{"POST", "/auth/register", "/v1/auth/register", gin.H{"username": "fuzz"}, "", 400},
+		{"POST", "/auth/register", "/v1/auth/register", gin.H{"username": "fuzz", "email": "fuzz@bar.com", "password": "correct horse battery staple"}, "", 400},
+
+		{"POST", "/books/", "/v1/books/", gin.H{"title": "Foo", "author": "Bar", "pages_count": 200, "pages_read": 1}, fooToken, 201},
+		{"POST", "/books/", "/v1/books/", gin.H{"title": "Foo", "author": "Bar", "pages_count": 10, "pages_read": 20}, fooToken, 400},
+		{"GET", "/books/", "/v1/books/", nil, fooToken, 200},
+		{"GET", "/books/", "/v1/books/", nil, "", 401},
+		{"GET", "/books/{id}", bookPath, nil, fooToken, 200},
+		{"GET", "/books/{id}", "/v1/books/abc", nil, fooToken, 400},
+		{"GET", "/books/{id}", "/v1/books/999", nil, fooToken, 404},
+		{"GET", "/books/{id}", bookPath, nil, barToken, 401},
+		{"PATCH", "/books/{id}", bookPath, gin.H{"pages_read": 50}, fooToken, 200},
+		{"POST", "/books/batch", "/v1/books/batch", gin.H{"operations": []gin.H{
+			{"op": "create", "book": gin.H{"title": "Foo", "author": "Bar", "pages_count": 200, "pages_read": 1}},
+			{"op": "update", "id": 999, "patch": gin.H{"pages_read": 2}},
+		}}, fooToken, 200},
+		{"POST", "/books/batch", "/v1/books/batch", gin.H{"operations": []gin.H{}}, fooToken, 400},
+		{"GET", "/search", "/v1/search?q=foo", nil, fooToken, 200},
+		{"GET", "/search", "/v1/search", nil, fooToken, 400},
+		{"GET", "/books/trash", "/v1/books/trash", nil, fooToken, 200},
+		{"GET", "/events", "/v1/events", nil, "", 401},
+		{"POST", "/books/{id}/restore", fmt.Sprintf("/v1/books/%d/restore", trashedBook.ID), nil, fooToken, 200},
+		{"POST", "/books/{id}/restore", bookPath + "/restore", nil, fooToken, 404},
+
+		{"GET", "/users/{id}", fooPath, nil, fooToken, 200},
+		{"GET", "/users/{id}", "/v1/users/999", nil, fooToken, 404},
+		{"PATCH", "/users/{id}", fooPath, gin.H{"username": "foo bar"}, fooToken, 200},
+		{"PATCH", "/users/{id}", fooPath, gin.H{"password": "foo"}, fooToken, 400},
+		{"GET", "/users/{id}/sessions", fooPath + "/sessions", nil, fooToken, 200},
+		{"DELETE", "/users/{id}/sessions/{sessionID}", fooPath + "/sessions/unknown", nil, fooToken, 404},
+		{"GET", "/users/{id}/activity", fooPath + "/activity", nil, fooToken, 200},
+		{"GET", "/users/{id}/activity", fooPath + "/activity?since=yesterday", nil, fooToken, 400},
+		{"GET", "/users/{id}/identities", fooPath + "/identities", nil, fooToken, 200},
+		{"DELETE", "/users/{id}/identities/{provider}", fooPath + "/identities/mock", nil, fooToken, 404},
+		{"POST", "/users/{id}/webhooks", fooPath + "/webhooks", gin.H{"url": "https://example.com/hook", "event_types": []string{"book.finished"}}, fooToken, 201},
+		{"POST", "/users/{id}/webhooks", fooPath + "/webhooks", gin.H{"url": "https://example.com/hook", "event_types": []string{"book.read"}}, fooToken, 400},
+		{"GET", "/users/{id}/webhooks", fooPath + "/webhooks", nil, fooToken, 200},
+		{"GET", "/users/{id}/webhooks/{webhookID}", webhookPath, nil, fooToken, 200},
+		{"GET", "/users/{id}/webhooks/{webhookID}", fooPath + "/webhooks/999", nil, fooToken, 404},
+		{"GET", "/users/{id}/webhooks/{webhookID}/deliveries", webhookPath + "/deliveries", nil, fooToken, 200},
+		{"POST", "/users/{id}/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver", fmt.Sprintf("%s/deliveries/%d/redeliver", webhookPath, deliveries[0].ID), nil, fooToken, 202},
+		{"POST", "/users/{id}/webhooks/{webhookID}/deliveries/{deliveryID}/redeliver", webhookPath + "/deliveries/999/redeliver", nil, fooToken, 404},
+		{"DELETE", "/users/{id}/webhooks/{webhookID}", webhookPath, nil, fooToken, 204},
+
+		{"GET", "/admin/users", "/v1/admin/users?q=foo", nil, adminToken, 200},
+		{"GET", "/admin/users", "/v1/admin/users", nil, fooToken, 403},
+		{"GET", "/admin/users/{id}", buzzAdminPath, nil, adminToken, 200},
+		{"POST", "/admin/users/{id}/disable", buzzAdminPath + "/disable", nil, adminToken, 200},
+		{"POST", "/admin/users/{id}/enable", buzzAdminPath + "/enable", nil, adminToken, 200},
+		{"POST", "/admin/users/{id}/password-reset", buzzAdminPath + "/password-reset", nil, adminToken, 200},
+		{"PUT", "/admin/users/{id}/role", buzzAdminPath + "/role", gin.H{"role": "admin"}, adminToken, 200},
+		{"PUT", "/admin/users/{id}/role", buzzAdminPath + "/role", gin.H{}, adminToken, 400},
+		{"GET", "/admin/stats", "/v1/admin/stats", nil, adminToken, 200},
+		{"GET", "/admin/audit", "/v1/admin/audit?action=book.update", nil, adminToken, 200},
+		{"GET", "/admin/audit", "/v1/admin/audit?limit=none", nil, adminToken, 400},
+
+		{"POST", "/graphql", "/graphql", gin.H{"query": "{ me { username books { title percentComplete } } }"}, fooToken, 200},
+		{"POST", "/graphql", "/graphql", gin.H{}, fooToken, 400},
+		{"POST", "/graphql", "/graphql", gin.H{"query": "{ me { username } }"}, "invalid", 401},
+		{"POST", "/graphql", "/graphql", gin.H{"query": "{ me { username } }" + strings.Repeat(" ", graphqlMaxBodySize)}, fooToken, 413},
+
+		{"GET", "/healthz", "/healthz", nil, "", 200},
+		{"GET", "/readyz", "/readyz", nil, "", 200},
+		{"GET", "/metrics", "/metrics", nil, "", 200},
+		{"GET", "/openapi.json", "/openapi.json", nil, "", 200},
+		{"GET", "/docs", "/docs", nil, "", 200},
+
+		{"DELETE", "/books/{id}", bookPath, nil, fooToken, 204},
+		{"DELETE", "/users/{id}", fmt.Sprintf("/v1/users/%d", bar.ID), nil, barToken, 204},
+	}
+
+	for _, tt := range tests {
+		w := doRequest(server, tt.method, tt.path, tt.body, tt.token)
+		assert.Equal(t, tt.status, w.Code, "%s %s: %s", tt.method, tt.path, w.Body.String())
+		contract.check(t, tt.method, tt.route, w)
+	}
+
+	// The event stream, until the request is cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("GET", "/v1/events", nil).WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+fooToken)
+	stream := httptest.NewRecorder()
+	server.router.ServeHTTP(stream, req)
+	contract.check(t, "GET", "/events", stream)
+
+	// Password logins.
+	contract.check(t, "POST", "/auth/login", login("foo@bar.com", "foo"))
+	contract.check(t, "POST", "/auth/login", login("foo@bar.com", "wrong"))
+	contract.check(t, "POST", "/auth/login", login("not an email", "foo"))
+
+	// Linking an identity, then logging in with it.
+	mock.SetUser(ssotest.User{Subject: "42", Email: "foo@corp.com", EmailVerified: true})
+
+	w := doRequest(server, "POST", fooPath+"/identities/mock", nil, fooToken)
+	contract.check(t, "POST", "/users/{id}/identities/{provider}", w)
+
+	var response map[string]string
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	w = completeFlow(t, server, mock, response["authorization_url"], w.Result().Cookies()[0])
+	assert.Equal(t, 201, w.Code)
+	contract.check(t, "GET", "/auth/oidc/{provider}/callback", w)
+
+	w = doRequest(server, "GET", "/v1/auth/oidc/mock/login", nil, "")
+	contract.check(t, "GET", "/auth/oidc/{provider}/login", w)
+	w = completeFlow(t, server, mock, w.Header().Get("Location"), w.Result().Cookies()[0])
+	assert.Equal(t, 200, w.Code)
+	contract.check(t, "GET", "/auth/oidc/{provider}/callback", w)
+
+	contract.check(t, "GET", "/auth/oidc/{provider}/login", doRequest(server, "GET", "/v1/auth/oidc/unknown/login", nil, ""))
+	contract.check(t, "GET", "/auth/oidc/{provider}/callback", doRequest(server, "GET", "/v1/auth/oidc/mock/callback?code=code&state=state", nil, ""))
+
+	contract.check(t, "DELETE", "/users/{id}/identities/{provider}", doRequest(server, "DELETE", fooPath+"/identities/mock", nil, fooToken))
+	contract.check(t, "DELETE", "/users/{id}", doRequest(server, "DELETE", fooPath, nil, fooToken))
+
+	// Every documented operation was exercised.
+	for key := range contract.operations() {
+		assert.True(t, contract.checked[key], "no response was checked for %s", key)
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/passwords"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	server, store := newMemoryServer(t)
+
+	register := func(password string) *httptest.ResponseRecorder {
+		return doRequest(server, "POST", "/auth/register", map[string]string{
+			"username": "buzz",
+			"email":    "buzz@bar.com",
+			"password": password,
+		}, "")
+	}
+
+	// Weak passwords are rejected with the reason.
+	w := register("short")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "at least 10 characters")
+
+	w = register("buzz-is-my-password")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "username or email")
+
+	w = register("password123")
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "too easy to guess")
+
+	// Passwords too long to hash are rejected before their strength is checked.
+	w = register(strings.Repeat("plum-Orbit-57-lantern ", 60))
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "at most 72 bytes")
+
+	w = register("plum-Orbit-57-lantern")
+	assert.Equal(t, 201, w.Code)
+
+	// The policy also applies to password changes.
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+
+	w = doRequest(server, "PATCH", fmt.Sprintf("/users/%d", foo.ID), map[string]interface{}{"password": "foofoofoofoo"}, fooToken)
+	assert.Equal(t, 400, w.Code)
+
+	w = doRequest(server, "PATCH", fmt.Sprintf("/users/%d", foo.ID), map[string]interface{}{"password": "quartz-Meadow-83-violin"}, fooToken)
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestRehashOnLogin(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	auditor := &recordingAuditor{}
+	server.Auditor = auditor
+
+	// Raise the cost above the one the test user's password was hashed with.
+	server.PasswordHasher = passwords.Hasher{Cost: bcrypt.MinCost + 1}
+
+	credentials := url.Values{}
+	credentials.Set("username", "foo@bar.com")
+	credentials.Set("password", "foo")
+
+	req := httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	// The stored hash was upgraded and still matches the password.
+	user, err := store.GetUserByEmail("foo@bar.com")
+	assert.NoError(t, err)
+
+	cost, err := bcrypt.Cost([]byte(user.Password))
+	assert.NoError(t, err)
+	assert.Equal(t, bcrypt.MinCost+1, cost)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(user.Password), []byte("foo")))
+
+	// Only the hash changed, which is audit-logged, while the version clients see stays the same.
+	assert.Equal(t, foo.Version, user.Version)
+	assert.Contains(t, auditor.actions(), "user.password_rehash")
+}
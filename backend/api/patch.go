@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/declanl482/go-book-tracker-app/backend/patch"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Fields of the representations that clients may change with PATCH. The others are read-only,
+// or in the case of the account state only changed through the admin API.
+var (
+	bookPatchFields = map[string]bool{"title": true, "edition": true, "author": true, "pages_count": true, "pages_read": true}
+	userPatchFields = map[string]bool{"username": true, "email": true, "password": true}
+)
+
+// The fields of a patched user that are kept. Decoding JSON matches names case-insensitively,
+// so the patched user is decoded into this rather than a types.User, which would take {"Role": ...}.
+type userPatch struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+// Applies the patch in the request body to the representation of the current resource, and decodes the result into patched.
+// Responds with a problem and returns false if the patch can't be applied, see patchResource.
+func applyPatch(c *gin.Context, current interface{}, patched interface{}, allowed map[string]bool) bool {
+	body, err := c.GetRawData()
+	if err != nil {
+		respondBindingError(c, err)
+		return false
+	}
+	if err := patchResource(c.ContentType(), body, current, patched, allowed); err != nil {
+		respondProblem(c, err)
+		return false
+	}
+	return true
+}
+
+// Applies the patch to the representation of the current resource, and decodes the result into patched.
+// The patch is a JSON Merge Patch or a JSON Patch depending on its content type, plain JSON is treated as a merge patch.
+// Returns the problem if the patch is malformed or can't be applied, or changes a field that isn't allowed.
+func patchResource(contentType string, body []byte, current interface{}, patched interface{}, allowed map[string]bool) *problem.Error {
+	var document map[string]interface{}
+	if err := roundTrip(current, &document); err != nil {
+		return problem.Internal("failed to encode the resource", err)
+	}
+
+	var result interface{}
+	switch contentType {
+	case patch.MergePatchContentType, binding.MIMEJSON, "":
+		var mergePatch interface{}
+		if err := json.Unmarshal(body, &mergePatch); err != nil {
+			return problem.FromBinding(err)
+		}
+		if _, ok := mergePatch.(map[string]interface{}); !ok {
+			return problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "merge patch must be a JSON object")
+		}
+		result = patch.Merge(document, mergePatch)
+
+	case patch.JSONPatchContentType:
+		var operations []patch.Operation
+		if err := json.Unmarshal(body, &operations); err != nil {
+			return problem.FromBinding(err)
+		}
+		var err error
+		result, err = patch.Apply(document, operations)
+		if errors.Is(err, patch.ErrTestFailed) {
+			return &problem.Error{Status: http.StatusConflict, Code: problem.CodeConflict, Detail: err.Error(), Err: err}
+		}
+		if err != nil {
+			return &problem.Error{Status: http.StatusBadRequest, Code: problem.CodeInvalidRequest, Detail: err.Error(), Err: err}
+		}
+
+	default:
+		return problem.New(http.StatusUnsupportedMediaType, problem.CodeInvalidRequest, "request body must be application/json, "+patch.MergePatchContentType+" or "+patch.JSONPatchContentType)
+	}
+
+	resultObject, ok := result.(map[string]interface{})
+	if !ok {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "patched resource must be a JSON object")
+	}
+
+	// Reject changes to the fields that aren't allowed, rather than silently dropping them.
+	var fields []problem.FieldError
+	for _, name := range patch.Changed(document, resultObject) {
+		if allowed[name] {
+			continue
+		}
+		if _, ok := document[name]; ok {
+			fields = append(fields, problem.FieldError{Field: name, Code: "read_only", Message: "can't be changed"})
+		} else {
+			fields = append(fields, problem.FieldError{Field: name, Code: "unknown", Message: "is not a field of the resource"})
+		}
+	}
+	if len(fields) > 0 {
+		return problem.Validation(fields...)
+	}
+
+	if err := roundTrip(resultObject, patched); err != nil {
+		return problem.FromBinding(err)
+	}
+	return nil
+}
+
+// Encodes the value as JSON and decodes it into target.
+func roundTrip(value interface{}, target interface{}) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(encoded, target)
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/patch"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPatchBook(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+
+	book, err := store.CreateBook(&types.Book{Title: "Foo", Edition: 2, Author: "Bar", PagesCount: 100, PagesRead: 10, OwnerID: foo.ID})
+	assert.NoError(t, err)
+	bookPath := fmt.Sprintf("/v1/books/%d", book.ID)
+
+	mergePatch := map[string]string{"Content-Type": patch.MergePatchContentType}
+	jsonPatch := map[string]string{"Content-Type": patch.JSONPatchContentType}
+
+	// Decodes the book in the response.
+	decodeBook := func(t *testing.T, body []byte) types.Book {
+		var book types.Book
+		assert.NoError(t, json.Unmarshal(body, &book))
+		return book
+	}
+
+	t.Run("TestMergePatch", func(t *testing.T) {
+		// Zero values can be set, and the other fields keep their values.
+		w := doRequestWithHeaders(server, "PATCH", bookPath, gin.H{"pages_read": 0}, fooToken, mergePatch)
+		assert.Equal(t, 200, w.Code)
+		patchedBook := decodeBook(t, w.Body.Bytes())
+		assert.Equal(t, 0, patchedBook.PagesRead)
+		assert.Equal(t, "Foo", patchedBook.Title)
+		assert.Equal(t, 100, patchedBook.PagesCount)
+
+		// Null removes the edition.
+		w = doRequestWithHeaders(server, "PATCH", bookPath, gin.H{"edition": nil, "pages_read": 20}, fooToken, mergePatch)
+		assert.Equal(t, 200, w.Code)
+		patchedBook = decodeBook(t, w.Body.Bytes())
+		assert.Equal(t, 0, patchedBook.Edition)
+		assert.Equal(t, 20, patchedBook.PagesRead)
+
+		// Plain JSON bodies are merge patches too.
+		w = doRequest(server, "PATCH", bookPath, gin.H{"title": "Fuzz"}, fooToken)
+		assert.Equal(t, 200, w.Code)
+		assert.Equal(t, "Fuzz", decodeBook(t, w.Body.Bytes()).Title)
+	})
+
+	t.Run("TestJSONPatch", func(t *testing.T) {
+		operations := []gin.H{
+			{"op": "test", "path": "/pages_read", "value": 20},
+			{"op": "replace", "path": "/pages_read", "value": 30},
+			{"op": "copy", "from": "/pages_read", "path": "/edition"},
+		}
+		w := doRequestWithHeaders(server, "PATCH", bookPath, operations, fooToken, jsonPatch)
+		assert.Equal(t, 200, w.Code)
+		patchedBook := decodeBook(t, w.Body.Bytes())
+		assert.Equal(t, 30, patchedBook.PagesRead)
+		assert.Equal(t, 30, patchedBook.Edition)
+
+		// A failed test leaves the book unchanged.
+		operations = []gin.H{
+			{"op": "replace", "path": "/pages_read", "value": 40},
+			{"op": "test", "path": "/title", "value": "Foo"},
+		}
+		w = doRequestWithHeaders(server, "PATCH", bookPath, operations, fooToken, jsonPatch)
+		assert.Equal(t, 409, w.Code)
+		assert.Equal(t, problem.CodeConflict, decodeProblem(t, w).Code)
+
+		// So does an operation that can't be applied.
+		w = doRequestWithHeaders(server, "PATCH", bookPath, []gin.H{{"op": "remove", "path": "/subtitle"}}, fooToken, jsonPatch)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, `operation 0 (remove /subtitle): member "subtitle" not found`, decodeProblem(t, w).Detail)
+
+		storedBook, err := store.GetBook(book.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 30, storedBook.PagesRead)
+	})
+
+	t.Run("TestRejectedPatches", func(t *testing.T) {
+		for _, test := range []struct {
+			name    string
+			body    interface{}
+			headers map[string]string
+			status  int
+			fields  []problem.FieldError
+		}{
+			{"read-only fields", gin.H{"owner_id": foo.ID + 1, "id": 100, "pages_read": 1}, mergePatch, 400, []problem.FieldError{
+				{Field: "id", Code: "read_only", Message: "can't be changed"},
+				{Field: "owner_id", Code: "read_only", Message: "can't be changed"},
+			}},
+			{"removed read-only field", []gin.H{{"op": "remove", "path": "/created_at"}}, jsonPatch, 400, []problem.FieldError{
+				{Field: "created_at", Code: "read_only", Message: "can't be changed"},
+			}},
+			{"unknown field", []gin.H{{"op": "add", "path": "/subtitle", "value": "Bar"}}, jsonPatch, 400, []problem.FieldError{
+				{Field: "subtitle", Code: "unknown", Message: "is not a field of the resource"},
+			}},
+			{"invalid result", gin.H{"pages_count": 10}, mergePatch, 400, []problem.FieldError{
+				{Field: "pages_read", Code: "range", Message: "must be between 0 and pages_count"},
+			}},
+			{"removed required field", gin.H{"title": nil}, mergePatch, 400, []problem.FieldError{
+				{Field: "title", Code: "required", Message: "is required"},
+			}},
+			{"wrong type", gin.H{"pages_read": "ten"}, mergePatch, 400, []problem.FieldError{
+				{Field: "pages_read", Code: "type", Message: "must be a number"},
+			}},
+			{"merge patch array", []int{1}, mergePatch, 400, nil},
+			{"unsupported media type", gin.H{"title": "Bar"}, map[string]string{"Content-Type": "text/plain"}, 415, nil},
+		} {
+			w := doRequestWithHeaders(server, "PATCH", bookPath, test.body, fooToken, test.headers)
+			assert.Equal(t, test.status, w.Code, test.name)
+			assert.Equal(t, test.fields, decodeProblem(t, w).Errors, test.name)
+		}
+
+		storedBook, err := store.GetBook(book.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, foo.ID, storedBook.OwnerID)
+		assert.Equal(t, "Fuzz", storedBook.Title)
+		assert.Equal(t, 30, storedBook.PagesRead)
+	})
+}
+
+func TestPatchUser(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+	userPath := fmt.Sprintf("/v1/users/%d", foo.ID)
+
+	_, err := store.CreateBook(&types.Book{Title: "Foo", Author: "Bar", PagesCount: 100, PagesRead: 10, OwnerID: foo.ID})
+	assert.NoError(t, err)
+
+	// A new password is checked against the policy and hashed.
+	operations := []gin.H{
+		{"op": "replace", "path": "/password", "value": "new-Orbit-57-lantern"},
+		{"op": "replace", "path": "/username", "value": "fooer"},
+	}
+	w := doRequestWithHeaders(server, "PATCH", userPath, operations, fooToken, map[string]string{"Content-Type": patch.JSONPatchContentType})
+	assert.Equal(t, 200, w.Code)
+
+	storedUser, err := store.GetUser(foo.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "fooer", storedUser.Username)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(storedUser.Password), []byte("new-Orbit-57-lantern")))
+
+	// Leaving the password hash as it is keeps the password.
+	w = doRequest(server, "PATCH", userPath, gin.H{"username": "foo", "password": storedUser.Password}, fooToken)
+	assert.Equal(t, 200, w.Code)
+	unchangedUser, err := store.GetUser(foo.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storedUser.Password, unchangedUser.Password)
+
+	for _, test := range []struct {
+		name   string
+		body   interface{}
+		fields []problem.FieldError
+	}{
+		{"books", gin.H{"books": []gin.H{}}, []problem.FieldError{{Field: "books", Code: "read_only", Message: "can't be changed"}}},
+		{"account state", gin.H{"disabled": true, "password_reset_required": true}, []problem.FieldError{
+			{Field: "disabled", Code: "read_only", Message: "can't be changed"},
+			{Field: "password_reset_required", Code: "read_only", Message: "can't be changed"},
+		}},
+		{"capitalized role", gin.H{"Role": rbac.RoleAdmin}, []problem.FieldError{{Field: "Role", Code: "unknown", Message: "is not a field of the resource"}}},
+		{"invalid email", gin.H{"email": "foo"}, []problem.FieldError{{Field: "email", Code: "email", Message: "must be an email address"}}},
+		{"removed username", gin.H{"username": nil}, []problem.FieldError{{Field: "username", Code: "required", Message: "is required"}}},
+	} {
+		w := doRequest(server, "PATCH", userPath, test.body, fooToken)
+		assert.Equal(t, 400, w.Code, test.name)
+		assert.Equal(t, test.fields, decodeProblem(t, w).Errors, test.name)
+	}
+
+	// Field names that only differ in case can't change the account state either.
+	w = doRequestWithHeaders(server, "PATCH", userPath, []gin.H{{"op": "add", "path": "/Role", "value": rbac.RoleAdmin}}, fooToken,
+		map[string]string{"Content-Type": patch.JSONPatchContentType})
+	assert.Equal(t, 400, w.Code)
+	storedUser, err = store.GetUser(foo.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, rbac.RoleUser, storedUser.Role)
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/gin-gonic/gin"
+)
+
+// Returns the limiter keys tracking login attempts from the client IP and against the account.
+func loginAttemptKeys(c *gin.Context, email string) (string, string) {
+	return "ip:" + c.ClientIP(), "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// Checks whether a login attempt may proceed for every key, counting it as a failure against them until it succeeds,
+// see ratelimit.Limiter.Allow. Responds with 429 and a Retry-After header and returns false if any key is throttled.
+func (s *Server) allowLoginAttempt(c *gin.Context, keys ...string) bool {
+	var retryAfter time.Duration
+	var counted []string
+
+	for _, key := range keys {
+		decision, err := s.LoginLimiter.Allow(key)
+		if err != nil {
+			s.refundLoginAttempt(c, counted...)
+			respondProblem(c, problem.Internal("failed to check login attempts", err))
+			return false
+		}
+
+		if decision.UnlockedNow {
+			s.recordEvent(c, audit.Event{Action: "auth.unlock", Target: key})
+		}
+		if decision.Allowed {
+			counted = append(counted, key)
+		} else if decision.RetryAfter > retryAfter {
+			retryAfter = decision.RetryAfter
+		}
+	}
+
+	if retryAfter > 0 {
+		// The attempt isn't made, so it doesn't count against the keys that allowed it.
+		s.refundLoginAttempt(c, counted...)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		respondError(c, http.StatusTooManyRequests, "too many login attempts")
+		return false
+	}
+	return true
+}
+
+// Takes back a login attempt counted against every key by allowLoginAttempt, because it didn't fail.
+func (s *Server) refundLoginAttempt(c *gin.Context, keys ...string) {
+	for _, key := range keys {
+		if err := s.LoginLimiter.Refund(key); err != nil {
+			// The attempt simply stays counted.
+			s.Logger.ErrorContext(c.Request.Context(), "failed to refund login attempt", "key", key, "error", err)
+		}
+	}
+}
+
+// Confirms that a login attempt counted against every key by allowLoginAttempt failed, audit-logging any lockout.
+func (s *Server) confirmLoginFailure(c *gin.Context, keys ...string) {
+	for _, key := range keys {
+		decision, err := s.LoginLimiter.Confirm(key)
+		if err != nil {
+			// The attempt stays counted, it just doesn't lock the key out.
+			s.Logger.ErrorContext(c.Request.Context(), "failed to confirm login failure", "key", key, "error", err)
+			continue
+		}
+
+		if decision.LockedNow {
+			s.recordEvent(c, audit.Event{
+				Action:  "auth.lockout",
+				Target:  key,
+				Details: map[string]interface{}{"locked_for_seconds": int(decision.RetryAfter.Seconds())},
+			})
+		}
+	}
+}
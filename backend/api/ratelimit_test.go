@@ -0,0 +1,219 @@
+package api
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/passwords"
+	"github.com/declanl482/go-book-tracker-app/backend/ratelimit"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeClock is a manually advanced clock for deterministic limiter tests.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// recordingAuditor keeps audit events in memory so tests can inspect them.
+type recordingAuditor struct {
+	events []audit.Event
+}
+
+func (a *recordingAuditor) Log(event audit.Event) {
+	a.events = append(a.events, event)
+}
+
+func (a *recordingAuditor) actions() []string {
+	actions := []string{}
+	for _, event := range a.events {
+		actions = append(actions, event.Action)
+	}
+	return actions
+}
+
+func TestLoginRateLimiting(t *testing.T) {
+	store := storage.NewMemoryStorage()
+
+	// Store the user directly with a cheap hash to keep the test fast.
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("foo"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	_, err = store.CreateUser(&types.User{Username: "foo", Email: "foo@bar.com", Password: string(hashedPassword)})
+	assert.NoError(t, err)
+
+	clock := &fakeClock{now: time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)}
+	policy := ratelimit.Policy{
+		FreeAttempts:    1,
+		BaseDelay:       10 * time.Second,
+		MaxDelay:        time.Minute,
+		MaxFailures:     3,
+		LockoutDuration: 5 * time.Minute,
+		Window:          time.Hour,
+	}
+	auditor := &recordingAuditor{}
+
+	server := NewServer(":8080", store)
+	server.AccessTokenSecretKey = testSecretKey
+	server.LoginLimiter = ratelimit.NewMemoryLimiter(policy, clock)
+	server.Auditor = auditor
+	server.PasswordHasher = passwords.Hasher{Cost: bcrypt.MinCost}
+	server.RegisterRoutes()
+
+	login := func(password string) *httptest.ResponseRecorder {
+		credentials := url.Values{}
+		credentials.Set("username", "foo@bar.com")
+		credentials.Set("password", password)
+
+		req := httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The first failure is free.
+	w := login("wrong")
+	assert.Equal(t, 403, w.Code)
+
+	// The second failure imposes a backoff.
+	w = login("wrong")
+	assert.Equal(t, 403, w.Code)
+
+	// Even the correct password is rejected while backing off.
+	w = login("foo")
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "10", w.Header().Get("Retry-After"))
+
+	// The third failure locks the account out.
+	clock.now = clock.now.Add(10 * time.Second)
+	w = login("wrong")
+	assert.Equal(t, 403, w.Code)
+	assert.Contains(t, auditor.actions(), "auth.lockout")
+
+	clock.now = clock.now.Add(time.Minute)
+	w = login("foo")
+	assert.Equal(t, 429, w.Code)
+	assert.Equal(t, "240", w.Header().Get("Retry-After"))
+
+	// Once the lockout expires the correct password is accepted and the unlock is audit-logged.
+	clock.now = clock.now.Add(4 * time.Minute)
+	w = login("foo")
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, auditor.actions(), "auth.unlock")
+}
+
+func TestSuccessfulLoginAtMaxFailures(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("foo"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	_, err = store.CreateUser(&types.User{Username: "foo", Email: "foo@bar.com", Password: string(hashedPassword)})
+	assert.NoError(t, err)
+
+	policy := ratelimit.Policy{
+		FreeAttempts:    3,
+		MaxFailures:     3,
+		LockoutDuration: time.Hour,
+		Window:          time.Hour,
+	}
+	auditor := &recordingAuditor{}
+
+	server := NewServer(":8080", store)
+	server.AccessTokenSecretKey = testSecretKey
+	server.LoginLimiter = ratelimit.NewMemoryLimiter(policy, nil)
+	server.Auditor = auditor
+	server.PasswordHasher = passwords.Hasher{Cost: bcrypt.MinCost}
+	server.RegisterRoutes()
+
+	login := func(password string) *httptest.ResponseRecorder {
+		credentials := url.Values{}
+		credentials.Set("username", "foo@bar.com")
+		credentials.Set("password", password)
+
+		req := httptest.NewRequest("POST", "/v1/auth/login", bytes.NewBufferString(credentials.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < policy.MaxFailures-1; i++ {
+		assert.Equal(t, 403, login("wrong").Code)
+	}
+
+	// The attempt reaching the most failures allowed succeeds, so neither the client IP nor the account is locked out.
+	assert.Equal(t, 200, login("foo").Code)
+	assert.NotContains(t, auditor.actions(), "auth.lockout")
+	assert.Equal(t, 200, login("foo").Code)
+
+	// Failing it instead locks the client IP out.
+	assert.Equal(t, 403, login("wrong").Code)
+	assert.Contains(t, auditor.actions(), "auth.lockout")
+	assert.Equal(t, 429, login("foo").Code)
+}
+
+func TestConcurrentLoginAttempts(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("foo"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	_, err = store.CreateUser(&types.User{Username: "foo", Email: "foo@bar.com", Password: string(hashedPassword)})
+	assert.NoError(t, err)
+
+	policy := ratelimit.Policy{
+		FreeAttempts:    1,
+		BaseDelay:       time.Minute,
+		MaxDelay:        time.Hour,
+		MaxFailures:     10,
+		LockoutDuration: time.Hour,
+		Window:          time.Hour,
+	}
+	server := NewServer(":8080", store)
+	server.AccessTokenSecretKey = testSecretKey
+	server.LoginLimiter = ratelimit.NewMemoryLimiter(policy, nil)
+	server.PasswordHasher = passwords.Hasher{Cost: bcrypt.MinCost}
+	server.RegisterRoutes()
+
+	login := func(password string) *httptest.ResponseRecorder {
+		credentials := url.Values{}
+		credentials.Set("username", "foo@bar.com")
+		credentials.Set("password", password)
+
+		req := httptest.NewRequest("POST", "/v1/auth/login", bytes.NewBufferString(credentials.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Guesses made at once are throttled as soon as the earlier ones are let through, not once they have failed.
+	var wg sync.WaitGroup
+	codes := make(chan int, 10)
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- login("wrong").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
+	}
+	assert.Equal(t, map[int]int{403: policy.FreeAttempts + 1, 429: cap(codes) - policy.FreeAttempts - 1}, counts)
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) handleSearch(c *gin.Context) {
+	// Get the authenticated user from the context.
+	currentUser := c.MustGet("currentUser").(*types.User)
+
+	if currentUser == nil {
+		respondError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		respondProblem(c, requiredProblem("q"))
+		return
+	}
+
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	// Only the user's own books are searched.
+	books, total, err := s.storage(c).SearchBooks(storage.BookSearch{
+		Query:   query,
+		OwnerID: currentUser.ID,
+		Offset:  offset,
+		Limit:   limit,
+	})
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to search books", err))
+		return
+	}
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, gin.H{"books": books, "total": total, "limit": limit, "offset": offset})
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchBooks(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	bar := createTestUser(t, store, "bar", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+
+	for _, book := range []types.Book{
+		{Title: "The Dispossessed", Author: "Ursula K. Le Guin", PagesCount: 387, OwnerID: foo.ID},
+		{Title: "The Left Hand of Darkness", Author: "Ursula K. Le Guin", PagesCount: 304, OwnerID: foo.ID},
+		{Title: "Dune", Author: "Frank Herbert", PagesCount: 412, OwnerID: foo.ID},
+		{Title: "The Lathe of Heaven", Author: "Ursula K. Le Guin", PagesCount: 184, OwnerID: bar.ID},
+	} {
+		_, err := store.CreateBook(&book)
+		assert.NoError(t, err)
+	}
+
+	type page struct {
+		Books  []types.Book `json:"books"`
+		Total  int64        `json:"total"`
+		Limit  int          `json:"limit"`
+		Offset int          `json:"offset"`
+	}
+
+	// Only the user's own books are found.
+	w := doRequest(server, "GET", "/v1/search?q=le+guin", nil, fooToken)
+	assert.Equal(t, 200, w.Code)
+	var results page
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	assert.Equal(t, int64(2), results.Total)
+	assert.Equal(t, defaultPageSize, results.Limit)
+	for _, book := range results.Books {
+		assert.Equal(t, foo.ID, book.OwnerID)
+	}
+
+	w = doRequest(server, "GET", "/v1/search?q=the&limit=1&offset=1", nil, fooToken)
+	assert.Equal(t, 200, w.Code)
+	results = page{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	assert.Equal(t, int64(2), results.Total)
+	assert.Len(t, results.Books, 1)
+	assert.Equal(t, "The Left Hand of Darkness", results.Books[0].Title)
+
+	// A query is required.
+	w = doRequest(server, "GET", "/v1/search?q=+", nil, fooToken)
+	assert.Equal(t, 400, w.Code)
+	assert.Equal(t, "q", decodeProblem(t, w).Errors[0].Field)
+
+	w = doRequest(server, "GET", "/v1/search?q=dune&limit=none", nil, fooToken)
+	assert.Equal(t, 400, w.Code)
+
+	w = doRequest(server, "GET", "/v1/search?q=dune", nil, "")
+	assert.Equal(t, 401, w.Code)
+}
@@ -1,56 +1,358 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/cors"
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/idempotency"
+	"github.com/declanl482/go-book-tracker-app/backend/metrics"
+	"github.com/declanl482/go-book-tracker-app/backend/passwords"
+	"github.com/declanl482/go-book-tracker-app/backend/ratelimit"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/service"
+	"github.com/declanl482/go-book-tracker-app/backend/sso"
 	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/webhooks"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
 )
 
+// How long the user and book counts exported on /metrics are reused before they are counted again.
+const statsMaxAge = 30 * time.Second
+
 type Server struct {
+	// The operations on users and books, shared with the gRPC API.
+	*service.Service
 	ListenAddress string
-	Storer        storage.Storage
-	router        *gin.Engine
+	// Where the gRPC API is served, see StartGRPC.
+	GRPCListenAddress string
+	// Serve HTTPS with the certificate and key if both are set.
+	TLSCertFile string
+	TLSKeyFile  string
+	// Secret access tokens and login flow states are signed with.
+	AccessTokenSecretKey string
+	// Which cross-origin requests browsers may make.
+	CORSPolicy   cors.Policy
+	LoginLimiter ratelimit.Limiter
+	// Where audit events are stored and queried from.
+	AuditLog audit.Store
+	Sessions *SessionTracker
+	// OpenID Connect providers users can log in with, keyed by name.
+	IdentityProviders map[string]*sso.Provider
+	// Where the request spans are recorded.
+	TracerProvider trace.TracerProvider
+	// Checks run by /readyz, keyed by the name of the dependency they check.
+	ReadinessChecks map[string]ReadinessCheck
+	// Whether updates and deletes of books and users must send the ETag they are based on in If-Match,
+	// or over gRPC and GraphQL the version. Otherwise they are only checked when they are sent.
+	RequireIfMatch bool
+	// Where requests made with an Idempotency-Key are recorded, and for how long their responses are replayed.
+	Idempotency    idempotency.Store
+	IdempotencyTTL time.Duration
+	// Most operations a batch request may have.
+	MaxBatchOperations int
+	// How deep GraphQL queries may nest their fields, and how costly they may be to resolve, see graphqlCost.
+	GraphQLMaxDepth      int
+	GraphQLMaxComplexity int
+	// How long deleted books stay in the trash before they are purged.
+	TrashRetention time.Duration
+	// How often the deleted books and accounts are purged.
+	PurgeInterval time.Duration
+	// How often idle event streams send a comment, so that proxies don't time them out.
+	EventHeartbeatInterval time.Duration
+	// Versions of the API, oldest first. The unversioned routes are deprecated aliases of the first version.
+	APIVersions []APIVersion
+	// When the unversioned routes were deprecated and when they will be removed.
+	UnversionedDeprecation time.Time
+	UnversionedSunset      time.Time
+	// Limits on how long connections may take to send requests, receive responses and sit idle.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	router       *gin.Engine
+
+	registerRoutes sync.Once
+	mu             sync.Mutex
+	httpServer     *http.Server
+	grpcServer     *grpc.Server
+	stopBackground context.CancelFunc
+	backgroundDone chan struct{}
+	shuttingDown   atomic.Bool
+	// Ends the event streams, which would otherwise keep shutdown waiting.
+	streams     context.Context
+	stopStreams context.CancelFunc
 }
 
 func NewServer(listenAddress string, storer storage.Storage) *Server {
-	router := gin.Default()
+	router := gin.New()
+	auditLog := audit.NewMemoryStore()
+
+	// Export the user and book counts, a failed scrape of them shows up on /metrics.
+	// Registering them only fails if their metrics clash with others, a programming error.
+	serverMetrics := metrics.New()
+	if err := serverMetrics.RegisterStats(storer.GetStats, statsMaxAge); err != nil {
+		panic(err)
+	}
+
+	streams, stopStreams := context.WithCancel(context.Background())
+
 	return &Server{
+		Service: &service.Service{
+			Storer:                     storer,
+			Logger:                     slog.Default(),
+			Auditor:                    audit.NewAsyncLogger(auditLog, audit.NewSlogLogger(slog.Default()), 1024),
+			Metrics:                    serverMetrics,
+			PasswordPolicy:             passwords.DefaultPolicy(),
+			PasswordHasher:             passwords.DefaultHasher(),
+			Events:                     events.NewBroker(1000),
+			Webhooks:                   webhooks.NewDispatcher(webhooks.NewMemoryStore()),
+			AccountDeletionGracePeriod: 14 * 24 * time.Hour,
+		},
 		ListenAddress: listenAddress,
-		Storer:        storer,
-		router:        router,
+		// Tokens signed with a random key don't outlive the server, main sets the configured one.
+		AccessTokenSecretKey:   newSecretKey(),
+		CORSPolicy:             cors.DefaultPolicy(),
+		LoginLimiter:           ratelimit.NewMemoryLimiter(ratelimit.DefaultPolicy(), ratelimit.SystemClock{}),
+		AuditLog:               auditLog,
+		Sessions:               NewSessionTracker(storer, 30*time.Second),
+		IdentityProviders:      make(map[string]*sso.Provider),
+		TracerProvider:         otel.GetTracerProvider(),
+		ReadinessChecks:        make(map[string]ReadinessCheck),
+		Idempotency:            idempotency.NewMemoryStore(),
+		IdempotencyTTL:         24 * time.Hour,
+		MaxBatchOperations:     100,
+		GraphQLMaxDepth:        8,
+		GraphQLMaxComplexity:   1000,
+		TrashRetention:         30 * 24 * time.Hour,
+		PurgeInterval:          time.Hour,
+		EventHeartbeatInterval: 15 * time.Second,
+		APIVersions:            DefaultAPIVersions(),
+		UnversionedDeprecation: defaultUnversionedDeprecation,
+		UnversionedSunset:      defaultUnversionedSunset,
+		ReadTimeout:            15 * time.Second,
+		WriteTimeout:           30 * time.Second,
+		IdleTimeout:            60 * time.Second,
+		router:                 router,
+		streams:                streams,
+		stopStreams:            stopStreams,
 	}
 }
 
+// Listens on the listen address and serves requests until the server is shut down.
 func (s *Server) Start() error {
-	// Create a new Gin router.
+	listener, err := net.Listen("tcp", s.ListenAddress)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serves requests on the listener until the server is shut down, returns nil once it has been.
+func (s *Server) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	if s.httpServer != nil {
+		s.mu.Unlock()
+		return errors.New("server already started")
+	}
+
+	// Periodically write session activity to the database, purge deleted records and deliver webhooks,
+	// and receive the events published by other instances.
+	s.Sessions.logger = s.Logger
+	s.Events.Logger = s.Logger
+	s.Webhooks.Logger = s.Logger
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	s.stopBackground = stopBackground
+	s.backgroundDone = make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(4)
+	go func() {
+		defer background.Done()
+		s.Sessions.Run(backgroundCtx)
+	}()
+	go func() {
+		defer background.Done()
+		s.RunPurge(backgroundCtx)
+	}()
+	go func() {
+		defer background.Done()
+		s.Events.Run(backgroundCtx)
+	}()
+	go func() {
+		defer background.Done()
+		s.Webhooks.Run(backgroundCtx)
+	}()
+	go func() {
+		background.Wait()
+		close(s.backgroundDone)
+	}()
+
+	// Register the middlewares and handlers, then serve the router.
+	s.RegisterRoutes()
+	s.httpServer = &http.Server{
+		Handler:           s.router,
+		ReadTimeout:       s.ReadTimeout,
+		ReadHeaderTimeout: s.ReadTimeout,
+		WriteTimeout:      s.WriteTimeout,
+		IdleTimeout:       s.IdleTimeout,
+		ErrorLog:          slog.NewLogLogger(s.Logger.Handler(), slog.LevelWarn),
+	}
+	httpServer := s.httpServer
+	s.mu.Unlock()
+
+	var err error
+	if s.TLSCertFile != "" && s.TLSKeyFile != "" {
+		s.Logger.Info("listening for requests", "address", listener.Addr().String(), "tls", true)
+		err = httpServer.ServeTLS(listener, s.TLSCertFile, s.TLSKeyFile)
+	} else {
+		s.Logger.Info("listening for requests", "address", listener.Addr().String(), "tls", false)
+		err = httpServer.Serve(listener)
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
 
+// Stops accepting requests and gRPC calls and waits for the in-flight ones to complete, or for the context to be done.
+// Readiness checks fail from the moment shutdown begins, so that load balancers stop routing requests here,
+// and the event streams end so that clients reconnect to another instance.
+// The pending session activity is flushed, and a purge in progress completes, before returning.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shuttingDown.Store(true)
+	s.stopStreams()
+
+	s.mu.Lock()
+	httpServer, grpcServer, stopBackground, backgroundDone := s.httpServer, s.grpcServer, s.stopBackground, s.backgroundDone
+	s.mu.Unlock()
+
+	if httpServer == nil {
+		return nil
+	}
+
+	// Let the in-flight gRPC calls complete alongside the requests.
+	grpcStopped := make(chan struct{})
+	go func() {
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
+		close(grpcStopped)
+	}()
+
+	err := httpServer.Shutdown(ctx)
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		// Cut off the calls still in flight.
+		if grpcServer != nil {
+			grpcServer.Stop()
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+
+	stopBackground()
+	select {
+	case <-backgroundDone:
+	case <-ctx.Done():
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+	return err
+}
+
+// Registers the middlewares and handlers on the router. Registering them more than once has no effect.
+func (s *Server) RegisterRoutes() {
+	s.registerRoutes.Do(s.registerAllRoutes)
+}
+
+func (s *Server) registerAllRoutes() {
+	// Register the middlewares and handlers on the Gin router.
+	s.router.Use(s.RequestIDMiddleware())
+	s.router.Use(s.TracingMiddleware())
+	s.router.Use(s.RequestLogger())
+	s.router.Use(s.MetricsMiddleware())
+	s.router.Use(s.ErrorMiddleware())
+	s.router.Use(s.Recovery())
 	s.router.Use(s.CORSMiddleware())
 	s.router.Use(s.DBConnectionMiddleware())
 
-	s.RegisterAuthHandlers()
-	s.router.Use(s.RequireValidAccessToken())
-	s.RegisterUserHandlers()
-	s.RegisterBookHandlers()
-	return s.router.Run(s.ListenAddress)
+	s.router.NoRoute(func(c *gin.Context) { respondError(c, http.StatusNotFound, "route not found") })
+
+	s.router.GET("/metrics", gin.WrapH(s.Metrics.Handler()))
+	s.router.GET("/healthz", s.handleHealthz)
+	s.router.GET("/readyz", s.handleReadyz)
+	s.router.GET("/openapi.json", s.handleOpenAPI)
+	s.router.GET("/docs", s.handleDocs)
+	s.router.POST("/graphql", s.handleGraphQL())
+	s.registerAPIVersions()
 }
 
-func (s *Server) RegisterAuthHandlers() {
-	// Register the auth handlers.
-	s.router.POST("/auth/login", s.handleLoginUser)
-	s.router.POST("/auth/register", s.handleCreateUser)
+func (s *Server) RegisterAuthHandlers(router *VersionRouter) {
+	// Register the auth handlers. Logins aren't idempotent: their credentials and access tokens must not be recorded.
+	router.POST("/auth/login", s.handleLoginUser)
+	router.POST("/auth/register", s.IdempotentRequests(), s.handleCreateUser)
+	router.GET("/auth/oidc/:provider/login", s.handleOIDCLogin)
+	router.GET("/auth/oidc/:provider/callback", s.handleOIDCCallback)
 }
 
-func (s *Server) RegisterUserHandlers() {
+func (s *Server) RegisterUserHandlers(router *VersionRouter) {
 	// Register the user handlers.
-	s.router.GET("/users/:id", s.handleGetUser)
-	s.router.PATCH("/users/:id", s.handleUpdateUser)
-	s.router.DELETE("/users/:id", s.handleDeleteUser)
+	router.GET("/users/:id", s.AuthorizeUser("view", rbac.PermissionReadAnyUser), s.handleGetUser)
+	router.PATCH("/users/:id", s.AuthorizeUser("update", rbac.PermissionWriteAnyUser), s.handleUpdateUser)
+	router.DELETE("/users/:id", s.AuthorizeUser("delete", rbac.PermissionWriteAnyUser), s.handleDeleteUser)
+	router.GET("/users/:id/sessions", s.AuthorizeUser("view", rbac.PermissionReadAnyUser), s.handleGetSessions)
+	router.DELETE("/users/:id/sessions/:sessionID", s.AuthorizeUser("update", rbac.PermissionWriteAnyUser), s.handleDeleteSession)
+	router.GET("/users/:id/activity", s.AuthorizeUser("view", rbac.PermissionViewAuditLog), s.handleGetActivity)
+	router.GET("/users/:id/identities", s.AuthorizeUser("view", rbac.PermissionReadAnyUser), s.handleGetIdentities)
+	router.POST("/users/:id/identities/:provider", s.AuthorizeUser("update", rbac.PermissionWriteAnyUser), s.handleLinkIdentity)
+	router.DELETE("/users/:id/identities/:provider", s.AuthorizeUser("update", rbac.PermissionWriteAnyUser), s.handleUnlinkIdentity)
+	router.POST("/users/:id/webhooks", s.AuthorizeUser("update", rbac.PermissionWriteAnyUser), s.handleCreateWebhook)
+	router.GET("/users/:id/webhooks", s.AuthorizeUser("view", rbac.PermissionReadAnyUser), s.handleGetWebhooks)
+	router.GET("/users/:id/webhooks/:webhookID", s.AuthorizeUser("view", rbac.PermissionReadAnyUser), s.handleGetWebhook)
+	router.DELETE("/users/:id/webhooks/:webhookID", s.AuthorizeUser("update", rbac.PermissionWriteAnyUser), s.handleDeleteWebhook)
+	router.GET("/users/:id/webhooks/:webhookID/deliveries", s.AuthorizeUser("view", rbac.PermissionReadAnyUser), s.handleGetWebhookDeliveries)
+	router.POST("/users/:id/webhooks/:webhookID/deliveries/:deliveryID/redeliver", s.AuthorizeUser("update", rbac.PermissionWriteAnyUser), s.handleRedeliverWebhook)
 }
 
-func (s *Server) RegisterBookHandlers() {
+func (s *Server) RegisterBookHandlers(router *VersionRouter) {
 	// Register the book handlers.
-	s.router.POST("/books/", s.handleCreateBook)
-	s.router.GET("/books/", s.handleGetBooks)
-	s.router.GET("/books/:id", s.handleGetBook)
-	s.router.PATCH("/books/:id", s.handleUpdateBook)
-	s.router.DELETE("/books/:id", s.handleDeleteBook)
+	router.POST("/books/", s.handleCreateBook)
+	router.POST("/books/batch", s.handleBatchBooks)
+	router.GET("/books/", s.handleGetBooks)
+	router.GET("/books/trash", s.handleGetTrash)
+	router.POST("/books/:id/restore", s.AuthorizeDeletedBook("restore", rbac.PermissionWriteAnyBook), s.handleRestoreBook)
+	router.GET("/books/:id", s.AuthorizeBook("view", rbac.PermissionReadAnyBook), s.handleGetBook)
+	router.PATCH("/books/:id", s.AuthorizeBook("update", rbac.PermissionWriteAnyBook), s.handleUpdateBook)
+	router.DELETE("/books/:id", s.AuthorizeBook("delete", rbac.PermissionWriteAnyBook), s.handleDeleteBook)
+	router.GET("/search", s.handleSearch)
+	router.GET("/events", s.handleEvents)
+}
+
+func (s *Server) RegisterAdminHandlers(router *VersionRouter) {
+	// Register the admin handlers.
+	admin := router.Group("/admin")
+
+	users := admin.Group("/users", s.RequirePermission(rbac.PermissionManageUsers))
+	users.GET("", s.handleAdminListUsers)
+	users.GET("/:id", s.AuthorizeUser("manage", rbac.PermissionManageUsers), s.handleAdminGetUser)
+	users.POST("/:id/disable", s.AuthorizeUser("manage", rbac.PermissionManageUsers), s.handleAdminDisableUser)
+	users.POST("/:id/enable", s.AuthorizeUser("manage", rbac.PermissionManageUsers), s.handleAdminEnableUser)
+	users.POST("/:id/password-reset", s.AuthorizeUser("manage", rbac.PermissionManageUsers), s.handleAdminForcePasswordReset)
+	users.PUT("/:id/role", s.AuthorizeUser("manage", rbac.PermissionManageUsers), s.handleAdminSetUserRole)
+
+	admin.GET("/stats", s.RequirePermission(rbac.PermissionViewStats), s.handleAdminGetStats)
+	admin.GET("/audit", s.RequirePermission(rbac.PermissionViewAuditLog), s.handleAdminQueryAuditLog)
 }
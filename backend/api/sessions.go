@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionTracker batches last-seen updates for sessions so that authenticated requests don't each write to the database.
+type SessionTracker struct {
+	storer   storage.Storage
+	interval time.Duration
+	logger   *slog.Logger
+	mu       sync.Mutex
+	pending  map[string]time.Time
+}
+
+// Constructs a new SessionTracker flushing to the storage every interval.
+func NewSessionTracker(storer storage.Storage, interval time.Duration) *SessionTracker {
+	return &SessionTracker{
+		storer:   storer,
+		interval: interval,
+		logger:   slog.Default(),
+		pending:  make(map[string]time.Time),
+	}
+}
+
+// Records that the session was used at the given time.
+func (t *SessionTracker) Touch(sessionID string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if at.After(t.pending[sessionID]) {
+		t.pending[sessionID] = at
+	}
+}
+
+// Returns the last-seen time of the session that has not been flushed yet, if any.
+func (t *SessionTracker) LastSeen(sessionID string) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	at, ok := t.pending[sessionID]
+	return at, ok
+}
+
+// Writes the pending last-seen times to the storage.
+func (t *SessionTracker) Flush() error {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[string]time.Time)
+	t.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := t.storer.TouchSessions(pending); err != nil {
+		// Put the times back so that they are retried on the next flush.
+		for sessionID, at := range pending {
+			t.Touch(sessionID, at)
+		}
+		return err
+	}
+	return nil
+}
+
+// Flushes periodically until the context is cancelled, then flushes one last time.
+func (t *SessionTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := t.Flush(); err != nil {
+				t.logger.Error("failed to flush session activity", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := t.Flush(); err != nil {
+				t.logger.Error("failed to flush session activity", "error", err)
+			}
+		}
+	}
+}
+
+// Returns a new random session id.
+func newSessionID() (string, error) {
+	buffer := make([]byte, 16)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buffer), nil
+}
+
+// Records a new login session for the user from the requesting device.
+func (s *Server) createSession(c *gin.Context, user *types.User) (*types.Session, error) {
+	sessionID, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return s.storage(c).CreateSession(&types.Session{
+		ID:         sessionID,
+		UserID:     user.ID,
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(AccessTokenLifetime),
+	})
+}
+
+func (s *Server) handleGetSessions(c *gin.Context) {
+
+	// Get the authorized user from the context.
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	sessions, err := s.storage(c).GetSessions(fetchedUser.ID)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to fetch sessions", err))
+		return
+	}
+
+	// Fill in activity that has not been flushed yet and mark the session making the request.
+	currentSession, _ := c.Get("currentSession")
+	for i := range *sessions {
+		session := &(*sessions)[i]
+		if lastSeen, ok := s.Sessions.LastSeen(session.ID); ok && lastSeen.After(session.LastSeenAt) {
+			session.LastSeenAt = lastSeen
+		}
+		if current, ok := currentSession.(*types.Session); ok && current.ID == session.ID {
+			session.Current = true
+		}
+	}
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, sessions)
+}
+
+func (s *Server) handleDeleteSession(c *gin.Context) {
+
+	// Get the authenticated and authorized users from the context.
+	currentUser := c.MustGet("currentUser").(*types.User)
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	// Fetch the session from the database.
+	session, err := s.storage(c).GetSession(c.Param("sessionID"))
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to get session", err))
+		return
+	}
+
+	// There is no session with the requested id for this user.
+	if session == nil || session.UserID != fetchedUser.ID {
+		respondError(c, http.StatusNotFound, "session not found")
+		return
+	}
+
+	// Revoke the session so that its access tokens are rejected.
+	if err := s.storage(c).RevokeSession(session); err != nil {
+		respondStorageError(c, err, "failed to revoke session")
+		return
+	}
+
+	s.recordEvent(c, audit.Event{
+		Action:    "session.revoke",
+		ActorID:   currentUser.ID,
+		SubjectID: fetchedUser.ID,
+		Target:    "session:" + session.ID,
+	})
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusNoContent, nil)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionHandlers(t *testing.T) {
+	server, store := newMemoryServer(t)
+
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fuzz := createTestUser(t, store, "fuzz", rbac.RoleUser)
+	fuzzToken := accessTokenFor(t, store, fuzz)
+
+	// Log in from two different devices.
+	login := func(userAgent string) string {
+		credentials := url.Values{}
+		credentials.Set("username", "foo@bar.com")
+		credentials.Set("password", "foo")
+
+		req := httptest.NewRequest("POST", "/auth/login", bytes.NewBufferString(credentials.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", userAgent)
+
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, 200, w.Code)
+
+		var response map[string]string
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response["access_token"]
+	}
+
+	phoneToken := login("phone")
+	laptopToken := login("laptop")
+
+	// List the sessions from the laptop.
+	w := doRequest(server, "GET", fmt.Sprintf("/users/%d/sessions", foo.ID), nil, laptopToken)
+	assert.Equal(t, 200, w.Code)
+
+	var sessions []types.Session
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &sessions))
+	assert.Len(t, sessions, 2)
+
+	var phoneSession types.Session
+	for _, session := range sessions {
+		assert.Equal(t, session.UserAgent == "laptop", session.Current)
+		if session.UserAgent == "phone" {
+			phoneSession = session
+		}
+	}
+	assert.NotEmpty(t, phoneSession.ID)
+
+	// Other users can neither see nor revoke the sessions.
+	w = doRequest(server, "GET", fmt.Sprintf("/users/%d/sessions", foo.ID), nil, fuzzToken)
+	assert.Equal(t, 401, w.Code)
+
+	w = doRequest(server, "DELETE", fmt.Sprintf("/users/%d/sessions/%s", fuzz.ID, phoneSession.ID), nil, fuzzToken)
+	assert.Equal(t, 404, w.Code)
+
+	// Revoke the phone session from the laptop.
+	w = doRequest(server, "DELETE", fmt.Sprintf("/users/%d/sessions/%s", foo.ID, phoneSession.ID), nil, laptopToken)
+	assert.Equal(t, 204, w.Code)
+
+	// The phone's token is rejected while the laptop's still works.
+	w = doRequest(server, "GET", "/books/", nil, phoneToken)
+	assert.Equal(t, 401, w.Code)
+
+	w = doRequest(server, "GET", "/books/", nil, laptopToken)
+	assert.Equal(t, 200, w.Code)
+
+	w = doRequest(server, "GET", fmt.Sprintf("/users/%d/sessions", foo.ID), nil, laptopToken)
+	assert.Equal(t, 200, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &sessions))
+	assert.Len(t, sessions, 1)
+
+	// Tokens without a session are rejected.
+	legacyToken, err := NewAuth(testSecretKey).GenerateAccessToken(foo.ID, "")
+	assert.NoError(t, err)
+	w = doRequest(server, "GET", "/books/", nil, legacyToken)
+	assert.Equal(t, 401, w.Code)
+}
+
+func TestSessionTracker(t *testing.T) {
+	_, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+
+	createdAt := time.Now().Add(-time.Hour)
+	_, err := store.CreateSession(&types.Session{
+		ID:         "session",
+		UserID:     foo.ID,
+		CreatedAt:  createdAt,
+		LastSeenAt: createdAt,
+		ExpiresAt:  time.Now().Add(time.Hour),
+	})
+	assert.NoError(t, err)
+
+	tracker := NewSessionTracker(store, time.Minute)
+
+	// Touches are kept in memory until flushed.
+	seenAt := time.Now()
+	tracker.Touch("session", seenAt.Add(-time.Second))
+	tracker.Touch("session", seenAt)
+
+	lastSeen, ok := tracker.LastSeen("session")
+	assert.True(t, ok)
+	assert.Equal(t, seenAt, lastSeen)
+
+	session, err := store.GetSession("session")
+	assert.NoError(t, err)
+	assert.Equal(t, createdAt, session.LastSeenAt)
+
+	// Flushing writes the latest time.
+	assert.NoError(t, tracker.Flush())
+
+	session, err = store.GetSession("session")
+	assert.NoError(t, err)
+	assert.Equal(t, seenAt, session.LastSeenAt)
+
+	_, ok = tracker.LastSeen("session")
+	assert.False(t, ok)
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/tracing"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the tracer the request spans are created with.
+const tracerName = "github.com/declanl482/go-book-tracker-app/backend/api"
+
+// Returns the storage bound to the request's context, so that its calls are traced as part of the request.
+func (s *Server) storage(c *gin.Context) storage.Storage {
+	return storage.WithContext(s.Storer, c.Request.Context())
+}
+
+// Middleware to record a server span for every request, continuing the trace from the traceparent header if there is one.
+func (s *Server) TracingMiddleware() gin.HandlerFunc {
+	propagator := tracing.Propagator()
+
+	return func(c *gin.Context) {
+		ctx := propagator.Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+
+		// Name the span after the route template so that requests for different ids are grouped together.
+		route := c.FullPath()
+		name := c.Request.Method
+		if route != "" {
+			name += " " + route
+		}
+
+		ctx, span := s.TracerProvider.Tracer(tracerName).Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(c.Request.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(c.Request.URL.Path),
+				semconv.ClientAddress(c.ClientIP()),
+				semconv.UserAgentOriginal(c.Request.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		if requestID := c.GetString("requestID"); requestID != "" {
+			span.SetAttributes(attribute.String("request.id", requestID))
+		}
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/logging"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/tracing"
+	"github.com/stretchr/testify/assert"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	server, store := newMemoryServer(t)
+	provider, exporter := tracing.NewTestProvider()
+	server.TracerProvider = provider
+	server.Storer = storage.NewTracedStorage(store, provider)
+
+	var logs bytes.Buffer
+	logger, err := logging.New(&logs, logging.DefaultConfig())
+	assert.NoError(t, err)
+	server.Logger = logger
+
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+
+	// Continue the trace started by the caller.
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("GET", fmt.Sprintf("/users/%d", foo.ID), nil)
+	req.Header.Set("Authorization", "Bearer "+fooToken)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	spans := exporter.GetSpans()
+	var serverSpan *trace.SpanContext
+	names := []string{}
+	for _, span := range spans {
+		assert.Equal(t, traceID, span.SpanContext.TraceID().String())
+		if span.SpanKind == trace.SpanKindServer {
+			serverSpan = &span.SpanContext
+			assert.Equal(t, "GET /users/:id", span.Name)
+			assert.Equal(t, "00f067aa0ba902b7", span.Parent.SpanID().String())
+			assert.Contains(t, span.Attributes, semconv.HTTPRoute("/users/:id"))
+			assert.Contains(t, span.Attributes, semconv.HTTPResponseStatusCode(200))
+		} else {
+			names = append(names, span.Name)
+		}
+	}
+	assert.NotNil(t, serverSpan)
+
+	// Authenticating and authorizing the request shows up as storage calls beneath it.
+	assert.Equal(t, []string{"storage.GetSession", "storage.GetUser", "storage.GetUser"}, names)
+	for _, span := range spans {
+		if span.SpanKind != trace.SpanKindServer {
+			assert.Equal(t, serverSpan.SpanID(), span.Parent.SpanID())
+		}
+	}
+
+	// The request's log record carries the trace.
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(bytes.TrimSpace(logs.Bytes()), &record))
+	assert.Equal(t, traceID, record["trace_id"])
+	assert.Equal(t, serverSpan.SpanID().String(), record["span_id"])
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/service"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+)
+
+func (s *Server) handleGetTrash(c *gin.Context) {
+	// Get the authenticated user from the context.
+	currentUser := c.MustGet("currentUser").(*types.User)
+
+	if currentUser == nil {
+		respondError(c, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	books, err := s.storage(c).GetDeletedBooks(currentUser.ID)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to fetch deleted books", err))
+		return
+	}
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, books)
+}
+
+func (s *Server) handleRestoreBook(c *gin.Context) {
+
+	// Get the authorized book from the context.
+	deletedBook := c.MustGet("targetBook").(*types.Book)
+
+	// Take the book out of the trash.
+	restoredBook, err := s.storage(c).RestoreBook(deletedBook)
+	if err != nil {
+		respondStorageError(c, err, "failed to restore book")
+		return
+	}
+
+	s.recordEvent(c, audit.Event{
+		Action:    "book.restore",
+		SubjectID: restoredBook.OwnerID,
+		Target:    service.BookTarget(restoredBook),
+		Details:   map[string]interface{}{"title": restoredBook.Title},
+	})
+	s.PublishBook(c.Request.Context(), events.BookRestored, restoredBook)
+
+	// SUCCESS.
+	respondWithETag(c, http.StatusOK, bookETag(restoredBook), restoredBook)
+}
+
+// Reports whether the user is deleted, and logging in can still cancel the deletion.
+func (s *Server) canCancelDeletion(user *types.User) bool {
+	return user != nil && user.DeletedAt.Valid && time.Since(user.DeletedAt.Time) < s.AccountDeletionGracePeriod
+}
+
+// Restores the account of a deleted user who logged in during the grace period.
+// Responds with a problem and returns false if the account can't be restored.
+func (s *Server) cancelAccountDeletion(c *gin.Context, user *types.User) bool {
+	restoredUser, err := s.storage(c).RestoreUser(user)
+	if err != nil {
+		respondStorageError(c, err, "failed to restore account")
+		return false
+	}
+
+	s.recordEvent(c, audit.Event{
+		Action:    "user.restore",
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Target:    service.UserTarget(user),
+		Details:   map[string]interface{}{"reason": "logged in during the deletion grace period"},
+	})
+	s.PublishUser(c.Request.Context(), events.UserRestored, restoredUser)
+	return true
+}
+
+// Purges deleted books and accounts every purge interval, starting right away, until the context is cancelled.
+func (s *Server) RunPurge(ctx context.Context) {
+	ticker := time.NewTicker(s.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.PurgeDeleted(time.Now()); err != nil {
+			s.Logger.Error("failed to purge deleted records", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Removes the books that have been in the trash for longer than the trash retention,
+// and the accounts deleted longer ago than the grace period, for good.
+func (s *Server) PurgeDeleted(now time.Time) error {
+	books, err := s.Storer.PurgeBooks(now.Add(-s.TrashRetention))
+	if err != nil {
+		return err
+	}
+	users, err := s.Storer.PurgeUsers(now.Add(-s.AccountDeletionGracePeriod))
+	if err != nil {
+		return err
+	}
+
+	if books > 0 || users > 0 {
+		s.Logger.Info("purged deleted records", "books", books, "users", users)
+	}
+	return nil
+}
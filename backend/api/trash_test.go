@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrash(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	bar := createTestUser(t, store, "bar", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+	barToken := accessTokenFor(t, store, bar)
+
+	book, err := store.CreateBook(&types.Book{Title: "Foo", Author: "Bar", PagesCount: 100, PagesRead: 10, OwnerID: foo.ID})
+	assert.NoError(t, err)
+	bookPath := fmt.Sprintf("/v1/books/%d", book.ID)
+
+	// Restoring a book that isn't in the trash is not found.
+	w := doRequest(server, "POST", bookPath+"/restore", nil, fooToken)
+	assert.Equal(t, 404, w.Code)
+
+	// Deleting the book moves it to the trash.
+	w = doRequest(server, "DELETE", bookPath, nil, fooToken)
+	assert.Equal(t, 204, w.Code)
+
+	w = doRequest(server, "GET", bookPath, nil, fooToken)
+	assert.Equal(t, 404, w.Code)
+
+	w = doRequest(server, "GET", "/v1/books/trash", nil, fooToken)
+	assert.Equal(t, 200, w.Code)
+	var trash []types.Book
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &trash))
+	assert.Len(t, trash, 1)
+	assert.Equal(t, book.ID, trash[0].ID)
+	assert.True(t, trash[0].DeletedAt.Valid)
+
+	// Other users can neither see nor restore it.
+	w = doRequest(server, "GET", "/v1/books/trash", nil, barToken)
+	assert.Equal(t, 200, w.Code)
+	assert.JSONEq(t, "[]", w.Body.String())
+
+	w = doRequest(server, "POST", bookPath+"/restore", nil, barToken)
+	assert.Equal(t, 401, w.Code)
+
+	// The owner can restore it.
+	w = doRequest(server, "POST", bookPath+"/restore", nil, fooToken)
+	assert.Equal(t, 200, w.Code)
+	var restoredBook types.Book
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &restoredBook))
+	assert.False(t, restoredBook.DeletedAt.Valid)
+	assert.Equal(t, bookETag(&restoredBook), w.Header().Get("ETag"))
+	assert.Contains(t, server.Auditor.(*recordingAuditor).actions(), "book.restore")
+
+	w = doRequest(server, "GET", bookPath, nil, fooToken)
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestAccountDeletion(t *testing.T) {
+	server, store := newMemoryServer(t)
+
+	login := func(email string, password string) *httptest.ResponseRecorder {
+		credentials := url.Values{"username": {email}, "password": {password}}
+		req := httptest.NewRequest("POST", "/v1/auth/login", bytes.NewBufferString(credentials.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Deletes the user with their own token.
+	deleteUser := func(t *testing.T, user *types.User) {
+		w := doRequest(server, "DELETE", fmt.Sprintf("/v1/users/%d", user.ID), nil, accessTokenFor(t, store, user))
+		assert.Equal(t, 204, w.Code)
+
+		deletedUser, err := store.GetUser(user.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, deletedUser)
+	}
+
+	t.Run("TestCancelledByLogin", func(t *testing.T) {
+		foo := createTestUser(t, store, "foo", rbac.RoleUser)
+		deleteUser(t, foo)
+
+		// A wrong password doesn't cancel the deletion.
+		w := login("foo@bar.com", "wrong")
+		assert.Equal(t, 403, w.Code)
+		deletedUser, err := store.GetUser(foo.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, deletedUser)
+
+		w = login("foo@bar.com", "foo")
+		assert.Equal(t, 200, w.Code)
+		restoredUser, err := store.GetUser(foo.ID)
+		assert.NoError(t, err)
+		assert.NotNil(t, restoredUser)
+		assert.Contains(t, server.Auditor.(*recordingAuditor).actions(), "user.restore")
+	})
+
+	t.Run("TestAfterGracePeriod", func(t *testing.T) {
+		bar := createTestUser(t, store, "bar", rbac.RoleUser)
+		deleteUser(t, bar)
+
+		server.AccountDeletionGracePeriod = time.Nanosecond
+		defer func() { server.AccountDeletionGracePeriod = 14 * 24 * time.Hour }()
+
+		w := login("bar@bar.com", "bar")
+		assert.Equal(t, 403, w.Code)
+
+		// The email stays taken until the account is purged.
+		w = doRequest(server, "POST", "/v1/auth/register", map[string]string{"username": "bar", "email": "bar@bar.com", "password": "correct horse battery staple"}, "")
+		assert.Equal(t, 400, w.Code)
+	})
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	bar := createTestUser(t, store, "bar", rbac.RoleUser)
+
+	fooBook, err := store.CreateBook(&types.Book{Title: "Foo", Author: "Bar", PagesCount: 100, PagesRead: 10, OwnerID: foo.ID})
+	assert.NoError(t, err)
+	barBook, err := store.CreateBook(&types.Book{Title: "Bar", Author: "Foo", PagesCount: 100, PagesRead: 10, OwnerID: bar.ID})
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.DeleteBook(fooBook))
+	assert.NoError(t, store.DeleteUser(bar))
+
+	// Nothing is purged before the trash retention and grace period are over.
+	assert.NoError(t, server.PurgeDeleted(time.Now()))
+	deletedBook, err := store.GetDeletedBook(fooBook.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, deletedBook)
+	deletedUser, err := store.GetDeletedUser(bar.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, deletedUser)
+
+	// The deleted account is purged after the grace period, with its books.
+	assert.NoError(t, server.PurgeDeleted(time.Now().Add(server.AccountDeletionGracePeriod+time.Minute)))
+	deletedUser, err = store.GetDeletedUser(bar.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, deletedUser)
+	purgedBook, err := store.GetBook(barBook.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, purgedBook)
+
+	deletedBook, err = store.GetDeletedBook(fooBook.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, deletedBook)
+
+	// The book is purged after the trash retention.
+	assert.NoError(t, server.PurgeDeleted(time.Now().Add(server.TrashRetention+time.Minute)))
+	deletedBook, err = store.GetDeletedBook(fooBook.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, deletedBook)
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIVersion is a version of the API, served under "/" followed by its name, e.g. "/v1".
+type APIVersion struct {
+	Name string
+	// Handlers replacing those of the previous version, keyed by method and route path, e.g. "GET /books/".
+	// Routes without a replacement serve the previous version's handler behind the same middlewares,
+	// so a new version only names the handlers whose behaviour changes while clients migrate to it.
+	Handlers map[string]gin.HandlerFunc
+}
+
+// Returns the versions served by default, oldest first.
+func DefaultAPIVersions() []APIVersion {
+	return []APIVersion{{Name: "v1"}}
+}
+
+// When the unversioned routes were deprecated, and when they will be removed by default.
+var (
+	defaultUnversionedDeprecation = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	defaultUnversionedSunset      = time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC)
+)
+
+// VersionRouter registers the routes of an API version on a router group,
+// swapping in the version's replacement handlers.
+type VersionRouter struct {
+	group *gin.RouterGroup
+	// Path of the group relative to the version's root, e.g. "/admin".
+	prefix   string
+	handlers map[string]gin.HandlerFunc
+}
+
+// Returns a router for the routes under the path, which run the middlewares first.
+func (r *VersionRouter) Group(path string, middlewares ...gin.HandlerFunc) *VersionRouter {
+	return &VersionRouter{
+		group:    r.group.Group(path, middlewares...),
+		prefix:   r.prefix + path,
+		handlers: r.handlers,
+	}
+}
+
+// Registers the handlers for the method and path, replacing the last one if the version has a replacement for the route.
+func (r *VersionRouter) Handle(method string, path string, handlers ...gin.HandlerFunc) {
+	if replacement, ok := r.handlers[method+" "+r.prefix+path]; ok {
+		handlers = append(handlers[:len(handlers)-1:len(handlers)-1], replacement)
+	}
+	r.group.Handle(method, path, handlers...)
+}
+
+func (r *VersionRouter) GET(path string, handlers ...gin.HandlerFunc) {
+	r.Handle(http.MethodGet, path, handlers...)
+}
+
+func (r *VersionRouter) POST(path string, handlers ...gin.HandlerFunc) {
+	r.Handle(http.MethodPost, path, handlers...)
+}
+
+func (r *VersionRouter) PUT(path string, handlers ...gin.HandlerFunc) {
+	r.Handle(http.MethodPut, path, handlers...)
+}
+
+func (r *VersionRouter) PATCH(path string, handlers ...gin.HandlerFunc) {
+	r.Handle(http.MethodPatch, path, handlers...)
+}
+
+func (r *VersionRouter) DELETE(path string, handlers ...gin.HandlerFunc) {
+	r.Handle(http.MethodDelete, path, handlers...)
+}
+
+// Registers every version of the API under its prefix, and the unversioned routes as deprecated aliases of the first version.
+func (s *Server) registerAPIVersions() {
+	handlers := make(map[string]gin.HandlerFunc)
+	for i, version := range s.APIVersions {
+		// Later versions inherit the replacements of the versions before them.
+		merged := make(map[string]gin.HandlerFunc, len(handlers)+len(version.Handlers))
+		for route, handler := range handlers {
+			merged[route] = handler
+		}
+		for route, handler := range version.Handlers {
+			merged[route] = handler
+		}
+		handlers = merged
+
+		s.registerAPI(s.router.Group("/"+version.Name), handlers)
+		if i == 0 {
+			s.registerAPI(s.router.Group("", s.DeprecatedRoutes("/"+version.Name)), handlers)
+		}
+	}
+}
+
+// Registers the routes of one version of the API on the group.
+func (s *Server) registerAPI(group *gin.RouterGroup, handlers map[string]gin.HandlerFunc) {
+	public := &VersionRouter{group: group, handlers: handlers}
+	s.RegisterAuthHandlers(public)
+
+	// Idempotency keys are checked after authentication, so that they are scoped to the user.
+	authenticated := public.Group("", s.RequireValidAccessToken(), s.IdempotentRequests())
+	s.RegisterUserHandlers(authenticated)
+	s.RegisterBookHandlers(authenticated)
+	s.RegisterAdminHandlers(authenticated)
+}
+
+// Marks responses as coming from a deprecated route, linking to the same route under the successor's prefix.
+// Sends the Deprecation header of RFC 9745 and the Sunset header of RFC 8594.
+func (s *Server) DeprecatedRoutes(successorPrefix string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		successor := successorPrefix + c.Request.URL.Path
+		if c.Request.URL.RawQuery != "" {
+			successor += "?" + c.Request.URL.RawQuery
+		}
+
+		c.Header("Deprecation", "@"+strconv.FormatInt(s.UnversionedDeprecation.Unix(), 10))
+		if !s.UnversionedSunset.IsZero() {
+			c.Header("Sunset", s.UnversionedSunset.UTC().Format(http.TimeFormat))
+		}
+		c.Header("Link", "<"+successor+">; rel=\"successor-version\"")
+		c.Next()
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/passwords"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDeprecatedRoutes(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+
+	// The unversioned routes still work, but point to their successors.
+	w := doRequest(server, "GET", fmt.Sprintf("/users/%d?fields=all", foo.ID), nil, fooToken)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, fmt.Sprintf("@%d", server.UnversionedDeprecation.Unix()), w.Header().Get("Deprecation"))
+	assert.Equal(t, server.UnversionedSunset.Format(http.TimeFormat), w.Header().Get("Sunset"))
+	assert.Equal(t, fmt.Sprintf("</v1/users/%d?fields=all>; rel=\"successor-version\"", foo.ID), w.Header().Get("Link"))
+
+	// Errors from the unversioned routes are marked too.
+	w = doRequest(server, "GET", "/books/", nil, "")
+	assert.Equal(t, 401, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Deprecation"))
+
+	// The versioned routes aren't deprecated.
+	w = doRequest(server, "GET", fmt.Sprintf("/v1/users/%d", foo.ID), nil, fooToken)
+	assert.Equal(t, 200, w.Code)
+	assert.Empty(t, w.Header().Get("Deprecation"))
+	assert.Empty(t, w.Header().Get("Sunset"))
+	assert.Empty(t, w.Header().Get("Link"))
+
+	// Neither are the routes that were never versioned.
+	w = doRequest(server, "GET", "/healthz", nil, "")
+	assert.Equal(t, 200, w.Code)
+	assert.Empty(t, w.Header().Get("Deprecation"))
+}
+
+func TestAPIVersionsSideBySide(t *testing.T) {
+	store := storage.NewMemoryStorage()
+	server := NewServer(":8080", store)
+	server.AccessTokenSecretKey = testSecretKey
+	server.Auditor = &recordingAuditor{}
+	server.PasswordHasher = passwords.Hasher{Cost: bcrypt.MinCost}
+
+	// v2 wraps the book list in an envelope and changes the stats, v3 only changes the stats again.
+	server.APIVersions = []APIVersion{
+		{Name: "v1"},
+		{Name: "v2", Handlers: map[string]gin.HandlerFunc{
+			"GET /books/": func(c *gin.Context) {
+				books, _ := store.GetBooks(c.MustGet("currentUser").(*types.User).ID)
+				c.JSON(http.StatusOK, gin.H{"books": books, "total": len(*books)})
+			},
+			"GET /admin/stats": func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"version": 2}) },
+		}},
+		{Name: "v3", Handlers: map[string]gin.HandlerFunc{
+			"GET /admin/stats": func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"version": 3}) },
+		}},
+	}
+	server.RegisterRoutes()
+
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	admin := createTestUser(t, store, "admin", rbac.RoleAdmin)
+	fooToken := accessTokenFor(t, store, foo)
+	adminToken := accessTokenFor(t, store, admin)
+	_, err := store.CreateBook(&types.Book{Title: "Foo", Author: "Bar", PagesCount: 10, PagesRead: 1, OwnerID: foo.ID})
+	assert.NoError(t, err)
+
+	// v1 and its deprecated aliases keep the old response.
+	for _, path := range []string{"/v1/books/", "/books/"} {
+		w := doRequest(server, "GET", path, nil, fooToken)
+		assert.Equal(t, 200, w.Code)
+
+		var books []types.Book
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &books), path)
+		assert.Len(t, books, 1)
+	}
+
+	// v2 and v3 serve the replacement.
+	for _, path := range []string{"/v2/books/", "/v3/books/"} {
+		w := doRequest(server, "GET", path, nil, fooToken)
+		assert.Equal(t, 200, w.Code)
+
+		var envelope struct {
+			Books []types.Book `json:"books"`
+			Total int          `json:"total"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &envelope), path)
+		assert.Equal(t, 1, envelope.Total)
+	}
+
+	// Replacements run behind the same middlewares.
+	w := doRequest(server, "GET", "/v2/books/", nil, "")
+	assert.Equal(t, 401, w.Code)
+	w = doRequest(server, "GET", "/v2/admin/stats", nil, fooToken)
+	assert.Equal(t, 403, w.Code)
+
+	// Later versions inherit replacements unless they replace them again.
+	w = doRequest(server, "GET", "/v2/admin/stats", nil, adminToken)
+	assert.JSONEq(t, `{"version": 2}`, w.Body.String())
+	w = doRequest(server, "GET", "/v3/admin/stats", nil, adminToken)
+	assert.JSONEq(t, `{"version": 3}`, w.Body.String())
+
+	// Routes without a replacement serve the v1 handler.
+	w = doRequest(server, "GET", fmt.Sprintf("/v3/users/%d", foo.ID), nil, fooToken)
+	assert.Equal(t, 200, w.Code)
+	assert.Empty(t, w.Header().Get("Deprecation"))
+}
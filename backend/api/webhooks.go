@@ -0,0 +1,218 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/declanl482/go-book-tracker-app/backend/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// The request body registering a webhook endpoint.
+type webhookRequest struct {
+	URL        string   `json:"url" binding:"required,url"`
+	EventTypes []string `json:"event_types" binding:"required,min=1"`
+}
+
+// Returns the webhook endpoint of the authorized user with the requested id, or responds with an error.
+func (s *Server) targetWebhook(c *gin.Context) (*webhooks.Endpoint, bool) {
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	endpointID, err := strconv.Atoi(c.Param("webhookID"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid webhook id")
+		return nil, false
+	}
+
+	endpoint, err := s.Webhooks.Store.GetEndpoint(endpointID)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to get webhook", err))
+		return nil, false
+	}
+
+	// There is no webhook with the requested id for this user.
+	if endpoint == nil || endpoint.UserID != fetchedUser.ID {
+		respondError(c, http.StatusNotFound, "webhook not found")
+		return nil, false
+	}
+	return endpoint, true
+}
+
+func (s *Server) handleCreateWebhook(c *gin.Context) {
+
+	// Get the authorized user from the context.
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	var request webhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondBindingError(c, err)
+		return
+	}
+
+	// Deliveries are posted over HTTP(S) only, and not to the server's own networks.
+	if err := s.Webhooks.CheckURL(c.Request.Context(), request.URL); errors.Is(err, webhooks.ErrPrivateAddress) {
+		respondProblem(c, problem.Validation(problem.FieldError{Field: "url", Code: "public_address", Message: "must not be a private, loopback or link-local address"}))
+		return
+	} else if err != nil {
+		respondProblem(c, problem.Validation(problem.FieldError{Field: "url", Code: "url", Message: err.Error()}))
+		return
+	}
+	for _, eventType := range request.EventTypes {
+		if !webhooks.IsEventType(eventType) {
+			respondProblem(c, problem.Validation(problem.FieldError{Field: "event_types", Code: "oneof",
+				Message: "must each be one of " + strings.Join(webhooks.EventTypes, ", ")}))
+			return
+		}
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to create webhook", err))
+		return
+	}
+
+	endpoint := webhooks.Endpoint{
+		UserID:     fetchedUser.ID,
+		URL:        request.URL,
+		Secret:     secret,
+		EventTypes: request.EventTypes,
+		CreatedAt:  time.Now(),
+	}
+	if err := s.Webhooks.Store.CreateEndpoint(&endpoint); err != nil {
+		respondProblem(c, problem.Internal("failed to create webhook", err))
+		return
+	}
+
+	s.recordEvent(c, audit.Event{
+		Action:    "webhook.create",
+		SubjectID: fetchedUser.ID,
+		Target:    "webhook:" + strconv.Itoa(endpoint.ID),
+		Details:   map[string]interface{}{"url": endpoint.URL, "event_types": endpoint.EventTypes},
+	})
+
+	// SUCCESS. The secret is only ever shown here.
+	c.IndentedJSON(http.StatusCreated, endpoint)
+}
+
+func (s *Server) handleGetWebhooks(c *gin.Context) {
+
+	// Get the authorized user from the context.
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	endpoints, err := s.Webhooks.Store.ListEndpoints(fetchedUser.ID)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to fetch webhooks", err))
+		return
+	}
+	for i := range endpoints {
+		endpoints[i].Secret = ""
+	}
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, endpoints)
+}
+
+func (s *Server) handleGetWebhook(c *gin.Context) {
+	endpoint, ok := s.targetWebhook(c)
+	if !ok {
+		return
+	}
+	endpoint.Secret = ""
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, endpoint)
+}
+
+func (s *Server) handleDeleteWebhook(c *gin.Context) {
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	endpoint, ok := s.targetWebhook(c)
+	if !ok {
+		return
+	}
+
+	// Delete the endpoint, and with it the deliveries still pending.
+	if err := s.Webhooks.Store.DeleteEndpoint(endpoint.ID); err != nil {
+		respondProblem(c, problem.Internal("failed to delete webhook", err))
+		return
+	}
+
+	s.recordEvent(c, audit.Event{
+		Action:    "webhook.delete",
+		SubjectID: fetchedUser.ID,
+		Target:    "webhook:" + strconv.Itoa(endpoint.ID),
+	})
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusNoContent, nil)
+}
+
+func (s *Server) handleGetWebhookDeliveries(c *gin.Context) {
+	endpoint, ok := s.targetWebhook(c)
+	if !ok {
+		return
+	}
+
+	limit, offset, ok := parsePagination(c)
+	if !ok {
+		return
+	}
+
+	deliveries, total, err := s.Webhooks.Store.ListDeliveries(endpoint.ID, offset, limit)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to fetch webhook deliveries", err))
+		return
+	}
+
+	// SUCCESS.
+	c.IndentedJSON(http.StatusOK, gin.H{"deliveries": deliveries, "total": total, "limit": limit, "offset": offset})
+}
+
+func (s *Server) handleRedeliverWebhook(c *gin.Context) {
+	fetchedUser := c.MustGet("targetUser").(*types.User)
+
+	endpoint, ok := s.targetWebhook(c)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := strconv.Atoi(c.Param("deliveryID"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, "invalid delivery id")
+		return
+	}
+
+	delivery, err := s.Webhooks.Store.GetDelivery(deliveryID)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to get webhook delivery", err))
+		return
+	}
+
+	// There is no delivery with the requested id to this endpoint.
+	if delivery == nil || delivery.EndpointID != endpoint.ID {
+		respondError(c, http.StatusNotFound, "delivery not found")
+		return
+	}
+
+	redelivery, err := s.Webhooks.Redeliver(delivery)
+	if err != nil {
+		respondProblem(c, problem.Internal("failed to redeliver webhook", err))
+		return
+	}
+
+	s.recordEvent(c, audit.Event{
+		Action:    "webhook.redeliver",
+		SubjectID: fetchedUser.ID,
+		Target:    "webhook:" + strconv.Itoa(endpoint.ID),
+		Details:   map[string]interface{}{"delivery_id": delivery.ID, "redelivery_id": redelivery.ID},
+	})
+
+	// SUCCESS. The redelivery is attempted in the background.
+	c.IndentedJSON(http.StatusAccepted, redelivery)
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/declanl482/go-book-tracker-app/backend/webhooks"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// Receives webhook deliveries, failing them while told to.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failing  bool
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	if r.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+func (r *webhookReceiver) setFailing(failing bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failing = failing
+}
+
+// Returns the event types of the deliveries received so far, checking their signatures.
+func (r *webhookReceiver) receivedTypes(t *testing.T, secret string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	eventTypes := []string{}
+	for i, req := range r.requests {
+		assert.NoError(t, webhooks.Verify(secret, req.Header, r.bodies[i], time.Now(), time.Minute))
+		var event events.Event
+		assert.NoError(t, json.Unmarshal(r.bodies[i], &event))
+		assert.Equal(t, event.Type, req.Header.Get(webhooks.EventHeader))
+		eventTypes = append(eventTypes, event.Type)
+	}
+	return eventTypes
+}
+
+func TestWebhooks(t *testing.T) {
+	server, store := newMemoryServer(t)
+	foo := createTestUser(t, store, "foo", rbac.RoleUser)
+	bar := createTestUser(t, store, "bar", rbac.RoleUser)
+	fooToken := accessTokenFor(t, store, foo)
+	barToken := accessTokenFor(t, store, bar)
+	auditor := &recordingAuditor{}
+	server.Auditor = auditor
+	// The receiver listens on the loopback interface.
+	server.Webhooks.AllowPrivateAddresses = true
+
+	receiver := &webhookReceiver{}
+	httpServer := httptest.NewServer(receiver)
+	defer httpServer.Close()
+
+	// Delivers what is due at the given time, as if it were now.
+	deliver := func(now time.Time) {
+		server.Webhooks.Now = func() time.Time { return now }
+		defer func() { server.Webhooks.Now = time.Now }()
+		_, err := server.Webhooks.DeliverDue(context.Background())
+		assert.NoError(t, err)
+	}
+
+	// Register an endpoint, which is shown its secret once.
+	webhooksPath := fmt.Sprintf("/v1/users/%d/webhooks", foo.ID)
+	w := doRequest(server, "POST", webhooksPath, gin.H{
+		"url":         httpServer.URL,
+		"event_types": []string{events.BookCreated, events.BookProgressed, events.BookFinished},
+	}, fooToken)
+	assert.Equal(t, 201, w.Code)
+	var endpoint webhooks.Endpoint
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &endpoint))
+	assert.NotEmpty(t, endpoint.Secret)
+	assert.Equal(t, foo.ID, endpoint.UserID)
+	webhookPath := fmt.Sprintf("%s/%d", webhooksPath, endpoint.ID)
+
+	w = doRequest(server, "GET", webhooksPath, nil, fooToken)
+	assert.Equal(t, 200, w.Code)
+	assert.NotContains(t, w.Body.String(), endpoint.Secret)
+	assert.Contains(t, w.Body.String(), httpServer.URL)
+	assert.Equal(t, []string{"webhook.create"}, auditor.actions())
+
+	// The book's creation, the reading progress and finishing it are delivered, plain updates are not.
+	w = doRequest(server, "POST", "/v1/books/", gin.H{"title": "Foo", "author": "Bar", "pages_count": 100, "pages_read": 1}, fooToken)
+	assert.Equal(t, 201, w.Code)
+	var book types.Book
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &book))
+	bookPath := fmt.Sprintf("/v1/books/%d", book.ID)
+
+	assert.Equal(t, 200, doRequest(server, "PATCH", bookPath, gin.H{"title": "Fuzz"}, fooToken).Code)
+	assert.Equal(t, 200, doRequest(server, "PATCH", bookPath, gin.H{"pages_read": 50}, fooToken).Code)
+	w = doRequest(server, "POST", "/v1/books/batch", gin.H{"operations": []gin.H{
+		{"op": "update", "id": book.ID, "patch": gin.H{"pages_read": 100}},
+	}}, fooToken)
+	assert.Equal(t, 200, w.Code)
+
+	// Other users' changes aren't delivered to the endpoint.
+	assert.Equal(t, 201, doRequest(server, "POST", "/v1/books/", gin.H{"title": "Foo", "author": "Bar", "pages_count": 100, "pages_read": 1}, barToken).Code)
+
+	deliver(time.Now())
+	assert.Equal(t, []string{events.BookCreated, events.BookProgressed, events.BookProgressed, events.BookFinished},
+		receiver.receivedTypes(t, endpoint.Secret))
+
+	t.Run("TestDeliveryLog", func(t *testing.T) {
+		receiver.setFailing(true)
+		defer receiver.setFailing(false)
+
+		w := doRequest(server, "POST", "/v1/books/", gin.H{"title": "Fuzz", "author": "Buzz", "pages_count": 10, "pages_read": 1}, fooToken)
+		assert.Equal(t, 201, w.Code)
+		now := time.Now()
+		deliver(now)
+
+		// The failed attempt is logged, and retried later.
+		w = doRequest(server, "GET", webhookPath+"/deliveries?limit=2", nil, fooToken)
+		assert.Equal(t, 200, w.Code)
+		var page struct {
+			Deliveries []webhooks.Delivery `json:"deliveries"`
+			Total      int64               `json:"total"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, int64(5), page.Total)
+		assert.Len(t, page.Deliveries, 2)
+		failed := page.Deliveries[0]
+		assert.Equal(t, webhooks.StatusPending, failed.Status)
+		assert.Equal(t, 1, failed.Attempts)
+		assert.Equal(t, http.StatusServiceUnavailable, failed.ResponseStatus)
+		assert.True(t, failed.NextAttemptAt.After(now))
+		assert.Equal(t, webhooks.StatusSucceeded, page.Deliveries[1].Status)
+
+		receiver.setFailing(false)
+		deliver(failed.NextAttemptAt)
+		assert.Len(t, receiver.receivedTypes(t, endpoint.Secret), 6)
+
+		// Redelivering sends the event again.
+		w = doRequest(server, "POST", fmt.Sprintf("%s/deliveries/%d/redeliver", webhookPath, failed.ID), nil, fooToken)
+		assert.Equal(t, 202, w.Code)
+		var redelivery webhooks.Delivery
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &redelivery))
+		assert.Equal(t, &failed.ID, redelivery.RedeliveryOf)
+		assert.Equal(t, failed.EventID, redelivery.EventID)
+
+		deliver(time.Now())
+		assert.Len(t, receiver.receivedTypes(t, endpoint.Secret), 7)
+		assert.Contains(t, auditor.actions(), "webhook.redeliver")
+	})
+
+	t.Run("TestValidation", func(t *testing.T) {
+		w := doRequest(server, "POST", webhooksPath, gin.H{"url": "ftp://example.com", "event_types": []string{events.BookCreated}}, fooToken)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, "url", decodeProblem(t, w).Errors[0].Field)
+
+		w = doRequest(server, "POST", webhooksPath, gin.H{"url": "https://example.com", "event_types": []string{"book.read"}}, fooToken)
+		assert.Equal(t, 400, w.Code)
+		assert.Equal(t, "event_types", decodeProblem(t, w).Errors[0].Field)
+
+		w = doRequest(server, "POST", webhooksPath, gin.H{"url": "https://example.com", "event_types": []string{}}, fooToken)
+		assert.Equal(t, 400, w.Code)
+
+		// Endpoints can't be on the server's own networks.
+		server.Webhooks.AllowPrivateAddresses = false
+		defer func() { server.Webhooks.AllowPrivateAddresses = true }()
+		for _, url := range []string{httpServer.URL, "http://169.254.169.254/latest/meta-data", "http://localhost:5432"} {
+			w = doRequest(server, "POST", webhooksPath, gin.H{"url": url, "event_types": []string{events.BookCreated}}, fooToken)
+			assert.Equal(t, 400, w.Code, url)
+			assert.Equal(t, problem.FieldError{Field: "url", Code: "public_address", Message: "must not be a private, loopback or link-local address"},
+				decodeProblem(t, w).Errors[0], url)
+		}
+	})
+
+	t.Run("TestAuthorization", func(t *testing.T) {
+		// Users can't see or change the endpoints of others.
+		assert.Equal(t, 401, doRequest(server, "GET", webhookPath, nil, barToken).Code)
+		assert.Equal(t, 401, doRequest(server, "DELETE", webhookPath, nil, barToken).Code)
+		barWebhookPath := fmt.Sprintf("/v1/users/%d/webhooks/%d", bar.ID, endpoint.ID)
+		assert.Equal(t, 404, doRequest(server, "GET", barWebhookPath, nil, barToken).Code)
+		assert.Equal(t, 404, doRequest(server, "GET", barWebhookPath+"/deliveries", nil, barToken).Code)
+	})
+
+	t.Run("TestDelete", func(t *testing.T) {
+		w := doRequest(server, "DELETE", webhookPath, nil, fooToken)
+		assert.Equal(t, 204, w.Code)
+		assert.Equal(t, 404, doRequest(server, "GET", webhookPath, nil, fooToken).Code)
+
+		// Nothing more is delivered to the endpoint.
+		assert.Equal(t, 201, doRequest(server, "POST", "/v1/books/", gin.H{"title": "Foo", "author": "Bar", "pages_count": 100, "pages_read": 1}, fooToken).Code)
+		deliver(time.Now())
+		assert.Len(t, receiver.receivedTypes(t, endpoint.Secret), 7)
+	})
+}
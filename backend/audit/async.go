@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"sync"
+	"time"
+)
+
+// AsyncLogger writes audit events to a Store in the background so that logging never blocks or fails a request.
+// Events that cannot be stored, because the queue is full or the store fails, are written to the fallback logger instead.
+type AsyncLogger struct {
+	store    Store
+	fallback Logger
+	events   chan Event
+	start    sync.Once
+	done     chan struct{}
+
+	mu      sync.Mutex
+	idle    *sync.Cond
+	pending int
+	closed  bool
+}
+
+// Constructs a new AsyncLogger queueing up to bufferSize events for the store.
+func NewAsyncLogger(store Store, fallback Logger, bufferSize int) *AsyncLogger {
+	l := &AsyncLogger{
+		store:    store,
+		fallback: fallback,
+		events:   make(chan Event, bufferSize),
+		done:     make(chan struct{}),
+	}
+	l.idle = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *AsyncLogger) Log(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	// The writer is started lazily so that unused loggers don't leave a goroutine behind.
+	l.start.Do(func() { go l.run() })
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		l.fallback.Log(event)
+		return
+	}
+
+	select {
+	case l.events <- event:
+		l.pending++
+	default:
+		// Never block the request on a slow store.
+		l.fallback.Log(event)
+	}
+}
+
+// Blocks until every queued event has been handled.
+func (l *AsyncLogger) Sync() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.pending > 0 {
+		l.idle.Wait()
+	}
+}
+
+// Stores the queued events and stops the writer. Events logged afterwards go to the fallback logger.
+func (l *AsyncLogger) Close() {
+	l.start.Do(func() { go l.run() })
+
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	l.closed = true
+	close(l.events)
+	l.mu.Unlock()
+
+	<-l.done
+}
+
+// Returns a copy of the event noting why it could not be stored.
+func withStoreError(event Event, err error) Event {
+	details := make(map[string]interface{}, len(event.Details)+1)
+	for key, value := range event.Details {
+		details[key] = value
+	}
+	details["store_error"] = err.Error()
+	event.Details = details
+	return event
+}
+
+func (l *AsyncLogger) run() {
+	defer close(l.done)
+
+	for event := range l.events {
+		if err := l.store.Append(&event); err != nil {
+			l.fallback.Log(withStoreError(event, err))
+		}
+
+		l.mu.Lock()
+		l.pending--
+		l.idle.Broadcast()
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,82 @@
+package audit
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Event describes a security- or data-relevant action.
+type Event struct {
+	ID     int64     `gorm:"primaryKey" json:"id,omitempty"`
+	Time   time.Time `gorm:"not null;index" json:"time"`
+	Action string    `gorm:"not null;index" json:"action"`
+	// The user who performed the action, if authenticated.
+	ActorID int `gorm:"index" json:"actor_id,omitempty"`
+	// The user whose account or data the action concerns, if any.
+	SubjectID int    `gorm:"index" json:"subject_id,omitempty"`
+	Target    string `gorm:"index" json:"target,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+
+	Details map[string]interface{} `gorm:"serializer:json" json:"details,omitempty"`
+}
+
+func (Event) TableName() string {
+	return "audit_events"
+}
+
+// Logger records audit events.
+type Logger interface {
+	Log(event Event)
+}
+
+// Filter narrows down the events returned by a Store query.
+type Filter struct {
+	// Only return events performed by or concerning this user, if set.
+	UserID    int
+	ActorID   int
+	SubjectID int
+	Action    string
+	Target    string
+	// Only return events in [Since, Until), if set.
+	Since  time.Time
+	Until  time.Time
+	Offset int
+	Limit  int
+}
+
+// Store is an append-only store of audit events.
+type Store interface {
+	Append(event *Event) error
+	// Returns the matching events, newest first, and the total number of matching events.
+	Query(filter Filter) ([]Event, int64, error)
+}
+
+// SlogLogger writes audit events as records of a structured logger.
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// Constructs a new SlogLogger writing to logger.
+func NewSlogLogger(logger *slog.Logger) *SlogLogger {
+	return &SlogLogger{logger: logger}
+}
+
+func (l *SlogLogger) Log(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	l.logger.Info("audit",
+		slog.Time("time", event.Time),
+		slog.String("action", event.Action),
+		slog.Int("actor_id", event.ActorID),
+		slog.Int("subject_id", event.SubjectID),
+		slog.String("target", event.Target),
+		slog.String("ip", event.IP),
+		slog.String("user_agent", event.UserAgent),
+		slog.String("request_id", event.RequestID),
+		slog.Any("details", event.Details),
+	)
+}
@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := NewSlogLogger(slog.New(slog.NewJSONHandler(&buffer, nil)))
+
+	logger.Log(Event{
+		Action: "auth.lockout",
+		Target: "account:foo@bar.com",
+		IP:     "1.2.3.4",
+	})
+
+	line := buffer.String()
+	assert.Contains(t, line, `"msg":"audit"`)
+	assert.Contains(t, line, `"action":"auth.lockout"`)
+	assert.Contains(t, line, `"target":"account:foo@bar.com"`)
+	assert.Contains(t, line, `"ip":"1.2.3.4"`)
+	assert.NotContains(t, line, `"time":"0001-01-01`)
+}
+
+func TestMemoryStoreQuery(t *testing.T) {
+	store := NewMemoryStore()
+	start := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	events := []Event{
+		{Time: start, Action: "auth.login", ActorID: 1, SubjectID: 1},
+		{Time: start.Add(time.Minute), Action: "admin.user.disable", ActorID: 2, SubjectID: 1},
+		{Time: start.Add(2 * time.Minute), Action: "auth.login", ActorID: 2, SubjectID: 2},
+		{Time: start.Add(3 * time.Minute), Action: "auth.login_failed", SubjectID: 1},
+	}
+	for i := range events {
+		assert.NoError(t, store.Append(&events[i]))
+	}
+
+	// Events performed by or concerning the user, newest first.
+	found, total, err := store.Query(Filter{UserID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Equal(t, "auth.login_failed", found[0].Action)
+	assert.Equal(t, "auth.login", found[2].Action)
+
+	found, total, err = store.Query(Filter{Action: "auth.login", Since: start.Add(time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, 2, found[0].ActorID)
+
+	// Pagination is applied after counting.
+	found, total, err = store.Query(Filter{Offset: 1, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+	assert.Len(t, found, 2)
+	assert.Equal(t, "auth.login", found[0].Action)
+}
+
+// failingStore rejects every event.
+type failingStore struct{}
+
+func (failingStore) Append(event *Event) error {
+	return errors.New("database is down")
+}
+
+func (failingStore) Query(filter Filter) ([]Event, int64, error) {
+	return nil, 0, errors.New("database is down")
+}
+
+func TestAsyncLogger(t *testing.T) {
+	var fallback bytes.Buffer
+
+	// Events are written to the store in the background.
+	store := NewMemoryStore()
+	logger := NewAsyncLogger(store, NewSlogLogger(slog.New(slog.NewJSONHandler(&fallback, nil))), 16)
+	logger.Log(Event{Action: "auth.login", ActorID: 1})
+	logger.Sync()
+
+	found, total, err := store.Query(Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.False(t, found[0].Time.IsZero())
+	assert.Empty(t, fallback.String())
+
+	// Events that cannot be stored go to the fallback logger.
+	logger.Close()
+	logger.Log(Event{Action: "auth.logout"})
+	assert.Contains(t, fallback.String(), `"action":"auth.logout"`)
+
+	failing := NewAsyncLogger(failingStore{}, NewSlogLogger(slog.New(slog.NewJSONHandler(&fallback, nil))), 16)
+	failing.Log(Event{Action: "book.delete"})
+	failing.Close()
+	assert.Contains(t, fallback.String(), `"action":"book.delete"`)
+}
+
+func TestDiff(t *testing.T) {
+	type user struct {
+		Username  string `json:"username"`
+		Email     string `json:"email"`
+		Password  string `json:"password"`
+		UpdatedAt int    `json:"updated_at"`
+	}
+
+	diff := Diff(
+		user{Username: "foo", Email: "foo@bar.com", Password: "hash", UpdatedAt: 1},
+		&user{Username: "fuzz", Email: "foo@bar.com", Password: "new-hash", UpdatedAt: 2},
+		"updated_at",
+	)
+
+	assert.Equal(t, map[string]interface{}{
+		"username": Change{From: "foo", To: "fuzz"},
+		"password": Change{From: Redacted, To: Redacted},
+	}, diff)
+}
@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// Fields whose values are never recorded in a diff, only the fact that they changed.
+var SensitiveFields = []string{"password"}
+
+// Value recorded in place of a sensitive field's value.
+const Redacted = "[redacted]"
+
+// Change is the before and after value of a field.
+type Change struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// Returns the fields that differ between the JSON encodings of before and after, keyed by JSON field name.
+// Fields named in ignore are skipped and the values of sensitive fields are redacted.
+func Diff(before interface{}, after interface{}, ignore ...string) map[string]interface{} {
+	beforeFields, afterFields := jsonFields(before), jsonFields(after)
+
+	skipped := make(map[string]bool)
+	for _, field := range ignore {
+		skipped[field] = true
+	}
+	sensitive := make(map[string]bool)
+	for _, field := range SensitiveFields {
+		sensitive[field] = true
+	}
+
+	diff := make(map[string]interface{})
+	for _, fields := range []map[string]interface{}{beforeFields, afterFields} {
+		for field := range fields {
+			if skipped[field] || diff[field] != nil || reflect.DeepEqual(beforeFields[field], afterFields[field]) {
+				continue
+			}
+			if sensitive[field] {
+				diff[field] = Change{From: Redacted, To: Redacted}
+				continue
+			}
+			diff[field] = Change{From: beforeFields[field], To: afterFields[field]}
+		}
+	}
+	return diff
+}
+
+// Returns the top-level fields of the value's JSON encoding, or nil if it does not encode to an object.
+func jsonFields(value interface{}) map[string]interface{} {
+	if value == nil {
+		return nil
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil
+	}
+	return fields
+}
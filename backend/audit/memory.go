@@ -0,0 +1,76 @@
+package audit
+
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryStore is an in-process Store, for tests and local development without a database.
+type MemoryStore struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Constructs a new, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) Append(event *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	event.ID = int64(len(s.events) + 1)
+	s.events = append(s.events, *event)
+	return nil
+}
+
+func (s *MemoryStore) Query(filter Filter) ([]Event, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	events := []Event{}
+	for _, event := range s.events {
+		if filter.matches(event) {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].ID > events[j].ID })
+
+	// Apply pagination after counting the matching events.
+	total := int64(len(events))
+	if filter.Offset > len(events) {
+		filter.Offset = len(events)
+	}
+	events = events[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(events) {
+		events = events[:filter.Limit]
+	}
+	return events, total, nil
+}
+
+// Reports whether the event satisfies the filter.
+func (f Filter) matches(event Event) bool {
+	if f.UserID != 0 && event.ActorID != f.UserID && event.SubjectID != f.UserID {
+		return false
+	}
+	if f.ActorID != 0 && event.ActorID != f.ActorID {
+		return false
+	}
+	if f.SubjectID != 0 && event.SubjectID != f.SubjectID {
+		return false
+	}
+	if f.Action != "" && event.Action != f.Action {
+		return false
+	}
+	if f.Target != "" && event.Target != f.Target {
+		return false
+	}
+	if !f.Since.IsZero() && event.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !event.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
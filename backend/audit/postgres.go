@@ -0,0 +1,62 @@
+package audit
+
+import "gorm.io/gorm"
+
+// PostgresStore stores audit events in the audit_events table.
+// It only ever inserts rows; the table can be made append-only for the application's database role by revoking UPDATE and DELETE.
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+// Constructs a new PostgresStore, creating the audit_events table if needed.
+func NewPostgresStore(db *gorm.DB) (*PostgresStore, error) {
+	if err := db.AutoMigrate(&Event{}); err != nil {
+		return nil, err
+	}
+	return &PostgresStore{db: db}, nil
+}
+
+func (s *PostgresStore) Append(event *Event) error {
+	return s.db.Create(event).Error
+}
+
+func (s *PostgresStore) Query(filter Filter) ([]Event, int64, error) {
+	query := s.db.Model(&Event{})
+	if filter.UserID != 0 {
+		query = query.Where("actor_id = ? OR subject_id = ?", filter.UserID, filter.UserID)
+	}
+	if filter.ActorID != 0 {
+		query = query.Where("actor_id = ?", filter.ActorID)
+	}
+	if filter.SubjectID != 0 {
+		query = query.Where("subject_id = ?", filter.SubjectID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if filter.Target != "" {
+		query = query.Where("target = ?", filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("time >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("time < ?", filter.Until)
+	}
+
+	// Count the matching events before applying pagination.
+	var total int64
+	if result := query.Count(&total); result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	events := []Event{}
+	query = query.Order("id DESC").Offset(filter.Offset)
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	if result := query.Find(&events); result.Error != nil {
+		return nil, 0, result.Error
+	}
+	return events, total, nil
+}
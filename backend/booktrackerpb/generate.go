@@ -0,0 +1,4 @@
+// Package booktrackerpb holds the gRPC API's messages and services, generated from booktracker.proto.
+package booktrackerpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative booktracker.proto
@@ -1,39 +1,558 @@
 package config
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/declanl482/go-book-tracker-app/backend/cors"
+	"github.com/declanl482/go-book-tracker-app/backend/logging"
+	"github.com/declanl482/go-book-tracker-app/backend/passwords"
+	"github.com/declanl482/go-book-tracker-app/backend/sso"
+	"github.com/declanl482/go-book-tracker-app/backend/tracing"
 	"github.com/joho/godotenv"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pelletier/go-toml/v2"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
 )
 
+// Minimum length of the secret access tokens are signed with.
+const MinSecretKeyLength = 32
+
+// Configuration holds every setting of the server.
+//
+// Settings are read in layers, each overriding the one before: defaults, then the config file,
+// then environment variables, then command-line flags. The config tag of a field is its key in
+// the config file; the environment variable is the key in upper case and the flag is the key with
+// dashes, e.g. listen_address, LISTEN_ADDRESS and --listen-address.
 type Configuration struct {
-	DatabaseHostname     string
-	DatabasePort         string
-	DatabaseName         string
-	DatabaseUsername     string
-	DatabasePassword     string
-	DatabaseTimezone     string
-	AccessTokenSecretKey string
+	ListenAddress string `config:"listen_address" usage:"address to listen for requests on"`
+	// The gRPC API is served alongside the REST API unless the address is empty.
+	GRPCListenAddress string `config:"grpc_listen_address" usage:"address to serve the gRPC API on, not served if empty"`
+	// Serve HTTPS with the certificate and key if both are set.
+	TLSCertFile string `config:"tls_cert_file" usage:"path of the TLS certificate, serves HTTPS if set"`
+	TLSKeyFile  string `config:"tls_key_file" usage:"path of the TLS private key"`
+	// Which cross-origin requests browsers may make, see cors.Policy.
+	CORSAllowedOrigins   []string      `config:"cors_allowed_origins" usage:"comma-separated origins allowed to make cross-origin requests, such as https://*.example.com, * for any"`
+	CORSAllowedMethods   []string      `config:"cors_allowed_methods" usage:"comma-separated methods cross-origin requests may use"`
+	CORSAllowedHeaders   []string      `config:"cors_allowed_headers" usage:"comma-separated headers cross-origin requests may send"`
+	CORSExposedHeaders   []string      `config:"cors_exposed_headers" usage:"comma-separated response headers cross-origin scripts may read"`
+	CORSAllowCredentials bool          `config:"cors_allow_credentials" usage:"whether cross-origin requests may send credentials, not allowed with the * origin"`
+	CORSMaxAge           time.Duration `config:"cors_max_age" usage:"how long browsers may cache preflight responses"`
+
+	// Limits on how long connections may take to send requests, receive responses and sit idle.
+	ServerReadTimeout  time.Duration `config:"server_read_timeout" usage:"how long clients may take to send a request"`
+	ServerWriteTimeout time.Duration `config:"server_write_timeout" usage:"how long a response may take to write"`
+	ServerIdleTimeout  time.Duration `config:"server_idle_timeout" usage:"how long idle keep-alive connections are kept open"`
+	// How long in-flight requests are given to complete on shutdown.
+	ShutdownTimeout time.Duration `config:"shutdown_timeout" usage:"how long in-flight requests are given to complete on shutdown"`
+	// How long responses to requests with an Idempotency-Key are replayed to retries.
+	IdempotencyKeyTTL time.Duration `config:"idempotency_key_ttl" usage:"how long responses to requests with an Idempotency-Key are replayed to retries"`
+	// Most operations a batch request may have.
+	BatchMaxOperations int `config:"batch_max_operations" usage:"maximum number of operations in a batch request"`
+	// How deep GraphQL queries may nest their fields, and how costly they may be to resolve.
+	GraphQLMaxDepth      int `config:"graphql_max_depth" usage:"maximum depth of the fields of a GraphQL query"`
+	GraphQLMaxComplexity int `config:"graphql_max_complexity" usage:"maximum complexity of a GraphQL query, counting every field once per item of the lists it is in"`
+	// How long deleted books and accounts are kept before they are purged, and how often they are purged.
+	TrashRetention             time.Duration `config:"trash_retention" usage:"how long deleted books stay in the trash before they are purged"`
+	AccountDeletionGracePeriod time.Duration `config:"account_deletion_grace_period" usage:"how long deleted accounts can be restored by logging in before they are purged"`
+	PurgeInterval              time.Duration `config:"purge_interval" usage:"how often deleted books and accounts are purged"`
+	// How many recent events are kept for clients resuming their event streams, and how often idle streams send a heartbeat.
+	EventsReplaySize        int           `config:"events_replay_size" usage:"number of recent events kept for resuming event streams"`
+	EventsHeartbeatInterval time.Duration `config:"events_heartbeat_interval" usage:"how often idle event streams send a heartbeat"`
+	// How webhook deliveries are attempted and retried.
+	WebhookMaxAttempts  int           `config:"webhook_max_attempts" usage:"maximum number of attempts made for a webhook delivery"`
+	WebhookRetryBase    time.Duration `config:"webhook_retry_base" usage:"how long to wait before retrying a webhook delivery the first time, doubled after each attempt"`
+	WebhookRetryMax     time.Duration `config:"webhook_retry_max" usage:"longest wait before retrying a webhook delivery"`
+	WebhookTimeout      time.Duration `config:"webhook_timeout" usage:"how long webhook endpoints may take to respond"`
+	WebhookPollInterval time.Duration `config:"webhook_poll_interval" usage:"how often pending webhook deliveries are checked for"`
+	// Whether updates and deletes of books and users must send If-Match.
+	RequireIfMatch bool `config:"require_if_match" usage:"whether updates and deletes of books and users must send an If-Match header, or the version over gRPC and GraphQL"`
+
+	DatabaseHostname string `config:"database_hostname" usage:"postgres host"`
+	DatabasePort     string `config:"database_port" usage:"postgres port"`
+	DatabaseName     string `config:"database_name" usage:"postgres database name"`
+	DatabaseUsername string `config:"database_username" usage:"postgres user"`
+	DatabasePassword string `config:"database_password" usage:"postgres password" secret:"true"`
+	DatabaseTimezone string `config:"database_timezone" usage:"time zone of the database session"`
+	// Connection pool limits, zero means unlimited.
+	DatabaseMaxOpenConns    int           `config:"database_max_open_conns" usage:"maximum open database connections, 0 for unlimited"`
+	DatabaseMaxIdleConns    int           `config:"database_max_idle_conns" usage:"maximum idle database connections"`
+	DatabaseConnMaxLifetime time.Duration `config:"database_conn_max_lifetime" usage:"how long a database connection may be reused, 0 for forever"`
+	DatabaseConnMaxIdleTime time.Duration `config:"database_conn_max_idle_time" usage:"how long a database connection may sit idle, 0 for forever"`
+
+	AccessTokenSecretKey string `config:"access_token_secret_key" usage:"secret access tokens are signed with, at least 32 characters" secret:"true"`
+	// OpenID Connect providers users can log in with. In the environment, they are listed in
+	// OIDC_PROVIDERS and configured by OIDC_<NAME>_ISSUER_URL, _CLIENT_ID, _CLIENT_SECRET and _REDIRECT_URL.
+	IdentityProviders []sso.ProviderConfig `config:"identity_providers"`
+
+	// Log level ("debug", "info", "warn" or "error") and format ("json" or "text").
+	LogLevel  string `config:"log_level" usage:"minimum level of logged records: debug, info, warn or error"`
+	LogFormat string `config:"log_format" usage:"format of logged records: json or text"`
+
+	// Where traces are exported to, see tracing.Config.
+	TracingExporter     string  `config:"tracing_exporter" usage:"where traces are exported to: none, otlp or stdout"`
+	TracingOTLPEndpoint string  `config:"tracing_otlp_endpoint" usage:"URL of the OTLP/HTTP collector"`
+	TracingServiceName  string  `config:"tracing_service_name" usage:"service name traces are reported under"`
+	TracingSampleRatio  float64 `config:"tracing_sample_ratio" usage:"fraction of new traces to record, between 0 and 1"`
+
+	// Password policy and hashing, defaulting to passwords.DefaultPolicy and passwords.DefaultHasher.
+	PasswordMinLength        int    `config:"password_min_length" usage:"minimum length of new passwords"`
+	PasswordMinScore         int    `config:"password_min_score" usage:"minimum zxcvbn strength score of new passwords, 0 to 4"`
+	PasswordBcryptCost       int    `config:"password_bcrypt_cost" usage:"bcrypt cost passwords are hashed with"`
+	PasswordBreachedListFile string `config:"password_breached_list_file" usage:"path of a sorted HIBP SHA-1 list of breached passwords"`
+
+	// Where the file layer was read from, if anywhere.
+	ConfigFile string `config:"-"`
+	// Whether to print the effective configuration and exit.
+	PrintConfig bool `config:"-"`
+}
+
+// Returns the configuration used for settings that aren't given anywhere.
+func Defaults() Configuration {
+	policy := passwords.DefaultPolicy()
+	tracingConfig := tracing.DefaultConfig()
+	corsPolicy := cors.DefaultPolicy()
+	loggingConfig := logging.DefaultConfig()
+
+	return Configuration{
+		ListenAddress:      ":8000",
+		GRPCListenAddress:  ":9090",
+		CORSAllowedOrigins: corsPolicy.AllowedOrigins,
+		CORSAllowedMethods: corsPolicy.AllowedMethods,
+		CORSAllowedHeaders: corsPolicy.AllowedHeaders,
+		CORSExposedHeaders: corsPolicy.ExposedHeaders,
+		CORSMaxAge:         corsPolicy.MaxAge,
+		ServerReadTimeout:  15 * time.Second,
+		ServerWriteTimeout: 30 * time.Second,
+		ServerIdleTimeout:  60 * time.Second,
+		ShutdownTimeout:    30 * time.Second,
+		IdempotencyKeyTTL:  24 * time.Hour,
+		BatchMaxOperations: 100,
+		TrashRetention:     30 * 24 * time.Hour,
+		PurgeInterval:      time.Hour,
+		DatabasePort:       "5432",
+		DatabaseTimezone:   "UTC",
+		LogLevel:           loggingConfig.Level,
+		LogFormat:          loggingConfig.Format,
+		TracingExporter:    tracingConfig.Exporter,
+		TracingServiceName: tracingConfig.ServiceName,
+		TracingSampleRatio: tracingConfig.SampleRatio,
+		PasswordMinLength:  policy.MinLength,
+		PasswordMinScore:   policy.MinScore,
+		PasswordBcryptCost: passwords.DefaultHasher().Cost,
+
+		GraphQLMaxDepth:      8,
+		GraphQLMaxComplexity: 1000,
+
+		AccountDeletionGracePeriod: 14 * 24 * time.Hour,
+
+		EventsReplaySize:        1000,
+		EventsHeartbeatInterval: 15 * time.Second,
+
+		WebhookMaxAttempts:  8,
+		WebhookRetryBase:    30 * time.Second,
+		WebhookRetryMax:     6 * time.Hour,
+		WebhookTimeout:      10 * time.Second,
+		WebhookPollInterval: 5 * time.Second,
+
+		DatabaseMaxOpenConns:    25,
+		DatabaseMaxIdleConns:    5,
+		DatabaseConnMaxLifetime: 30 * time.Minute,
+		DatabaseConnMaxIdleTime: 5 * time.Minute,
+	}
 }
 
-var Config Configuration
+// Loads the configuration from the defaults, the config file, the environment and the command-line arguments, then validates it.
+// The config file is given by --config or CONFIG_FILE, and variables in a .env file in the working directory are added to the environment.
+// Returns flag.ErrHelp if the arguments ask for usage.
+func Load(args []string) (*Configuration, error) {
+	// Variables already in the environment take precedence over the .env file.
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load .env: %w", err)
+	}
 
-func LoadConfigurationVariables() (*Configuration, error) {
-	err := godotenv.Load(".env")
+	configuration := Defaults()
+	flagValues, err := configuration.parseFlags(args)
 	if err != nil {
 		return nil, err
 	}
 
-	Config = Configuration{
-		DatabaseHostname:     os.Getenv("DATABASE_HOSTNAME"),
-		DatabasePort:         os.Getenv("DATABASE_PORT"),
-		DatabaseName:         os.Getenv("DATABASE_NAME"),
-		DatabaseUsername:     os.Getenv("DATABASE_USERNAME"),
-		DatabasePassword:     os.Getenv("DATABASE_PASSWORD"),
-		DatabaseTimezone:     os.Getenv("DATABASE_TIMEZONE"),
-		AccessTokenSecretKey: os.Getenv("ACCESS_TOKEN_SECRET_KEY"),
+	// Config file.
+	if configuration.ConfigFile == "" {
+		configuration.ConfigFile = os.Getenv("CONFIG_FILE")
+	}
+	if configuration.ConfigFile != "" {
+		fileValues, err := readFile(configuration.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := configuration.apply(fileValues); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", configuration.ConfigFile, err)
+		}
+	}
+
+	// Environment variables.
+	envValues := make(map[string]interface{})
+	for _, setting := range settings() {
+		if value := os.Getenv(setting.env()); value != "" {
+			envValues[setting.key] = value
+		}
+	}
+	if err := configuration.apply(envValues); err != nil {
+		return nil, fmt.Errorf("invalid environment: %w", err)
+	}
+	if os.Getenv("OIDC_PROVIDERS") != "" {
+		configuration.IdentityProviders = loadIdentityProviders()
+	}
+
+	// Command-line flags.
+	if err := configuration.apply(flagValues); err != nil {
+		return nil, fmt.Errorf("invalid flags: %w", err)
+	}
+
+	if err := configuration.Validate(); err != nil {
+		return nil, err
+	}
+	return &configuration, nil
+}
+
+// setting describes a field of the configuration that can be set from the environment and flags.
+type setting struct {
+	key    string
+	usage  string
+	secret bool
+	index  int
+}
+
+// Returns the name of the environment variable setting the field.
+func (s setting) env() string {
+	return strings.ToUpper(s.key)
+}
+
+// Returns the name of the flag setting the field.
+func (s setting) flag() string {
+	return strings.ReplaceAll(s.key, "_", "-")
+}
+
+// Returns the settings in the order they are declared, leaving out those that can only be set in the config file.
+func settings() []setting {
+	var result []setting
+	configurationType := reflect.TypeOf(Configuration{})
+	for i := 0; i < configurationType.NumField(); i++ {
+		field := configurationType.Field(i)
+		key := field.Tag.Get("config")
+		if key == "" || key == "-" || field.Tag.Get("usage") == "" {
+			continue
+		}
+		result = append(result, setting{
+			key:    key,
+			usage:  field.Tag.Get("usage"),
+			secret: field.Tag.Get("secret") == "true",
+			index:  i,
+		})
+	}
+	return result
+}
+
+// Parses the command-line arguments, returning the settings given as flags to be applied last.
+// The config file and print options are set right away, as they decide how the rest is loaded.
+func (c *Configuration) parseFlags(args []string) (map[string]interface{}, error) {
+	flags := flag.NewFlagSet("go-book-tracker-app", flag.ContinueOnError)
+	flags.StringVar(&c.ConfigFile, "config", "", "path of a YAML or TOML config file (env CONFIG_FILE)")
+	flags.BoolVar(&c.PrintConfig, "print-config", false, "print the effective configuration, with secrets redacted, and exit")
+
+	values := make(map[string]interface{})
+	for _, s := range settings() {
+		s := s
+		flags.Func(s.flag(), fmt.Sprintf("%s (env %s)", s.usage, s.env()), func(value string) error {
+			values[s.key] = value
+			return nil
+		})
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	return values, nil
+}
+
+// Reads the settings in the config file, which is parsed as YAML or TOML depending on its extension.
+func readFile(path string) (map[string]interface{}, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	values := make(map[string]interface{})
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &values)
+	case ".toml":
+		err = toml.Unmarshal(contents, &values)
+	default:
+		return nil, fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
 	}
-	return &Config, nil
+	return values, nil
+}
+
+// Sets the fields named by the keys of the values, converting strings to the fields' types.
+// Unknown keys are rejected so that misspelt settings don't go unnoticed.
+func (c *Configuration) apply(values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName:          "config",
+		WeaklyTypedInput: true,
+		ErrorUnused:      true,
+		Result:           c,
+		// Keys of nested settings, such as identity providers, are matched in snake case.
+		MatchName: func(key string, fieldName string) bool {
+			return strings.EqualFold(strings.ReplaceAll(key, "_", ""), fieldName)
+		},
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			stringToTrimmedSliceHook,
+		),
+	})
+	if err != nil {
+		return err
+	}
+	return decoder.Decode(values)
+}
+
+// Splits comma-separated strings into slices, trimming the spaces around each element.
+func stringToTrimmedSliceHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if from.Kind() != reflect.String || to.Kind() != reflect.Slice {
+		return data, nil
+	}
+
+	var elements []string
+	for _, element := range strings.Split(data.(string), ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements, nil
+}
+
+// Loads the OpenID Connect providers listed in OIDC_PROVIDERS, e.g. "google,okta".
+// Each provider is configured by OIDC_<NAME>_ISSUER_URL, _CLIENT_ID, _CLIENT_SECRET and _REDIRECT_URL.
+func loadIdentityProviders() []sso.ProviderConfig {
+	var providers []sso.ProviderConfig
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, sso.ProviderConfig{
+			Name:         name,
+			IssuerURL:    os.Getenv(prefix + "ISSUER_URL"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		})
+	}
+	return providers
+}
+
+// Returns an error listing every invalid setting.
+func (c *Configuration) Validate() error {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.ListenAddress); err != nil {
+		problem("listen_address %q must be a host and port, such as \":8000\"", c.ListenAddress)
+	}
+	if _, _, err := net.SplitHostPort(c.GRPCListenAddress); c.GRPCListenAddress != "" && err != nil {
+		problem("grpc_listen_address %q must be a host and port, such as \":9090\"", c.GRPCListenAddress)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problem("tls_cert_file and tls_key_file must be set together")
+	}
+	if err := c.CORS().Validate(); err != nil {
+		problem("cors: %v", err)
+	}
+
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server_read_timeout", c.ServerReadTimeout},
+		{"server_write_timeout", c.ServerWriteTimeout},
+		{"server_idle_timeout", c.ServerIdleTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout},
+		{"idempotency_key_ttl", c.IdempotencyKeyTTL},
+		{"trash_retention", c.TrashRetention},
+		{"account_deletion_grace_period", c.AccountDeletionGracePeriod},
+		{"purge_interval", c.PurgeInterval},
+		{"events_heartbeat_interval", c.EventsHeartbeatInterval},
+		{"webhook_retry_base", c.WebhookRetryBase},
+		{"webhook_retry_max", c.WebhookRetryMax},
+		{"webhook_timeout", c.WebhookTimeout},
+		{"webhook_poll_interval", c.WebhookPollInterval},
+	} {
+		if timeout.value <= 0 {
+			problem("%s must be positive", timeout.name)
+		}
+	}
+
+	if c.BatchMaxOperations < 1 {
+		problem("batch_max_operations must be at least 1")
+	}
+	if c.GraphQLMaxDepth < 1 || c.GraphQLMaxComplexity < 1 {
+		problem("graphql_max_depth and graphql_max_complexity must be at least 1")
+	}
+	if c.EventsReplaySize < 0 {
+		problem("events_replay_size must not be negative")
+	}
+	if c.WebhookMaxAttempts < 1 {
+		problem("webhook_max_attempts must be at least 1")
+	}
+
+	for _, required := range []struct {
+		name  string
+		value string
+	}{
+		{"database_hostname", c.DatabaseHostname},
+		{"database_name", c.DatabaseName},
+		{"database_username", c.DatabaseUsername},
+	} {
+		if required.value == "" {
+			problem("%s is required", required.name)
+		}
+	}
+	if port, err := strconv.Atoi(c.DatabasePort); err != nil || port < 1 || port > 65535 {
+		problem("database_port %q must be a port number", c.DatabasePort)
+	}
+	if _, err := time.LoadLocation(c.DatabaseTimezone); err != nil {
+		problem("database_timezone %q is not a known time zone", c.DatabaseTimezone)
+	}
+	if c.DatabaseMaxOpenConns < 0 || c.DatabaseMaxIdleConns < 0 || c.DatabaseConnMaxLifetime < 0 || c.DatabaseConnMaxIdleTime < 0 {
+		problem("database connection pool limits must not be negative")
+	}
+
+	if c.AccessTokenSecretKey == "" {
+		problem("access_token_secret_key is required")
+	} else if len(c.AccessTokenSecretKey) < MinSecretKeyLength {
+		problem("access_token_secret_key must be at least %d characters long", MinSecretKeyLength)
+	}
+	for _, provider := range c.IdentityProviders {
+		if provider.Name == "" || provider.IssuerURL == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			problem("identity provider %q needs a name, issuer_url, client_id and redirect_url", provider.Name)
+		}
+	}
+
+	if _, err := logging.New(io.Discard, c.Logging()); err != nil {
+		problem("log_level or log_format: %v", err)
+	}
+
+	switch strings.ToLower(c.TracingExporter) {
+	case "", "none", "otlp", "stdout":
+	default:
+		problem("tracing_exporter %q must be none, otlp or stdout", c.TracingExporter)
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		problem("tracing_sample_ratio must be between 0 and 1")
+	}
+
+	if c.PasswordMinLength < 1 {
+		problem("password_min_length must be at least 1")
+	}
+	if c.PasswordMinScore < 0 || c.PasswordMinScore > 4 {
+		problem("password_min_score must be between 0 and 4")
+	}
+	if c.PasswordBcryptCost < bcrypt.MinCost || c.PasswordBcryptCost > bcrypt.MaxCost {
+		problem("password_bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(problems...))
+	}
+	return nil
+}
+
+// Returns the CORS policy.
+func (c *Configuration) CORS() cors.Policy {
+	return cors.Policy{
+		AllowedOrigins:   c.CORSAllowedOrigins,
+		AllowedMethods:   c.CORSAllowedMethods,
+		AllowedHeaders:   c.CORSAllowedHeaders,
+		ExposedHeaders:   c.CORSExposedHeaders,
+		AllowCredentials: c.CORSAllowCredentials,
+		MaxAge:           c.CORSMaxAge,
+	}
+}
+
+// Returns the tracing settings.
+func (c *Configuration) Tracing() tracing.Config {
+	return tracing.Config{
+		Exporter:    c.TracingExporter,
+		Endpoint:    c.TracingOTLPEndpoint,
+		ServiceName: c.TracingServiceName,
+		SampleRatio: c.TracingSampleRatio,
+	}
+}
+
+// Returns the logging settings.
+func (c *Configuration) Logging() logging.Config {
+	return logging.Config{Level: c.LogLevel, Format: c.LogFormat}
+}
+
+// Writes the effective configuration, one setting per line, with secrets redacted.
+func (c *Configuration) Dump(w io.Writer) error {
+	value := reflect.ValueOf(*c)
+	for _, s := range settings() {
+		field := value.Field(s.index)
+
+		var formatted string
+		switch {
+		case s.secret && !field.IsZero():
+			formatted = logging.Redacted
+		case field.Kind() == reflect.Slice:
+			formatted = strings.Join(field.Interface().([]string), ",")
+		default:
+			formatted = fmt.Sprint(field.Interface())
+		}
+
+		if _, err := fmt.Fprintf(w, "%s = %q\n", s.key, formatted); err != nil {
+			return err
+		}
+	}
+
+	for _, provider := range c.IdentityProviders {
+		clientSecret := ""
+		if provider.ClientSecret != "" {
+			clientSecret = logging.Redacted
+		}
+		if _, err := fmt.Fprintf(w, "identity_providers.%s = {issuer_url = %q, client_id = %q, client_secret = %q, redirect_url = %q}\n",
+			provider.Name, provider.IssuerURL, provider.ClientID, clientSecret, provider.RedirectURL); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 type TestConfiguration struct {
@@ -46,8 +565,6 @@ type TestConfiguration struct {
 	TestAccessTokenSecretKey string
 }
 
-var TestConfig TestConfiguration
-
 func LoadTestConfigurationVariables() (*TestConfiguration, error) {
 
 	err := godotenv.Load("../../.env.test")
@@ -55,7 +572,7 @@ func LoadTestConfigurationVariables() (*TestConfiguration, error) {
 		return nil, err
 	}
 
-	TestConfig = TestConfiguration{
+	return &TestConfiguration{
 		TestDatabaseHostname:     os.Getenv("TEST_DATABASE_HOSTNAME"),
 		TestDatabasePort:         os.Getenv("TEST_DATABASE_PORT"),
 		TestDatabaseName:         os.Getenv("TEST_DATABASE_NAME"),
@@ -63,6 +580,5 @@ func LoadTestConfigurationVariables() (*TestConfiguration, error) {
 		TestDatabasePassword:     os.Getenv("TEST_DATABASE_PASSWORD"),
 		TestDatabaseTimezone:     os.Getenv("TEST_DATABASE_TIMEZONE"),
 		TestAccessTokenSecretKey: os.Getenv("TEST_ACCESS_TOKEN_SECRET_KEY"),
-	}
-	return &TestConfig, nil
+	}, nil
 }
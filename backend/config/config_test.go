@@ -1,7 +1,13 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/sso"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -20,3 +26,144 @@ func TestConfigurationVariables(t *testing.T) {
 
 	t.Logf("Successfully loaded and verified configuration variables for testing database.")
 }
+
+// Sets the settings without defaults, so that the configuration is valid.
+func setRequiredEnv(t *testing.T) {
+	t.Setenv("DATABASE_HOSTNAME", "localhost")
+	t.Setenv("DATABASE_NAME", "books")
+	t.Setenv("DATABASE_USERNAME", "postgres")
+	t.Setenv("ACCESS_TOKEN_SECRET_KEY", strings.Repeat("s", MinSecretKeyLength))
+}
+
+// Writes a config file with the contents to a temporary directory, returns its path.
+func writeConfigFile(t *testing.T, name string, contents string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	setRequiredEnv(t)
+
+	configuration, err := Load(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ":8000", configuration.ListenAddress)
+	assert.Equal(t, []string{"*"}, configuration.CORSAllowedOrigins)
+	assert.Equal(t, "5432", configuration.DatabasePort)
+	assert.Equal(t, 25, configuration.DatabaseMaxOpenConns)
+	assert.Equal(t, 30*time.Minute, configuration.DatabaseConnMaxLifetime)
+	assert.Equal(t, "info", configuration.LogLevel)
+}
+
+func TestLoadLayers(t *testing.T) {
+	setRequiredEnv(t)
+
+	for _, test := range []struct {
+		name     string
+		filename string
+		contents string
+	}{
+		{
+			name:     "yaml",
+			filename: "config.yaml",
+			contents: "listen_address: \":9000\"\nlog_level: debug\ndatabase_max_open_conns: 50\nserver_read_timeout: 5s\ncors_allowed_origins:\n  - https://a.example.com\n  - https://b.example.com\n",
+		},
+		{
+			name:     "toml",
+			filename: "config.toml",
+			contents: "listen_address = \":9000\"\nlog_level = \"debug\"\ndatabase_max_open_conns = 50\nserver_read_timeout = \"5s\"\ncors_allowed_origins = [\"https://a.example.com\", \"https://b.example.com\"]\n",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeConfigFile(t, test.filename, test.contents)
+
+			// The file overrides the defaults.
+			configuration, err := Load([]string{"--config", path})
+			assert.NoError(t, err)
+			assert.Equal(t, ":9000", configuration.ListenAddress)
+			assert.Equal(t, "debug", configuration.LogLevel)
+			assert.Equal(t, 50, configuration.DatabaseMaxOpenConns)
+			assert.Equal(t, 5*time.Second, configuration.ServerReadTimeout)
+			assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, configuration.CORSAllowedOrigins)
+			assert.Equal(t, path, configuration.ConfigFile)
+
+			// The environment overrides the file, and flags override the environment.
+			t.Setenv("CONFIG_FILE", path)
+			t.Setenv("LISTEN_ADDRESS", ":9100")
+			t.Setenv("LOG_LEVEL", "warn")
+			t.Setenv("CORS_ALLOWED_ORIGINS", "https://c.example.com, https://d.example.com")
+			configuration, err = Load([]string{"--listen-address", ":9200"})
+			assert.NoError(t, err)
+			assert.Equal(t, ":9200", configuration.ListenAddress)
+			assert.Equal(t, "warn", configuration.LogLevel)
+			assert.Equal(t, 50, configuration.DatabaseMaxOpenConns)
+			assert.Equal(t, []string{"https://c.example.com", "https://d.example.com"}, configuration.CORSAllowedOrigins)
+		})
+	}
+}
+
+func TestLoadRejectsUnknownSettings(t *testing.T) {
+	setRequiredEnv(t)
+
+	_, err := Load([]string{"--config", writeConfigFile(t, "config.yaml", "listen_adress: \":9000\"\n")})
+	assert.ErrorContains(t, err, "listen_adress")
+
+	_, err = Load([]string{"--no-such-flag"})
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() Configuration {
+		configuration := Defaults()
+		configuration.DatabaseHostname = "localhost"
+		configuration.DatabaseName = "books"
+		configuration.DatabaseUsername = "postgres"
+		configuration.AccessTokenSecretKey = strings.Repeat("s", MinSecretKeyLength)
+		return configuration
+	}
+	configuration := valid()
+	assert.NoError(t, configuration.Validate())
+
+	for _, test := range []struct {
+		name    string
+		modify  func(*Configuration)
+		problem string
+	}{
+		{"missing secret", func(c *Configuration) { c.AccessTokenSecretKey = "" }, "access_token_secret_key is required"},
+		{"short secret", func(c *Configuration) { c.AccessTokenSecretKey = "short" }, "at least 32 characters"},
+		{"missing database", func(c *Configuration) { c.DatabaseHostname = "" }, "database_hostname is required"},
+		{"invalid listen address", func(c *Configuration) { c.ListenAddress = "8000" }, "listen_address"},
+		{"invalid gRPC listen address", func(c *Configuration) { c.GRPCListenAddress = "9090" }, "grpc_listen_address"},
+		{"tls key without certificate", func(c *Configuration) { c.TLSKeyFile = "key.pem" }, "tls_cert_file and tls_key_file"},
+		{"invalid origin", func(c *Configuration) { c.CORSAllowedOrigins = []string{"example.com"} }, "cors"},
+		{"credentials from any origin", func(c *Configuration) { c.CORSAllowCredentials = true }, "credentials cannot be allowed from any origin"},
+		{"negative pool limit", func(c *Configuration) { c.DatabaseMaxOpenConns = -1 }, "pool limits"},
+		{"unknown log level", func(c *Configuration) { c.LogLevel = "loud" }, "log_level"},
+		{"no GraphQL depth", func(c *Configuration) { c.GraphQLMaxDepth = 0 }, "graphql_max_depth"},
+		{"sample ratio out of range", func(c *Configuration) { c.TracingSampleRatio = 2 }, "tracing_sample_ratio"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			configuration := valid()
+			test.modify(&configuration)
+			assert.ErrorContains(t, configuration.Validate(), test.problem)
+		})
+	}
+}
+
+func TestDumpRedactsSecrets(t *testing.T) {
+	configuration := Defaults()
+	configuration.DatabasePassword = "database-password"
+	configuration.AccessTokenSecretKey = "access-token-secret-key"
+	configuration.IdentityProviders = []sso.ProviderConfig{{Name: "google", ClientID: "client", ClientSecret: "client-secret"}}
+
+	var dump strings.Builder
+	assert.NoError(t, configuration.Dump(&dump))
+
+	assert.Contains(t, dump.String(), `listen_address = ":8000"`)
+	assert.Contains(t, dump.String(), `database_password = "[REDACTED]"`)
+	assert.Contains(t, dump.String(), `access_token_secret_key = "[REDACTED]"`)
+	assert.Contains(t, dump.String(), `client_id = "client"`)
+	for _, secret := range []string{"database-password", "access-token-secret-key", "client-secret"} {
+		assert.NotContains(t, dump.String(), secret)
+	}
+}
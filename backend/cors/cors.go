@@ -0,0 +1,141 @@
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy describes which cross-origin requests browsers are allowed to make.
+type Policy struct {
+	// Origins requests may come from, e.g. "https://books.example.com". A "*" in place of the
+	// leftmost host label matches any subdomain, e.g. "https://*.example.com", and "*" alone matches any origin.
+	AllowedOrigins []string
+	// Methods and request headers that may be used.
+	AllowedMethods []string
+	AllowedHeaders []string
+	// Response headers scripts may read besides the CORS-safelisted ones.
+	ExposedHeaders []string
+	// Whether cookies and authorization headers may be sent. Cannot be combined with the "*" origin.
+	AllowCredentials bool
+	// How long browsers may cache the result of a preflight request.
+	MaxAge time.Duration
+}
+
+// Returns the policy used when none is configured, which allows any origin without credentials.
+func DefaultPolicy() Policy {
+	return Policy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPatch, http.MethodDelete, http.MethodOptions},
+		AllowedHeaders: []string{"Authorization", "Content-Type", "X-Request-ID", "Idempotency-Key", "If-Match", "If-None-Match"},
+		ExposedHeaders: []string{"X-Request-ID", "Idempotent-Replayed", "ETag", "Retry-After", "Deprecation", "Sunset", "Link"},
+		MaxAge:         10 * time.Minute,
+	}
+}
+
+// Returns an error if an origin pattern is malformed or credentials are allowed from any origin.
+func (p Policy) Validate() error {
+	var problems []error
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if p.AllowCredentials {
+				problems = append(problems, errors.New("credentials cannot be allowed from any origin"))
+			}
+			continue
+		}
+
+		parsed, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || parsed.Path != "" || parsed.RawQuery != "" {
+			problems = append(problems, fmt.Errorf("origin %q must be * or a scheme and host such as \"https://books.example.com\" or \"https://*.example.com\"", origin))
+		}
+	}
+	if p.MaxAge < 0 {
+		problems = append(problems, errors.New("max age must not be negative"))
+	}
+	return errors.Join(problems...)
+}
+
+// Reports whether requests may come from the origin.
+func (p Policy) AllowsOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, pattern := range p.AllowedOrigins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the origin matches the pattern. Schemes and hosts are compared case-insensitively.
+func matchOrigin(pattern string, origin string) bool {
+	if pattern == "*" {
+		return true
+	}
+	pattern = strings.ToLower(pattern)
+	origin = strings.ToLower(origin)
+
+	prefix, suffix, wildcard := strings.Cut(pattern, "://*.")
+	if !wildcard {
+		return pattern == origin
+	}
+
+	// The wildcard stands for one or more labels, never for nothing, so "https://example.com" doesn't match "https://*.example.com".
+	scheme, host, ok := strings.Cut(origin, "://")
+	if !ok || scheme != prefix {
+		return false
+	}
+	subdomain, found := strings.CutSuffix(host, "."+suffix)
+	return found && subdomain != "" && !strings.ContainsAny(subdomain, "/:@")
+}
+
+// Reports whether the method may be used.
+func (p Policy) AllowsMethod(method string) bool {
+	for _, allowedMethod := range p.AllowedMethods {
+		if strings.EqualFold(allowedMethod, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether every header in the comma-separated list of a preflight's Access-Control-Request-Headers may be sent.
+func (p Policy) AllowsHeaders(requestedHeaders string) bool {
+	for _, header := range strings.Split(requestedHeaders, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+
+		allowed := false
+		for _, allowedHeader := range p.AllowedHeaders {
+			if allowedHeader == "*" || strings.EqualFold(allowedHeader, header) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the value of Access-Control-Allow-Origin for a request from the allowed origin.
+// The origin is reflected unless any origin is allowed without credentials, in which case the response doesn't vary by origin.
+func (p Policy) AllowOriginHeader(origin string) string {
+	if !p.AllowCredentials && len(p.AllowedOrigins) == 1 && p.AllowedOrigins[0] == "*" {
+		return "*"
+	}
+	return origin
+}
+
+// Returns the value of Access-Control-Max-Age, in whole seconds.
+func (p Policy) MaxAgeHeader() string {
+	return strconv.Itoa(int(p.MaxAge.Seconds()))
+}
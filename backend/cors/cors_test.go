@@ -0,0 +1,69 @@
+package cors
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowsOrigin(t *testing.T) {
+	policy := Policy{AllowedOrigins: []string{"https://books.example.com", "https://*.example.org", "http://localhost:3000"}}
+
+	for _, test := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://books.example.com", true},
+		{"HTTPS://Books.Example.com", true},
+		{"http://books.example.com", false},
+		{"https://books.example.com:8443", false},
+		{"https://evil-books.example.com", false},
+		{"https://app.example.org", true},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://app.example.org.evil.com", false},
+		{"https://evilexample.org", false},
+		{"http://app.example.org", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3001", false},
+		{"null", false},
+		{"", false},
+	} {
+		assert.Equal(t, test.allowed, policy.AllowsOrigin(test.origin), "origin %q", test.origin)
+	}
+
+	assert.True(t, DefaultPolicy().AllowsOrigin("https://anywhere.example.net"))
+}
+
+func TestAllowsHeaders(t *testing.T) {
+	policy := DefaultPolicy()
+	assert.True(t, policy.AllowsHeaders(""))
+	assert.True(t, policy.AllowsHeaders("authorization, content-type"))
+	// Conditional requests can be made with the ETags the API exposes.
+	assert.True(t, policy.AllowsHeaders("If-Match, If-None-Match"))
+	assert.Subset(t, policy.ExposedHeaders, []string{"ETag", "Deprecation", "Sunset", "Link"})
+	assert.False(t, policy.AllowsHeaders("Authorization, X-Custom-Header"))
+
+	policy.AllowedHeaders = []string{"*"}
+	assert.True(t, policy.AllowsHeaders("X-Custom-Header"))
+}
+
+func TestAllowOriginHeader(t *testing.T) {
+	// Any origin without credentials doesn't need to be reflected.
+	assert.Equal(t, "*", DefaultPolicy().AllowOriginHeader("https://books.example.com"))
+
+	policy := Policy{AllowedOrigins: []string{"https://*.example.com"}, AllowCredentials: true}
+	assert.Equal(t, "https://books.example.com", policy.AllowOriginHeader("https://books.example.com"))
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, DefaultPolicy().Validate())
+	assert.NoError(t, Policy{AllowedOrigins: []string{"https://*.example.com", "http://localhost:3000"}, AllowCredentials: true}.Validate())
+
+	assert.Error(t, Policy{AllowedOrigins: []string{"*"}, AllowCredentials: true}.Validate())
+	assert.Error(t, Policy{AllowedOrigins: []string{"books.example.com"}}.Validate())
+	assert.Error(t, Policy{AllowedOrigins: []string{"https://books.example.com/path"}}.Validate())
+	assert.Error(t, Policy{AllowedOrigins: []string{"ftp://books.example.com"}}.Validate())
+	assert.Error(t, Policy{AllowedOrigins: []string{"*"}, MaxAge: -time.Second}.Validate())
+}
@@ -0,0 +1,157 @@
+package events
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// How many events a subscriber may fall behind by before it is dropped.
+const subscriberBuffer = 64
+
+// How long to wait before listening to the backplane again after its connection failed.
+const listenRetryDelay = 5 * time.Second
+
+// Broker fans the published events out to the subscriptions of their users,
+// keeping the most recent ones so that clients can resume where they left off.
+type Broker struct {
+	// Carries the events between server instances, if set. Otherwise events only reach this instance's subscribers.
+	Backplane Backplane
+	Logger    *slog.Logger
+
+	mu            sync.Mutex
+	replay        []Event
+	next          int
+	subscriptions map[*Subscription]struct{}
+}
+
+// Subscription receives the events of one user.
+type Subscription struct {
+	UserID int
+	// The events, closed when the subscriber fell too far behind, or unsubscribed.
+	C  <-chan Event
+	ch chan Event
+}
+
+// Constructs a new Broker keeping the replay size most recent events for resuming.
+func NewBroker(replaySize int) *Broker {
+	return &Broker{
+		Logger:        slog.Default(),
+		replay:        make([]Event, 0, replaySize),
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publishes the event to the subscribers of its user, on every server instance if there is a backplane.
+func (b *Broker) Publish(ctx context.Context, event Event) error {
+	if b.Backplane != nil {
+		// The event comes back to this instance through the backplane.
+		return b.Backplane.Publish(ctx, event)
+	}
+	b.deliver(event)
+	return nil
+}
+
+// Listens to the backplane, if there is one, until the context is done, delivering the events of every instance.
+// Listens again whenever the connection to it fails.
+func (b *Broker) Run(ctx context.Context) {
+	if b.Backplane == nil {
+		return
+	}
+
+	for {
+		err := b.Backplane.Listen(ctx, b.deliver)
+		if ctx.Err() != nil {
+			return
+		}
+		b.Logger.Error("lost the events backplane, listening again", "error", err, "delay", listenRetryDelay)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetryDelay):
+		}
+	}
+}
+
+// Keeps the event for resuming, and sends it to the subscriptions of its user.
+func (b *Broker) deliver(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if cap(b.replay) > 0 {
+		if len(b.replay) < cap(b.replay) {
+			b.replay = append(b.replay, event)
+		} else {
+			b.replay[b.next] = event
+		}
+		b.next = (b.next + 1) % cap(b.replay)
+	}
+
+	for subscription := range b.subscriptions {
+		if subscription.UserID != event.UserID {
+			continue
+		}
+		select {
+		case subscription.ch <- event:
+		default:
+			// Drop subscribers that fell too far behind rather than hold up everyone else;
+			// they can resume from the replay buffer.
+			b.Logger.Warn("dropped a slow events subscriber", "user_id", subscription.UserID)
+			b.remove(subscription)
+		}
+	}
+}
+
+// Subscribes to the events of the user. If the last event ID is set, the user's events published after it
+// are returned to be replayed, and complete reports whether all of them still were in the replay buffer.
+// The resume ID is that of the most recent event kept, from which clients that missed events can resume.
+func (b *Broker) Subscribe(userID int, lastEventID string) (subscription *Subscription, replay []Event, complete bool, resumeID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The kept events, oldest first.
+	kept := make([]Event, 0, len(b.replay))
+	if len(b.replay) == cap(b.replay) {
+		kept = append(kept, b.replay[b.next:]...)
+		kept = append(kept, b.replay[:b.next]...)
+	} else {
+		kept = append(kept, b.replay...)
+	}
+	if len(kept) > 0 {
+		resumeID = kept[len(kept)-1].ID
+	}
+
+	complete = lastEventID == ""
+	for i := 0; i < len(kept) && !complete; i++ {
+		if kept[i].ID != lastEventID {
+			continue
+		}
+		complete = true
+		for _, event := range kept[i+1:] {
+			if event.UserID == userID {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	ch := make(chan Event, subscriberBuffer)
+	subscription = &Subscription{UserID: userID, C: ch, ch: ch}
+	b.subscriptions[subscription] = struct{}{}
+	return subscription, replay, complete, resumeID
+}
+
+// Stops sending events to the subscription.
+func (b *Broker) Unsubscribe(subscription *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(subscription)
+}
+
+func (b *Broker) remove(subscription *Subscription) {
+	if _, ok := b.subscriptions[subscription]; ok {
+		delete(b.subscriptions, subscription)
+		close(subscription.ch)
+	}
+}
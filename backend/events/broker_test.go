@@ -0,0 +1,140 @@
+package events
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Publishes an event of the type for the user.
+func publish(t *testing.T, broker *Broker, eventType string, userID int) {
+	event, err := New(eventType, userID, map[string]int{"id": userID})
+	assert.NoError(t, err)
+	assert.NoError(t, broker.Publish(context.Background(), event))
+}
+
+// Receives the next event of the subscription, failing if there is none.
+func receive(t *testing.T, subscription *Subscription) Event {
+	select {
+	case event := <-subscription.C:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker(3)
+
+	// Subscribers receive the events of their own user.
+	foo, replay, complete, _ := broker.Subscribe(1, "")
+	assert.Empty(t, replay)
+	assert.True(t, complete)
+	bar, _, _, _ := broker.Subscribe(2, "")
+
+	publish(t, broker, BookCreated, 1)
+	publish(t, broker, BookCreated, 2)
+
+	first := receive(t, foo)
+	assert.NotEmpty(t, first.ID)
+	assert.Equal(t, BookCreated, first.Type)
+	assert.JSONEq(t, `{"id": 1}`, string(first.Data))
+	assert.False(t, first.Time.IsZero())
+	assert.Equal(t, 2, receive(t, bar).UserID)
+	assert.Empty(t, foo.C)
+
+	// Resuming replays the user's events after the last one received.
+	publish(t, broker, BookUpdated, 1)
+	second := receive(t, foo)
+	_, replay, complete, _ = broker.Subscribe(1, first.ID)
+	assert.True(t, complete)
+	assert.Equal(t, []Event{second}, replay)
+
+	// Only the most recent events are kept.
+	publish(t, broker, BookDeleted, 1)
+	third := receive(t, foo)
+	_, replay, complete, resumeID := broker.Subscribe(1, first.ID)
+	assert.False(t, complete)
+	assert.Empty(t, replay)
+	assert.Equal(t, third.ID, resumeID)
+
+	_, replay, complete, _ = broker.Subscribe(1, second.ID)
+	assert.True(t, complete)
+	assert.Equal(t, []Event{third}, replay)
+
+	// Unsubscribing closes the subscription.
+	broker.Unsubscribe(bar)
+	_, ok := <-bar.C
+	assert.False(t, ok)
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	broker := NewBroker(0)
+	subscription, _, _, _ := broker.Subscribe(1, "")
+
+	// Subscribers that don't keep up are dropped.
+	for i := 0; i <= subscriberBuffer; i++ {
+		event, err := New(BookUpdated, 1, nil)
+		assert.NoError(t, err)
+		assert.NoError(t, broker.Publish(context.Background(), event))
+	}
+
+	received := 0
+	for range subscription.C {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received)
+	broker.Unsubscribe(subscription)
+}
+
+// Delivers the published events to every listening broker, like Postgres does.
+type fakeBackplane struct {
+	listeners chan func(Event)
+	deliver   []func(Event)
+}
+
+func (f *fakeBackplane) Publish(ctx context.Context, event Event) error {
+	for _, deliver := range f.deliver {
+		deliver(event)
+	}
+	return nil
+}
+
+func (f *fakeBackplane) Listen(ctx context.Context, deliver func(Event)) error {
+	f.listeners <- deliver
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestBrokerBackplane(t *testing.T) {
+	backplane := &fakeBackplane{listeners: make(chan func(Event))}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two instances listen to the backplane.
+	brokers := []*Broker{NewBroker(10), NewBroker(10)}
+	for _, broker := range brokers {
+		broker.Backplane = backplane
+		go broker.Run(ctx)
+		backplane.deliver = append(backplane.deliver, <-backplane.listeners)
+	}
+
+	subscriptions := []*Subscription{}
+	for _, broker := range brokers {
+		subscription, _, _, _ := broker.Subscribe(1, "")
+		subscriptions = append(subscriptions, subscription)
+	}
+
+	// An event published on one instance reaches the subscribers of both, with the same ID.
+	event, err := New(UserUpdated, 1, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, brokers[0].Publish(ctx, event))
+
+	first, second := receive(t, subscriptions[0]), receive(t, subscriptions[1])
+	assert.NotEmpty(t, first.ID)
+	assert.Equal(t, first.ID, second.ID)
+	assert.Empty(t, subscriptions[0].C)
+}
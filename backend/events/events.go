@@ -0,0 +1,67 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// The types of the events.
+const (
+	BookCreated  = "book.created"
+	BookUpdated  = "book.updated"
+	BookDeleted  = "book.deleted"
+	BookRestored = "book.restored"
+	UserUpdated  = "user.updated"
+	UserDeleted  = "user.deleted"
+	UserRestored = "user.restored"
+)
+
+// The types of the events derived from book updates. They are only sent to webhooks, clients see the updates.
+const (
+	// The update increased the pages read.
+	BookProgressed = "book.progressed"
+	// The update increased the pages read to the page count.
+	BookFinished = "book.finished"
+)
+
+// Event is a change to the books or account of a user, streamed to the user's clients.
+type Event struct {
+	// Unique across server instances.
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// The user whose clients the event is streamed to.
+	UserID int       `json:"user_id"`
+	Time   time.Time `json:"time"`
+	// The changed book or user, as the API represents it.
+	Data json.RawMessage `json:"data"`
+}
+
+// Returns a new event of the type for the user happening now, with a new ID and the data encoded as JSON.
+func New(eventType string, userID int, data interface{}) (Event, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, err
+	}
+	now := time.Now()
+	return Event{ID: newID(now), Type: eventType, UserID: userID, Time: now, Data: encoded}, nil
+}
+
+// Backplane carries the published events to every server instance, so that clients are streamed the events
+// published by any of them.
+type Backplane interface {
+	// Sends the event to every instance, including this one.
+	Publish(ctx context.Context, event Event) error
+	// Delivers the events sent by every instance until the context is done or the connection fails.
+	Listen(ctx context.Context, deliver func(Event)) error
+}
+
+// Returns a new event ID, which starts with the time so that IDs are roughly ordered in logs.
+func newID(now time.Time) string {
+	random := make([]byte, 6)
+	rand.Read(random)
+	return strconv.FormatInt(now.UnixMilli(), 36) + "-" + hex.EncodeToString(random)
+}
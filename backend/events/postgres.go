@@ -0,0 +1,83 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+)
+
+// The channel the events are sent on.
+const postgresChannel = "book_tracker_events"
+
+// Postgres rejects notification payloads of this many bytes or more.
+const maxNotificationPayload = 8000
+
+// PostgresBackplane carries the events between server instances with LISTEN and NOTIFY.
+type PostgresBackplane struct {
+	db *gorm.DB
+}
+
+// Constructs a new PostgresBackplane on the database's connection pool.
+func NewPostgresBackplane(db *gorm.DB) *PostgresBackplane {
+	return &PostgresBackplane{db: db}
+}
+
+func (p *PostgresBackplane) Publish(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if len(payload) >= maxNotificationPayload {
+		return fmt.Errorf("event %s of %d bytes is too large to publish", event.Type, len(payload))
+	}
+	return p.db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", postgresChannel, string(payload)).Error
+}
+
+// Listens on a connection of its own, taken from the pool for as long as it listens.
+func (p *PostgresBackplane) Listen(ctx context.Context, deliver func(Event)) error {
+	sqlDB, err := p.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		stdlibConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return errors.New("events backplane requires the pgx driver")
+		}
+		pgxConn := stdlibConn.Conn()
+
+		if _, err := pgxConn.Exec(ctx, "LISTEN "+postgresChannel); err != nil {
+			return err
+		}
+		// Stop listening before the connection goes back to the pool, unless waiting closed it.
+		defer func() {
+			if !pgxConn.IsClosed() {
+				pgxConn.Exec(context.Background(), "UNLISTEN "+postgresChannel)
+			}
+		}()
+
+		for {
+			notification, err := pgxConn.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+
+			var event Event
+			if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+				// Not sent by Publish.
+				continue
+			}
+			deliver(event)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+// Record is a request made with an idempotency key, and its response once it has completed.
+type Record struct {
+	// The idempotency key, scoped to the client that sent it.
+	Key string
+	// Identifies the request the key was first used with, see Fingerprint.
+	Fingerprint string
+	CreatedAt   time.Time
+	// When the key may be used for another request.
+	ExpiresAt time.Time
+	// The response to replay, nil while the request is in progress.
+	Response *Response
+}
+
+// Response is a recorded response to a request.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Reports whether the record no longer holds the key at the given time.
+func (r *Record) expired(now time.Time) bool {
+	return !now.Before(r.ExpiresAt)
+}
+
+// Store keeps the requests made with idempotency keys and their responses.
+type Store interface {
+	// Claims the key of the record for its request, unless the key is held by a record that hasn't expired
+	// at the record's creation time, which is returned instead. Only one of any concurrent claims succeeds.
+	Claim(record Record) (*Record, error)
+	// Records the response of the request that claimed the key.
+	Complete(key string, response Response) error
+	// Gives up the claim on the key without a response, so that the request may be retried with it.
+	Release(key string) error
+}
+
+// Returns the fingerprint of a request, which differs for requests with a different method, path or body.
+func Fingerprint(method string, path string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + " " + path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,113 @@
+package idempotency
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	newRecord := func(key string, fingerprint string, at time.Time) Record {
+		return Record{Key: key, Fingerprint: fingerprint, CreatedAt: at, ExpiresAt: at.Add(time.Hour)}
+	}
+
+	t.Run("TestReplay", func(t *testing.T) {
+		store := NewMemoryStore()
+
+		existing, err := store.Claim(newRecord("user:1:a", "foo", now))
+		assert.NoError(t, err)
+		assert.Nil(t, existing)
+
+		// While the request is in progress, the key is held without a response.
+		existing, err = store.Claim(newRecord("user:1:a", "foo", now))
+		assert.NoError(t, err)
+		assert.NotNil(t, existing)
+		assert.Nil(t, existing.Response)
+
+		response := Response{Status: http.StatusCreated, Header: http.Header{"Content-Type": {"application/json"}}, Body: []byte(`{"id":1}`)}
+		assert.NoError(t, store.Complete("user:1:a", response))
+
+		existing, err = store.Claim(newRecord("user:1:a", "bar", now.Add(time.Minute)))
+		assert.NoError(t, err)
+		assert.Equal(t, "foo", existing.Fingerprint)
+		assert.Equal(t, &response, existing.Response)
+
+		// Other keys are independent.
+		existing, err = store.Claim(newRecord("user:2:a", "foo", now))
+		assert.NoError(t, err)
+		assert.Nil(t, existing)
+	})
+
+	t.Run("TestRelease", func(t *testing.T) {
+		store := NewMemoryStore()
+
+		_, err := store.Claim(newRecord("a", "foo", now))
+		assert.NoError(t, err)
+		assert.NoError(t, store.Release("a"))
+
+		existing, err := store.Claim(newRecord("a", "bar", now))
+		assert.NoError(t, err)
+		assert.Nil(t, existing)
+
+		assert.Error(t, store.Complete("b", Response{Status: http.StatusOK}))
+	})
+
+	t.Run("TestExpiry", func(t *testing.T) {
+		store := NewMemoryStore()
+
+		_, err := store.Claim(newRecord("a", "foo", now))
+		assert.NoError(t, err)
+		assert.NoError(t, store.Complete("a", Response{Status: http.StatusOK}))
+
+		existing, err := store.Claim(newRecord("a", "bar", now.Add(time.Hour-time.Second)))
+		assert.NoError(t, err)
+		assert.NotNil(t, existing)
+
+		// Once the record expires, the key may be used for another request.
+		existing, err = store.Claim(newRecord("a", "bar", now.Add(time.Hour)))
+		assert.NoError(t, err)
+		assert.Nil(t, existing)
+
+		// Expired records are swept away.
+		_, err = store.Claim(newRecord("b", "foo", now))
+		assert.NoError(t, err)
+		_, err = store.Claim(newRecord("c", "foo", now.Add(2*time.Hour)))
+		assert.NoError(t, err)
+		assert.NotContains(t, store.records, "b")
+	})
+
+	t.Run("TestConcurrentClaims", func(t *testing.T) {
+		store := NewMemoryStore()
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		claimed := 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				existing, err := store.Claim(newRecord("a", "foo", now))
+				assert.NoError(t, err)
+				if existing == nil {
+					mu.Lock()
+					claimed++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, claimed)
+	})
+}
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := Fingerprint("POST", "/v1/books/", []byte(`{"title":"foo"}`))
+	assert.Equal(t, fingerprint, Fingerprint("POST", "/v1/books/", []byte(`{"title":"foo"}`)))
+	assert.NotEqual(t, fingerprint, Fingerprint("POST", "/v1/books/", []byte(`{"title":"bar"}`)))
+	assert.NotEqual(t, fingerprint, Fingerprint("POST", "/v1/auth/register", []byte(`{"title":"foo"}`)))
+	assert.NotEqual(t, fingerprint, Fingerprint("PATCH", "/v1/books/", []byte(`{"title":"foo"}`)))
+}
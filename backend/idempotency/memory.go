@@ -0,0 +1,75 @@
+package idempotency
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// How often the memory store drops the expired records.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the records in process memory.
+// It is suitable for a single server instance and for tests.
+type MemoryStore struct {
+	mu        sync.Mutex
+	records   map[string]Record
+	lastSweep time.Time
+}
+
+// Constructs a new, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (s *MemoryStore) Claim(record Record) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(record.CreatedAt)
+
+	if existing, ok := s.records[record.Key]; ok && !existing.expired(record.CreatedAt) {
+		return &existing, nil
+	}
+
+	record.Response = nil
+	s.records[record.Key] = record
+	return nil, nil
+}
+
+func (s *MemoryStore) Complete(key string, response Response) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[key]
+	if !ok {
+		return fmt.Errorf("idempotency key %q is not claimed", key)
+	}
+	response.Body = append([]byte(nil), response.Body...)
+	response.Header = response.Header.Clone()
+	record.Response = &response
+	s.records[key] = record
+	return nil
+}
+
+func (s *MemoryStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+// Drops the records expired at the given time, at most once per sweep interval, so the map does not grow unbounded.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, record := range s.records {
+		if record.expired(now) {
+			delete(s.records, key)
+		}
+	}
+}
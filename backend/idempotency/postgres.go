@@ -0,0 +1,104 @@
+package idempotency
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdempotencyRecord is the database row holding a request made with an idempotency key.
+type IdempotencyRecord struct {
+	Key         string    `gorm:"column:idempotency_key;primaryKey"`
+	Fingerprint string    `gorm:"not null"`
+	CreatedAt   time.Time `gorm:"not null"`
+	ExpiresAt   time.Time `gorm:"not null;index"`
+	// Whether the response was recorded, the request is in progress until it is.
+	Completed      bool        `gorm:"not null;default:false"`
+	ResponseStatus int         `gorm:"not null;default:0"`
+	ResponseHeader http.Header `gorm:"serializer:json"`
+	ResponseBody   []byte
+}
+
+// PostgresStore keeps the records in Postgres so that retries are recognised by every server instance.
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+// Constructs a new PostgresStore, migrating its table if needed.
+func NewPostgresStore(db *gorm.DB) (*PostgresStore, error) {
+	// Migrate the idempotency records table to the database.
+	if err := db.AutoMigrate(&IdempotencyRecord{}); err != nil {
+		return nil, err
+	}
+	return &PostgresStore{db: db}, nil
+}
+
+// Claims the key by inserting its row. Of concurrent inserts only one succeeds; the others wait for it
+// to commit and then find its row, so duplicate requests never both run.
+func (s *PostgresStore) Claim(record Record) (*Record, error) {
+	var existing *Record
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Drop the expired records, so that their keys may be claimed again.
+		if err := tx.Where("expires_at <= ?", record.CreatedAt).Delete(&IdempotencyRecord{}).Error; err != nil {
+			return err
+		}
+
+		row := IdempotencyRecord{
+			Key:         record.Key,
+			Fingerprint: record.Fingerprint,
+			CreatedAt:   record.CreatedAt,
+			ExpiresAt:   record.ExpiresAt,
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			return nil
+		}
+
+		if err := tx.Where("idempotency_key = ?", record.Key).First(&row).Error; err != nil {
+			return err
+		}
+		existing = &Record{
+			Key:         row.Key,
+			Fingerprint: row.Fingerprint,
+			CreatedAt:   row.CreatedAt,
+			ExpiresAt:   row.ExpiresAt,
+		}
+		if row.Completed {
+			existing.Response = &Response{Status: row.ResponseStatus, Header: row.ResponseHeader, Body: row.ResponseBody}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existing, nil
+}
+
+func (s *PostgresStore) Complete(key string, response Response) error {
+	result := s.db.Model(&IdempotencyRecord{}).Where("idempotency_key = ?", key).
+		Select("completed", "response_status", "response_header", "response_body").
+		Updates(&IdempotencyRecord{
+			Completed:      true,
+			ResponseStatus: response.Status,
+			ResponseHeader: response.Header,
+			ResponseBody:   response.Body,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("idempotency key %q is not claimed", key)
+	}
+	return nil
+}
+
+func (s *PostgresStore) Release(key string) error {
+	return s.db.Where("idempotency_key = ?", key).Delete(&IdempotencyRecord{}).Error
+}
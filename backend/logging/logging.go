@@ -0,0 +1,121 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Value logged in place of secrets.
+const Redacted = "[REDACTED]"
+
+// Config selects the level and format of log output.
+type Config struct {
+	// One of "debug", "info", "warn" or "error".
+	Level string
+	// Either "json" or "text".
+	Format string
+}
+
+// Returns the configuration used when none is given.
+func DefaultConfig() Config {
+	return Config{Level: "info", Format: "json"}
+}
+
+// Constructs a new structured logger writing to w.
+// Attributes with sensitive keys are redacted, and the request id and trace are added to records logged with a request's context.
+func New(w io.Writer, config Config) (*slog.Logger, error) {
+	level, err := ParseLevel(config.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(config.Format) {
+	case "", "json":
+		handler = slog.NewJSONHandler(w, options)
+	case "text":
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", config.Format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+// Parses a level name such as "info" or "warn".
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", name)
+	}
+	return level, nil
+}
+
+// Key fragments identifying attributes whose values must never be logged.
+var sensitiveKeys = []string{"password", "token", "secret", "authorization", "cookie", "api_key", "apikey"}
+
+// Reports whether an attribute or header with the key holds a secret.
+func IsSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if attr.Value.Kind() != slog.KindGroup && IsSensitive(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+	return attr
+}
+
+type requestIDKey struct{}
+
+// Returns a copy of the context carrying the request id.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// Returns the request id carried by the context, if any.
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// contextHandler adds the request id and trace from the context to every record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestID := RequestID(ctx); requestID != "" {
+		record.AddAttrs(slog.String("request_id", requestID))
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(slog.String("trace_id", spanContext.TraceID().String()), slog.String("span_id", spanContext.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, DefaultConfig())
+	assert.NoError(t, err)
+
+	ctx := WithRequestID(context.Background(), "request-1")
+	logger.InfoContext(ctx, "login", "password", "hunter2", "access_token", "abc", "user_id", 1)
+	logger.Debug("hidden")
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "login", record["msg"])
+	assert.Equal(t, "request-1", record["request_id"])
+	assert.Equal(t, Redacted, record["password"])
+	assert.Equal(t, Redacted, record["access_token"])
+	assert.Equal(t, float64(1), record["user_id"])
+	assert.NotContains(t, buf.String(), "hidden")
+}
+
+func TestConfig(t *testing.T) {
+	level, err := ParseLevel("warn")
+	assert.NoError(t, err)
+	assert.Equal(t, slog.LevelWarn, level)
+
+	_, err = ParseLevel("loud")
+	assert.Error(t, err)
+
+	_, err = New(&bytes.Buffer{}, Config{Level: "info", Format: "xml"})
+	assert.Error(t, err)
+
+	var buf bytes.Buffer
+	logger, err := New(&buf, Config{Level: "debug", Format: "text"})
+	assert.NoError(t, err)
+	logger.Debug("visible", "secret", "abc")
+	assert.Contains(t, buf.String(), "msg=visible secret="+Redacted)
+}
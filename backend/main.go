@@ -1,23 +1,69 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/declanl482/go-book-tracker-app/backend/api"
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
 	"github.com/declanl482/go-book-tracker-app/backend/config"
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/idempotency"
+	"github.com/declanl482/go-book-tracker-app/backend/logging"
+	"github.com/declanl482/go-book-tracker-app/backend/passwords"
+	"github.com/declanl482/go-book-tracker-app/backend/ratelimit"
+	"github.com/declanl482/go-book-tracker-app/backend/sso"
 	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/tracing"
+	"github.com/declanl482/go-book-tracker-app/backend/webhooks"
 )
 
 func main() {
 
-	// listenAddress := flag.String("listenAddress", ":8000", "the server address")
-	listenAddress := ":8000"
+	// Layer the config file, environment variables and flags over the defaults.
+	configuration, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		slog.Error("failed to load configuration", "error", err)
+		os.Exit(2)
+	}
+
+	// Print the effective configuration, with secrets redacted, if asked to.
+	if configuration.PrintConfig {
+		if err := configuration.Dump(os.Stdout); err != nil {
+			slog.Error("failed to print configuration", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Log structured records at the configured level, and make the logger the default for libraries.
+	logger, err := logging.New(os.Stdout, configuration.Logging())
+	if err != nil {
+		slog.Error("failed to configure logging", "error", err)
+		return
+	}
+	slog.SetDefault(logger)
 
-	configuration, err := config.LoadConfigurationVariables()
+	var effectiveConfiguration strings.Builder
+	configuration.Dump(&effectiveConfiguration)
+	logger.Info("loaded configuration", "file", configuration.ConfigFile, "config", effectiveConfiguration.String())
+
+	// Trace requests and storage calls, exporting the spans as configured.
+	tracerProvider, err := tracing.Setup(context.Background(), configuration.Tracing())
 	if err != nil {
-		fmt.Println("Failed to load configuration variables:", err)
+		slog.Error("failed to configure tracing", "error", err)
 		return
 	}
+	defer tracerProvider.Shutdown(context.Background())
 
 	hostname := configuration.DatabaseHostname
 	username := configuration.DatabaseUsername
@@ -27,19 +73,147 @@ func main() {
 	timezone := configuration.DatabaseTimezone
 
 	// Create a new instance of PostgresStorage.
-	postgresStorage, err := storage.NewPostgresStorage(hostname, username, password, name, port, timezone)
+	postgresStorage, err := storage.NewPostgresStorage(hostname, username, password, name, port, timezone,
+		storage.WithLogger(logger),
+		storage.WithPoolLimits(storage.PoolLimits{
+			MaxOpenConns:    configuration.DatabaseMaxOpenConns,
+			MaxIdleConns:    configuration.DatabaseMaxIdleConns,
+			ConnMaxLifetime: configuration.DatabaseConnMaxLifetime,
+			ConnMaxIdleTime: configuration.DatabaseConnMaxIdleTime,
+		}),
+	)
 	if err != nil {
 		// Handle the error if any.
 		panic(err)
 	}
 
+	// Record the queries run by each storage call in its span.
+	if err := tracing.InstrumentDB(postgresStorage.DB(), tracerProvider); err != nil {
+		panic(err)
+	}
+
 	// Create a new instance of the Server with the UserStorage and BookStorage implementations.
-	server := api.NewServer(listenAddress, postgresStorage)
+	server := api.NewServer(configuration.ListenAddress, storage.NewTracedStorage(postgresStorage, tracerProvider))
+	server.Logger = logger
+	server.GRPCListenAddress = configuration.GRPCListenAddress
+	server.AccessTokenSecretKey = configuration.AccessTokenSecretKey
+	server.CORSPolicy = configuration.CORS()
+	server.TLSCertFile = configuration.TLSCertFile
+	server.TLSKeyFile = configuration.TLSKeyFile
+	server.TracerProvider = tracerProvider
+	server.ReadTimeout = configuration.ServerReadTimeout
+	server.WriteTimeout = configuration.ServerWriteTimeout
+	server.IdleTimeout = configuration.ServerIdleTimeout
+	server.RequireIfMatch = configuration.RequireIfMatch
+	server.MaxBatchOperations = configuration.BatchMaxOperations
+	server.GraphQLMaxDepth = configuration.GraphQLMaxDepth
+	server.GraphQLMaxComplexity = configuration.GraphQLMaxComplexity
+	server.TrashRetention = configuration.TrashRetention
+	server.AccountDeletionGracePeriod = configuration.AccountDeletionGracePeriod
+	server.PurgeInterval = configuration.PurgeInterval
+	server.EventHeartbeatInterval = configuration.EventsHeartbeatInterval
+	server.ReadinessChecks["database"] = postgresStorage.Ready
 
-	// Start the server.
-	err = server.Start()
+	// Export query latencies and connection pool stats on /metrics.
+	if err := server.Metrics.InstrumentDB(postgresStorage.DB()); err != nil {
+		panic(err)
+	}
+
+	// Track login attempts in the database so that throttling is shared across server instances.
+	loginLimiter, err := ratelimit.NewPostgresLimiter(postgresStorage.DB(), ratelimit.DefaultPolicy(), ratelimit.SystemClock{})
+	if err != nil {
+		panic(err)
+	}
+	server.LoginLimiter = loginLimiter
+
+	// Record idempotent requests in the database so that retries are recognised by every server instance.
+	idempotencyStore, err := idempotency.NewPostgresStore(postgresStorage.DB())
+	if err != nil {
+		panic(err)
+	}
+	server.Idempotency = idempotencyStore
+	server.IdempotencyTTL = configuration.IdempotencyKeyTTL
+
+	// Carry the events streamed to clients between server instances with LISTEN and NOTIFY.
+	server.Events = events.NewBroker(configuration.EventsReplaySize)
+	server.Events.Backplane = events.NewPostgresBackplane(postgresStorage.DB())
+
+	// Queue webhook deliveries in the database, so that they are retried across restarts and by any instance.
+	webhookStore, err := webhooks.NewPostgresStore(postgresStorage.DB())
 	if err != nil {
-		// Handle the error if any.
 		panic(err)
 	}
+	server.Webhooks = webhooks.NewDispatcher(webhookStore)
+	server.Webhooks.MaxAttempts = configuration.WebhookMaxAttempts
+	server.Webhooks.RetryBase = configuration.WebhookRetryBase
+	server.Webhooks.RetryMax = configuration.WebhookRetryMax
+	server.Webhooks.Timeout = configuration.WebhookTimeout
+	server.Webhooks.PollInterval = configuration.WebhookPollInterval
+
+	// Persist audit events in the database, writing them in the background.
+	auditLog, err := audit.NewPostgresStore(postgresStorage.DB())
+	if err != nil {
+		panic(err)
+	}
+	auditLogger := audit.NewAsyncLogger(auditLog, audit.NewSlogLogger(logger), 1024)
+	server.AuditLog = auditLog
+	server.Auditor = auditLogger
+
+	// Apply the configured password policy and hashing parameters.
+	server.PasswordPolicy = passwords.Policy{
+		MinLength: configuration.PasswordMinLength,
+		MinScore:  configuration.PasswordMinScore,
+	}
+	server.PasswordHasher = passwords.Hasher{Cost: configuration.PasswordBcryptCost}
+	if configuration.PasswordBreachedListFile != "" {
+		breachedList, err := passwords.OpenBreachedList(configuration.PasswordBreachedListFile)
+		if err != nil {
+			panic(err)
+		}
+		defer breachedList.Close()
+		server.PasswordPolicy.Breached = breachedList
+	}
+
+	// Discover the configured single sign-on providers.
+	for _, providerConfig := range configuration.IdentityProviders {
+		provider, err := sso.NewProvider(context.Background(), providerConfig)
+		if err != nil {
+			panic(err)
+		}
+		server.IdentityProviders[provider.Name] = provider
+	}
+
+	// Start serving the REST and gRPC APIs, then wait for either to fail or for a signal to stop.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 2)
+	go func() {
+		serverErr <- server.Start()
+	}()
+	if server.GRPCListenAddress != "" {
+		go func() {
+			serverErr <- server.StartGRPC()
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			slog.Error("server failed", "error", err)
+		}
+	case <-ctx.Done():
+		slog.Info("shutting down, draining in-flight requests", "timeout", configuration.ShutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), configuration.ShutdownTimeout)
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("failed to shut down gracefully", "error", err)
+		}
+		cancel()
+	}
+
+	// Flush the audit log before the database connections go away.
+	auditLogger.Close()
+	if err := postgresStorage.Close(); err != nil {
+		slog.Error("failed to close the database connections", "error", err)
+	}
 }
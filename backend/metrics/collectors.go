@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+var (
+	usersDesc         = prometheus.NewDesc(Namespace+"_users", "Registered users.", nil, nil)
+	adminUsersDesc    = prometheus.NewDesc(Namespace+"_admin_users", "Users with the admin role.", nil, nil)
+	disabledUsersDesc = prometheus.NewDesc(Namespace+"_disabled_users", "Disabled users.", nil, nil)
+	booksDesc         = prometheus.NewDesc(Namespace+"_books", "Books tracked by all users.", nil, nil)
+	finishedBooksDesc = prometheus.NewDesc(Namespace+"_finished_books", "Books read to the last page.", nil, nil)
+)
+
+// statsCollector exports the counts of users and books as gauges.
+type statsCollector struct {
+	source func() (*types.Stats, error)
+	// How long the counts are reused for before they are read again.
+	maxAge time.Duration
+
+	mu        sync.Mutex
+	stats     *types.Stats
+	fetchedAt time.Time
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usersDesc
+	ch <- adminUsersDesc
+	ch <- disabledUsersDesc
+	ch <- booksDesc
+	ch <- finishedBooksDesc
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.get()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(usersDesc, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(usersDesc, prometheus.GaugeValue, float64(stats.Users))
+	ch <- prometheus.MustNewConstMetric(adminUsersDesc, prometheus.GaugeValue, float64(stats.AdminUsers))
+	ch <- prometheus.MustNewConstMetric(disabledUsersDesc, prometheus.GaugeValue, float64(stats.DisabledUsers))
+	ch <- prometheus.MustNewConstMetric(booksDesc, prometheus.GaugeValue, float64(stats.Books))
+	ch <- prometheus.MustNewConstMetric(finishedBooksDesc, prometheus.GaugeValue, float64(stats.FinishedBooks))
+}
+
+// Returns the counts, reading them from the source again once they are older than maxAge. Failures aren't cached.
+func (c *statsCollector) get() (*types.Stats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stats != nil && time.Since(c.fetchedAt) < c.maxAge {
+		return c.stats, nil
+	}
+	stats, err := c.source()
+	if err != nil {
+		return nil, err
+	}
+	c.stats, c.fetchedAt = stats, time.Now()
+	return stats, nil
+}
+
+// Key under which a query's start time is kept on the statement.
+const queryStartKey = "metrics:query_start"
+
+// queryPlugin times every query gorm runs through its callbacks.
+type queryPlugin struct {
+	metrics *Metrics
+}
+
+func (p queryPlugin) Name() string {
+	return "metrics"
+}
+
+func (p queryPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	hooks := []error{
+		callback.Create().Before("gorm:create").Register("metrics:before_create", p.before),
+		callback.Create().After("gorm:create").Register("metrics:after_create", p.after("create")),
+		callback.Query().Before("gorm:query").Register("metrics:before_query", p.before),
+		callback.Query().After("gorm:query").Register("metrics:after_query", p.after("query")),
+		callback.Update().Before("gorm:update").Register("metrics:before_update", p.before),
+		callback.Update().After("gorm:update").Register("metrics:after_update", p.after("update")),
+		callback.Delete().Before("gorm:delete").Register("metrics:before_delete", p.before),
+		callback.Delete().After("gorm:delete").Register("metrics:after_delete", p.after("delete")),
+		callback.Row().Before("gorm:row").Register("metrics:before_row", p.before),
+		callback.Row().After("gorm:row").Register("metrics:after_row", p.after("row")),
+		callback.Raw().Before("gorm:raw").Register("metrics:before_raw", p.before),
+		callback.Raw().After("gorm:raw").Register("metrics:after_raw", p.after("raw")),
+	}
+	return errors.Join(hooks...)
+}
+
+func (p queryPlugin) before(db *gorm.DB) {
+	db.InstanceSet(queryStartKey, time.Now())
+}
+
+func (p queryPlugin) after(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		value, ok := db.InstanceGet(queryStartKey)
+		if !ok {
+			return
+		}
+		start := value.(time.Time)
+
+		table := db.Statement.Table
+		if table == "" {
+			table = "unknown"
+		}
+
+		p.metrics.DBQueryDuration.WithLabelValues(operation, table).Observe(time.Since(start).Seconds())
+		if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+			p.metrics.DBQueryErrors.WithLabelValues(operation, table).Inc()
+		}
+	}
+}
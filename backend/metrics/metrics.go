@@ -0,0 +1,145 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+// Prefix of every metric name.
+const Namespace = "booktracker"
+
+// Route label used for requests that matched no route, keeping unknown paths out of the labels.
+const UnmatchedRoute = "unmatched"
+
+// Method label used for requests with a non-standard method, keeping arbitrary methods out of the labels.
+const OtherMethod = "other"
+
+// The methods requests are labelled with, any other is labelled OtherMethod.
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// Metrics holds the collectors exported by the server.
+// Each instance has its own registry so that servers and tests don't share counts.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	HTTPRequests        *prometheus.CounterVec
+	HTTPRequestDuration *prometheus.HistogramVec
+	Logins              *prometheus.CounterVec
+	PasswordHashing     *prometheus.HistogramVec
+	DBQueryDuration     *prometheus.HistogramVec
+	DBQueryErrors       *prometheus.CounterVec
+}
+
+// Constructs a new set of metrics registered with a new registry, along with the Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		HTTPRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by method, route template and status.",
+		}, []string{"method", "route", "status"}),
+		HTTPRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by method, route template and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		Logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "auth_logins_total",
+			Help:      "Login attempts, by method and result.",
+		}, []string{"method", "result"}),
+		PasswordHashing: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "password_hash_duration_seconds",
+			Help:      "Time taken to hash and verify passwords.",
+			Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"operation"}),
+		DBQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Time taken by database queries, by operation and table.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"operation", "table"}),
+		DBQueryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "db_query_errors_total",
+			Help:      "Failed database queries, by operation and table.",
+		}, []string{"operation", "table"}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.HTTPRequests,
+		m.HTTPRequestDuration,
+		m.Logins,
+		m.PasswordHashing,
+		m.DBQueryDuration,
+		m.DBQueryErrors,
+	)
+	return m
+}
+
+// Returns the handler serving the metrics in the Prometheus text exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// Records a handled HTTP request. An empty route means no route matched.
+func (m *Metrics) ObserveRequest(method string, route string, status int, duration time.Duration) {
+	if !knownMethods[method] {
+		method = OtherMethod
+	}
+	if route == "" {
+		route = UnmatchedRoute
+	}
+	labels := prometheus.Labels{"method": method, "route": route, "status": strconv.Itoa(status)}
+	m.HTTPRequests.With(labels).Inc()
+	m.HTTPRequestDuration.With(labels).Observe(duration.Seconds())
+}
+
+// Records a login attempt. The result is "success" or the reason the attempt failed.
+func (m *Metrics) ObserveLogin(method string, result string) {
+	m.Logins.WithLabelValues(method, result).Inc()
+}
+
+// Records the time taken by a password operation, "hash" or "verify".
+func (m *Metrics) ObservePasswordHashing(operation string, duration time.Duration) {
+	m.PasswordHashing.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+// Exports the user and book counts, read from the source at most once per maxAge so that scrapes don't each count every row.
+func (m *Metrics) RegisterStats(source func() (*types.Stats, error), maxAge time.Duration) error {
+	return m.Registry.Register(&statsCollector{source: source, maxAge: maxAge})
+}
+
+// Exports the query latencies and errors of the database, and the connection pool stats of its sql.DB.
+func (m *Metrics) InstrumentDB(db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	if err := m.Registry.Register(collectors.NewDBStatsCollector(sqlDB, Namespace)); err != nil {
+		return err
+	}
+	return db.Use(queryPlugin{metrics: m})
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New()
+
+	m.ObserveRequest("GET", "/books/:id", 200, 10*time.Millisecond)
+	m.ObserveRequest("GET", "/books/:id", 200, 20*time.Millisecond)
+	m.ObserveRequest("GET", "", 404, time.Millisecond)
+	m.ObserveRequest("FOO", "", 405, time.Millisecond)
+	m.ObserveLogin("password", "success")
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(m.HTTPRequests.WithLabelValues("GET", "/books/:id", "200")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.HTTPRequests.WithLabelValues("GET", UnmatchedRoute, "404")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.HTTPRequests.WithLabelValues(OtherMethod, UnmatchedRoute, "405")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(m.Logins.WithLabelValues("password", "success")))
+
+	// The handler serves the text exposition format.
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `booktracker_http_requests_total{method="GET",route="/books/:id",status="200"} 2`)
+	assert.Contains(t, w.Body.String(), "go_goroutines")
+}
+
+func TestStats(t *testing.T) {
+	m := New()
+
+	calls := 0
+	var err error
+	source := func() (*types.Stats, error) {
+		calls++
+		return &types.Stats{Users: 3, AdminUsers: 1, Books: 7, FinishedBooks: 2}, err
+	}
+	assert.NoError(t, m.RegisterStats(source, time.Minute))
+
+	expected := `
+# HELP booktracker_books Books tracked by all users.
+# TYPE booktracker_books gauge
+booktracker_books 7
+# HELP booktracker_users Registered users.
+# TYPE booktracker_users gauge
+booktracker_users 3
+`
+	assert.NoError(t, testutil.GatherAndCompare(m.Registry, strings.NewReader(expected), "booktracker_users", "booktracker_books"))
+
+	// The counts are reused by later scrapes until they are too old.
+	_, gatherErr := m.Registry.Gather()
+	assert.NoError(t, gatherErr)
+	assert.Equal(t, 1, calls)
+
+	// Failing to read the counts fails the scrape of them, and isn't cached.
+	m = New()
+	assert.NoError(t, m.RegisterStats(source, 0))
+	err = errors.New("database is down")
+	_, gatherErr = m.Registry.Gather()
+	assert.ErrorContains(t, gatherErr, "database is down")
+	err = nil
+	_, gatherErr = m.Registry.Gather()
+	assert.NoError(t, gatherErr)
+}
+
+func TestInstrumentDB(t *testing.T) {
+	m := New()
+
+	// Dry runs go through the callbacks without a database connection.
+	db, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	assert.NoError(t, err)
+	assert.NoError(t, m.InstrumentDB(db))
+
+	var book types.Book
+	db.First(&book, 1)
+	db.Create(&types.Book{Title: "foo"})
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `booktracker_db_query_duration_seconds_count{operation="query",table="books"} 1`)
+	assert.Contains(t, w.Body.String(), `booktracker_db_query_duration_seconds_count{operation="create",table="books"} 1`)
+	assert.Equal(t, float64(0), testutil.ToFloat64(m.DBQueryErrors.WithLabelValues("query", "books")))
+
+	// The connection pool stats are exported.
+	count, err := testutil.GatherAndCount(m.Registry, "go_sql_max_open_connections")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,127 @@
+package passwords
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+)
+
+// BreachedList looks up passwords in a local file of breached password hashes.
+//
+// The file uses the format of the Have I Been Pwned downloads: one upper-case hex SHA-1 hash per line,
+// optionally followed by ":" and a count, sorted by hash. Lookups binary search the file, so it is never loaded into memory.
+type BreachedList struct {
+	file *os.File
+	size int64
+}
+
+// Opens the breached hash list at the path.
+func OpenBreachedList(path string) (*BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &BreachedList{file: file, size: info.Size()}, nil
+}
+
+// Closes the underlying file.
+func (b *BreachedList) Close() error {
+	return b.file.Close()
+}
+
+// Reports whether the password appears in the list.
+func (b *BreachedList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := b.Range(hash[:5])
+	if err != nil {
+		return false, err
+	}
+	for _, suffix := range suffixes {
+		if suffix == hash[5:] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Returns the hash suffixes in the list starting with the 5 character prefix.
+// This is the k-anonymity range query, which only needs the prefix of the hash being looked up.
+func (b *BreachedList) Range(prefix string) ([]string, error) {
+	prefix = strings.ToUpper(prefix)
+
+	// Find the first line whose hash is not before the prefix.
+	low, high := int64(0), b.size
+	for low < high {
+		middle := low + (high-low)/2
+		line, _, err := b.lineAt(middle)
+		if err != nil {
+			return nil, err
+		}
+		if line != "" && lineHash(line) < prefix {
+			low = middle + 1
+		} else {
+			high = middle
+		}
+	}
+
+	_, start, err := b.lineAt(low)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the lines sharing the prefix.
+	var suffixes []string
+	scanner := bufio.NewScanner(io.NewSectionReader(b.file, start, b.size-start))
+	for scanner.Scan() {
+		hash := lineHash(scanner.Text())
+		if !strings.HasPrefix(hash, prefix) {
+			break
+		}
+		suffixes = append(suffixes, hash[len(prefix):])
+	}
+	return suffixes, scanner.Err()
+}
+
+// Returns the first complete line starting at or after the offset and the offset it starts at.
+// The line is empty if there is none.
+func (b *BreachedList) lineAt(offset int64) (string, int64, error) {
+	start := offset
+	if offset > 0 {
+		// Skip the rest of the line containing the byte before the offset.
+		reader := bufio.NewReader(io.NewSectionReader(b.file, offset-1, b.size-offset+1))
+		skipped, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return "", b.size, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		start = offset - 1 + int64(len(skipped))
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(b.file, start, b.size-start))
+	line, err := reader.ReadBytes('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", 0, err
+	}
+	return string(bytes.TrimSpace(line)), start, nil
+}
+
+// Returns the upper-case hash of a line, without the count.
+func lineHash(line string) string {
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return strings.ToUpper(hash)
+}
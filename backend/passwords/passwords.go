@@ -0,0 +1,128 @@
+package passwords
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nbutton23/zxcvbn-go"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Longest password allowed, in bytes. bcrypt can't hash longer passwords, and checking their strength would take too long.
+const MaxLength = 72
+
+// Violation is returned when a password does not satisfy the policy.
+// Its message is safe to show to the user.
+type Violation struct {
+	Message string
+}
+
+func (v *Violation) Error() string {
+	return v.Message
+}
+
+// Policy describes the passwords users may choose.
+type Policy struct {
+	// Minimum number of characters.
+	MinLength int
+	// Minimum zxcvbn strength score, from 0 (guessable) to 4 (very unguessable).
+	MinScore int
+	// Known breached passwords to reject, if set.
+	Breached *BreachedList
+}
+
+// Returns the policy used when none is configured.
+func DefaultPolicy() Policy {
+	return Policy{
+		MinLength: 10,
+		MinScore:  3,
+	}
+}
+
+// Checks the password against the policy. The user inputs, such as the username and email, may not appear in the password.
+// Returns a *Violation if the password is not allowed, or another error if the breached list could not be read.
+func (p Policy) Validate(password string, userInputs ...string) error {
+	if len(password) > MaxLength {
+		return &Violation{Message: fmt.Sprintf("password must be at most %d bytes long", MaxLength)}
+	}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return &Violation{Message: fmt.Sprintf("password must be at least %d characters long", p.MinLength)}
+	}
+
+	// Reject passwords built from the user's own details.
+	lowerPassword := strings.ToLower(password)
+	for _, input := range expandUserInputs(userInputs) {
+		if strings.Contains(lowerPassword, input) {
+			return &Violation{Message: "password must not contain your username or email"}
+		}
+	}
+
+	if zxcvbn.PasswordStrength(password, userInputs).Score < p.MinScore {
+		return &Violation{Message: "password is too easy to guess, try a longer passphrase of unrelated words"}
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return fmt.Errorf("failed to check breached passwords: %w", err)
+		}
+		if breached {
+			return &Violation{Message: "password has appeared in a data breach, choose a different one"}
+		}
+	}
+	return nil
+}
+
+// Returns the lowercased user inputs along with the local part of emails, ignoring inputs too short to matter.
+func expandUserInputs(userInputs []string) []string {
+	var expanded []string
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if localPart, _, ok := strings.Cut(input, "@"); ok {
+			expanded = append(expanded, localPart)
+		}
+		expanded = append(expanded, input)
+	}
+
+	inputs := expanded[:0]
+	for _, input := range expanded {
+		if len(input) >= 3 {
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// Hasher hashes passwords with bcrypt at a configurable cost.
+type Hasher struct {
+	Cost int
+}
+
+// Returns the hasher used when none is configured.
+func DefaultHasher() Hasher {
+	return Hasher{Cost: 14}
+}
+
+// Hashes the password, returns the encoded hash.
+func (h Hasher) Hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashedPassword), nil
+}
+
+// Checks the password against the encoded hash, returns an error if they don't match.
+func (h Hasher) Verify(hashedPassword string, password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+}
+
+// Reports whether the hash was made with weaker parameters than the hasher's and should be replaced.
+func (h Hasher) NeedsRehash(hashedPassword string) bool {
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	if err != nil {
+		return false
+	}
+	return cost < h.Cost
+}
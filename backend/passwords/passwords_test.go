@@ -0,0 +1,99 @@
+package passwords
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPolicyValidate(t *testing.T) {
+	policy := DefaultPolicy()
+
+	tests := []struct {
+		name     string
+		password string
+		allowed  bool
+	}{
+		{"TooShort", "Xk9!mQ", false},
+		{"ContainsUsername", "foobarian-orbit-lantern-57", false},
+		{"ContainsEmailLocalPart", "xx-jdoe-meadow-quartz-83", false},
+		{"Guessable", "password1234", false},
+		{"Strong", "plum-Orbit-57-lantern", true},
+		{"TooLong", strings.Repeat("plum-Orbit-57-lantern ", 4), false},
+		{"TooLongInBytes", strings.Repeat("é", 37), false},
+		{"Longest", "plum-Orbit-57-lantern " + strings.Repeat("x", MaxLength-22), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := policy.Validate(test.password, "foobar", "jdoe@example.com")
+			if test.allowed {
+				assert.NoError(t, err)
+				return
+			}
+
+			var violation *Violation
+			assert.True(t, errors.As(err, &violation), "expected a policy violation, got: %v.", err)
+		})
+	}
+}
+
+// Writes a breached list containing the passwords in the downloadable format.
+func writeBreachedList(t *testing.T, passwords ...string) string {
+	var lines []string
+	for i, password := range passwords {
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":"+strings.Repeat("1", i+1))
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\r\n")), 0o600))
+	return path
+}
+
+func TestBreachedList(t *testing.T) {
+	breachedPasswords := []string{"plum-Orbit-57-lantern", "hunter2", "correct horse battery staple", "a", "b", "c", "d"}
+	list, err := OpenBreachedList(writeBreachedList(t, breachedPasswords...))
+	assert.NoError(t, err)
+	defer list.Close()
+
+	for _, password := range breachedPasswords {
+		breached, err := list.Contains(password)
+		assert.NoError(t, err)
+		assert.True(t, breached, "expected %q to be breached.", password)
+	}
+
+	breached, err := list.Contains("quartz-Meadow-83-violin")
+	assert.NoError(t, err)
+	assert.False(t, breached)
+
+	// The policy rejects breached passwords that are otherwise strong.
+	policy := DefaultPolicy()
+	policy.Breached = list
+
+	var violation *Violation
+	assert.True(t, errors.As(policy.Validate("plum-Orbit-57-lantern"), &violation))
+	assert.NoError(t, policy.Validate("quartz-Meadow-83-violin"))
+}
+
+func TestHasher(t *testing.T) {
+	weak := Hasher{Cost: bcrypt.MinCost}
+	strong := Hasher{Cost: bcrypt.MinCost + 1}
+
+	hashedPassword, err := weak.Hash("foo")
+	assert.NoError(t, err)
+	assert.NoError(t, strong.Verify(hashedPassword, "foo"))
+	assert.Error(t, strong.Verify(hashedPassword, "bar"))
+
+	assert.False(t, weak.NeedsRehash(hashedPassword))
+	assert.True(t, strong.NeedsRehash(hashedPassword))
+}
@@ -0,0 +1,348 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Media types of the patch documents, see RFC 7396 and RFC 6902.
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+// ErrTestFailed is returned when a "test" operation finds a value other than the expected one.
+var ErrTestFailed = errors.New("test failed")
+
+// Operation is one step of a JSON Patch document.
+type Operation struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	// Location of the value to move or copy.
+	From string `json:"from,omitempty"`
+	// Value to add, replace with or test against. Left empty when the operation has none, as opposed to null.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// OperationError describes why an operation of a JSON Patch document could not be applied.
+type OperationError struct {
+	// Position of the operation in the document, starting at 0.
+	Index int
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *OperationError) Error() string {
+	return fmt.Sprintf("operation %d (%s %s): %s", e.Index, e.Op, e.Path, e.Err)
+}
+
+func (e *OperationError) Unwrap() error {
+	return e.Err
+}
+
+// Returns the target with the merge patch applied, see RFC 7396.
+// Members of the patch set to null are removed from the target, objects are merged recursively
+// and any other value replaces the target's. The target isn't modified.
+func Merge(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return clone(patch)
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{})
+	}
+	merged := make(map[string]interface{}, len(targetObject))
+	for name, value := range targetObject {
+		merged[name] = value
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(merged, name)
+			continue
+		}
+		merged[name] = Merge(merged[name], value)
+	}
+	return clone(merged)
+}
+
+// Returns the document with the operations applied in order, see RFC 6902.
+// The document isn't modified, and if an operation fails the error says which one.
+func Apply(document interface{}, operations []Operation) (interface{}, error) {
+	document = clone(document)
+
+	for i, operation := range operations {
+		var err error
+		document, err = apply(document, operation)
+		if err != nil {
+			return nil, &OperationError{Index: i, Op: operation.Op, Path: operation.Path, Err: err}
+		}
+	}
+	return document, nil
+}
+
+func apply(document interface{}, operation Operation) (interface{}, error) {
+	path, err := parsePointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch operation.Op {
+	case "add", "replace", "test":
+		if len(operation.Value) == 0 {
+			return nil, errors.New("value is required")
+		}
+		var value interface{}
+		if err := json.Unmarshal(operation.Value, &value); err != nil {
+			return nil, fmt.Errorf("value is not valid JSON: %w", err)
+		}
+
+		switch operation.Op {
+		case "add":
+			return add(document, path, value)
+		case "replace":
+			if len(path) == 0 {
+				return value, nil
+			}
+			if document, _, err = remove(document, path); err != nil {
+				return nil, err
+			}
+			return add(document, path, value)
+		default:
+			current, err := get(document, path)
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.DeepEqual(current, value) {
+				return nil, ErrTestFailed
+			}
+			return document, nil
+		}
+
+	case "remove":
+		document, _, err = remove(document, path)
+		return document, err
+
+	case "move", "copy":
+		from, err := parsePointer(operation.From)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+
+		if operation.Op == "copy" {
+			value, err := get(document, from)
+			if err != nil {
+				return nil, fmt.Errorf("from: %w", err)
+			}
+			return add(document, path, clone(value))
+		}
+
+		// A value can't be moved into one of its own children.
+		if len(from) < len(path) && reflect.DeepEqual(from, path[:len(from)]) {
+			return nil, errors.New("can't move a value into itself")
+		}
+		document, value, err := remove(document, from)
+		if err != nil {
+			return nil, fmt.Errorf("from: %w", err)
+		}
+		return add(document, path, value)
+
+	default:
+		return nil, fmt.Errorf("unknown operation %q", operation.Op)
+	}
+}
+
+// Returns the names of the members whose values differ between the two objects, sorted.
+// Members only present in one of them are included.
+func Changed(before map[string]interface{}, after map[string]interface{}) []string {
+	var names []string
+	for name, value := range before {
+		if afterValue, ok := after[name]; !ok || !reflect.DeepEqual(value, afterValue) {
+			names = append(names, name)
+		}
+	}
+	for name := range after {
+		if _, ok := before[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parses a JSON Pointer into its unescaped reference tokens, see RFC 6901.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q must be empty or start with a slash", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// Returns the value at the path.
+func get(document interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch container := document.(type) {
+		case map[string]interface{}:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			document = value
+		case []interface{}:
+			index, err := arrayIndex(token, len(container)-1)
+			if err != nil {
+				return nil, err
+			}
+			document = container[index]
+		default:
+			return nil, fmt.Errorf("can't look up %q in a value that isn't an object or array", token)
+		}
+	}
+	return document, nil
+}
+
+// Returns the document with the value added at the path, inserting it into arrays
+// and replacing the members of objects.
+func add(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	token, rest := path[0], path[1:]
+
+	switch container := document.(type) {
+	case map[string]interface{}:
+		if len(rest) == 0 {
+			container[token] = value
+			return container, nil
+		}
+		child, ok := container[token]
+		if !ok {
+			return nil, fmt.Errorf("member %q not found", token)
+		}
+		child, err := add(child, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		container[token] = child
+		return container, nil
+
+	case []interface{}:
+		if len(rest) == 0 {
+			index := len(container)
+			if token != "-" {
+				var err error
+				if index, err = arrayIndex(token, len(container)); err != nil {
+					return nil, err
+				}
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		}
+		index, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, err
+		}
+		if container[index], err = add(container[index], rest, value); err != nil {
+			return nil, err
+		}
+		return container, nil
+
+	default:
+		return nil, fmt.Errorf("can't add %q to a value that isn't an object or array", token)
+	}
+}
+
+// Returns the document without the value at the path, and the removed value.
+func remove(document interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, errors.New("can't remove the whole document")
+	}
+	token, rest := path[0], path[1:]
+
+	switch container := document.(type) {
+	case map[string]interface{}:
+		child, ok := container[token]
+		if !ok {
+			return nil, nil, fmt.Errorf("member %q not found", token)
+		}
+		if len(rest) == 0 {
+			delete(container, token)
+			return container, child, nil
+		}
+		child, removed, err := remove(child, rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		container[token] = child
+		return container, removed, nil
+
+	case []interface{}:
+		index, err := arrayIndex(token, len(container)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(rest) == 0 {
+			removed := container[index]
+			return append(container[:index], container[index+1:]...), removed, nil
+		}
+		child, removed, err := remove(container[index], rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		container[index] = child
+		return container, removed, nil
+
+	default:
+		return nil, nil, fmt.Errorf("can't remove %q from a value that isn't an object or array", token)
+	}
+}
+
+// Parses an array index of at most max, which has no sign or leading zeros.
+func arrayIndex(token string, max int) (int, error) {
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || token != strconv.Itoa(index) {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	if index > max {
+		return 0, fmt.Errorf("array index %d is out of bounds", index)
+	}
+	return index, nil
+}
+
+// Returns a deep copy of a decoded JSON value.
+func clone(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		cloned := make(map[string]interface{}, len(value))
+		for name, member := range value {
+			cloned[name] = clone(member)
+		}
+		return cloned
+	case []interface{}:
+		cloned := make([]interface{}, len(value))
+		for i, element := range value {
+			cloned[i] = clone(element)
+		}
+		return cloned
+	default:
+		return value
+	}
+}
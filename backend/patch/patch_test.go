@@ -0,0 +1,110 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Decodes the JSON text, failing the test if it isn't valid.
+func decode(t *testing.T, text string) interface{} {
+	var value interface{}
+	assert.NoError(t, json.Unmarshal([]byte(text), &value), text)
+	return value
+}
+
+func TestMerge(t *testing.T) {
+	// The examples of RFC 7396, appendix A.
+	for _, test := range []struct {
+		target, patch, result string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		target := decode(t, test.target)
+		result := Merge(target, decode(t, test.patch))
+		assert.Equal(t, decode(t, test.result), result, "%s merged with %s", test.target, test.patch)
+
+		// The target is left as it was.
+		assert.Equal(t, decode(t, test.target), target)
+	}
+}
+
+func TestApply(t *testing.T) {
+	// Examples of RFC 6902, appendix A, and the error cases around them.
+	for _, test := range []struct {
+		name       string
+		document   string
+		operations string
+		result     string
+		err        string
+	}{
+		{"add member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`, ""},
+		{"add element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`, ""},
+		{"append element", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`, ""},
+		{"add null", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":null}]`, `{"foo":"bar","baz":null}`, ""},
+		{"remove member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`, ""},
+		{"remove element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`, ""},
+		{"replace", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`, ""},
+		{"replace document", `{"foo":"bar"}`, `[{"op":"replace","path":"","value":[1]}]`, `[1]`, ""},
+		{"move member", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`, ""},
+		{"move element", `{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`, ""},
+		{"copy", `{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"}]`, `{"foo":{"bar":1},"baz":{"bar":1}}`, ""},
+		{"test", `{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`, `{"baz":"qux","foo":["a",2,"c"]}`, ""},
+		{"escaped path", `{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10},{"op":"remove","path":"/~1"}]`, `{"~1":10}`, ""},
+		{"test failed", `{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, "", "operation 0 (test /baz): test failed"},
+		{"missing parent", `{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, "", `operation 0 (add /baz/bat): member "baz" not found`},
+		{"missing member", `{"foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"qux"}]`, "", `operation 0 (replace /baz): member "baz" not found`},
+		{"missing value", `{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`, "", "operation 0 (add /baz): value is required"},
+		{"index out of bounds", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":1}]`, "", "operation 0 (add /foo/2): array index 2 is out of bounds"},
+		{"leading zero", `{"foo":["bar","baz"]}`, `[{"op":"remove","path":"/foo/01"}]`, "", `operation 0 (remove /foo/01): "01" is not an array index`},
+		{"relative path", `{"foo":"bar"}`, `[{"op":"remove","path":"foo"}]`, "", `operation 0 (remove foo): path "foo" must be empty or start with a slash`},
+		{"move into child", `{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`, "", "operation 0 (move /foo/bar/baz): can't move a value into itself"},
+		{"unknown operation", `{"foo":"bar"}`, `[{"op":"fly","path":"/foo"}]`, "", `operation 0 (fly /foo): unknown operation "fly"`},
+		{"later operation", `{"foo":"bar"}`, `[{"op":"remove","path":"/foo"},{"op":"remove","path":"/foo"}]`, "", `operation 1 (remove /foo): member "foo" not found`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var operations []Operation
+			assert.NoError(t, json.Unmarshal([]byte(test.operations), &operations))
+
+			document := decode(t, test.document)
+			result, err := Apply(document, operations)
+			if test.err != "" {
+				assert.EqualError(t, err, test.err)
+				var operationErr *OperationError
+				assert.True(t, errors.As(err, &operationErr))
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, decode(t, test.result), result)
+			}
+
+			// The document is left as it was, even if some of the operations were applied.
+			assert.Equal(t, decode(t, test.document), document)
+		})
+	}
+
+	_, err := Apply(decode(t, `{"a":1}`), []Operation{{Op: "test", Path: "/a", Value: json.RawMessage(`2`)}})
+	assert.ErrorIs(t, err, ErrTestFailed)
+}
+
+func TestChanged(t *testing.T) {
+	before := decode(t, `{"id":1,"title":"Foo","tags":["a"],"edition":2}`).(map[string]interface{})
+	after := decode(t, `{"id":1,"title":"Bar","tags":["a","b"],"owner_id":3}`).(map[string]interface{})
+	assert.Equal(t, []string{"edition", "owner_id", "tags", "title"}, Changed(before, after))
+	assert.Empty(t, Changed(before, before))
+}
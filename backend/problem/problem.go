@@ -0,0 +1,227 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Media type of problem details documents, see RFC 7807.
+const ContentType = "application/problem+json"
+
+// Code identifies the kind of error. Codes are stable, clients may branch on them.
+type Code string
+
+const (
+	CodeInvalidRequest        Code = "invalid_request"
+	CodeValidationFailed      Code = "validation_failed"
+	CodeUnauthenticated       Code = "unauthenticated"
+	CodeInvalidCredentials    Code = "invalid_credentials"
+	CodeInvalidToken          Code = "invalid_token"
+	CodeSessionRevoked        Code = "session_revoked"
+	CodeForbidden             Code = "forbidden"
+	CodeAccountDisabled       Code = "account_disabled"
+	CodePasswordResetRequired Code = "password_reset_required"
+	CodeWeakPassword          Code = "weak_password"
+	CodeNotFound              Code = "not_found"
+	CodeMethodNotAllowed      Code = "method_not_allowed"
+	CodeConflict              Code = "conflict"
+	CodeEmailTaken            Code = "email_taken"
+	CodePreconditionFailed    Code = "precondition_failed"
+	CodePreconditionRequired  Code = "precondition_required"
+	CodeIdempotencyKeyReused  Code = "idempotency_key_reused"
+	CodeIdempotencyKeyInUse   Code = "idempotency_key_in_use"
+	CodeBatchAborted          Code = "batch_aborted"
+	CodeRateLimited           Code = "rate_limited"
+	CodeQueryTooComplex       Code = "query_too_complex"
+	CodeInternal              Code = "internal_error"
+	CodeUnavailable           Code = "unavailable"
+)
+
+// Returns the code used for errors with the status that have no more specific one.
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidRequest
+	case http.StatusUnprocessableEntity:
+		return CodeValidationFailed
+	case http.StatusUnauthorized:
+		return CodeUnauthenticated
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodePreconditionFailed
+	case http.StatusPreconditionRequired:
+		return CodePreconditionRequired
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusServiceUnavailable:
+		return CodeUnavailable
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// FieldError describes why a single field of the request was rejected.
+type FieldError struct {
+	// Name of the field as the client sent it, e.g. "pages_count".
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is an error with everything needed to render it as a problem details document.
+// The detail is shown to clients, the wrapped error is only logged.
+type Error struct {
+	Status int
+	Code   Code
+	Detail string
+	Fields []FieldError
+	Err    error
+}
+
+// Constructs an error with the status, code and detail.
+func New(status int, code Code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+// Constructs a 500 error hiding the cause from clients behind the detail.
+func Internal(detail string, err error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: detail, Err: err}
+}
+
+// Constructs a validation error for the rejected fields.
+func Validation(fields ...FieldError) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeValidationFailed, Detail: "request validation failed", Fields: fields}
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("%s: %s", e.Code, e.Detail)
+	if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+	return message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Returns the problem details document describing the error.
+// The instance identifies the occurrence, e.g. the request path, and the request id correlates it with the server's logs.
+func (e *Error) Document(instance string, requestID string) Document {
+	return Document{
+		Type:      "/problems/" + string(e.Code),
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Detail,
+		Instance:  instance,
+		Code:      e.Code,
+		RequestID: requestID,
+		Errors:    e.Fields,
+	}
+}
+
+// Document is a problem details document, extended with the error code, request id and rejected fields.
+type Document struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      Code         `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// Returns the error as an *Error, wrapping errors that aren't one as internal errors.
+func From(err error) *Error {
+	var problemErr *Error
+	if errors.As(err, &problemErr) {
+		return problemErr
+	}
+	return Internal("internal server error", err)
+}
+
+// Translates an error from binding a request body or form into a problem.
+// Validation failures are reported per field, and decoding errors without echoing the input.
+func FromBinding(err error) *Error {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		fields := make([]FieldError, 0, len(validationErrors))
+		for _, fieldError := range validationErrors {
+			fields = append(fields, FieldError{
+				Field:   fieldError.Field(),
+				Code:    fieldError.Tag(),
+				Message: validationMessage(fieldError),
+			})
+		}
+		return &Error{Status: http.StatusBadRequest, Code: CodeValidationFailed, Detail: "request validation failed", Fields: fields, Err: err}
+	}
+
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		field := typeError.Field
+		if field == "" {
+			field = "body"
+		}
+		return &Error{
+			Status: http.StatusBadRequest,
+			Code:   CodeValidationFailed,
+			Detail: "request validation failed",
+			Fields: []FieldError{{Field: field, Code: "type", Message: "must be " + jsonTypeName(typeError.Type.Kind().String())}},
+			Err:    err,
+		}
+	}
+
+	var syntaxError *json.SyntaxError
+	if errors.As(err, &syntaxError) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &Error{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: "request body is not valid JSON", Err: err}
+	}
+	return &Error{Status: http.StatusBadRequest, Code: CodeInvalidRequest, Detail: "request could not be read", Err: err}
+}
+
+// Returns a message describing the failed validation rule.
+func validationMessage(fieldError validator.FieldError) string {
+	switch fieldError.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be an email address"
+	case "min":
+		return "must be at least " + fieldError.Param()
+	case "max":
+		return "must be at most " + fieldError.Param()
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fieldError.Param()), ", ")
+	}
+	return "must satisfy " + fieldError.Tag()
+}
+
+// Returns the JSON name of the Go kind.
+func jsonTypeName(kind string) string {
+	switch {
+	case strings.HasPrefix(kind, "int"), strings.HasPrefix(kind, "uint"), strings.HasPrefix(kind, "float"):
+		return "a number"
+	case kind == "string":
+		return "a string"
+	case kind == "bool":
+		return "a boolean"
+	case kind == "slice", kind == "array":
+		return "an array"
+	}
+	return "an object"
+}
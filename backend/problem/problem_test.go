@@ -0,0 +1,59 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodeForStatus(t *testing.T) {
+	assert.Equal(t, CodeInvalidRequest, CodeForStatus(http.StatusBadRequest))
+	assert.Equal(t, CodeUnauthenticated, CodeForStatus(http.StatusUnauthorized))
+	assert.Equal(t, CodeNotFound, CodeForStatus(http.StatusNotFound))
+	assert.Equal(t, CodeRateLimited, CodeForStatus(http.StatusTooManyRequests))
+	assert.Equal(t, CodePreconditionFailed, CodeForStatus(http.StatusPreconditionFailed))
+	assert.Equal(t, CodeInternal, CodeForStatus(http.StatusBadGateway))
+}
+
+func TestError(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := Internal("failed to fetch books", cause)
+
+	// The cause is logged, but never shown to clients.
+	assert.ErrorIs(t, err, cause)
+	assert.Contains(t, err.Error(), "connection refused")
+	document := err.Document("/books/", "request-1")
+	assert.Equal(t, Document{
+		Type:      "/problems/internal_error",
+		Title:     "Internal Server Error",
+		Status:    500,
+		Detail:    "failed to fetch books",
+		Instance:  "/books/",
+		Code:      CodeInternal,
+		RequestID: "request-1",
+	}, document)
+
+	// Problems are found through wrapping, anything else is internal.
+	wrapped := fmt.Errorf("handling request: %w", New(http.StatusForbidden, CodeAccountDisabled, "account disabled"))
+	assert.Equal(t, CodeAccountDisabled, From(wrapped).Code)
+	assert.Equal(t, CodeInternal, From(cause).Code)
+	assert.Equal(t, "internal server error", From(cause).Detail)
+}
+
+func TestFromBinding(t *testing.T) {
+	var target struct {
+		Pages int `json:"pages"`
+	}
+	err := json.Unmarshal([]byte(`{"pages": "many"}`), &target)
+	problem := FromBinding(err)
+	assert.Equal(t, CodeValidationFailed, problem.Code)
+	assert.Equal(t, []FieldError{{Field: "pages", Code: "type", Message: "must be a number"}}, problem.Fields)
+
+	err = json.Unmarshal([]byte(`{"pages": `), &target)
+	assert.Equal(t, CodeInvalidRequest, FromBinding(err).Code)
+	assert.Equal(t, "request body is not valid JSON", FromBinding(err).Detail)
+}
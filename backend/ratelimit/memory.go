@@ -0,0 +1,77 @@
+package ratelimit
+
+import "sync"
+
+// MemoryLimiter keeps attempt history in process memory.
+// It is suitable for a single server instance and for tests.
+type MemoryLimiter struct {
+	policy Policy
+	clock  Clock
+	mu     sync.Mutex
+	states map[string]State
+}
+
+// Constructs a new MemoryLimiter enforcing the given policy.
+func NewMemoryLimiter(policy Policy, clock Clock) *MemoryLimiter {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return &MemoryLimiter{
+		policy: policy,
+		clock:  clock,
+		states: make(map[string]State),
+	}
+}
+
+func (l *MemoryLimiter) Allow(key string) (Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, decision := l.policy.allow(l.states[key], l.clock.Now())
+	l.store(key, state)
+	return decision, nil
+}
+
+func (l *MemoryLimiter) Fail(key string) (Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, decision := l.policy.fail(l.states[key], l.clock.Now())
+	l.store(key, state)
+	return decision, nil
+}
+
+func (l *MemoryLimiter) Confirm(key string) (Decision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, decision := l.policy.confirm(l.states[key], l.clock.Now())
+	l.store(key, state)
+	return decision, nil
+}
+
+func (l *MemoryLimiter) Refund(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, _ := l.policy.refund(l.states[key], l.clock.Now())
+	l.store(key, state)
+	return nil
+}
+
+func (l *MemoryLimiter) Reset(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.states, key)
+	return nil
+}
+
+// Saves the state for the key, dropping empty states so the map does not grow unbounded.
+func (l *MemoryLimiter) store(key string, state State) {
+	if state.empty() {
+		delete(l.states, key)
+		return
+	}
+	l.states[key] = state
+}
@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginAttempt is the database row holding the attempt history for a key.
+type LoginAttempt struct {
+	Key         string    `gorm:"column:attempt_key;primaryKey"`
+	Failures    int       `gorm:"not null;default:0"`
+	LastFailure time.Time `gorm:"not null"`
+	LockedUntil time.Time `gorm:"not null"`
+}
+
+// PostgresLimiter keeps attempt history in Postgres so that it is shared across server instances.
+type PostgresLimiter struct {
+	db     *gorm.DB
+	policy Policy
+	clock  Clock
+}
+
+// Constructs a new PostgresLimiter enforcing the given policy, migrating its table if needed.
+func NewPostgresLimiter(db *gorm.DB, policy Policy, clock Clock) (*PostgresLimiter, error) {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+
+	// Migrate the login attempts table to the database.
+	if err := db.AutoMigrate(&LoginAttempt{}); err != nil {
+		return nil, err
+	}
+
+	return &PostgresLimiter{db: db, policy: policy, clock: clock}, nil
+}
+
+func (l *PostgresLimiter) Allow(key string) (Decision, error) {
+	return l.update(key, l.policy.allow)
+}
+
+func (l *PostgresLimiter) Fail(key string) (Decision, error) {
+	return l.update(key, l.policy.fail)
+}
+
+func (l *PostgresLimiter) Confirm(key string) (Decision, error) {
+	return l.update(key, l.policy.confirm)
+}
+
+func (l *PostgresLimiter) Refund(key string) error {
+	_, err := l.update(key, l.policy.refund)
+	return err
+}
+
+func (l *PostgresLimiter) Reset(key string) error {
+	return l.db.Where("attempt_key = ?", key).Delete(&LoginAttempt{}).Error
+}
+
+// Applies a policy transition to the row for the key while holding a row lock,
+// so that concurrent attempts on different server instances are serialised.
+func (l *PostgresLimiter) update(key string, apply func(State, time.Time) (State, Decision)) (Decision, error) {
+	var decision Decision
+
+	err := l.db.Transaction(func(tx *gorm.DB) error {
+		// Make sure the row exists so that it can be locked.
+		row := LoginAttempt{Key: key}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("attempt_key = ?", key).First(&row).Error; err != nil {
+			return err
+		}
+
+		var state State
+		state, decision = apply(State{
+			Failures:    row.Failures,
+			LastFailure: row.LastFailure,
+			LockedUntil: row.LockedUntil,
+		}, l.clock.Now())
+
+		// Drop rows that no longer carry any history.
+		if state.empty() {
+			return tx.Where("attempt_key = ?", key).Delete(&LoginAttempt{}).Error
+		}
+
+		return tx.Model(&LoginAttempt{}).Where("attempt_key = ?", key).Updates(map[string]interface{}{
+			"failures":     state.Failures,
+			"last_failure": state.LastFailure,
+			"locked_until": state.LockedUntil,
+		}).Error
+	})
+	if err != nil {
+		return Decision{}, err
+	}
+	return decision, nil
+}
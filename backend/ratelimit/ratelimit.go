@@ -0,0 +1,209 @@
+package ratelimit
+
+import "time"
+
+// Clock abstracts the current time so that limiters can be tested deterministically.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a Clock backed by the system time.
+type SystemClock struct{}
+
+// Returns the current system time.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Policy describes how failed attempts translate into backoff delays and lockouts.
+type Policy struct {
+	// Number of failures tolerated before any backoff is applied.
+	FreeAttempts int
+	// Delay after the first penalised failure, doubled for every further failure.
+	BaseDelay time.Duration
+	// Upper bound for the backoff delay.
+	MaxDelay time.Duration
+	// Number of failures after which the key is locked out.
+	MaxFailures int
+	// How long a lockout lasts.
+	LockoutDuration time.Duration
+	// Failures older than the window are forgotten.
+	Window time.Duration
+}
+
+// Returns the policy used when none is configured.
+func DefaultPolicy() Policy {
+	return Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		MaxFailures:     10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          15 * time.Minute,
+	}
+}
+
+// State is the attempt history recorded for a single key.
+type State struct {
+	Failures    int
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+// Reports whether the state carries no history at all.
+func (s State) empty() bool {
+	return s.Failures == 0 && s.LockedUntil.IsZero()
+}
+
+// Decision is the outcome of checking or recording an attempt for a key.
+type Decision struct {
+	// Whether an attempt may be made right now.
+	Allowed bool
+	// How long the client has to wait before the next attempt is allowed, counting the allowed attempt as a failure.
+	RetryAfter time.Duration
+	// Whether the key is locked out.
+	Locked bool
+	// Whether this call locked the key out.
+	LockedNow bool
+	// Whether this call cleared an expired lockout.
+	UnlockedNow bool
+}
+
+// Limiter tracks failed attempts per key (e.g. per IP address or per account).
+type Limiter interface {
+	// Reports whether an attempt for the key may proceed and, if it may, counts it as a failed attempt in the same step,
+	// so that concurrent attempts can't all proceed before the first of them fails. Counted attempts only lock the key
+	// out once they are confirmed to have failed, and attempts that succeed are refunded.
+	Allow(key string) (Decision, error)
+	// Records a failed attempt for the key, which wasn't counted by Allow.
+	Fail(key string) (Decision, error)
+	// Confirms that an attempt counted by Allow failed, locking the key out if it has now failed too often.
+	Confirm(key string) (Decision, error)
+	// Takes back an attempt counted by Allow that didn't fail. Lockouts stay in place.
+	Refund(key string) error
+	// Forgets every attempt recorded for the key.
+	Reset(key string) error
+}
+
+// Returns the backoff delay that applies after the given number of failures.
+func (p Policy) delay(failures int) time.Duration {
+	penalised := failures - p.FreeAttempts
+	if penalised <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < penalised; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return delay
+}
+
+// Clears an expired lockout or stale failures from the state.
+// The second return value reports whether a lockout was lifted.
+func (p Policy) expire(state State, now time.Time) (State, bool) {
+	if !state.LockedUntil.IsZero() {
+		if now.Before(state.LockedUntil) {
+			return state, false
+		}
+		return State{}, true
+	}
+	if p.Window > 0 && state.Failures > 0 && now.Sub(state.LastFailure) >= p.Window {
+		return State{}, false
+	}
+	return state, false
+}
+
+// Decides whether an attempt may proceed given the (already expired) state.
+func (p Policy) decide(state State, now time.Time) Decision {
+	if !state.LockedUntil.IsZero() && now.Before(state.LockedUntil) {
+		return Decision{Allowed: false, RetryAfter: state.LockedUntil.Sub(now), Locked: true}
+	}
+
+	// Attempts still in progress have used up the failures allowed, so further ones wait until they are settled.
+	if p.MaxFailures > 0 && state.Failures >= p.MaxFailures {
+		return Decision{Allowed: false, RetryAfter: max(p.delay(state.Failures), time.Second)}
+	}
+
+	next := state.LastFailure.Add(p.delay(state.Failures))
+	if state.Failures > 0 && now.Before(next) {
+		return Decision{Allowed: false, RetryAfter: next.Sub(now)}
+	}
+	return Decision{Allowed: true}
+}
+
+// Checks an attempt against the state and counts it as a failure if it may proceed, without locking the state out
+// until the failure is confirmed. Returns the updated state and the decision.
+func (p Policy) allow(state State, now time.Time) (State, Decision) {
+	state, unlocked := p.expire(state, now)
+	if decision := p.decide(state, now); !decision.Allowed {
+		decision.UnlockedNow = unlocked
+		return state, decision
+	}
+
+	state.Failures++
+	state.LastFailure = now
+	decision := p.decide(state, now)
+	decision.Allowed = true
+	decision.UnlockedNow = unlocked
+	return state, decision
+}
+
+// Records a failed attempt against the state, returning the updated state and the decision.
+func (p Policy) fail(state State, now time.Time) (State, Decision) {
+	state, unlocked := p.expire(state, now)
+
+	// Failures while locked out do not extend the lockout.
+	if !state.LockedUntil.IsZero() {
+		return state, p.decide(state, now)
+	}
+
+	state, lockedNow := p.count(state, now)
+	decision := p.decide(state, now)
+	decision.LockedNow = lockedNow
+	decision.UnlockedNow = unlocked
+	return state, decision
+}
+
+// Confirms a failed attempt counted by allow, returning the updated state and the decision.
+func (p Policy) confirm(state State, now time.Time) (State, Decision) {
+	state, unlocked := p.expire(state, now)
+	state, lockedNow := p.lock(state, now)
+	decision := p.decide(state, now)
+	decision.LockedNow = lockedNow
+	decision.UnlockedNow = unlocked
+	return state, decision
+}
+
+// Takes back an attempt counted as a failure from the state, returning the updated state and the decision.
+func (p Policy) refund(state State, now time.Time) (State, Decision) {
+	state, _ = p.expire(state, now)
+	if state.LockedUntil.IsZero() && state.Failures > 0 {
+		state.Failures--
+	}
+	return state, p.decide(state, now)
+}
+
+// Counts a failure against the state, locking it out once it has failed too often.
+// The second return value reports whether it was locked out.
+func (p Policy) count(state State, now time.Time) (State, bool) {
+	state.Failures++
+	state.LastFailure = now
+	return p.lock(state, now)
+}
+
+// Locks the state out if it has failed too often and isn't locked out already.
+// The second return value reports whether it was locked out.
+func (p Policy) lock(state State, now time.Time) (State, bool) {
+	if state.LockedUntil.IsZero() && p.MaxFailures > 0 && state.Failures >= p.MaxFailures {
+		state.LockedUntil = now.Add(p.LockoutDuration)
+		return state, true
+	}
+	return state, false
+}
@@ -0,0 +1,213 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a manually advanced Clock for deterministic tests.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	policy := Policy{
+		FreeAttempts:    2,
+		BaseDelay:       time.Second,
+		MaxDelay:        4 * time.Second,
+		MaxFailures:     5,
+		LockoutDuration: time.Minute,
+		Window:          10 * time.Minute,
+	}
+
+	t.Run("TestBackoff", func(t *testing.T) {
+		clock := &fakeClock{now: time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)}
+		limiter := NewMemoryLimiter(policy, clock)
+
+		// Free attempts are not penalised.
+		for i := 0; i < policy.FreeAttempts; i++ {
+			decision, err := limiter.Fail("ip:1.2.3.4")
+			assert.NoError(t, err)
+			assert.True(t, decision.Allowed)
+		}
+
+		// The third failure imposes the base delay.
+		decision, err := limiter.Fail("ip:1.2.3.4")
+		assert.NoError(t, err)
+		assert.False(t, decision.Allowed)
+		assert.Equal(t, time.Second, decision.RetryAfter)
+
+		decision, err = limiter.Allow("ip:1.2.3.4")
+		assert.NoError(t, err)
+		assert.False(t, decision.Allowed)
+
+		// Once the delay has passed attempts are allowed again. An allowed attempt counts as a failure, doubling the delay.
+		clock.Advance(time.Second)
+		decision, err = limiter.Allow("ip:1.2.3.4")
+		assert.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, 2*time.Second, decision.RetryAfter)
+
+		decision, err = limiter.Allow("ip:1.2.3.4")
+		assert.NoError(t, err)
+		assert.False(t, decision.Allowed)
+
+		// Unless it is refunded, because it succeeded.
+		assert.NoError(t, limiter.Refund("ip:1.2.3.4"))
+		clock.Advance(time.Second)
+		decision, err = limiter.Allow("ip:1.2.3.4")
+		assert.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		assert.Equal(t, 2*time.Second, decision.RetryAfter)
+
+		// Other keys are unaffected.
+		decision, err = limiter.Allow("ip:5.6.7.8")
+		assert.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	})
+
+	t.Run("TestLockoutAndUnlock", func(t *testing.T) {
+		clock := &fakeClock{now: time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)}
+		limiter := NewMemoryLimiter(policy, clock)
+
+		var decision Decision
+		var err error
+		for i := 0; i < policy.MaxFailures; i++ {
+			decision, err = limiter.Fail("account:foo@bar.com")
+			assert.NoError(t, err)
+			clock.Advance(policy.MaxDelay)
+		}
+		assert.True(t, decision.LockedNow)
+		assert.True(t, decision.Locked)
+
+		// Further failures neither extend the lockout nor report it again.
+		decision, err = limiter.Fail("account:foo@bar.com")
+		assert.NoError(t, err)
+		assert.False(t, decision.LockedNow)
+		assert.True(t, decision.Locked)
+		assert.Equal(t, policy.LockoutDuration-policy.MaxDelay, decision.RetryAfter)
+
+		// The lockout is lifted once it expires.
+		clock.Advance(policy.LockoutDuration)
+		decision, err = limiter.Allow("account:foo@bar.com")
+		assert.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		assert.True(t, decision.UnlockedNow)
+
+		decision, err = limiter.Allow("account:foo@bar.com")
+		assert.NoError(t, err)
+		assert.False(t, decision.UnlockedNow)
+	})
+
+	t.Run("TestLockoutOnConfirm", func(t *testing.T) {
+		clock := &fakeClock{now: time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)}
+		limiter := NewMemoryLimiter(policy, clock)
+
+		for i := 0; i < policy.MaxFailures-1; i++ {
+			_, err := limiter.Fail("account:foo@bar.com")
+			assert.NoError(t, err)
+			clock.Advance(policy.MaxDelay)
+		}
+
+		// The attempt reaching the most failures allowed doesn't lock the key out while it is in progress,
+		// but holds off further attempts until it is settled.
+		decision, err := limiter.Allow("account:foo@bar.com")
+		assert.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		assert.False(t, decision.LockedNow)
+		assert.False(t, decision.Locked)
+
+		clock.Advance(policy.MaxDelay)
+		decision, err = limiter.Allow("account:foo@bar.com")
+		assert.NoError(t, err)
+		assert.False(t, decision.Allowed)
+		assert.False(t, decision.Locked)
+
+		// If it succeeds the key isn't locked out.
+		assert.NoError(t, limiter.Refund("account:foo@bar.com"))
+		decision, err = limiter.Allow("account:foo@bar.com")
+		assert.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		assert.False(t, decision.Locked)
+
+		// If it fails the key is locked out.
+		decision, err = limiter.Confirm("account:foo@bar.com")
+		assert.NoError(t, err)
+		assert.True(t, decision.LockedNow)
+		assert.True(t, decision.Locked)
+		assert.Equal(t, policy.LockoutDuration, decision.RetryAfter)
+
+		decision, err = limiter.Confirm("account:foo@bar.com")
+		assert.NoError(t, err)
+		assert.False(t, decision.LockedNow)
+		assert.True(t, decision.Locked)
+	})
+
+	t.Run("TestWindowAndReset", func(t *testing.T) {
+		clock := &fakeClock{now: time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)}
+		limiter := NewMemoryLimiter(policy, clock)
+
+		for i := 0; i < 4; i++ {
+			_, err := limiter.Fail("account:foo@bar.com")
+			assert.NoError(t, err)
+		}
+
+		// Failures older than the window are forgotten.
+		clock.Advance(policy.Window)
+		decision, err := limiter.Fail("account:foo@bar.com")
+		assert.NoError(t, err)
+		assert.True(t, decision.Allowed)
+
+		for i := 0; i < 4; i++ {
+			_, err := limiter.Fail("account:foo@bar.com")
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, limiter.Reset("account:foo@bar.com"))
+
+		decision, err = limiter.Allow("account:foo@bar.com")
+		assert.NoError(t, err)
+		assert.True(t, decision.Allowed)
+	})
+
+	t.Run("TestConcurrentAttempts", func(t *testing.T) {
+		clock := &fakeClock{now: time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)}
+		limiter := NewMemoryLimiter(policy, clock)
+
+		// Attempts made at once are counted as they are allowed, so only the free ones and the first penalised one proceed.
+		var wg sync.WaitGroup
+		var allowed atomic.Int32
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				decision, err := limiter.Allow("account:foo@bar.com")
+				assert.NoError(t, err)
+				if decision.Allowed {
+					allowed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(policy.FreeAttempts+1), allowed.Load())
+	})
+
+	t.Run("TestDelayIsCapped", func(t *testing.T) {
+		assert.Equal(t, time.Duration(0), policy.delay(2))
+		assert.Equal(t, time.Second, policy.delay(3))
+		assert.Equal(t, 2*time.Second, policy.delay(4))
+		assert.Equal(t, 4*time.Second, policy.delay(5))
+		assert.Equal(t, 4*time.Second, policy.delay(50))
+	})
+}
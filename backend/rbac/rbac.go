@@ -0,0 +1,93 @@
+package rbac
+
+import (
+	"sort"
+	"sync"
+)
+
+// Permission names a single capability that can be granted to a role.
+type Permission string
+
+const (
+	// Read any user's profile, not only one's own.
+	PermissionReadAnyUser Permission = "users:read:any"
+	// Update or delete any user, not only oneself.
+	PermissionWriteAnyUser Permission = "users:write:any"
+	// Read any user's books, not only one's own.
+	PermissionReadAnyBook Permission = "books:read:any"
+	// Update or delete any user's books, not only one's own.
+	PermissionWriteAnyBook Permission = "books:write:any"
+	// Use the admin API to list, search and manage accounts.
+	PermissionManageUsers Permission = "admin:users"
+	// Use the admin API to view system-wide statistics.
+	PermissionViewStats Permission = "admin:stats"
+	// View any user's activity and query the audit log.
+	PermissionViewAuditLog Permission = "admin:audit"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+var (
+	mu              sync.RWMutex
+	rolePermissions = map[string]map[Permission]bool{
+		RoleUser: {},
+		RoleAdmin: {
+			PermissionReadAnyUser:  true,
+			PermissionWriteAnyUser: true,
+			PermissionReadAnyBook:  true,
+			PermissionWriteAnyBook: true,
+			PermissionManageUsers:  true,
+			PermissionViewStats:    true,
+			PermissionViewAuditLog: true,
+		},
+	}
+)
+
+// Registers a role, or grants additional permissions to an existing one.
+func Register(role string, permissions ...Permission) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	granted, ok := rolePermissions[role]
+	if !ok {
+		granted = make(map[Permission]bool)
+		rolePermissions[role] = granted
+	}
+	for _, permission := range permissions {
+		granted[permission] = true
+	}
+}
+
+// Reports whether the role is known.
+func IsRole(role string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Returns the names of all known roles in alphabetical order.
+func Roles() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	roles := make([]string, 0, len(rolePermissions))
+	for role := range rolePermissions {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// Reports whether the role has been granted the permission.
+// Unknown roles have no permissions.
+func Can(role string, permission Permission) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	return rolePermissions[role][permission]
+}
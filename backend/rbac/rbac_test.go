@@ -0,0 +1,32 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoles(t *testing.T) {
+	// Regular users have no elevated permissions.
+	assert.True(t, IsRole(RoleUser))
+	assert.False(t, Can(RoleUser, PermissionReadAnyUser))
+	assert.False(t, Can(RoleUser, PermissionManageUsers))
+
+	// Admins have every permission.
+	assert.True(t, IsRole(RoleAdmin))
+	assert.True(t, Can(RoleAdmin, PermissionReadAnyBook))
+	assert.True(t, Can(RoleAdmin, PermissionManageUsers))
+	assert.True(t, Can(RoleAdmin, PermissionViewStats))
+	assert.True(t, Can(RoleAdmin, PermissionViewAuditLog))
+
+	// Unknown roles have no permissions.
+	assert.False(t, IsRole("librarian"))
+	assert.False(t, Can("librarian", PermissionReadAnyBook))
+
+	// New roles can be registered.
+	Register("librarian", PermissionReadAnyBook)
+	assert.True(t, IsRole("librarian"))
+	assert.True(t, Can("librarian", PermissionReadAnyBook))
+	assert.False(t, Can("librarian", PermissionWriteAnyBook))
+	assert.Equal(t, []string{"admin", "librarian", "user"}, Roles())
+}
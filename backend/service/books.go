@@ -0,0 +1,146 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+)
+
+// Gets a book from the storage, such as Storage.GetBook.
+type BookGetter func(store storage.Storage, id int) (*types.Book, error)
+
+// Loads the book with the getter and checks that the user may act on it.
+// Owners may always act on their books; acting on anyone else's requires the permission.
+// Returns the problem if the book doesn't exist or the user may not act on it.
+func AuthorizeBook(store storage.Storage, get BookGetter, currentUser *types.User, bookID int, action string, permission rbac.Permission) (*types.Book, *problem.Error) {
+	// Fetch the book from the database.
+	fetchedBook, err := get(store, bookID)
+	if err != nil {
+		return nil, problem.Internal("failed to get book", err)
+	}
+
+	// There is no book with the requested id.
+	if fetchedBook == nil {
+		return nil, problem.New(http.StatusNotFound, problem.CodeNotFound, "book not found")
+	}
+
+	// Check that the client is authorized to act on the fetched book.
+	if fetchedBook.OwnerID != currentUser.ID && !rbac.Can(currentUser.Role, permission) {
+		return nil, problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "you cannot "+action+" this book")
+	}
+	return fetchedBook, nil
+}
+
+// Returns the problems with the page counts of the book, if any.
+func ValidatePages(book *types.Book) []problem.FieldError {
+	var fields []problem.FieldError
+	if book.PagesCount <= 0 {
+		fields = append(fields, problem.FieldError{Field: "pages_count", Code: "min", Message: "must be at least 1"})
+	}
+	if book.PagesRead < 0 || book.PagesRead > book.PagesCount {
+		fields = append(fields, problem.FieldError{Field: "pages_read", Code: "range", Message: "must be between 0 and pages_count"})
+	}
+	return fields
+}
+
+// Loads the book with the id, which isn't in the trash, and checks that the actor may act on it, see AuthorizeBook.
+func (s *Service) GetBook(ctx context.Context, actor Actor, bookID int, action string, permission rbac.Permission) (*types.Book, *problem.Error) {
+	if actor.User == nil {
+		return nil, unauthenticated()
+	}
+	return AuthorizeBook(s.storage(ctx), storage.Storage.GetBook, actor.User, bookID, action, permission)
+}
+
+// Returns the books of the actor.
+func (s *Service) ListBooks(ctx context.Context, actor Actor) (*[]types.Book, *problem.Error) {
+	if actor.User == nil {
+		return nil, unauthenticated()
+	}
+
+	books, err := s.storage(ctx).GetBooks(actor.User.ID)
+	if err != nil {
+		return nil, problem.Internal("failed to fetch books", err)
+	}
+	return books, nil
+}
+
+// Creates the book, owned by the actor.
+func (s *Service) CreateBook(ctx context.Context, actor Actor, newBook *types.Book) (*types.Book, *problem.Error) {
+	if actor.User == nil {
+		return nil, unauthenticated()
+	}
+
+	newBook.OwnerID = actor.User.ID
+
+	if fields := ValidatePages(newBook); len(fields) > 0 {
+		return nil, problem.Validation(fields...)
+	}
+	if err := newBook.ValidateBook(); err != nil {
+		return nil, ValidationProblem(err)
+	}
+
+	// Create the book in the database.
+	createdBook, err := s.storage(ctx).CreateBook(newBook)
+	if err != nil {
+		return nil, StorageProblem(err, "failed to create book")
+	}
+
+	s.Record(actor, audit.Event{
+		Action:    "book.create",
+		SubjectID: createdBook.OwnerID,
+		Target:    BookTarget(createdBook),
+		Details:   audit.Diff(types.Book{}, createdBook, AuditDiffIgnoredFields...),
+	})
+	s.PublishBook(ctx, events.BookCreated, createdBook)
+	return createdBook, nil
+}
+
+// Saves the patched book in place of the book.
+func (s *Service) UpdateBook(ctx context.Context, actor Actor, book *types.Book, patchedBook types.Book) (*types.Book, *problem.Error) {
+	previousBook := *book
+
+	if err := patchedBook.ValidateBook(); err != nil {
+		return nil, ValidationProblem(err)
+	}
+
+	// Update the updated_at time stamp.
+	patchedBook.UpdatedAt = time.Now()
+
+	// Update the patched book in the database.
+	updatedBook, err := s.storage(ctx).UpdateBook(&patchedBook)
+	if err != nil {
+		return nil, StorageProblem(err, "failed to update book")
+	}
+
+	s.Record(actor, audit.Event{
+		Action:    "book.update",
+		SubjectID: updatedBook.OwnerID,
+		Target:    BookTarget(updatedBook),
+		Details:   audit.Diff(previousBook, updatedBook, AuditDiffIgnoredFields...),
+	})
+	s.PublishBookUpdate(ctx, &previousBook, updatedBook)
+	return updatedBook, nil
+}
+
+// Moves the book to the trash, it is purged once the trash retention is over.
+func (s *Service) DeleteBook(ctx context.Context, actor Actor, book *types.Book) *problem.Error {
+	if err := s.storage(ctx).DeleteBook(book); err != nil {
+		return StorageProblem(err, "failed to delete book")
+	}
+
+	s.Record(actor, audit.Event{
+		Action:    "book.delete",
+		SubjectID: book.OwnerID,
+		Target:    BookTarget(book),
+		Details:   map[string]interface{}{"title": book.Title},
+	})
+	s.PublishBook(ctx, events.BookDeleted, book)
+	return nil
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/declanl482/go-book-tracker-app/backend/webhooks"
+)
+
+// Publishes the change to the streams of the user it belongs to, and queues it for their webhooks.
+// Like audit logging, publishing never fails the operation.
+func (s *Service) Publish(ctx context.Context, eventType string, userID int, data interface{}) {
+	// Publish even if the client has gone away, the change is saved either way.
+	ctx = context.WithoutCancel(ctx)
+
+	event, err := events.New(eventType, userID, data)
+	if err != nil {
+		s.Logger.ErrorContext(ctx, "failed to encode event", "type", eventType, "error", err)
+		return
+	}
+	s.afterCommit(func() {
+		if err := s.Events.Publish(ctx, event); err != nil {
+			s.Logger.ErrorContext(ctx, "failed to publish event", "type", eventType, "error", err)
+		}
+	})
+	s.enqueueWebhooks(ctx, event)
+}
+
+// Publishes the change to the book to its owner's streams.
+func (s *Service) PublishBook(ctx context.Context, eventType string, book *types.Book) {
+	s.Publish(ctx, eventType, book.OwnerID, book)
+}
+
+// Publishes the change to the user to their own streams, without their password.
+func (s *Service) PublishUser(ctx context.Context, eventType string, user *types.User) {
+	s.Publish(ctx, eventType, user.ID, WithoutSecrets(*user))
+}
+
+// Publishes the update to the book, and notifies webhooks of the reading progress it made.
+func (s *Service) PublishBookUpdate(ctx context.Context, previousBook *types.Book, book *types.Book) {
+	s.PublishBook(ctx, events.BookUpdated, book)
+
+	if book.PagesRead <= previousBook.PagesRead {
+		return
+	}
+	progress := []string{events.BookProgressed}
+	if book.PagesRead >= book.PagesCount && previousBook.PagesRead < book.PagesCount {
+		progress = append(progress, events.BookFinished)
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	for _, eventType := range progress {
+		event, err := events.New(eventType, book.OwnerID, book)
+		if err != nil {
+			s.Logger.ErrorContext(ctx, "failed to encode event", "type", eventType, "error", err)
+			continue
+		}
+		s.enqueueWebhooks(ctx, event)
+	}
+}
+
+// Queues the event for the webhooks of its user. In a transaction the webhook store can take part in, the deliveries
+// are saved as part of it, so that they are queued if and only if the change is, and failing to queue them rolls it back.
+// Otherwise they are queued once the change is saved and, like publishing, queueing never fails the operation.
+func (s *Service) enqueueWebhooks(ctx context.Context, event events.Event) {
+	if store := s.transactionWebhookStore(); store != nil {
+		if _, err := s.Webhooks.EnqueueIn(store, event); err != nil && s.transaction.err == nil {
+			s.transaction.err = fmt.Errorf("failed to queue webhook deliveries: %w", err)
+		}
+		return
+	}
+
+	s.afterCommit(func() {
+		if _, err := s.Webhooks.Enqueue(event); err != nil {
+			s.Logger.ErrorContext(ctx, "failed to queue webhook deliveries", "type", event.Type, "event_id", event.ID, "error", err)
+		}
+	})
+}
+
+// Returns the webhook store taking part in the transaction the service runs in,
+// or nil if it doesn't run in one or the store can't take part in it.
+func (s *Service) transactionWebhookStore() webhooks.Store {
+	if s.transaction == nil {
+		return nil
+	}
+	db := storage.DB(s.Storer)
+	databaseStore, ok := s.Webhooks.Store.(webhooks.DatabaseStore)
+	if db == nil || !ok {
+		return nil
+	}
+	return databaseStore.WithDB(db)
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/passwords"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+)
+
+// Checks a newly chosen password against the password policy, returning the reason if it is not allowed.
+func (s *Service) ValidatePassword(password string, username string, email string) *problem.Error {
+	err := s.PasswordPolicy.Validate(password, username, email)
+	if err == nil {
+		return nil
+	}
+
+	var violation *passwords.Violation
+	if errors.As(err, &violation) {
+		return &problem.Error{
+			Status: http.StatusBadRequest,
+			Code:   problem.CodeWeakPassword,
+			Detail: violation.Message,
+			Fields: []problem.FieldError{{Field: "password", Code: "policy", Message: violation.Message}},
+		}
+	}
+	return problem.Internal("failed to check password", err)
+}
+
+// Hashes the password with the configured hasher, recording how long it took.
+func (s *Service) HashPassword(password string) (string, error) {
+	start := time.Now()
+	defer func() { s.Metrics.ObservePasswordHashing("hash", time.Since(start)) }()
+	return s.PasswordHasher.Hash(password)
+}
+
+// Checks the password against the stored hash, recording how long it took.
+func (s *Service) VerifyPassword(hashedPassword string, password string) error {
+	start := time.Now()
+	defer func() { s.Metrics.ObservePasswordHashing("verify", time.Since(start)) }()
+	return s.PasswordHasher.Verify(hashedPassword, password)
+}
+
+// Replaces the user's password hash with one made with the current hashing parameters, while the password is at hand.
+// Failures are logged rather than failing the login, the old hash keeps working and the upgrade is retried on the next one.
+func (s *Service) RehashPassword(ctx context.Context, actor Actor, user *types.User, password string) {
+	hashedPassword, err := s.HashPassword(password)
+	if err != nil {
+		s.Logger.ErrorContext(ctx, "failed to rehash password", "user_id", user.ID, "error", err)
+		return
+	}
+
+	// Neither the password nor anything else clients can see changes, so the change isn't published.
+	if err := s.storage(ctx).RehashPassword(user, hashedPassword); err != nil {
+		s.Logger.ErrorContext(ctx, "failed to store rehashed password", "user_id", user.ID, "error", err)
+		return
+	}
+
+	s.Record(actor, audit.Event{
+		Action:    "user.password_rehash",
+		ActorID:   user.ID,
+		SubjectID: user.ID,
+		Target:    UserTarget(user),
+	})
+}
+
+// Checks a newly chosen password against the policy and hashes it.
+func (s *Service) newPasswordHash(password string, username string, email string) (string, *problem.Error) {
+	if problemErr := s.ValidatePassword(password, username, email); problemErr != nil {
+		return "", problemErr
+	}
+
+	hashedPassword, err := s.HashPassword(password)
+	if err != nil {
+		return "", problem.Internal("failed to hash password", err)
+	}
+	return hashedPassword, nil
+}
+
+// Revokes the user's active sessions so that their access tokens are rejected.
+// Failures are logged rather than failing the operation.
+func (s *Service) RevokeSessions(ctx context.Context, user *types.User) {
+	sessions, err := s.storage(ctx).GetSessions(user.ID)
+	if err != nil {
+		s.Logger.ErrorContext(ctx, "failed to fetch sessions to revoke", "user_id", user.ID, "error", err)
+		return
+	}
+
+	now := time.Now()
+	for i := range *sessions {
+		session := &(*sessions)[i]
+		if !session.IsActive(now) {
+			continue
+		}
+		if err := s.storage(ctx).RevokeSession(session); err != nil {
+			s.Logger.ErrorContext(ctx, "failed to revoke session", "user_id", user.ID, "session_id", session.ID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package service implements the operations on users and books shared by the REST and gRPC APIs:
+// the authorization checks, validation, storage calls, audit events and published changes.
+// Failures are returned as problems, which each API renders in its own way.
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/metrics"
+	"github.com/declanl482/go-book-tracker-app/backend/passwords"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/declanl482/go-book-tracker-app/backend/webhooks"
+)
+
+type Service struct {
+	Storer  storage.Storage
+	Logger  *slog.Logger
+	Auditor audit.Logger
+	// Collectors of the password hashing latencies.
+	Metrics *metrics.Metrics
+	// Rules for new passwords and how they are hashed.
+	PasswordPolicy passwords.Policy
+	PasswordHasher passwords.Hasher
+	// Where changes to books and users are published, to be streamed on /events.
+	Events *events.Broker
+	// Delivers the events to the webhook endpoints users registered.
+	Webhooks *webhooks.Dispatcher
+	// How long deleted accounts can be restored by logging in before they are purged.
+	AccountDeletionGracePeriod time.Duration
+
+	// The transaction the operations are part of, nil outside of InTransaction.
+	transaction *transaction
+}
+
+// transaction holds back the effects of the operations run in it until it commits.
+type transaction struct {
+	// The audit events and changes of the operations, recorded and published once the transaction commits.
+	pending []func()
+	// Why the webhook deliveries of a change couldn't be queued as part of the transaction, which rolls it back.
+	err error
+}
+
+// Actor is the user an operation is performed by, and where their request came from.
+type Actor struct {
+	// The authenticated user, nil for anonymous requests such as registration.
+	User      *types.User
+	IP        string
+	UserAgent string
+	RequestID string
+}
+
+// Fields left out of audit diffs because they change on every update or are audited separately.
+var AuditDiffIgnoredFields = []string{"updated_at", "books"}
+
+// Returns the storage bound to the context, so that its calls are traced and cancelled with it.
+func (s *Service) storage(ctx context.Context) storage.Storage {
+	return storage.WithContext(s.Storer, ctx)
+}
+
+// Runs fn with a copy of the service whose operations are part of one transaction of the storage,
+// committed if fn returns nil and rolled back otherwise, see storage.Transaction.
+// The audit events and changes of the operations are only recorded and published once the transaction commits.
+// Their webhook deliveries are queued as part of it if the webhook store can take part, see enqueueWebhooks.
+func (s *Service) InTransaction(ctx context.Context, fn func(tx *Service) error) error {
+	tx := *s
+	tx.transaction = &transaction{}
+
+	err := storage.Transaction(s.storage(ctx), func(txStorage storage.Storage) error {
+		tx.Storer = txStorage
+		if err := fn(&tx); err != nil {
+			return err
+		}
+		return tx.transaction.err
+	})
+	if err != nil {
+		return err
+	}
+
+	// The changes are saved, record and publish them.
+	pending := tx.transaction.pending
+	tx.transaction = nil
+	for _, effect := range pending {
+		effect()
+	}
+	return nil
+}
+
+// Runs the effect of an operation now, or once the transaction the operation is part of commits.
+func (s *Service) afterCommit(effect func()) {
+	if s.transaction != nil {
+		s.transaction.pending = append(s.transaction.pending, effect)
+		return
+	}
+	effect()
+}
+
+// Records an audit event, filling in the actor and request details.
+// Audit logging never fails the operation.
+func (s *Service) Record(actor Actor, event audit.Event) {
+	if event.ActorID == 0 && actor.User != nil {
+		event.ActorID = actor.User.ID
+	}
+
+	event.IP = actor.IP
+	event.UserAgent = actor.UserAgent
+	event.RequestID = actor.RequestID
+	s.afterCommit(func() { s.Auditor.Log(event) })
+}
+
+// Returns the audit log target naming the user.
+func UserTarget(user *types.User) string {
+	return "user:" + strconv.Itoa(user.ID)
+}
+
+// Returns the audit log target naming the book.
+func BookTarget(book *types.Book) string {
+	return "book:" + strconv.Itoa(book.ID)
+}
+
+// Returns a copy of the user without their password hash and books, safe to show to administrators and in events.
+func WithoutSecrets(user types.User) types.User {
+	user.Password = ""
+	user.Books = nil
+	return user
+}
+
+// Returns the problem answering an operation that requires an authenticated user.
+func unauthenticated() *problem.Error {
+	return problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "unauthorized")
+}
+
+// Translates an error returned by the storage into its problem.
+// Errors other than the storage's domain errors are hidden from the client behind the detail.
+func StorageProblem(err error, detail string) *problem.Error {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return &problem.Error{Status: http.StatusNotFound, Code: problem.CodeNotFound, Detail: "record not found", Err: err}
+	case errors.Is(err, storage.ErrConflict):
+		return &problem.Error{Status: http.StatusConflict, Code: problem.CodeConflict, Detail: "record conflicts with an existing one", Err: err}
+	case errors.Is(err, storage.ErrStale):
+		return &problem.Error{Status: http.StatusPreconditionFailed, Code: problem.CodePreconditionFailed, Detail: "record was changed by another request", Err: err}
+	}
+	return problem.Internal(detail, err)
+}
+
+// Returns the validation problem for the error of ValidateBook or ValidateUser.
+func ValidationProblem(err error) *problem.Error {
+	var validationErr *types.ValidationError
+	if errors.As(err, &validationErr) {
+		return problem.Validation(problem.FieldError{Field: validationErr.Field, Code: validationErr.Code, Message: validationErr.Message})
+	}
+	return &problem.Error{Status: http.StatusBadRequest, Code: problem.CodeValidationFailed, Detail: "request validation failed", Err: err}
+}
+
+// Returns the problem of the email being in use by another account.
+func emailTaken(err error) *problem.Error {
+	return &problem.Error{Status: http.StatusBadRequest, Code: problem.CodeEmailTaken, Detail: "email is taken", Err: err}
+}
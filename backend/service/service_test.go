@@ -0,0 +1,289 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/metrics"
+	"github.com/declanl482/go-book-tracker-app/backend/passwords"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/declanl482/go-book-tracker-app/backend/webhooks"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type recordingAuditor struct {
+	events []audit.Event
+}
+
+func (a *recordingAuditor) Log(event audit.Event) {
+	a.events = append(a.events, event)
+}
+
+func newTestService() (*Service, *recordingAuditor) {
+	auditor := &recordingAuditor{}
+	return &Service{
+		Storer:         storage.NewMemoryStorage(),
+		Logger:         slog.Default(),
+		Auditor:        auditor,
+		Metrics:        metrics.New(),
+		PasswordPolicy: passwords.DefaultPolicy(),
+		PasswordHasher: passwords.Hasher{Cost: bcrypt.MinCost},
+		Events:         events.NewBroker(10),
+		Webhooks:       webhooks.NewDispatcher(webhooks.NewMemoryStore()),
+	}, auditor
+}
+
+func TestUsers(t *testing.T) {
+	s, auditor := newTestService()
+	ctx := context.Background()
+
+	// Registration ignores the role and hashes the password.
+	foo, problemErr := s.RegisterUser(ctx, Actor{IP: "127.0.0.1"}, &types.User{
+		Username: "foo", Email: "foo@example.com", Password: "correct horse battery staple", Role: rbac.RoleAdmin,
+	})
+	assert.Nil(t, problemErr)
+	assert.Equal(t, rbac.RoleUser, foo.Role)
+	assert.NoError(t, s.VerifyPassword(foo.Password, "correct horse battery staple"))
+	assert.Equal(t, foo.ID, auditor.events[0].ActorID)
+	assert.Equal(t, "127.0.0.1", auditor.events[0].IP)
+
+	_, problemErr = s.RegisterUser(ctx, Actor{}, &types.User{
+		Username: "foo", Email: "foo@example.com", Password: "correct horse battery staple",
+	})
+	assert.Equal(t, problem.CodeEmailTaken, problemErr.Code)
+
+	bar, problemErr := s.RegisterUser(ctx, Actor{}, &types.User{
+		Username: "bar", Email: "bar@example.com", Password: "correct horse battery staple",
+	})
+	assert.Nil(t, problemErr)
+
+	// Users may only act on others with the permission.
+	_, problemErr = s.AuthorizeUser(ctx, Actor{User: bar}, foo.ID, "view", rbac.PermissionReadAnyUser)
+	assert.Equal(t, http.StatusUnauthorized, problemErr.Status)
+	_, problemErr = s.AuthorizeUser(ctx, Actor{}, foo.ID, "view", rbac.PermissionReadAnyUser)
+	assert.Equal(t, http.StatusUnauthorized, problemErr.Status)
+	_, problemErr = s.AuthorizeUser(ctx, Actor{User: foo}, 1000, "view", rbac.PermissionReadAnyUser)
+	assert.Equal(t, http.StatusNotFound, problemErr.Status)
+	admin := &types.User{ID: 1000, Role: rbac.RoleAdmin}
+	fetched, problemErr := s.AuthorizeUser(ctx, Actor{User: admin}, foo.ID, "view", rbac.PermissionReadAnyUser)
+	assert.Nil(t, problemErr)
+	assert.Equal(t, "foo", fetched.Username)
+
+	// A new password is checked against the policy before it is hashed.
+	patched := *foo
+	patched.Password = "foo"
+	_, problemErr = s.UpdateUser(ctx, Actor{User: foo}, foo, patched)
+	assert.Equal(t, http.StatusBadRequest, problemErr.Status)
+
+	patched.Password = "another correct horse battery staple"
+	updated, problemErr := s.UpdateUser(ctx, Actor{User: foo}, foo, patched)
+	assert.Nil(t, problemErr)
+	assert.NoError(t, s.VerifyPassword(updated.Password, "another correct horse battery staple"))
+
+	actions := []string{}
+	for _, event := range auditor.events {
+		actions = append(actions, event.Action)
+	}
+	assert.Equal(t, []string{"user.register", "user.register", "user.update", "user.password_change"}, actions)
+}
+
+func TestBooks(t *testing.T) {
+	s, auditor := newTestService()
+	ctx := context.Background()
+	foo := &types.User{ID: 1, Role: rbac.RoleUser}
+	bar := &types.User{ID: 2, Role: rbac.RoleUser}
+
+	subscription, _, _, _ := s.Events.Subscribe(foo.ID, "")
+	defer s.Events.Unsubscribe(subscription)
+
+	// Books are always created for the actor.
+	book, problemErr := s.CreateBook(ctx, Actor{User: foo}, &types.Book{Title: "Foo", Author: "Bar", PagesCount: 100, OwnerID: bar.ID})
+	assert.Nil(t, problemErr)
+	assert.Equal(t, foo.ID, book.OwnerID)
+	assert.Equal(t, "book.create", auditor.events[0].Action)
+	assert.Equal(t, BookTarget(book), auditor.events[0].Target)
+
+	select {
+	case event := <-subscription.C:
+		assert.Equal(t, events.BookCreated, event.Type)
+	case <-time.After(time.Second):
+		t.Fatal("book creation was not published")
+	}
+
+	_, problemErr = s.CreateBook(ctx, Actor{User: foo}, &types.Book{Title: "Foo", Author: "Bar", PagesCount: 10, PagesRead: 11})
+	assert.Equal(t, http.StatusBadRequest, problemErr.Status)
+	assert.Equal(t, []problem.FieldError{{Field: "pages_read", Code: "range", Message: "must be between 0 and pages_count"}}, problemErr.Fields)
+
+	_, problemErr = s.GetBook(ctx, Actor{User: bar}, book.ID, "view", rbac.PermissionReadAnyBook)
+	assert.Equal(t, http.StatusUnauthorized, problemErr.Status)
+
+	fetched, problemErr := s.GetBook(ctx, Actor{User: foo}, book.ID, "update", rbac.PermissionWriteAnyBook)
+	assert.Nil(t, problemErr)
+	patched := *fetched
+	patched.PagesRead = 50
+	updated, problemErr := s.UpdateBook(ctx, Actor{User: foo}, fetched, patched)
+	assert.Nil(t, problemErr)
+	assert.Equal(t, 50, updated.PagesRead)
+	assert.Equal(t, audit.Change{From: float64(0), To: float64(50)}, auditor.events[1].Details["pages_read"])
+
+	assert.Nil(t, s.DeleteBook(ctx, Actor{User: foo}, updated))
+	books, problemErr := s.ListBooks(ctx, Actor{User: foo})
+	assert.Nil(t, problemErr)
+	assert.Empty(t, *books)
+}
+
+func TestInTransaction(t *testing.T) {
+	s, auditor := newTestService()
+	ctx := context.Background()
+	foo := &types.User{ID: 1, Role: rbac.RoleUser}
+
+	subscription, _, _, _ := s.Events.Subscribe(foo.ID, "")
+	defer s.Events.Unsubscribe(subscription)
+
+	// Rolled back operations are neither saved, audited nor published.
+	err := s.InTransaction(ctx, func(tx *Service) error {
+		_, problemErr := tx.CreateBook(ctx, Actor{User: foo}, &types.Book{Title: "Foo", Author: "Bar", PagesCount: 100})
+		assert.Nil(t, problemErr)
+		_, problemErr = tx.CreateBook(ctx, Actor{User: foo}, &types.Book{Title: "Foo", Author: "Bar", PagesCount: 10, PagesRead: 11})
+		return problemErr
+	})
+	assert.Error(t, err)
+	books, _ := s.ListBooks(ctx, Actor{User: foo})
+	assert.Empty(t, *books)
+	assert.Empty(t, auditor.events)
+	assert.Empty(t, subscription.C)
+
+	// Committed operations are audited and published once the transaction commits.
+	err = s.InTransaction(ctx, func(tx *Service) error {
+		book, problemErr := tx.CreateBook(ctx, Actor{User: foo}, &types.Book{Title: "Foo", Author: "Bar", PagesCount: 100})
+		assert.Nil(t, problemErr)
+		assert.Empty(t, auditor.events)
+
+		patched := *book
+		patched.PagesRead = 100
+		_, problemErr = tx.UpdateBook(ctx, Actor{User: foo}, book, patched)
+		assert.Nil(t, problemErr)
+		return nil
+	})
+	assert.NoError(t, err)
+	books, _ = s.ListBooks(ctx, Actor{User: foo})
+	assert.Len(t, *books, 1)
+	assert.Len(t, auditor.events, 2)
+	assert.Equal(t, events.BookCreated, (<-subscription.C).Type)
+	assert.Equal(t, events.BookUpdated, (<-subscription.C).Type)
+
+	// Including the webhook deliveries of the progress made.
+	endpoint := webhooks.Endpoint{UserID: foo.ID, URL: "https://example.com", Secret: "secret", EventTypes: []string{events.BookFinished}}
+	assert.NoError(t, s.Webhooks.Store.CreateEndpoint(&endpoint))
+	err = s.InTransaction(ctx, func(tx *Service) error {
+		book, problemErr := tx.CreateBook(ctx, Actor{User: foo}, &types.Book{Title: "Fizz", Author: "Buzz", PagesCount: 10})
+		assert.Nil(t, problemErr)
+		patched := *book
+		patched.PagesRead = 10
+		_, problemErr = tx.UpdateBook(ctx, Actor{User: foo}, book, patched)
+		assert.Nil(t, problemErr)
+
+		_, total, err := s.Webhooks.Store.ListDeliveries(endpoint.ID, 0, 10)
+		assert.NoError(t, err)
+		assert.Zero(t, total)
+		return nil
+	})
+	assert.NoError(t, err)
+	_, total, err := s.Webhooks.Store.ListDeliveries(endpoint.ID, 0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+}
+
+// databaseStorage is a memory storage posing as one run on a database, whose transactions run on their own handle.
+type databaseStorage struct {
+	*storage.MemoryStorage
+	db   *gorm.DB
+	txDB *gorm.DB
+}
+
+func (s *databaseStorage) DB() *gorm.DB {
+	return s.db
+}
+
+func (s *databaseStorage) Transaction(fn func(tx storage.Storage) error) error {
+	return s.MemoryStorage.Transaction(func(tx storage.Storage) error {
+		return fn(&databaseStorage{MemoryStorage: tx.(*storage.MemoryStorage), db: s.txDB})
+	})
+}
+
+// databaseWebhookStore is a memory webhook store posing as one kept in a database,
+// which records the handles it saved deliveries with and fails to save them if told to.
+type databaseWebhookStore struct {
+	*webhooks.MemoryStore
+	db    *gorm.DB
+	saved *[]*gorm.DB
+	err   *error
+}
+
+func (s *databaseWebhookStore) WithDB(db *gorm.DB) webhooks.Store {
+	return &databaseWebhookStore{MemoryStore: s.MemoryStore, db: db, saved: s.saved, err: s.err}
+}
+
+func (s *databaseWebhookStore) CreateDeliveries(deliveries []webhooks.Delivery) error {
+	if *s.err != nil {
+		return *s.err
+	}
+	*s.saved = append(*s.saved, s.db)
+	return s.MemoryStore.CreateDeliveries(deliveries)
+}
+
+func TestInTransactionQueuesWebhooks(t *testing.T) {
+	s, _ := newTestService()
+	ctx := context.Background()
+	foo := &types.User{ID: 1, Role: rbac.RoleUser}
+
+	txDB := &gorm.DB{}
+	s.Storer = &databaseStorage{MemoryStorage: storage.NewMemoryStorage(), db: &gorm.DB{}, txDB: txDB}
+	var saved []*gorm.DB
+	var saveErr error
+	store := &databaseWebhookStore{MemoryStore: webhooks.NewMemoryStore(), saved: &saved, err: &saveErr}
+	s.Webhooks = webhooks.NewDispatcher(store)
+
+	endpoint := webhooks.Endpoint{UserID: foo.ID, URL: "https://example.com", Secret: "secret", EventTypes: []string{events.BookCreated}}
+	assert.NoError(t, store.CreateEndpoint(&endpoint))
+	subscription, _, _, _ := s.Events.Subscribe(foo.ID, "")
+	defer s.Events.Unsubscribe(subscription)
+
+	// The deliveries are saved as part of the transaction, while the change is only published once it commits.
+	err := s.InTransaction(ctx, func(tx *Service) error {
+		_, problemErr := tx.CreateBook(ctx, Actor{User: foo}, &types.Book{Title: "Foo", Author: "Bar", PagesCount: 100})
+		assert.Nil(t, problemErr)
+
+		_, total, err := store.ListDeliveries(endpoint.ID, 0, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), total)
+		assert.Empty(t, subscription.C)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []*gorm.DB{txDB}, saved)
+	assert.Equal(t, events.BookCreated, (<-subscription.C).Type)
+
+	// A change whose deliveries can't be saved is rolled back.
+	saveErr = errors.New("connection reset")
+	err = s.InTransaction(ctx, func(tx *Service) error {
+		_, problemErr := tx.CreateBook(ctx, Actor{User: foo}, &types.Book{Title: "Fizz", Author: "Buzz", PagesCount: 10})
+		assert.Nil(t, problemErr)
+		return nil
+	})
+	assert.ErrorIs(t, err, saveErr)
+	books, _ := s.ListBooks(ctx, Actor{User: foo})
+	assert.Len(t, *books, 1)
+	assert.Empty(t, subscription.C)
+}
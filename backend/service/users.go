@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/audit"
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/problem"
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/storage"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+)
+
+// Loads the user with the id and checks that the actor may act on them.
+// Users may always act on themselves; acting on anyone else requires the permission.
+func (s *Service) AuthorizeUser(ctx context.Context, actor Actor, userID int, action string, permission rbac.Permission) (*types.User, *problem.Error) {
+	if actor.User == nil {
+		return nil, unauthenticated()
+	}
+
+	// Fetch the user from the database.
+	fetchedUser, err := s.storage(ctx).GetUser(userID)
+	if err != nil {
+		return nil, problem.Internal("failed to get user", err)
+	}
+
+	// There is no user with the requested id.
+	if fetchedUser == nil {
+		return nil, problem.New(http.StatusNotFound, problem.CodeNotFound, "user not found")
+	}
+
+	// Check that the actor is authorized to act on the fetched user.
+	if fetchedUser.ID != actor.User.ID && !rbac.Can(actor.User.Role, permission) {
+		return nil, problem.New(http.StatusUnauthorized, problem.CodeUnauthenticated, "you cannot "+action+" this user")
+	}
+	return fetchedUser, nil
+}
+
+// Creates the account of a new user, who always starts as an enabled regular user.
+func (s *Service) RegisterUser(ctx context.Context, actor Actor, newUser *types.User) (*types.User, *problem.Error) {
+	if err := newUser.ValidateUser(); err != nil {
+		return nil, ValidationProblem(err)
+	}
+
+	// Check if the new user's email is already in use.
+	emailIsTaken, err := s.storage(ctx).IsEmailTaken(newUser.Email)
+	if err != nil {
+		return nil, problem.Internal("failed to check email", err)
+	}
+	if emailIsTaken {
+		return nil, emailTaken(nil)
+	}
+
+	newUser.Role = rbac.RoleUser
+	newUser.Disabled = false
+	newUser.PasswordResetRequired = false
+
+	// Check the new user's password against the password policy and hash it.
+	hashedPassword, problemErr := s.newPasswordHash(newUser.Password, newUser.Username, newUser.Email)
+	if problemErr != nil {
+		return nil, problemErr
+	}
+	newUser.Password = hashedPassword
+
+	// Create the new user in the database.
+	createdUser, err := s.storage(ctx).CreateUser(newUser)
+	if errors.Is(err, storage.ErrConflict) {
+		// The email was taken since it was checked.
+		return nil, emailTaken(err)
+	}
+	if err != nil {
+		return nil, StorageProblem(err, "failed to create user")
+	}
+
+	s.Record(actor, audit.Event{
+		Action:    "user.register",
+		ActorID:   createdUser.ID,
+		SubjectID: createdUser.ID,
+		Target:    UserTarget(createdUser),
+	})
+	return createdUser, nil
+}
+
+// Saves the patched user in place of the user, hashing the password if it was changed.
+func (s *Service) UpdateUser(ctx context.Context, actor Actor, user *types.User, patchedUser types.User) (*types.User, *problem.Error) {
+	previousUser := *user
+
+	if err := patchedUser.ValidateUser(); err != nil {
+		return nil, ValidationProblem(err)
+	}
+
+	if patchedUser.Password != previousUser.Password {
+		// Password is newly updated, check it against the policy and hash it.
+		hashedPassword, problemErr := s.newPasswordHash(patchedUser.Password, patchedUser.Username, patchedUser.Email)
+		if problemErr != nil {
+			return nil, problemErr
+		}
+		patchedUser.Password = hashedPassword
+
+		// A newly chosen password completes any reset forced by an administrator.
+		patchedUser.PasswordResetRequired = false
+	}
+
+	// Update the updated_at time stamp.
+	patchedUser.UpdatedAt = time.Now()
+
+	// Update the patched user in the database.
+	updatedUser, err := s.storage(ctx).UpdateUser(&patchedUser)
+	if errors.Is(err, storage.ErrConflict) {
+		return nil, emailTaken(err)
+	}
+	if err != nil {
+		return nil, StorageProblem(err, "failed to update user")
+	}
+
+	s.Record(actor, audit.Event{
+		Action:    "user.update",
+		SubjectID: updatedUser.ID,
+		Target:    UserTarget(updatedUser),
+		Details:   audit.Diff(previousUser, updatedUser, AuditDiffIgnoredFields...),
+	})
+	if updatedUser.Password != previousUser.Password {
+		s.Record(actor, audit.Event{
+			Action:    "user.password_change",
+			SubjectID: updatedUser.ID,
+			Target:    UserTarget(updatedUser),
+		})
+	}
+	s.PublishUser(ctx, events.UserUpdated, updatedUser)
+	return updatedUser, nil
+}
+
+// Deletes the user, who is purged with their books once the grace period is over.
+func (s *Service) DeleteUser(ctx context.Context, actor Actor, user *types.User) *problem.Error {
+	if err := s.storage(ctx).DeleteUser(user); err != nil {
+		return StorageProblem(err, "failed to delete user")
+	}
+
+	// Sign the user out everywhere, so that only logging in again can cancel the deletion.
+	s.RevokeSessions(ctx, user)
+
+	s.Record(actor, audit.Event{
+		Action:    "user.delete",
+		SubjectID: user.ID,
+		Target:    UserTarget(user),
+		Details: map[string]interface{}{
+			"email":       user.Email,
+			"purge_after": time.Now().Add(s.AccountDeletionGracePeriod).UTC().Format(time.RFC3339),
+		},
+	})
+	s.PublishUser(ctx, events.UserDeleted, user)
+	return nil
+}
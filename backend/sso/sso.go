@@ -0,0 +1,119 @@
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ProviderConfig configures an OpenID Connect identity provider.
+type ProviderConfig struct {
+	// Name used in URLs and stored with linked identities, e.g. "google".
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// Our callback URL registered with the provider.
+	RedirectURL string
+	// Scopes requested in addition to "openid", defaults to "email" and "profile".
+	Scopes []string
+}
+
+// Claims holds the identity information taken from a verified ID token.
+type Claims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// Provider is an OpenID Connect relying party for a single identity provider.
+type Provider struct {
+	Name     string
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// Constructs a new Provider, discovering the provider's endpoints and signing keys from its issuer URL.
+func NewProvider(ctx context.Context, config ProviderConfig) (*Provider, error) {
+	if config.Name == "" || config.IssuerURL == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("provider name, issuer url, client id and redirect url are required")
+	}
+
+	discovered, err := oidc.NewProvider(ctx, config.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover provider %q: %w", config.Name, err)
+	}
+
+	scopes := config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"email", "profile"}
+	}
+
+	return &Provider{
+		Name: config.Name,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			Endpoint:     discovered.Endpoint(),
+			RedirectURL:  config.RedirectURL,
+			Scopes:       append([]string{oidc.ScopeOpenID}, scopes...),
+		},
+		verifier: discovered.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	}, nil
+}
+
+// Returns the URL to send the user to in order to authenticate with the provider.
+// The PKCE challenge is derived from the flow's verifier.
+func (p *Provider) AuthCodeURL(flow *FlowState) string {
+	return p.oauth2.AuthCodeURL(flow.State,
+		oidc.Nonce(flow.Nonce),
+		oauth2.S256ChallengeOption(flow.Verifier),
+	)
+}
+
+// Returns the path of our callback URL, where the provider sends the user back to.
+func (p *Provider) CallbackPath() string {
+	callbackURL, err := url.Parse(p.oauth2.RedirectURL)
+	if err != nil || callbackURL.Path == "" {
+		return "/"
+	}
+	return callbackURL.Path
+}
+
+// Exchanges the authorization code for tokens and verifies the returned ID token against the flow.
+func (p *Provider) Exchange(ctx context.Context, code string, flow *FlowState) (*Claims, error) {
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, errors.New("token response did not include an id token")
+	}
+
+	// Verify the signature, issuer, audience and expiry of the ID token.
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify id token: %w", err)
+	}
+
+	// The nonce binds the ID token to this flow, preventing replay.
+	if idToken.Nonce != flow.Nonce {
+		return nil, errors.New("id token nonce does not match")
+	}
+
+	var claims Claims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to read id token claims: %w", err)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	return &claims, nil
+}
@@ -0,0 +1,83 @@
+package sso
+
+import (
+	"context"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/sso/ssotest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *ssotest.MockProvider) {
+	mock := ssotest.NewMockProvider("client", "secret")
+	t.Cleanup(mock.Close)
+
+	provider, err := NewProvider(context.Background(), ProviderConfig{
+		Name:         "mock",
+		IssuerURL:    mock.Issuer(),
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/auth/oidc/mock/callback",
+	})
+	assert.NoError(t, err)
+	return provider, mock
+}
+
+func TestProviderFlow(t *testing.T) {
+	provider, mock := newTestProvider(t)
+	mock.SetUser(ssotest.User{Subject: "42", Email: "foo@bar.com", EmailVerified: true, Name: "foo"})
+
+	flow, err := NewFlowState("mock", 0)
+	assert.NoError(t, err)
+
+	callback, err := mock.Authorize(provider.AuthCodeURL(flow))
+	assert.NoError(t, err)
+	assert.Equal(t, flow.State, callback.Query().Get("state"))
+
+	claims, err := provider.Exchange(context.Background(), callback.Query().Get("code"), flow)
+	assert.NoError(t, err)
+	assert.Equal(t, &Claims{Subject: "42", Email: "foo@bar.com", EmailVerified: true, Name: "foo"}, claims)
+
+	// Codes cannot be reused.
+	_, err = provider.Exchange(context.Background(), callback.Query().Get("code"), flow)
+	assert.Error(t, err)
+}
+
+func TestProviderRejectsMismatchedFlow(t *testing.T) {
+	provider, mock := newTestProvider(t)
+	mock.SetUser(ssotest.User{Subject: "42"})
+
+	flow, err := NewFlowState("mock", 0)
+	assert.NoError(t, err)
+	otherFlow, err := NewFlowState("mock", 0)
+	assert.NoError(t, err)
+
+	// A code exchanged with another flow's PKCE verifier is rejected by the provider.
+	callback, err := mock.Authorize(provider.AuthCodeURL(flow))
+	assert.NoError(t, err)
+	_, err = provider.Exchange(context.Background(), callback.Query().Get("code"), otherFlow)
+	assert.Error(t, err)
+
+	// An ID token issued for another nonce is rejected.
+	callback, err = mock.Authorize(provider.AuthCodeURL(flow))
+	assert.NoError(t, err)
+	otherFlow.Verifier = flow.Verifier
+	_, err = provider.Exchange(context.Background(), callback.Query().Get("code"), otherFlow)
+	assert.Error(t, err)
+}
+
+func TestFlowState(t *testing.T) {
+	flow, err := NewFlowState("mock", 7)
+	assert.NoError(t, err)
+
+	encoded, err := EncodeFlowState([]byte("key"), flow)
+	assert.NoError(t, err)
+
+	decoded, err := DecodeFlowState([]byte("key"), encoded)
+	assert.NoError(t, err)
+	assert.Equal(t, flow, decoded)
+
+	// Flow states signed with another key are rejected.
+	_, err = DecodeFlowState([]byte("other"), encoded)
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package sso
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/oauth2"
+)
+
+// How long a user has to complete an authorization flow.
+const FlowLifetime = 10 * time.Minute
+
+// FlowState is the per-flow data kept by the browser between the redirect to the provider and the callback.
+// It is signed so it cannot be tampered with, and must never be sent to the provider because it carries the PKCE verifier.
+type FlowState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// Set when an authenticated user is linking the provider to their account.
+	LinkUserID int `json:"link_user_id,omitempty"`
+	jwt.StandardClaims
+}
+
+// Constructs a new FlowState with fresh random state, nonce and PKCE verifier.
+func NewFlowState(provider string, linkUserID int) (*FlowState, error) {
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	return &FlowState{
+		Provider:   provider,
+		State:      state,
+		Nonce:      nonce,
+		Verifier:   oauth2.GenerateVerifier(),
+		LinkUserID: linkUserID,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(FlowLifetime).Unix(),
+		},
+	}, nil
+}
+
+// Signs the flow state with the secret key, returns the encoded flow state.
+func EncodeFlowState(secretKey []byte, flow *FlowState) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, flow).SignedString(secretKey)
+}
+
+// Verifies and decodes a flow state produced by EncodeFlowState.
+func DecodeFlowState(secretKey []byte, encoded string) (*FlowState, error) {
+	var flow FlowState
+
+	token, err := jwt.ParseWithClaims(encoded, &flow, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, errors.New("unexpected signing method")
+		}
+		return secretKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid flow state")
+	}
+	return &flow, nil
+}
+
+// Returns a random URL-safe string with 256 bits of entropy.
+func randomString() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
@@ -0,0 +1,16 @@
+package storage
+
+import "context"
+
+// ContextStorage is implemented by storages that can run their queries in a context, e.g. one carrying a trace.
+type ContextStorage interface {
+	WithContext(ctx context.Context) Storage
+}
+
+// Returns the storage bound to the context if it supports contexts, or the storage itself otherwise.
+func WithContext(storage Storage, ctx context.Context) Storage {
+	if contextStorage, ok := storage.(ContextStorage); ok {
+		return contextStorage.WithContext(ctx)
+	}
+	return storage
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// Errors returned by storages, wrapped with details of the record concerned.
+// Getting a record that doesn't exist is not an error; the getters return nil instead.
+var (
+	// The record to update or revoke doesn't exist.
+	ErrNotFound = errors.New("record not found")
+	// The record violates a unique constraint, such as the unique email of users.
+	ErrConflict = errors.New("record conflicts with an existing one")
+	// The record to update was changed since the given version of it was read.
+	ErrStale = errors.New("record was changed since it was read")
+)
+
+// SQLSTATE code of unique constraint violations.
+const uniqueViolation = "23505"
+
+// Translates database errors into the storage's domain errors, leaving any other error as it is.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return fmt.Errorf("%w: unique constraint %q", ErrConflict, pgErr.ConstraintName)
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	return err
+}
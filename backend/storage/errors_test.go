@@ -0,0 +1,25 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestTranslateError(t *testing.T) {
+	assert.NoError(t, translateError(nil))
+	assert.ErrorIs(t, translateError(gorm.ErrRecordNotFound), ErrNotFound)
+	assert.ErrorIs(t, translateError(gorm.ErrDuplicatedKey), ErrConflict)
+
+	err := translateError(&pgconn.PgError{Code: "23505", ConstraintName: "users_email_key"})
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Contains(t, err.Error(), "users_email_key")
+
+	// Other errors are left as they are.
+	other := errors.New("connection refused")
+	assert.Equal(t, other, translateError(other))
+	assert.NotErrorIs(t, translateError(&pgconn.PgError{Code: "23503"}), ErrConflict)
+}
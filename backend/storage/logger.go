@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Queries slower than this are logged as warnings.
+const slowQueryThreshold = 200 * time.Millisecond
+
+// gormLogger sends gorm's logs to a structured logger.
+// SQL is logged with placeholders only, so that values such as password hashes never reach the logs.
+type gormLogger struct {
+	logger *slog.Logger
+}
+
+func (l gormLogger) LogMode(logger.LogLevel) logger.Interface {
+	// The level is controlled by the structured logger.
+	return l
+}
+
+func (l gormLogger) Info(ctx context.Context, message string, args ...interface{}) {
+	l.logger.InfoContext(ctx, message, "args", args)
+}
+
+func (l gormLogger) Warn(ctx context.Context, message string, args ...interface{}) {
+	l.logger.WarnContext(ctx, message, "args", args)
+}
+
+func (l gormLogger) Error(ctx context.Context, message string, args ...interface{}) {
+	l.logger.ErrorContext(ctx, message, "args", args)
+}
+
+func (l gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	elapsed := time.Since(begin)
+	sql, rows := fc()
+	attrs := []any{"sql", sql, "rows", rows, "duration_ms", float64(elapsed.Microseconds()) / 1000}
+
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		l.logger.ErrorContext(ctx, "query failed", append(attrs, "error", err)...)
+	case elapsed > slowQueryThreshold:
+		l.logger.WarnContext(ctx, "slow query", attrs...)
+	default:
+		l.logger.DebugContext(ctx, "query", attrs...)
+	}
+}
+
+// Drops the query parameters from logged SQL.
+func (l gormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
@@ -0,0 +1,713 @@
+package storage
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"gorm.io/gorm"
+)
+
+// MemoryStorage is an in-process implementation of Storage.
+// It is intended for tests and local development without a database.
+type MemoryStorage struct {
+	mu             sync.Mutex
+	users          map[int]types.User
+	books          map[int]types.Book
+	sessions       map[string]types.Session
+	identities     map[int]types.Identity
+	nextUserID     int
+	nextBookID     int
+	nextIdentityID int
+}
+
+// Constructs a new, empty MemoryStorage.
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:          make(map[int]types.User),
+		books:          make(map[int]types.Book),
+		sessions:       make(map[string]types.Session),
+		identities:     make(map[int]types.Identity),
+		nextUserID:     1,
+		nextBookID:     1,
+		nextIdentityID: 1,
+	}
+}
+
+// Runs fn on a copy of the storage, which replaces the storage's records if fn succeeds.
+// Other calls wait until the transaction is over, so they never see its changes before it commits.
+func (s *MemoryStorage) Transaction(fn func(tx Storage) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &MemoryStorage{
+		users:          maps.Clone(s.users),
+		books:          maps.Clone(s.books),
+		sessions:       maps.Clone(s.sessions),
+		identities:     maps.Clone(s.identities),
+		nextUserID:     s.nextUserID,
+		nextBookID:     s.nextBookID,
+		nextIdentityID: s.nextIdentityID,
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	s.users, s.books, s.sessions, s.identities = tx.users, tx.books, tx.sessions, tx.identities
+	s.nextUserID, s.nextBookID, s.nextIdentityID = tx.nextUserID, tx.nextBookID, tx.nextIdentityID
+	return nil
+}
+
+func (s *MemoryStorage) IsEmailTaken(email string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Deleted users keep their emails until they are purged.
+	for _, user := range s.users {
+		if user.Email == email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *MemoryStorage) CreateUser(user *types.User) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Enforce the unique email constraint.
+	for _, existingUser := range s.users {
+		if strings.EqualFold(existingUser.Email, user.Email) {
+			return nil, fmt.Errorf("%w: unique constraint %q", ErrConflict, "users_email_key")
+		}
+	}
+
+	if user.ID == 0 {
+		user.ID = s.nextUserID
+	}
+	if _, exists := s.users[user.ID]; exists {
+		return nil, fmt.Errorf("%w: unique constraint %q", ErrConflict, "users_pkey")
+	}
+	if user.ID >= s.nextUserID {
+		s.nextUserID = user.ID + 1
+	}
+
+	if user.Version == 0 {
+		user.Version = 1
+	}
+	now := time.Now()
+	if user.CreatedAt.IsZero() {
+		user.CreatedAt = now
+	}
+	if user.UpdatedAt.IsZero() {
+		user.UpdatedAt = now
+	}
+
+	if user.Role == "" {
+		user.Role = rbac.RoleUser
+	}
+
+	stored := *user
+	stored.Books = nil
+	s.users[user.ID] = stored
+	return user, nil
+}
+
+func (s *MemoryStorage) GetUserByEmail(email string) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email && !user.DeletedAt.Valid {
+			fetchedUser := user
+			return &fetchedUser, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStorage) GetUser(id int) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return nil, nil
+	}
+
+	// Preload the books associated with the user.
+	user.Books = s.booksOwnedBy(id)
+	return &user, nil
+}
+
+func (s *MemoryStorage) UpdateUser(user *types.User) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.users[user.ID]
+	if !ok || current.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	if err := checkVersion("user", user.ID, &user.Version, current.Version); err != nil {
+		return nil, err
+	}
+
+	stored := *user
+	stored.Books = nil
+	s.users[user.ID] = stored
+	return user, nil
+}
+
+func (s *MemoryStorage) RehashPassword(user *types.User, hashedPassword string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.ID]
+	if !ok || stored.DeletedAt.Valid || stored.Password != user.Password {
+		return fmt.Errorf("%w: the password of user %d was changed since it was read", ErrStale, user.ID)
+	}
+	stored.Password = hashedPassword
+	s.users[user.ID] = stored
+	user.Password = hashedPassword
+	return nil
+}
+
+func (s *MemoryStorage) DeleteUser(user *types.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.users[user.ID]; ok && !stored.DeletedAt.Valid {
+		stored.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		s.users[user.ID] = stored
+		user.DeletedAt = stored.DeletedAt
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GetDeletedUser(id int) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok || !user.DeletedAt.Valid {
+		return nil, nil
+	}
+	user.Books = s.booksOwnedBy(id)
+	return &user, nil
+}
+
+func (s *MemoryStorage) GetDeletedUserByEmail(email string) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email && user.DeletedAt.Valid {
+			fetchedUser := user
+			return &fetchedUser, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStorage) RestoreUser(user *types.User) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.users[user.ID]
+	if !ok || !stored.DeletedAt.Valid {
+		return nil, fmt.Errorf("%w: deleted user %d", ErrNotFound, user.ID)
+	}
+	stored.DeletedAt = gorm.DeletedAt{}
+	s.users[user.ID] = stored
+
+	user.DeletedAt = gorm.DeletedAt{}
+	return user, nil
+}
+
+func (s *MemoryStorage) PurgeUsers(deletedBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for id, user := range s.users {
+		if !user.DeletedAt.Valid || !user.DeletedAt.Time.Before(deletedBefore) {
+			continue
+		}
+		delete(s.users, id)
+		purged++
+
+		// Mirror the ON DELETE CASCADE constraints on the user's books, sessions and identities.
+		for bookID, book := range s.books {
+			if book.OwnerID == id {
+				delete(s.books, bookID)
+			}
+		}
+		for sessionID, session := range s.sessions {
+			if session.UserID == id {
+				delete(s.sessions, sessionID)
+			}
+		}
+		for identityID, identity := range s.identities {
+			if identity.UserID == id {
+				delete(s.identities, identityID)
+			}
+		}
+	}
+	return purged, nil
+}
+
+func (s *MemoryStorage) ListUsers(filter UserFilter) (*[]types.User, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query := strings.ToLower(filter.Query)
+	users := []types.User{}
+	for _, user := range s.users {
+		if user.DeletedAt.Valid {
+			continue
+		}
+		if query != "" && !strings.Contains(strings.ToLower(user.Username), query) && !strings.Contains(strings.ToLower(user.Email), query) {
+			continue
+		}
+		if filter.Role != "" && user.Role != filter.Role {
+			continue
+		}
+		if filter.Disabled != nil && user.Disabled != *filter.Disabled {
+			continue
+		}
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	// Apply pagination after counting the matching users.
+	total := int64(len(users))
+	users = paginate(users, filter.Offset, filter.Limit)
+	return &users, total, nil
+}
+
+func (s *MemoryStorage) GetUsersByIDs(ids []int) (*[]types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wanted := make(map[int]bool)
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	users := []types.User{}
+	for _, user := range s.users {
+		if wanted[user.ID] && !user.DeletedAt.Valid {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return &users, nil
+}
+
+func (s *MemoryStorage) GetStats() (*types.Stats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stats types.Stats
+	for _, user := range s.users {
+		if user.DeletedAt.Valid {
+			continue
+		}
+		stats.Users++
+		if user.Role == rbac.RoleAdmin {
+			stats.AdminUsers++
+		}
+		if user.Disabled {
+			stats.DisabledUsers++
+		}
+	}
+	for _, book := range s.books {
+		if book.DeletedAt.Valid {
+			continue
+		}
+		stats.Books++
+		if book.PagesRead >= book.PagesCount {
+			stats.FinishedBooks++
+		}
+	}
+	return &stats, nil
+}
+
+func (s *MemoryStorage) CreateSession(session *types.Session) (*types.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sessions[session.ID]; exists {
+		return nil, fmt.Errorf("%w: unique constraint %q", ErrConflict, "sessions_pkey")
+	}
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = time.Now()
+	}
+
+	s.sessions[session.ID] = *session
+	return session, nil
+}
+
+func (s *MemoryStorage) GetSession(id string) (*types.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (s *MemoryStorage) GetSessions(userID int) (*[]types.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Only return sessions that can still be used.
+	now := time.Now()
+	sessions := []types.Session{}
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.IsActive(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return &sessions, nil
+}
+
+func (s *MemoryStorage) RevokeSession(session *types.Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.sessions[session.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if stored.RevokedAt == nil {
+		now := time.Now()
+		stored.RevokedAt = &now
+		s.sessions[session.ID] = stored
+	}
+	session.RevokedAt = stored.RevokedAt
+	return nil
+}
+
+func (s *MemoryStorage) TouchSessions(lastSeen map[string]time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, seenAt := range lastSeen {
+		session, ok := s.sessions[id]
+		if ok && session.LastSeenAt.Before(seenAt) {
+			session.LastSeenAt = seenAt
+			s.sessions[id] = session
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) CreateIdentity(identity *types.Identity) (*types.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Enforce the unique provider and subject constraint.
+	for _, existingIdentity := range s.identities {
+		if existingIdentity.Provider == identity.Provider && existingIdentity.Subject == identity.Subject {
+			return nil, fmt.Errorf("%w: unique constraint %q", ErrConflict, "idx_identities_provider_subject")
+		}
+	}
+
+	identity.ID = s.nextIdentityID
+	s.nextIdentityID++
+	if identity.CreatedAt.IsZero() {
+		identity.CreatedAt = time.Now()
+	}
+
+	s.identities[identity.ID] = *identity
+	return identity, nil
+}
+
+func (s *MemoryStorage) GetIdentity(provider string, subject string) (*types.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, identity := range s.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			fetchedIdentity := identity
+			return &fetchedIdentity, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStorage) GetIdentities(userID int) (*[]types.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identities := []types.Identity{}
+	for _, identity := range s.identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool { return identities[i].Provider < identities[j].Provider })
+	return &identities, nil
+}
+
+func (s *MemoryStorage) DeleteIdentity(identity *types.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.identities, identity.ID)
+	return nil
+}
+
+func (s *MemoryStorage) CreateBook(book *types.Book) (*types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if book.ID == 0 {
+		book.ID = s.nextBookID
+	}
+	if _, exists := s.books[book.ID]; exists {
+		return nil, fmt.Errorf("%w: unique constraint %q", ErrConflict, "books_pkey")
+	}
+	if book.ID >= s.nextBookID {
+		s.nextBookID = book.ID + 1
+	}
+
+	if book.Version == 0 {
+		book.Version = 1
+	}
+	now := time.Now()
+	if book.CreatedAt.IsZero() {
+		book.CreatedAt = now
+	}
+	if book.UpdatedAt.IsZero() {
+		book.UpdatedAt = now
+	}
+
+	s.books[book.ID] = *book
+	return book, nil
+}
+
+func (s *MemoryStorage) GetBooks(id int) (*[]types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	books := s.booksOwnedBy(id)
+	return &books, nil
+}
+
+func (s *MemoryStorage) GetBooksByOwners(ownerIDs []int) (*[]types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owners := make(map[int]bool)
+	for _, id := range ownerIDs {
+		owners[id] = true
+	}
+
+	books := []types.Book{}
+	for _, book := range s.books {
+		if owners[book.OwnerID] && !book.DeletedAt.Valid {
+			books = append(books, book)
+		}
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].ID < books[j].ID })
+	return &books, nil
+}
+
+// Matches the words of the query against the starts of the words of the titles and authors, without typo tolerance.
+// Books ranked by how many words match their title, then by how many match their author.
+func (s *MemoryStorage) SearchBooks(search BookSearch) (*[]types.Book, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	terms := searchTerms(search.Query)
+	books := []types.Book{}
+	ranks := map[int]int{}
+	if len(terms) > 0 {
+		for _, book := range s.booksOwnedBy(search.OwnerID) {
+			titleWords, authorWords := searchTerms(book.Title), searchTerms(book.Author)
+
+			rank := 0
+			for _, term := range terms {
+				if startsAnyWord(titleWords, term) {
+					rank += len(terms) + 1
+				} else if startsAnyWord(authorWords, term) {
+					rank++
+				} else {
+					rank = -1
+					break
+				}
+			}
+			if rank >= 0 {
+				books = append(books, book)
+				ranks[book.ID] = rank
+			}
+		}
+	}
+	sort.Slice(books, func(i, j int) bool {
+		if ranks[books[i].ID] != ranks[books[j].ID] {
+			return ranks[books[i].ID] > ranks[books[j].ID]
+		}
+		return books[i].ID < books[j].ID
+	})
+
+	// Apply pagination after counting the matching books.
+	total := int64(len(books))
+	books = paginate(books, search.Offset, search.Limit)
+	return &books, total, nil
+}
+
+// Reports whether the prefix starts any of the words.
+func startsAnyWord(words []string, prefix string) bool {
+	for _, word := range words {
+		if strings.HasPrefix(word, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStorage) GetBook(id int) (*types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	book, ok := s.books[id]
+	if !ok || book.DeletedAt.Valid {
+		return nil, nil
+	}
+	return &book, nil
+}
+
+func (s *MemoryStorage) UpdateBook(book *types.Book) (*types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.books[book.ID]
+	if !ok || current.DeletedAt.Valid {
+		return nil, ErrNotFound
+	}
+	if err := checkVersion("book", book.ID, &book.Version, current.Version); err != nil {
+		return nil, err
+	}
+
+	s.books[book.ID] = *book
+	return book, nil
+}
+
+func (s *MemoryStorage) DeleteBook(book *types.Book) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.books[book.ID]; ok && !stored.DeletedAt.Valid {
+		stored.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		s.books[book.ID] = stored
+		book.DeletedAt = stored.DeletedAt
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GetDeletedBooks(ownerID int) (*[]types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	books := []types.Book{}
+	for _, book := range s.books {
+		if book.OwnerID == ownerID && book.DeletedAt.Valid {
+			books = append(books, book)
+		}
+	}
+
+	// The most recently deleted books come first.
+	sort.Slice(books, func(i, j int) bool {
+		if !books[i].DeletedAt.Time.Equal(books[j].DeletedAt.Time) {
+			return books[i].DeletedAt.Time.After(books[j].DeletedAt.Time)
+		}
+		return books[i].ID < books[j].ID
+	})
+	return &books, nil
+}
+
+func (s *MemoryStorage) GetDeletedBook(id int) (*types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	book, ok := s.books[id]
+	if !ok || !book.DeletedAt.Valid {
+		return nil, nil
+	}
+	return &book, nil
+}
+
+func (s *MemoryStorage) RestoreBook(book *types.Book) (*types.Book, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.books[book.ID]
+	if !ok || !stored.DeletedAt.Valid {
+		return nil, fmt.Errorf("%w: deleted book %d", ErrNotFound, book.ID)
+	}
+	stored.DeletedAt = gorm.DeletedAt{}
+	s.books[book.ID] = stored
+
+	book.DeletedAt = gorm.DeletedAt{}
+	return book, nil
+}
+
+func (s *MemoryStorage) PurgeBooks(deletedBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged int64
+	for id, book := range s.books {
+		if book.DeletedAt.Valid && book.DeletedAt.Time.Before(deletedBefore) {
+			delete(s.books, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// Advances the version of a record being updated to the one after the current version,
+// unless the update is based on an older version. A zero version is taken to be the current one.
+func checkVersion(kind string, id int, version *int, current int) error {
+	if *version != 0 && *version != current {
+		return fmt.Errorf("%w: %s %d has version %d, not %d", ErrStale, kind, id, current, *version)
+	}
+	*version = current + 1
+	return nil
+}
+
+// Returns the books owned by the user that aren't deleted, ordered by id. The caller must hold the lock.
+func (s *MemoryStorage) booksOwnedBy(ownerID int) []types.Book {
+	books := []types.Book{}
+	for _, book := range s.books {
+		if book.OwnerID == ownerID && !book.DeletedAt.Valid {
+			books = append(books, book)
+		}
+	}
+	sort.Slice(books, func(i, j int) bool { return books[i].ID < books[j].ID })
+	return books
+}
+
+// Returns the page of items starting at offset, with at most limit items if limit is positive.
+func paginate[T any](items []T, offset int, limit int) []T {
+	if offset > len(items) {
+		offset = len(items)
+	}
+	if offset > 0 {
+		items = items[offset:]
+	}
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
@@ -0,0 +1,317 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStorageFunctions(t *testing.T) {
+	var store Storage = NewMemoryStorage()
+
+	user := &types.User{Username: "foo", Email: "foo@bar.com", Password: "foo"}
+
+	// (1) Create a user.
+	createdUser, err := store.CreateUser(user)
+	assert.NoError(t, err, "expected no error creating user, got: %v.", err)
+	assert.Equal(t, 1, createdUser.ID)
+	assert.False(t, createdUser.CreatedAt.IsZero())
+
+	// Emails are unique.
+	_, err = store.CreateUser(&types.User{Username: "bar", Email: "foo@bar.com", Password: "bar"})
+	assert.ErrorIs(t, err, ErrConflict, "expected error creating user with a duplicate email.")
+
+	emailTaken, err := store.IsEmailTaken("foo@bar.com")
+	assert.NoError(t, err)
+	assert.True(t, emailTaken)
+
+	emailTaken, err = store.IsEmailTaken("fuzz@buzz.com")
+	assert.NoError(t, err)
+	assert.False(t, emailTaken)
+
+	// (2) Create books for the user.
+	book, err := store.CreateBook(&types.Book{Title: "foo", Author: "bar", PagesCount: 10, OwnerID: createdUser.ID})
+	assert.NoError(t, err)
+	_, err = store.CreateBook(&types.Book{Title: "fuzz", Author: "buzz", PagesCount: 20, OwnerID: createdUser.ID})
+	assert.NoError(t, err)
+
+	// (3) Fetch the user with their books preloaded.
+	fetchedUser, err := store.GetUser(createdUser.ID)
+	assert.NoError(t, err)
+	assert.Len(t, fetchedUser.Books, 2)
+
+	missingUser, err := store.GetUser(1000)
+	assert.NoError(t, err)
+	assert.Nil(t, missingUser)
+
+	// (4) Updates are not visible until saved.
+	fetchedBook, err := store.GetBook(book.ID)
+	assert.NoError(t, err)
+	fetchedBook.PagesRead = 5
+
+	unsavedBook, err := store.GetBook(book.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, unsavedBook.PagesRead)
+
+	_, err = store.UpdateBook(fetchedBook)
+	assert.NoError(t, err)
+
+	savedBook, err := store.GetBook(book.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, savedBook.PagesRead)
+	assert.Equal(t, 2, savedBook.Version)
+
+	// Updates based on a stale copy are rejected.
+	unsavedBook.PagesRead = 7
+	_, err = store.UpdateBook(unsavedBook)
+	assert.ErrorIs(t, err, ErrStale)
+	assert.Equal(t, 1, unsavedBook.Version)
+
+	savedBook, err = store.GetBook(book.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, savedBook.PagesRead)
+
+	// Updating a missing book is an error, getting one is not.
+	_, err = store.UpdateBook(&types.Book{ID: 1000})
+	assert.ErrorIs(t, err, ErrNotFound)
+	missingBook, err := store.GetBook(1000)
+	assert.NoError(t, err)
+	assert.Nil(t, missingBook)
+
+	// (5) List users matching a filter.
+	_, err = store.CreateUser(&types.User{Username: "fuzz", Email: "fuzz@buzz.com", Password: "fuzz", Role: "admin"})
+	assert.NoError(t, err)
+
+	users, total, err := store.ListUsers(UserFilter{Query: "BUZZ"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, "fuzz", (*users)[0].Username)
+
+	users, total, err = store.ListUsers(UserFilter{Limit: 1, Offset: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Len(t, *users, 1)
+
+	stats, err := store.GetStats()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), stats.Users)
+	assert.Equal(t, int64(1), stats.AdminUsers)
+	assert.Equal(t, int64(0), stats.FinishedBooks)
+
+	// (6) Deleted books are moved to the trash, and can be restored from it.
+	assert.NoError(t, store.DeleteBook(savedBook))
+
+	deletedBook, err := store.GetBook(book.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, deletedBook)
+	_, err = store.UpdateBook(savedBook)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	trash, err := store.GetDeletedBooks(createdUser.ID)
+	assert.NoError(t, err)
+	assert.Len(t, *trash, 1)
+	assert.True(t, (*trash)[0].DeletedAt.Valid)
+
+	deletedBook, err = store.GetDeletedBook(book.ID)
+	assert.NoError(t, err)
+	restoredBook, err := store.RestoreBook(deletedBook)
+	assert.NoError(t, err)
+	assert.False(t, restoredBook.DeletedAt.Valid)
+	_, err = store.RestoreBook(restoredBook)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	books, err := store.GetBooks(createdUser.ID)
+	assert.NoError(t, err)
+	assert.Len(t, *books, 2)
+
+	// (7) Deleting the user keeps them, and their email, until they are purged.
+	assert.NoError(t, store.DeleteUser(fetchedUser))
+
+	deletedUser, err := store.GetUser(createdUser.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, deletedUser)
+	deletedUser, err = store.GetUserByEmail("foo@bar.com")
+	assert.NoError(t, err)
+	assert.Nil(t, deletedUser)
+	emailTaken, err = store.IsEmailTaken("foo@bar.com")
+	assert.NoError(t, err)
+	assert.True(t, emailTaken)
+
+	users, total, err = store.ListUsers(UserFilter{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+
+	deletedUser, err = store.GetDeletedUserByEmail("foo@bar.com")
+	assert.NoError(t, err)
+	assert.Equal(t, createdUser.ID, deletedUser.ID)
+
+	// Purging the deleted user cascades to their books.
+	purged, err := store.PurgeUsers(time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), purged)
+	purged, err = store.PurgeUsers(time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	deletedUser, err = store.GetDeletedUser(createdUser.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, deletedUser)
+
+	purgedBook, err := store.GetBook(book.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, purgedBook)
+}
+
+func TestMemoryStorageTransaction(t *testing.T) {
+	store := NewMemoryStorage()
+	user, err := store.CreateUser(&types.User{Username: "foo", Email: "foo@bar.com", Password: "foo"})
+	assert.NoError(t, err)
+
+	// The changes of a failed transaction are rolled back.
+	failure := errors.New("failure")
+	err = Transaction(store, func(tx Storage) error {
+		_, err := tx.CreateBook(&types.Book{Title: "foo", Author: "bar", PagesCount: 10, OwnerID: user.ID})
+		assert.NoError(t, err)
+
+		books, err := tx.GetBooks(user.ID)
+		assert.NoError(t, err)
+		assert.Len(t, *books, 1)
+		return failure
+	})
+	assert.ErrorIs(t, err, failure)
+
+	books, err := store.GetBooks(user.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, *books)
+
+	// The changes of a successful one are saved.
+	err = Transaction(store, func(tx Storage) error {
+		_, err := tx.CreateBook(&types.Book{Title: "foo", Author: "bar", PagesCount: 10, OwnerID: user.ID})
+		return err
+	})
+	assert.NoError(t, err)
+
+	books, err = store.GetBooks(user.ID)
+	assert.NoError(t, err)
+	assert.Len(t, *books, 1)
+}
+
+func TestMemoryStorageRehashPassword(t *testing.T) {
+	store := NewMemoryStorage()
+	user, err := store.CreateUser(&types.User{Username: "foo", Email: "foo@bar.com", Password: "old"})
+	assert.NoError(t, err)
+	version := user.Version
+
+	// A hash that was changed since it was read is not replaced.
+	stale := *user
+	stale.Password = "other"
+	assert.ErrorIs(t, store.RehashPassword(&stale, "new"), ErrStale)
+
+	// The hash is replaced without a new version.
+	assert.NoError(t, store.RehashPassword(user, "new"))
+	assert.Equal(t, "new", user.Password)
+	fetchedUser, err := store.GetUser(user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", fetchedUser.Password)
+	assert.Equal(t, version, fetchedUser.Version)
+}
+
+func TestMemoryStorageSearchBooks(t *testing.T) {
+	store := NewMemoryStorage()
+	foo, err := store.CreateUser(&types.User{Username: "foo", Email: "foo@bar.com", Password: "foo"})
+	assert.NoError(t, err)
+	bar, err := store.CreateUser(&types.User{Username: "bar", Email: "bar@bar.com", Password: "bar"})
+	assert.NoError(t, err)
+
+	createBook := func(title string, author string, ownerID int) *types.Book {
+		book, err := store.CreateBook(&types.Book{Title: title, Author: author, PagesCount: 10, OwnerID: ownerID})
+		assert.NoError(t, err)
+		return book
+	}
+	dispossessed := createBook("The Dispossessed", "Ursula K. Le Guin", foo.ID)
+	darkness := createBook("The Left Hand of Darkness", "Ursula K. Le Guin", foo.ID)
+	earthsea := createBook("A Wizard of Earthsea", "Ursula K. Le Guin", foo.ID)
+	ursula := createBook("Ursula", "Jane Doe", foo.ID)
+	createBook("The Dispossessed", "Ursula K. Le Guin", bar.ID)
+
+	ids := func(books *[]types.Book) []int {
+		ids := []int{}
+		for _, book := range *books {
+			ids = append(ids, book.ID)
+		}
+		return ids
+	}
+
+	// Words match the starts of words, case-insensitively, and only the owner's books are searched.
+	books, total, err := store.SearchBooks(BookSearch{Query: "DISPOSS", OwnerID: foo.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, []int{dispossessed.ID}, ids(books))
+
+	// Every word must match, and title matches rank above author matches.
+	books, total, err = store.SearchBooks(BookSearch{Query: "ursula le", OwnerID: foo.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Equal(t, []int{darkness.ID, dispossessed.ID, earthsea.ID}, ids(books))
+
+	books, _, err = store.SearchBooks(BookSearch{Query: "ursula", OwnerID: foo.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{ursula.ID, dispossessed.ID, darkness.ID, earthsea.ID}, ids(books))
+
+	// Pagination applies after counting.
+	books, total, err = store.SearchBooks(BookSearch{Query: "ursula", OwnerID: foo.ID, Offset: 1, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+	assert.Equal(t, []int{dispossessed.ID, darkness.ID}, ids(books))
+
+	// Deleted books and punctuation-only queries match nothing.
+	assert.NoError(t, store.DeleteBook(dispossessed))
+	books, _, err = store.SearchBooks(BookSearch{Query: "dispossessed", OwnerID: foo.ID})
+	assert.NoError(t, err)
+	assert.Empty(t, *books)
+
+	books, total, err = store.SearchBooks(BookSearch{Query: "!?", OwnerID: foo.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), total)
+	assert.Empty(t, *books)
+}
+
+func TestMemoryStorageBatchGetters(t *testing.T) {
+	store := NewMemoryStorage()
+	foo, err := store.CreateUser(&types.User{Username: "foo", Email: "foo@bar.com", Password: "foo"})
+	assert.NoError(t, err)
+	bar, err := store.CreateUser(&types.User{Username: "bar", Email: "bar@bar.com", Password: "bar"})
+	assert.NoError(t, err)
+	baz, err := store.CreateUser(&types.User{Username: "baz", Email: "baz@bar.com", Password: "baz"})
+	assert.NoError(t, err)
+	assert.NoError(t, store.DeleteUser(baz))
+
+	for _, owner := range []*types.User{foo, bar, foo} {
+		_, err := store.CreateBook(&types.Book{Title: "foo", Author: "bar", PagesCount: 10, OwnerID: owner.ID})
+		assert.NoError(t, err)
+	}
+	deletedBook, err := store.CreateBook(&types.Book{Title: "foo", Author: "bar", PagesCount: 10, OwnerID: bar.ID})
+	assert.NoError(t, err)
+	assert.NoError(t, store.DeleteBook(deletedBook))
+
+	// Deleted users and books are left out.
+	users, err := store.GetUsersByIDs([]int{baz.ID, bar.ID, foo.ID, 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, []string{(*users)[0].Username, (*users)[1].Username})
+	assert.Len(t, *users, 2)
+
+	books, err := store.GetBooksByOwners([]int{foo.ID, bar.ID})
+	assert.NoError(t, err)
+	owners := []int{}
+	for _, book := range *books {
+		owners = append(owners, book.OwnerID)
+	}
+	assert.Equal(t, []int{foo.ID, bar.ID, foo.ID}, owners)
+
+	books, err = store.GetBooksByOwners(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, *books)
+}
@@ -1,16 +1,49 @@
 package storage
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
+	"time"
 
+	"github.com/declanl482/go-book-tracker-app/backend/rbac"
 	"github.com/declanl482/go-book-tracker-app/backend/types"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PostgresStorage struct {
-	db *gorm.DB
+	db     *gorm.DB
+	logger *slog.Logger
+	pool   PoolLimits
+}
+
+// PoolLimits bounds the database connection pool, zero values leave the driver's defaults.
+type PoolLimits struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// Option configures a PostgresStorage.
+type Option func(*PostgresStorage)
+
+// Logs through the logger instead of the default logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *PostgresStorage) {
+		s.logger = logger
+	}
+}
+
+// Bounds the connection pool by the limits.
+func WithPoolLimits(limits PoolLimits) Option {
+	return func(s *PostgresStorage) {
+		s.pool = limits
+	}
 }
 
 var appDB *gorm.DB
@@ -20,7 +53,7 @@ func GetDatabaseInstance() (*PostgresStorage, error) {
 	if appDB == nil {
 		return nil, errors.New("database connection error: database instance is nil")
 	}
-	return &PostgresStorage{db: appDB}, nil
+	return &PostgresStorage{db: appDB, logger: slog.Default()}, nil
 }
 func OpenDatabaseConnection(dsn string) (*gorm.DB, error) {
 	// Open a connection to the database.
@@ -29,13 +62,42 @@ func OpenDatabaseConnection(dsn string) (*gorm.DB, error) {
 	return appDB, appDBErr
 }
 
+// The types stored in the application database, one table each.
+var models = []interface{}{&types.User{}, &types.Book{}, &types.Session{}, &types.Identity{}}
+
+// Index the titles and authors of the books for full-text search, both by word and,
+// so that misspelled queries still match, by trigram.
+var bookSearchMigrations = []string{
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`ALTER TABLE books ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('simple', coalesce(title, '')), 'A') || setweight(to_tsvector('simple', coalesce(author, '')), 'B')
+	) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_books_search_vector ON books USING GIN (search_vector)`,
+	`CREATE INDEX IF NOT EXISTS idx_books_title_trgm ON books USING GIN (lower(title) gin_trgm_ops)`,
+	`CREATE INDEX IF NOT EXISTS idx_books_author_trgm ON books USING GIN (lower(author) gin_trgm_ops)`,
+}
+
 func MigrateTablesToDatabase(db *gorm.DB) error {
 	// Migrate desired tables to database using pre-defined types.
-	appDBErr = db.AutoMigrate(&types.User{}, &types.Book{})
-	return appDBErr
+	appDBErr = db.AutoMigrate(models...)
+	if appDBErr != nil {
+		return appDBErr
+	}
+
+	for _, statement := range bookSearchMigrations {
+		if appDBErr = db.Exec(statement).Error; appDBErr != nil {
+			return appDBErr
+		}
+	}
+	return nil
 }
 
-func NewPostgresStorage(hostname string, username string, password string, dbname string, port string, timezone string) (*PostgresStorage, error) {
+func NewPostgresStorage(hostname string, username string, password string, dbname string, port string, timezone string, options ...Option) (*PostgresStorage, error) {
+	storage := &PostgresStorage{logger: slog.Default()}
+	for _, option := range options {
+		option(storage)
+	}
+
 	// Configure the postgres dsn.
 
 	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable TimeZone=%s",
@@ -49,39 +111,108 @@ func NewPostgresStorage(hostname string, username string, password string, dbnam
 	// Open a connection to the database.
 	appDB, appDBErr = OpenDatabaseConnection(dsn)
 	if appDBErr != nil {
-		fmt.Println("Error connecting to application database:", appDBErr)
+		storage.logger.Error("failed to connect to the application database", "host", hostname, "database", dbname, "error", appDBErr)
 		return nil, appDBErr
 	}
+	appDB.Logger = gormLogger{logger: storage.logger}
+
+	// Bound the connection pool.
+	sqlDB, err := appDB.DB()
+	if err != nil {
+		return nil, err
+	}
+	if storage.pool.MaxOpenConns > 0 {
+		sqlDB.SetMaxOpenConns(storage.pool.MaxOpenConns)
+	}
+	if storage.pool.MaxIdleConns > 0 {
+		sqlDB.SetMaxIdleConns(storage.pool.MaxIdleConns)
+	}
+	if storage.pool.ConnMaxLifetime > 0 {
+		sqlDB.SetConnMaxLifetime(storage.pool.ConnMaxLifetime)
+	}
+	if storage.pool.ConnMaxIdleTime > 0 {
+		sqlDB.SetConnMaxIdleTime(storage.pool.ConnMaxIdleTime)
+	}
 
 	// Migrate tables to the database.
 	appDBErr = MigrateTablesToDatabase(appDB)
 	if appDBErr != nil {
-		fmt.Println("Error migrating tables to the application database:", appDBErr)
+		storage.logger.Error("failed to migrate tables to the application database", "error", appDBErr)
 		return nil, appDBErr
 	}
 
 	// The application database connection and table migration was successful.
 	// Return an instance of the application database without errors.
-	fmt.Println("Connection to the application database was successful.")
+	storage.logger.Info("connected to the application database", "host", hostname, "database", dbname)
 	// Return an instance of PostgresStorage.
-	return &PostgresStorage{db: appDB}, nil
+	storage.db = appDB
+	return storage, nil
 }
 
-func (s *PostgresStorage) IsEmailTaken(email string) (bool, error) {
-	// Check if the new user's email is already in use.
-	existingUser, err := s.GetUserByEmail(email)
+// Returns the underlying database handle, e.g. for components that keep their own tables.
+func (s *PostgresStorage) DB() *gorm.DB {
+	return s.db
+}
+
+// Returns an error if the database cannot be reached or its tables have not been migrated.
+func (s *PostgresStorage) Ready(ctx context.Context) error {
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	if err := sqlDB.PingContext(ctx); err != nil {
+		return fmt.Errorf("database unreachable: %w", err)
+	}
+
+	migrator := s.db.WithContext(ctx).Migrator()
+	for _, model := range models {
+		if !migrator.HasTable(model) {
+			return fmt.Errorf("table for %T has not been migrated", model)
+		}
+	}
+	return nil
+}
+
+// Closes the connections to the database.
+func (s *PostgresStorage) Close() error {
+	sqlDB, err := s.db.DB()
 	if err != nil {
-		return false, err
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// Returns a copy of the storage that runs its queries in the context.
+func (s *PostgresStorage) WithContext(ctx context.Context) Storage {
+	return &PostgresStorage{db: s.db.WithContext(ctx), logger: s.logger}
+}
+
+// Runs fn with a storage whose queries are part of one database transaction.
+func (s *PostgresStorage) Transaction(fn func(tx Storage) error) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&PostgresStorage{db: tx, logger: s.logger})
+	})
+}
+
+func (s *PostgresStorage) IsEmailTaken(email string) (bool, error) {
+	// Check if the new user's email is already in use, deleted users keep theirs until they are purged.
+	var count int64
+	result := s.db.Unscoped().Model(&types.User{}).Where("email = ?", email).Count(&count)
+	if result.Error != nil {
+		return false, translateError(result.Error)
 	}
-	return existingUser != nil, nil
+	return count > 0, nil
 }
 
 func (s *PostgresStorage) CreateUser(user *types.User) (*types.User, error) {
+	if user.Version == 0 {
+		user.Version = 1
+	}
 
 	// Create the new user in the database.
 	result := s.db.Create(user)
 	if result.Error != nil {
-		return nil, result.Error
+		return nil, translateError(result.Error)
 	}
 
 	// Return the newly created user.
@@ -95,7 +226,7 @@ func (s *PostgresStorage) GetUserByEmail(email string) (*types.User, error) {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, nil // Record not found.
 		}
-		return nil, result.Error // Return the error for any other error.
+		return nil, translateError(result.Error) // Return the error for any other error.
 	}
 	return &user, nil
 }
@@ -112,7 +243,7 @@ func (s *PostgresStorage) GetUser(id int) (*types.User, error) {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, nil // User with the given id was not found.
 		}
-		return nil, result.Error // Database error when fetching.
+		return nil, translateError(result.Error) // Database error when fetching.
 	}
 
 	// Return the fetched user.
@@ -120,25 +251,252 @@ func (s *PostgresStorage) GetUser(id int) (*types.User, error) {
 }
 
 func (s *PostgresStorage) UpdateUser(user *types.User) (*types.User, error) {
-	result := s.db.Model(&user).Updates(user)
-	if result.Error != nil {
-		return nil, result.Error
+	// Save every column so that fields can be reset to their zero values (e.g. re-enabling an account).
+	err := s.updateVersioned(&types.User{}, "user", user.ID, &user.Version, func(query *gorm.DB) *gorm.DB {
+		return query.Model(&user).Select("*").Omit("Books", "Sessions", "Identities").Updates(user)
+	})
+	if err != nil {
+		return nil, err
 	}
 	return user, nil
 }
 
+func (s *PostgresStorage) RehashPassword(user *types.User, hashedPassword string) error {
+	// Write the hash alone, leaving the version and updated_at as they are.
+	result := s.db.Model(&types.User{}).Where("id = ? AND password = ?", user.ID, user.Password).UpdateColumn("password", hashedPassword)
+	if result.Error != nil {
+		return translateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: the password of user %d was changed since it was read", ErrStale, user.ID)
+	}
+	user.Password = hashedPassword
+	return nil
+}
+
 func (s *PostgresStorage) DeleteUser(user *types.User) error {
 	result := s.db.Delete(&user)
 	if result.Error != nil {
-		return result.Error
+		return translateError(result.Error)
+	}
+	return nil
+}
+
+func (s *PostgresStorage) GetDeletedUser(id int) (*types.User, error) {
+	var user types.User
+	result := s.db.Unscoped().Where("deleted_at IS NOT NULL").Preload("Books").First(&user, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, translateError(result.Error)
+	}
+	return &user, nil
+}
+
+func (s *PostgresStorage) GetDeletedUserByEmail(email string) (*types.User, error) {
+	var user types.User
+	result := s.db.Unscoped().Where("email = ? AND deleted_at IS NOT NULL", email).First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, translateError(result.Error)
+	}
+	return &user, nil
+}
+
+func (s *PostgresStorage) RestoreUser(user *types.User) (*types.User, error) {
+	if err := s.restore(&types.User{}, "user", user.ID); err != nil {
+		return nil, err
+	}
+	user.DeletedAt = gorm.DeletedAt{}
+	return user, nil
+}
+
+func (s *PostgresStorage) PurgeUsers(deletedBefore time.Time) (int64, error) {
+	// The books, sessions, identities and webhook endpoints of the users are removed by the ON DELETE CASCADE constraints.
+	result := s.db.Unscoped().Where("deleted_at < ?", deletedBefore).Delete(&types.User{})
+	if result.Error != nil {
+		return 0, translateError(result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+func (s *PostgresStorage) ListUsers(filter UserFilter) (*[]types.User, int64, error) {
+	var users []types.User
+	var total int64
+
+	query := s.db.Model(&types.User{})
+	if filter.Query != "" {
+		pattern := "%" + filter.Query + "%"
+		query = query.Where("username ILIKE ? OR email ILIKE ?", pattern, pattern)
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if filter.Disabled != nil {
+		query = query.Where("disabled = ?", *filter.Disabled)
+	}
+
+	// Count the matching users before applying pagination.
+	if result := query.Count(&total); result.Error != nil {
+		return nil, 0, translateError(result.Error)
+	}
+
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	result := query.Order("id").Offset(filter.Offset).Find(&users)
+	if result.Error != nil {
+		return nil, 0, translateError(result.Error)
+	}
+	return &users, total, nil
+}
+
+func (s *PostgresStorage) GetUsersByIDs(ids []int) (*[]types.User, error) {
+	users := []types.User{}
+	if len(ids) == 0 {
+		return &users, nil
+	}
+
+	result := s.db.Where("id IN ?", ids).Order("id").Find(&users)
+	if result.Error != nil {
+		return nil, translateError(result.Error)
+	}
+	return &users, nil
+}
+
+func (s *PostgresStorage) GetStats() (*types.Stats, error) {
+	var stats types.Stats
+
+	counts := []struct {
+		model interface{}
+		where []interface{}
+		dest  *int64
+	}{
+		{&types.User{}, nil, &stats.Users},
+		{&types.User{}, []interface{}{"role = ?", rbac.RoleAdmin}, &stats.AdminUsers},
+		{&types.User{}, []interface{}{"disabled"}, &stats.DisabledUsers},
+		{&types.Book{}, nil, &stats.Books},
+		{&types.Book{}, []interface{}{"pages_read >= pages_count"}, &stats.FinishedBooks},
+	}
+
+	for _, count := range counts {
+		query := s.db.Model(count.model)
+		if count.where != nil {
+			query = query.Where(count.where[0], count.where[1:]...)
+		}
+		if result := query.Count(count.dest); result.Error != nil {
+			return nil, translateError(result.Error)
+		}
+	}
+	return &stats, nil
+}
+
+func (s *PostgresStorage) CreateSession(session *types.Session) (*types.Session, error) {
+	result := s.db.Create(session)
+	if result.Error != nil {
+		return nil, translateError(result.Error)
+	}
+	return session, nil
+}
+
+func (s *PostgresStorage) GetSession(id string) (*types.Session, error) {
+	var session types.Session
+
+	result := s.db.Where("id = ?", id).First(&session)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, translateError(result.Error)
+	}
+	return &session, nil
+}
+
+func (s *PostgresStorage) GetSessions(userID int) (*[]types.Session, error) {
+	var sessions []types.Session
+
+	// Only return sessions that can still be used.
+	result := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions)
+	if result.Error != nil {
+		return nil, translateError(result.Error)
+	}
+	return &sessions, nil
+}
+
+func (s *PostgresStorage) RevokeSession(session *types.Session) error {
+	now := time.Now()
+	result := s.db.Model(&types.Session{}).Where("id = ? AND revoked_at IS NULL", session.ID).Update("revoked_at", now)
+	if result.Error != nil {
+		return translateError(result.Error)
+	}
+	session.RevokedAt = &now
+	return nil
+}
+
+func (s *PostgresStorage) TouchSessions(lastSeen map[string]time.Time) error {
+	// Apply all of the batched last-seen times in a single transaction.
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for id, seenAt := range lastSeen {
+			result := tx.Model(&types.Session{}).Where("id = ? AND last_seen_at < ?", id, seenAt).Update("last_seen_at", seenAt)
+			if result.Error != nil {
+				return translateError(result.Error)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStorage) CreateIdentity(identity *types.Identity) (*types.Identity, error) {
+	result := s.db.Create(identity)
+	if result.Error != nil {
+		return nil, translateError(result.Error)
+	}
+	return identity, nil
+}
+
+func (s *PostgresStorage) GetIdentity(provider string, subject string) (*types.Identity, error) {
+	var identity types.Identity
+
+	result := s.db.Where("provider = ? AND subject = ?", provider, subject).First(&identity)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, translateError(result.Error)
+	}
+	return &identity, nil
+}
+
+func (s *PostgresStorage) GetIdentities(userID int) (*[]types.Identity, error) {
+	var identities []types.Identity
+
+	result := s.db.Where("user_id = ?", userID).Order("provider").Find(&identities)
+	if result.Error != nil {
+		return nil, translateError(result.Error)
+	}
+	return &identities, nil
+}
+
+func (s *PostgresStorage) DeleteIdentity(identity *types.Identity) error {
+	result := s.db.Delete(identity)
+	if result.Error != nil {
+		return translateError(result.Error)
 	}
 	return nil
 }
 
 func (s *PostgresStorage) CreateBook(book *types.Book) (*types.Book, error) {
+	if book.Version == 0 {
+		book.Version = 1
+	}
 	result := s.db.Create(book)
 	if result.Error != nil {
-		return nil, result.Error
+		return nil, translateError(result.Error)
 	}
 	return book, nil
 }
@@ -148,11 +506,66 @@ func (s *PostgresStorage) GetBooks(id int) (*[]types.Book, error) {
 
 	result := s.db.Where("owner_id = ?", id).Find(&books)
 	if result.Error != nil {
-		return nil, result.Error
+		return nil, translateError(result.Error)
+	}
+	return &books, nil
+}
+
+func (s *PostgresStorage) GetBooksByOwners(ownerIDs []int) (*[]types.Book, error) {
+	books := []types.Book{}
+	if len(ownerIDs) == 0 {
+		return &books, nil
+	}
+
+	result := s.db.Where("owner_id IN ?", ownerIDs).Order("id").Find(&books)
+	if result.Error != nil {
+		return nil, translateError(result.Error)
 	}
 	return &books, nil
 }
 
+func (s *PostgresStorage) SearchBooks(search BookSearch) (*[]types.Book, int64, error) {
+	books := []types.Book{}
+	var total int64
+
+	terms := searchTerms(search.Query)
+	if len(terms) == 0 {
+		return &books, 0, nil
+	}
+
+	// Every word of the query must start a word of the book, unless the query is similar enough to the title or author.
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		prefixes[i] = term + ":*"
+	}
+	tsQuery := strings.Join(prefixes, " & ")
+	text := strings.Join(terms, " ")
+
+	query := s.db.Model(&types.Book{}).
+		Where("owner_id = ?", search.OwnerID).
+		Where("search_vector @@ to_tsquery('simple', ?) OR ? <% lower(title) OR ? <% lower(author)", tsQuery, text, text)
+
+	// Count the matching books before applying pagination.
+	if result := query.Count(&total); result.Error != nil {
+		return nil, 0, translateError(result.Error)
+	}
+
+	if search.Limit > 0 {
+		query = query.Limit(search.Limit)
+	}
+	// Rank the word matches, weighing titles over authors, and the similar titles and authors.
+	rank := clause.Expr{
+		SQL:                "ts_rank(search_vector, to_tsquery('simple', ?)) + greatest(word_similarity(?, lower(title)), word_similarity(?, lower(author))) DESC, id",
+		Vars:               []interface{}{tsQuery, text, text},
+		WithoutParentheses: true,
+	}
+	result := query.Order(clause.OrderBy{Expression: rank}).Offset(search.Offset).Find(&books)
+	if result.Error != nil {
+		return nil, 0, translateError(result.Error)
+	}
+	return &books, total, nil
+}
+
 func (s *PostgresStorage) GetBook(id int) (*types.Book, error) {
 	var book types.Book
 
@@ -161,15 +574,19 @@ func (s *PostgresStorage) GetBook(id int) (*types.Book, error) {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, nil
 		}
-		return nil, result.Error
+		return nil, translateError(result.Error)
 	}
 	return &book, nil
 }
 
 func (s *PostgresStorage) UpdateBook(book *types.Book) (*types.Book, error) {
-	result := s.db.Model(&book).Updates(book)
-	if result.Error != nil {
-		return nil, result.Error
+	// Save every column so that fields can be reset to their zero values (e.g. no pages read, or no edition).
+	// Moving the book to the trash is left to DeleteBook.
+	err := s.updateVersioned(&types.Book{}, "book", book.ID, &book.Version, func(query *gorm.DB) *gorm.DB {
+		return query.Model(&book).Select("*").Omit("DeletedAt").Updates(book)
+	})
+	if err != nil {
+		return nil, err
 	}
 	return book, nil
 }
@@ -177,7 +594,92 @@ func (s *PostgresStorage) UpdateBook(book *types.Book) (*types.Book, error) {
 func (s *PostgresStorage) DeleteBook(book *types.Book) error {
 	result := s.db.Delete(&book)
 	if result.Error != nil {
-		return result.Error
+		return translateError(result.Error)
 	}
 	return nil
 }
+
+func (s *PostgresStorage) GetDeletedBooks(ownerID int) (*[]types.Book, error) {
+	var books []types.Book
+
+	result := s.db.Unscoped().Where("owner_id = ? AND deleted_at IS NOT NULL", ownerID).Order("deleted_at DESC, id").Find(&books)
+	if result.Error != nil {
+		return nil, translateError(result.Error)
+	}
+	return &books, nil
+}
+
+func (s *PostgresStorage) GetDeletedBook(id int) (*types.Book, error) {
+	var book types.Book
+
+	result := s.db.Unscoped().Where("deleted_at IS NOT NULL").First(&book, id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, translateError(result.Error)
+	}
+	return &book, nil
+}
+
+func (s *PostgresStorage) RestoreBook(book *types.Book) (*types.Book, error) {
+	if err := s.restore(&types.Book{}, "book", book.ID); err != nil {
+		return nil, err
+	}
+	book.DeletedAt = gorm.DeletedAt{}
+	return book, nil
+}
+
+func (s *PostgresStorage) PurgeBooks(deletedBefore time.Time) (int64, error) {
+	result := s.db.Unscoped().Where("deleted_at < ?", deletedBefore).Delete(&types.Book{})
+	if result.Error != nil {
+		return 0, translateError(result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// Undoes the soft deletion of a record. Returns ErrNotFound if the record doesn't exist or isn't deleted.
+func (s *PostgresStorage) restore(model interface{}, kind string, id int) error {
+	result := s.db.Unscoped().Model(model).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+	if result.Error != nil {
+		return translateError(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: deleted %s %d", ErrNotFound, kind, id)
+	}
+	return nil
+}
+
+// Runs the update of a record only if the record still has the version that was read, in the same statement,
+// advancing the version to the next one. A zero version is taken to be the current one, so the update always applies.
+// Returns ErrStale if the record has another version, and ErrNotFound if it doesn't exist.
+func (s *PostgresStorage) updateVersioned(model interface{}, kind string, id int, version *int, update func(query *gorm.DB) *gorm.DB) error {
+	readVersion := *version
+	expected := readVersion
+	if expected == 0 {
+		if result := s.db.Model(model).Select("version").Where("id = ?", id).Scan(&expected); result.Error != nil {
+			return translateError(result.Error)
+		}
+	}
+
+	*version = expected + 1
+	result := update(s.db.Where("version = ?", expected))
+	if result.Error == nil && result.RowsAffected > 0 {
+		return nil
+	}
+
+	*version = readVersion
+	if result.Error != nil {
+		return translateError(result.Error)
+	}
+
+	// Nothing was updated, either because the record is gone or because it has another version.
+	var count int64
+	if result := s.db.Model(model).Where("id = ?", id).Count(&count); result.Error != nil {
+		return translateError(result.Error)
+	}
+	if count == 0 {
+		return fmt.Errorf("%w: %s %d", ErrNotFound, kind, id)
+	}
+	return fmt.Errorf("%w: %s %d no longer has version %d", ErrStale, kind, id, expected)
+}
@@ -274,6 +274,11 @@ func TestStorageFunctions(t *testing.T) {
 		assert.NoError(t, err, "expected no error fetching user 1's books, go: %v.", err)
 		assert.Equal(t, []types.Book{*bookInitial2}, *books2)
 
+		// Get the books of both owners at once (success).
+		ownersBooks, err := store.GetBooksByOwners([]int{1, 2})
+		assert.NoError(t, err, "expected no error fetching the books of users 1 and 2, got: %v.", err)
+		assert.Equal(t, []types.Book{*bookInitial1, *bookInitial2}, *ownersBooks)
+
 		// Get a book (not found).
 		bookNotFound, err := store.GetBook(0)
 		assert.Empty(t, bookNotFound)
@@ -300,6 +305,27 @@ func TestStorageFunctions(t *testing.T) {
 		assert.NoError(t, err, "expected no error updating book 2, got: %v.", err)
 		assert.Equal(t, bookUpdated2, newBook2)
 
+		// Reset book 1's edition and progress to their zero values (success).
+		resetBook1 := *newBook1
+		resetBook1.Edition, resetBook1.PagesRead = 0, 0
+		_, err = store.UpdateBook(&resetBook1)
+		assert.NoError(t, err, "expected no error resetting book 1, got: %v.", err)
+		fetchedResetBook1, err := store.GetBook(1)
+		assert.NoError(t, err, "expected no error fetching reset book 1, got: %v.", err)
+		assert.Equal(t, 0, fetchedResetBook1.Edition)
+		assert.Equal(t, 0, fetchedResetBook1.PagesRead)
+		assert.Equal(t, newBook1.Title, fetchedResetBook1.Title)
+
+		// Search owner 1's books by the start of a word, and with a typo.
+		foundBooks, total, err := store.SearchBooks(BookSearch{Query: "tit", OwnerID: 1})
+		assert.NoError(t, err, "expected no error searching user 1's books, got: %v.", err)
+		assert.Equal(t, int64(1), total)
+		assert.Equal(t, 1, (*foundBooks)[0].ID)
+
+		foundBooks, _, err = store.SearchBooks(BookSearch{Query: "new titel", OwnerID: 1})
+		assert.NoError(t, err, "expected no error searching user 1's books, got: %v.", err)
+		assert.Len(t, *foundBooks, 1)
+
 		// Delete book 1 (success).
 		err = store.DeleteBook(bookInitial1)
 		assert.NoError(t, err, "expected no error deleting book 1, got: %v.", err)
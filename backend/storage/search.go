@@ -0,0 +1,22 @@
+package storage
+
+import (
+	"strings"
+	"unicode"
+)
+
+// BookSearch finds the books of a user matching a full-text query, most relevant first.
+type BookSearch struct {
+	// Words matched against the titles and authors of the books. Each word also matches longer words it is the start of.
+	Query   string
+	OwnerID int
+	Offset  int
+	Limit   int
+}
+
+// Splits the query into lowercase words, leaving out punctuation so the words are safe to use in text search queries.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
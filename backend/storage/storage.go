@@ -1,18 +1,72 @@
 package storage
 
-import "github.com/declanl482/go-book-tracker-app/backend/types"
+import (
+	"time"
 
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+)
+
+// UserFilter narrows down the users returned by ListUsers.
+type UserFilter struct {
+	// Case-insensitive substring matched against usernames and emails.
+	Query string
+	// Only return users with this role, if set.
+	Role string
+	// Only return users with this disabled state, if set.
+	Disabled *bool
+	Offset   int
+	Limit    int
+}
+
+// Storage keeps the users, books, sessions and identities.
+// Users and books are deleted softly: the getters leave deleted ones out, except for those getting deleted ones,
+// and they are only removed for good when purged.
 type Storage interface {
 	CreateUser(user *types.User) (*types.User, error)
 	GetUserByEmail(email string) (*types.User, error)
 	GetUser(id int) (*types.User, error)
 	UpdateUser(user *types.User) (*types.User, error)
+	// Replaces the user's password hash with a new hash of the same password if it is still the hash read,
+	// returning ErrStale otherwise. The version is kept, as nothing clients can see about the user changes.
+	RehashPassword(user *types.User, hashedPassword string) error
+	// Reports whether a user has the email, including deleted users that haven't been purged.
 	IsEmailTaken(email string) (bool, error)
 	DeleteUser(user *types.User) error
+	ListUsers(filter UserFilter) (*[]types.User, int64, error)
+	// Returns those of the users with the ids that exist, by id and without their books, in one query.
+	GetUsersByIDs(ids []int) (*[]types.User, error)
+	GetStats() (*types.Stats, error)
+
+	GetDeletedUser(id int) (*types.User, error)
+	GetDeletedUserByEmail(email string) (*types.User, error)
+	RestoreUser(user *types.User) (*types.User, error)
+	// Removes the users deleted before the time for good, along with their books, sessions and identities.
+	PurgeUsers(deletedBefore time.Time) (int64, error)
+
+	CreateSession(session *types.Session) (*types.Session, error)
+	GetSession(id string) (*types.Session, error)
+	GetSessions(userID int) (*[]types.Session, error)
+	RevokeSession(session *types.Session) error
+	TouchSessions(lastSeen map[string]time.Time) error
+
+	CreateIdentity(identity *types.Identity) (*types.Identity, error)
+	GetIdentity(provider string, subject string) (*types.Identity, error)
+	GetIdentities(userID int) (*[]types.Identity, error)
+	DeleteIdentity(identity *types.Identity) error
 
 	CreateBook(book *types.Book) (*types.Book, error)
 	GetBooks(id int) (*[]types.Book, error)
+	// Returns the books of all the owners, by id, in one query.
+	GetBooksByOwners(ownerIDs []int) (*[]types.Book, error)
 	GetBook(id int) (*types.Book, error)
+	// Returns a page of the user's books matching the search, most relevant first, and how many match in all.
+	SearchBooks(search BookSearch) (*[]types.Book, int64, error)
 	UpdateBook(book *types.Book) (*types.Book, error)
 	DeleteBook(book *types.Book) error
+
+	GetDeletedBooks(ownerID int) (*[]types.Book, error)
+	GetDeletedBook(id int) (*types.Book, error)
+	RestoreBook(book *types.Book) (*types.Book, error)
+	// Removes the books deleted before the time for good.
+	PurgeBooks(deletedBefore time.Time) (int64, error)
 }
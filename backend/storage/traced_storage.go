@@ -0,0 +1,276 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// Name of the tracer the storage spans are created with.
+const TracerName = "github.com/declanl482/go-book-tracker-app/backend/storage"
+
+// TracedStorage wraps a storage, recording a span for every call.
+// The spans are children of the span in the context given to WithContext, and the wrapped storage
+// runs its queries in the call's span context so that they show up beneath it.
+type TracedStorage struct {
+	storage Storage
+	tracer  trace.Tracer
+	ctx     context.Context
+}
+
+// Constructs a new TracedStorage wrapping the storage.
+func NewTracedStorage(storage Storage, provider trace.TracerProvider) *TracedStorage {
+	return &TracedStorage{
+		storage: storage,
+		tracer:  provider.Tracer(TracerName),
+		ctx:     context.Background(),
+	}
+}
+
+// Returns a copy of the storage whose spans are children of the span in the context.
+func (t *TracedStorage) WithContext(ctx context.Context) Storage {
+	return &TracedStorage{storage: t.storage, tracer: t.tracer, ctx: ctx}
+}
+
+// Starts the span for a call, returning the wrapped storage bound to it and a function ending it.
+func (t *TracedStorage) start(method string, attributes ...attribute.KeyValue) (Storage, func(error)) {
+	ctx, span := t.tracer.Start(t.ctx, "storage."+method, trace.WithAttributes(attributes...))
+	return WithContext(t.storage, ctx), func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// Runs fn in a transaction of the wrapped storage, recording a span for it.
+// The spans of the calls made in the transaction are children of its span.
+func (t *TracedStorage) Transaction(fn func(tx Storage) error) (err error) {
+	ctx, span := t.tracer.Start(t.ctx, "storage.Transaction")
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	return Transaction(WithContext(t.storage, ctx), func(tx Storage) error {
+		return fn(&TracedStorage{storage: tx, tracer: t.tracer, ctx: ctx})
+	})
+}
+
+// Returns the handle the wrapped storage runs its queries with, see DatabaseStorage.
+func (t *TracedStorage) DB() *gorm.DB {
+	return DB(t.storage)
+}
+
+func (t *TracedStorage) CreateUser(user *types.User) (createdUser *types.User, err error) {
+	storage, end := t.start("CreateUser")
+	defer func() { end(err) }()
+	return storage.CreateUser(user)
+}
+
+func (t *TracedStorage) GetUserByEmail(email string) (user *types.User, err error) {
+	storage, end := t.start("GetUserByEmail")
+	defer func() { end(err) }()
+	return storage.GetUserByEmail(email)
+}
+
+func (t *TracedStorage) GetUser(id int) (user *types.User, err error) {
+	storage, end := t.start("GetUser", attribute.Int("user.id", id))
+	defer func() { end(err) }()
+	return storage.GetUser(id)
+}
+
+func (t *TracedStorage) UpdateUser(user *types.User) (updatedUser *types.User, err error) {
+	storage, end := t.start("UpdateUser", attribute.Int("user.id", user.ID))
+	defer func() { end(err) }()
+	return storage.UpdateUser(user)
+}
+
+func (t *TracedStorage) RehashPassword(user *types.User, hashedPassword string) (err error) {
+	storage, end := t.start("RehashPassword", attribute.Int("user.id", user.ID))
+	defer func() { end(err) }()
+	return storage.RehashPassword(user, hashedPassword)
+}
+
+func (t *TracedStorage) IsEmailTaken(email string) (taken bool, err error) {
+	storage, end := t.start("IsEmailTaken")
+	defer func() { end(err) }()
+	return storage.IsEmailTaken(email)
+}
+
+func (t *TracedStorage) DeleteUser(user *types.User) (err error) {
+	storage, end := t.start("DeleteUser", attribute.Int("user.id", user.ID))
+	defer func() { end(err) }()
+	return storage.DeleteUser(user)
+}
+
+func (t *TracedStorage) ListUsers(filter UserFilter) (users *[]types.User, total int64, err error) {
+	storage, end := t.start("ListUsers", attribute.Int("offset", filter.Offset), attribute.Int("limit", filter.Limit))
+	defer func() { end(err) }()
+	return storage.ListUsers(filter)
+}
+
+func (t *TracedStorage) GetUsersByIDs(ids []int) (users *[]types.User, err error) {
+	storage, end := t.start("GetUsersByIDs", attribute.IntSlice("user.ids", ids))
+	defer func() { end(err) }()
+	return storage.GetUsersByIDs(ids)
+}
+
+func (t *TracedStorage) GetStats() (stats *types.Stats, err error) {
+	storage, end := t.start("GetStats")
+	defer func() { end(err) }()
+	return storage.GetStats()
+}
+
+func (t *TracedStorage) GetDeletedUser(id int) (user *types.User, err error) {
+	storage, end := t.start("GetDeletedUser", attribute.Int("user.id", id))
+	defer func() { end(err) }()
+	return storage.GetDeletedUser(id)
+}
+
+func (t *TracedStorage) GetDeletedUserByEmail(email string) (user *types.User, err error) {
+	storage, end := t.start("GetDeletedUserByEmail")
+	defer func() { end(err) }()
+	return storage.GetDeletedUserByEmail(email)
+}
+
+func (t *TracedStorage) RestoreUser(user *types.User) (restoredUser *types.User, err error) {
+	storage, end := t.start("RestoreUser", attribute.Int("user.id", user.ID))
+	defer func() { end(err) }()
+	return storage.RestoreUser(user)
+}
+
+func (t *TracedStorage) PurgeUsers(deletedBefore time.Time) (purged int64, err error) {
+	storage, end := t.start("PurgeUsers")
+	defer func() { end(err) }()
+	return storage.PurgeUsers(deletedBefore)
+}
+
+func (t *TracedStorage) CreateSession(session *types.Session) (createdSession *types.Session, err error) {
+	storage, end := t.start("CreateSession", attribute.Int("user.id", session.UserID))
+	defer func() { end(err) }()
+	return storage.CreateSession(session)
+}
+
+func (t *TracedStorage) GetSession(id string) (session *types.Session, err error) {
+	storage, end := t.start("GetSession")
+	defer func() { end(err) }()
+	return storage.GetSession(id)
+}
+
+func (t *TracedStorage) GetSessions(userID int) (sessions *[]types.Session, err error) {
+	storage, end := t.start("GetSessions", attribute.Int("user.id", userID))
+	defer func() { end(err) }()
+	return storage.GetSessions(userID)
+}
+
+func (t *TracedStorage) RevokeSession(session *types.Session) (err error) {
+	storage, end := t.start("RevokeSession", attribute.Int("user.id", session.UserID))
+	defer func() { end(err) }()
+	return storage.RevokeSession(session)
+}
+
+func (t *TracedStorage) TouchSessions(lastSeen map[string]time.Time) (err error) {
+	storage, end := t.start("TouchSessions", attribute.Int("sessions", len(lastSeen)))
+	defer func() { end(err) }()
+	return storage.TouchSessions(lastSeen)
+}
+
+func (t *TracedStorage) CreateIdentity(identity *types.Identity) (createdIdentity *types.Identity, err error) {
+	storage, end := t.start("CreateIdentity", attribute.Int("user.id", identity.UserID), attribute.String("identity.provider", identity.Provider))
+	defer func() { end(err) }()
+	return storage.CreateIdentity(identity)
+}
+
+func (t *TracedStorage) GetIdentity(provider string, subject string) (identity *types.Identity, err error) {
+	storage, end := t.start("GetIdentity", attribute.String("identity.provider", provider))
+	defer func() { end(err) }()
+	return storage.GetIdentity(provider, subject)
+}
+
+func (t *TracedStorage) GetIdentities(userID int) (identities *[]types.Identity, err error) {
+	storage, end := t.start("GetIdentities", attribute.Int("user.id", userID))
+	defer func() { end(err) }()
+	return storage.GetIdentities(userID)
+}
+
+func (t *TracedStorage) DeleteIdentity(identity *types.Identity) (err error) {
+	storage, end := t.start("DeleteIdentity", attribute.Int("user.id", identity.UserID), attribute.String("identity.provider", identity.Provider))
+	defer func() { end(err) }()
+	return storage.DeleteIdentity(identity)
+}
+
+func (t *TracedStorage) CreateBook(book *types.Book) (createdBook *types.Book, err error) {
+	storage, end := t.start("CreateBook", attribute.Int("user.id", book.OwnerID))
+	defer func() { end(err) }()
+	return storage.CreateBook(book)
+}
+
+func (t *TracedStorage) GetBooks(id int) (books *[]types.Book, err error) {
+	storage, end := t.start("GetBooks", attribute.Int("user.id", id))
+	defer func() { end(err) }()
+	return storage.GetBooks(id)
+}
+
+func (t *TracedStorage) GetBooksByOwners(ownerIDs []int) (books *[]types.Book, err error) {
+	storage, end := t.start("GetBooksByOwners", attribute.IntSlice("user.ids", ownerIDs))
+	defer func() { end(err) }()
+	return storage.GetBooksByOwners(ownerIDs)
+}
+
+func (t *TracedStorage) GetBook(id int) (book *types.Book, err error) {
+	storage, end := t.start("GetBook", attribute.Int("book.id", id))
+	defer func() { end(err) }()
+	return storage.GetBook(id)
+}
+
+func (t *TracedStorage) SearchBooks(search BookSearch) (books *[]types.Book, total int64, err error) {
+	storage, end := t.start("SearchBooks", attribute.Int("user.id", search.OwnerID), attribute.Int("offset", search.Offset), attribute.Int("limit", search.Limit))
+	defer func() { end(err) }()
+	return storage.SearchBooks(search)
+}
+
+func (t *TracedStorage) UpdateBook(book *types.Book) (updatedBook *types.Book, err error) {
+	storage, end := t.start("UpdateBook", attribute.Int("book.id", book.ID))
+	defer func() { end(err) }()
+	return storage.UpdateBook(book)
+}
+
+func (t *TracedStorage) DeleteBook(book *types.Book) (err error) {
+	storage, end := t.start("DeleteBook", attribute.Int("book.id", book.ID))
+	defer func() { end(err) }()
+	return storage.DeleteBook(book)
+}
+
+func (t *TracedStorage) GetDeletedBooks(ownerID int) (books *[]types.Book, err error) {
+	storage, end := t.start("GetDeletedBooks", attribute.Int("user.id", ownerID))
+	defer func() { end(err) }()
+	return storage.GetDeletedBooks(ownerID)
+}
+
+func (t *TracedStorage) GetDeletedBook(id int) (book *types.Book, err error) {
+	storage, end := t.start("GetDeletedBook", attribute.Int("book.id", id))
+	defer func() { end(err) }()
+	return storage.GetDeletedBook(id)
+}
+
+func (t *TracedStorage) RestoreBook(book *types.Book) (restoredBook *types.Book, err error) {
+	storage, end := t.start("RestoreBook", attribute.Int("book.id", book.ID))
+	defer func() { end(err) }()
+	return storage.RestoreBook(book)
+}
+
+func (t *TracedStorage) PurgeBooks(deletedBefore time.Time) (purged int64, err error) {
+	storage, end := t.start("PurgeBooks")
+	defer func() { end(err) }()
+	return storage.PurgeBooks(deletedBefore)
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/tracing"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestTracedStorage(t *testing.T) {
+	provider, exporter := tracing.NewTestProvider()
+	traced := NewTracedStorage(NewMemoryStorage(), provider)
+
+	// Calls made in a request's context are children of the request's span.
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	store := WithContext(traced, ctx)
+
+	user, err := store.CreateUser(&types.User{Username: "foo", Email: "foo@bar.com", Password: "foo"})
+	assert.NoError(t, err)
+	_, err = store.GetUser(user.ID)
+	assert.NoError(t, err)
+	_, err = store.UpdateBook(&types.Book{ID: 42})
+	assert.Error(t, err)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 4)
+	assert.Equal(t, "storage.CreateUser", spans[0].Name)
+	assert.Equal(t, "storage.GetUser", spans[1].Name)
+	assert.Contains(t, spans[1].Attributes, attribute.Int("user.id", user.ID))
+
+	for _, span := range spans[:3] {
+		assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext.TraceID())
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+	}
+
+	// Failed calls are marked as errors.
+	assert.Equal(t, "storage.UpdateBook", spans[2].Name)
+	assert.Equal(t, codes.Error, spans[2].Status.Code)
+	assert.Equal(t, codes.Unset, spans[1].Status.Code)
+}
+
+func TestTracedStorageTransaction(t *testing.T) {
+	provider, exporter := tracing.NewTestProvider()
+	store := NewTracedStorage(NewMemoryStorage(), provider)
+
+	err := Transaction(store, func(tx Storage) error {
+		_, err := tx.CreateUser(&types.User{Username: "foo", Email: "foo@bar.com", Password: "foo"})
+		return err
+	})
+	assert.NoError(t, err)
+
+	// The calls made in the transaction are children of its span.
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "storage.CreateUser", spans[0].Name)
+	assert.Equal(t, "storage.Transaction", spans[1].Name)
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// The storage can't run calls in a transaction.
+var ErrTransactionsUnsupported = errors.New("storage does not support transactions")
+
+// TransactionalStorage is implemented by storages that can run several calls as one transaction.
+type TransactionalStorage interface {
+	// Runs fn with a storage whose calls are part of one transaction, which is committed if fn returns nil
+	// and rolled back otherwise. Returns the error of fn, or of committing the transaction.
+	Transaction(fn func(tx Storage) error) error
+}
+
+// Runs fn in a transaction of the storage, see TransactionalStorage.
+// Returns ErrTransactionsUnsupported without running fn if the storage doesn't support transactions.
+func Transaction(storage Storage, fn func(tx Storage) error) error {
+	if transactional, ok := storage.(TransactionalStorage); ok {
+		return transactional.Transaction(fn)
+	}
+	return ErrTransactionsUnsupported
+}
+
+// DatabaseStorage is implemented by storages that run their queries on a gorm database, so that stores kept in the
+// same database can run theirs with the same handle, e.g. to take part in one of the storage's transactions.
+type DatabaseStorage interface {
+	DB() *gorm.DB
+}
+
+// Returns the handle the storage runs its queries with, such as that of one of its transactions,
+// or nil if it doesn't run them on a database.
+func DB(storage Storage) *gorm.DB {
+	if databaseStorage, ok := storage.(DatabaseStorage); ok {
+		return databaseStorage.DB()
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+// Name of the tracer the query spans are created with.
+const TracerName = "github.com/declanl482/go-book-tracker-app/backend/tracing"
+
+// Key under which a query's span is kept on the statement.
+const querySpanKey = "tracing:span"
+
+// Records a span for every query run on the database, as a child of the span in the query's context.
+// The statement is recorded with placeholders in place of its parameters.
+func InstrumentDB(db *gorm.DB, provider trace.TracerProvider) error {
+	return db.Use(queryPlugin{tracer: provider.Tracer(TracerName)})
+}
+
+// queryPlugin starts and ends spans around gorm's callbacks.
+type queryPlugin struct {
+	tracer trace.Tracer
+}
+
+func (p queryPlugin) Name() string {
+	return "tracing"
+}
+
+func (p queryPlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	hooks := []error{
+		callback.Create().Before("gorm:create").Register("tracing:before_create", p.before("create")),
+		callback.Create().After("gorm:create").Register("tracing:after_create", p.after),
+		callback.Query().Before("gorm:query").Register("tracing:before_query", p.before("query")),
+		callback.Query().After("gorm:query").Register("tracing:after_query", p.after),
+		callback.Update().Before("gorm:update").Register("tracing:before_update", p.before("update")),
+		callback.Update().After("gorm:update").Register("tracing:after_update", p.after),
+		callback.Delete().Before("gorm:delete").Register("tracing:before_delete", p.before("delete")),
+		callback.Delete().After("gorm:delete").Register("tracing:after_delete", p.after),
+		callback.Row().Before("gorm:row").Register("tracing:before_row", p.before("row")),
+		callback.Row().After("gorm:row").Register("tracing:after_row", p.after),
+		callback.Raw().Before("gorm:raw").Register("tracing:before_raw", p.before("raw")),
+		callback.Raw().After("gorm:raw").Register("tracing:after_raw", p.after),
+	}
+	return errors.Join(hooks...)
+}
+
+func (p queryPlugin) before(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		_, span := p.tracer.Start(db.Statement.Context, "db."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperation(operation)),
+		)
+		db.InstanceSet(querySpanKey, span)
+	}
+}
+
+func (p queryPlugin) after(db *gorm.DB) {
+	value, ok := db.InstanceGet(querySpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+
+	span.SetAttributes(semconv.DBStatement(db.Statement.SQL.String()))
+	if db.Statement.Table != "" {
+		span.SetAttributes(semconv.DBSQLTable(db.Statement.Table))
+	}
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(db.Error)
+		span.SetStatus(codes.Error, db.Error.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// Config selects where traces are exported to.
+type Config struct {
+	// One of "none", "otlp" or "stdout".
+	Exporter string
+	// URL of the OTLP/HTTP collector, e.g. "http://localhost:4318".
+	// The standard OTEL_EXPORTER_OTLP_* variables apply when empty.
+	Endpoint string
+	// Name the spans are reported under.
+	ServiceName string
+	// Fraction of new traces to record, between 0 and 1. Traces started by callers keep their sampling decision.
+	SampleRatio float64
+}
+
+// Returns the configuration used when none is given, which exports nothing.
+func DefaultConfig() Config {
+	return Config{Exporter: "none", ServiceName: "book-tracker", SampleRatio: 1}
+}
+
+// Returns the propagator reading and writing W3C trace context and baggage headers.
+func Propagator() propagation.TextMapPropagator {
+	return propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+}
+
+// Constructs the exporter selected by the configuration, returns nil if tracing is disabled.
+func NewExporter(ctx context.Context, config Config) (sdktrace.SpanExporter, error) {
+	switch strings.ToLower(config.Exporter) {
+	case "", "none":
+		return nil, nil
+	case "otlp":
+		var options []otlptracehttp.Option
+		if config.Endpoint != "" {
+			options = append(options, otlptracehttp.WithEndpointURL(config.Endpoint))
+		}
+		return otlptracehttp.New(ctx, options...)
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", config.Exporter)
+	}
+}
+
+// Constructs a tracer provider batching spans to the exporter.
+// Without an exporter, spans are still created and propagated, but not recorded.
+func NewProvider(exporter sdktrace.SpanExporter, config Config) *sdktrace.TracerProvider {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(config.ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	}
+	if exporter != nil {
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	return sdktrace.NewTracerProvider(options...)
+}
+
+// Constructs the configured tracer provider and installs it, along with the W3C propagator, as the global default.
+// The provider must be shut down to flush the remaining spans.
+func Setup(ctx context.Context, config Config) (*sdktrace.TracerProvider, error) {
+	exporter, err := NewExporter(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := NewProvider(exporter, config)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(Propagator())
+	return provider, nil
+}
+
+// Constructs a tracer provider recording every span in memory as soon as it ends, for tests.
+func NewTestProvider() (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSyncer(exporter),
+		sdktrace.WithSampler(sdktrace.AlwaysSample()),
+	)
+	return provider, exporter
+}
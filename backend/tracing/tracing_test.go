@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/stretchr/testify/assert"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestNewExporter(t *testing.T) {
+	exporter, err := NewExporter(context.Background(), DefaultConfig())
+	assert.NoError(t, err)
+	assert.Nil(t, exporter)
+
+	exporter, err = NewExporter(context.Background(), Config{Exporter: "otlp", Endpoint: "http://localhost:4318"})
+	assert.NoError(t, err)
+	assert.NotNil(t, exporter)
+	assert.NoError(t, exporter.Shutdown(context.Background()))
+
+	_, err = NewExporter(context.Background(), Config{Exporter: "zipkin"})
+	assert.Error(t, err)
+}
+
+func TestInstrumentDB(t *testing.T) {
+	provider, exporter := NewTestProvider()
+
+	// Dry runs go through the callbacks without a database connection.
+	db, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	assert.NoError(t, err)
+	assert.NoError(t, InstrumentDB(db, provider))
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "storage.GetBook")
+	var book types.Book
+	db.WithContext(ctx).First(&book, 42)
+	parent.End()
+
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+
+	query := spans[0]
+	assert.Equal(t, "db.query", query.Name)
+	assert.Equal(t, trace.SpanKindClient, query.SpanKind)
+	assert.Equal(t, parent.SpanContext().SpanID(), query.Parent.SpanID())
+	assert.Contains(t, query.Attributes, semconv.DBSystemPostgreSQL)
+	assert.Contains(t, query.Attributes, semconv.DBSQLTable("books"))
+
+	// The statement is recorded with placeholders rather than the query's parameters.
+	assert.Contains(t, query.Attributes, semconv.DBStatement(`SELECT * FROM "books" WHERE "books"."id" = $1 AND "books"."deleted_at" IS NULL ORDER BY "books"."id" LIMIT 1`))
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"regexp"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 func ValidateEmail(email string) bool {
@@ -31,28 +33,53 @@ func (c *Credentials) ValidateCredentials() error {
 }
 
 type User struct {
-	ID        int       `gorm:"primaryKey" json:"id"`
-	Username  string    `gorm:"not null" json:"username" binding:"required"`
-	Email     string    `gorm:"unique;not null" json:"email" binding:"required,email"`
-	Password  string    `gorm:"not null" binding:"required" json:"password"`
-	Books     []Book    `gorm:"foreignKey:OwnerID;references:ID;constraint:OnDelete:CASCADE" json:"books"`
-	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
-	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+	ID         int        `gorm:"primaryKey" json:"id"`
+	Username   string     `gorm:"not null" json:"username" binding:"required"`
+	Email      string     `gorm:"unique;not null" json:"email" binding:"required,email"`
+	Password   string     `gorm:"not null" binding:"required" json:"password"`
+	Books      []Book     `gorm:"foreignKey:OwnerID;references:ID;constraint:OnDelete:CASCADE" json:"books"`
+	Sessions   []Session  `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+	Identities []Identity `gorm:"foreignKey:UserID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	UpdatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+
+	// Administrative account state, only changed through the admin API.
+	Role                  string `gorm:"not null;default:user" json:"role"`
+	Disabled              bool   `gorm:"not null;default:false" json:"disabled"`
+	PasswordResetRequired bool   `gorm:"not null;default:false" json:"password_reset_required"`
+
+	// Incremented by every update, so that updates based on a stale copy can be rejected.
+	Version int `gorm:"not null;default:1" json:"version"`
+
+	// When the account was deleted. Deleted accounts are purged after a grace period, until then logging in restores them.
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+// ValidationError is returned when a field of a user or book is invalid.
+type ValidationError struct {
+	// Name of the field in JSON, e.g. "pages_read".
+	Field   string
+	Code    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + " " + e.Message
 }
 
 func (u *User) ValidateUser() error {
 	if u.Username == "" {
-		return errors.New("username is required")
+		return &ValidationError{Field: "username", Code: "required", Message: "is required"}
 	}
 	if u.Email == "" {
-		return errors.New("email is required")
+		return &ValidationError{Field: "email", Code: "required", Message: "is required"}
 	}
 	if u.Password == "" {
-		return errors.New("password is required")
+		return &ValidationError{Field: "password", Code: "required", Message: "is required"}
 	}
 
 	if !ValidateEmail(u.Email) {
-		return errors.New("email is invalid")
+		return &ValidationError{Field: "email", Code: "email", Message: "must be an email address"}
 	}
 
 	return nil
@@ -68,20 +95,66 @@ type Book struct {
 	OwnerID    int       `json:"owner_id"`
 	CreatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
+
+	// Incremented by every update, so that updates based on a stale copy can be rejected.
+	Version int `gorm:"not null;default:1" json:"version"`
+
+	// When the book was moved to the trash, null unless it is there. Books in the trash are purged after a retention period.
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 }
 
 func (b *Book) ValidateBook() error {
 	if b.Title == "" {
-		return errors.New("book title is required")
+		return &ValidationError{Field: "title", Code: "required", Message: "is required"}
 	}
 	if b.Author == "" {
-		return errors.New("book author is required")
+		return &ValidationError{Field: "author", Code: "required", Message: "is required"}
 	}
 	if b.PagesCount <= 0 {
-		return errors.New("invalid pages count")
+		return &ValidationError{Field: "pages_count", Code: "min", Message: "must be at least 1"}
 	}
 	if b.PagesRead < 0 || b.PagesRead > b.PagesCount {
-		return errors.New("invalid pages read")
+		return &ValidationError{Field: "pages_read", Code: "range", Message: "must be between 0 and pages_count"}
 	}
 	return nil
 }
+
+// Session is a login of a user on one device, identified by the access tokens issued for it.
+type Session struct {
+	ID         string     `gorm:"primaryKey" json:"id"`
+	UserID     int        `gorm:"not null;index" json:"user_id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+
+	// Whether this is the session making the request; not stored.
+	Current bool `gorm:"-" json:"current"`
+}
+
+// Reports whether access tokens for the session may still be used at the given time.
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// Identity links an account at an external OpenID Connect provider to a user.
+type Identity struct {
+	ID       int    `gorm:"primaryKey" json:"id"`
+	UserID   int    `gorm:"not null;index" json:"user_id"`
+	Provider string `gorm:"not null;uniqueIndex:idx_identities_provider_subject" json:"provider"`
+	// The provider's stable, unique identifier for the account.
+	Subject   string    `gorm:"not null;uniqueIndex:idx_identities_provider_subject" json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Stats holds system-wide counts for administrators.
+type Stats struct {
+	Users         int64 `json:"users"`
+	AdminUsers    int64 `json:"admin_users"`
+	DisabledUsers int64 `json:"disabled_users"`
+	Books         int64 `json:"books"`
+	FinishedBooks int64 `json:"finished_books"`
+}
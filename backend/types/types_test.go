@@ -240,3 +240,17 @@ func TestTypes(t *testing.T) {
 		}
 	})
 }
+
+func TestValidationError(t *testing.T) {
+	book := Book{Title: "foo", Author: "bar", PagesCount: 10, PagesRead: 11}
+
+	var validationErr *ValidationError
+	err := book.ValidateBook()
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "pages_read", validationErr.Field)
+	assert.EqualError(t, err, "pages_read must be between 0 and pages_count")
+
+	user := User{Username: "foo", Email: "foobar.com", Password: "foo"}
+	assert.ErrorAs(t, user.ValidateUser(), &validationErr)
+	assert.Equal(t, "email", validationErr.Field)
+}
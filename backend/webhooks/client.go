@@ -0,0 +1,90 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned when an endpoint is, or resolves to, an address on the server's own networks.
+var ErrPrivateAddress = errors.New("endpoint address is not public")
+
+// Reports whether deliveries may be sent to the address: loopback, private, link-local,
+// multicast and unspecified addresses could reach the server's own network, or its cloud metadata service.
+func IsPublicAddress(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// Returns the client deliveries are sent with. Unless private addresses are allowed, it refuses to connect to them,
+// checking the address actually dialed so that DNS answers changing after an endpoint was registered are covered too.
+// It doesn't follow redirects, which would lead it elsewhere than the registered URL.
+func newClient(allowPrivateAddresses func() bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			if allowPrivateAddresses() {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicAddress(ip) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be dialed instead of the endpoint, bypassing the check.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Checks that the URL can be registered as an endpoint: it must be an http or https URL and,
+// unless private addresses are allowed, its host must not be or resolve to a private address.
+// Hosts that can't be resolved yet are accepted, deliveries are checked again when they are sent.
+func (d *Dispatcher) CheckURL(ctx context.Context, rawURL string) error {
+	endpointURL, err := url.Parse(rawURL)
+	if err != nil || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") || endpointURL.Host == "" {
+		return errors.New("must be an http or https URL")
+	}
+	if d.AllowPrivateAddresses {
+		return nil
+	}
+
+	host := endpointURL.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !IsPublicAddress(ip) {
+			return ErrPrivateAddress
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, address := range addresses {
+		if !IsPublicAddress(address.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
@@ -0,0 +1,283 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+)
+
+// How many due deliveries are claimed at a time.
+const claimBatchSize = 50
+
+// Dispatcher queues the events for the endpoints subscribed to them, and delivers the queued events,
+// retrying failed attempts with exponential backoff.
+type Dispatcher struct {
+	Store  Store
+	Client *http.Client
+	Logger *slog.Logger
+	// Most attempts made for a delivery before it is marked as failed.
+	MaxAttempts int
+	// How long to wait before the first retry. The wait doubles after each attempt, up to the maximum.
+	RetryBase time.Duration
+	RetryMax  time.Duration
+	// How long an endpoint may take to respond to an attempt.
+	Timeout time.Duration
+	// How often the queue is checked for due deliveries.
+	PollInterval time.Duration
+	// Whether endpoints may be on the server's own networks, see IsPublicAddress. Only meant for development and tests.
+	AllowPrivateAddresses bool
+	// Returns the current time, read afresh for every claim and attempt. Replaced in tests.
+	Now func() time.Time
+}
+
+// Constructs a new Dispatcher with the default retry policy.
+func NewDispatcher(store Store) *Dispatcher {
+	d := &Dispatcher{
+		Store:        store,
+		Logger:       slog.Default(),
+		MaxAttempts:  8,
+		RetryBase:    30 * time.Second,
+		RetryMax:     6 * time.Hour,
+		Timeout:      10 * time.Second,
+		PollInterval: 5 * time.Second,
+		Now:          time.Now,
+	}
+	d.Client = newClient(func() bool { return d.AllowPrivateAddresses })
+	return d
+}
+
+// Queues the event for each endpoint of its user subscribed to its type, and returns the queued deliveries.
+func (d *Dispatcher) Enqueue(event events.Event) ([]Delivery, error) {
+	return d.EnqueueIn(d.Store, event)
+}
+
+// Queues the event like Enqueue, with the endpoints and deliveries kept in the store,
+// e.g. a copy of the dispatcher's store taking part in a transaction.
+func (d *Dispatcher) EnqueueIn(store Store, event events.Event) ([]Delivery, error) {
+	endpoints, err := store.ListEndpoints(event.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	now := d.Now()
+	deliveries := []Delivery{}
+	for _, endpoint := range endpoints {
+		if !endpoint.Subscribes(event.Type) {
+			continue
+		}
+		deliveries = append(deliveries, Delivery{
+			EndpointID:    endpoint.ID,
+			EventID:       event.ID,
+			EventType:     event.Type,
+			Payload:       payload,
+			Status:        StatusPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		})
+	}
+
+	if err := store.CreateDeliveries(deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// Queues the delivery to be sent again as a new delivery, which is attempted as soon as possible
+// with the full number of attempts.
+func (d *Dispatcher) Redeliver(delivery *Delivery) (*Delivery, error) {
+	now := d.Now()
+	redelivery := Delivery{
+		EndpointID:    delivery.EndpointID,
+		EventID:       delivery.EventID,
+		EventType:     delivery.EventType,
+		Payload:       delivery.Payload,
+		Status:        StatusPending,
+		NextAttemptAt: now,
+		RedeliveryOf:  &delivery.ID,
+		CreatedAt:     now,
+	}
+
+	deliveries := []Delivery{redelivery}
+	if err := d.Store.CreateDeliveries(deliveries); err != nil {
+		return nil, err
+	}
+	return &deliveries[0], nil
+}
+
+// Delivers the due deliveries until the context is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+				d.Logger.Error("failed to deliver webhooks", "error", err)
+			}
+		}
+	}
+}
+
+// Attempts the deliveries that are due, and returns how many were attempted.
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	attempted := 0
+	for ctx.Err() == nil {
+		// Lease the deliveries for longer than attempting them may take, even if they are all to the same endpoint,
+		// so that no other dispatcher claims them while they are still being attempted.
+		// Each batch is leased from when it is claimed, however long the previous ones took.
+		deliveries, err := d.Store.ClaimDue(d.Now(), time.Duration(claimBatchSize+1)*d.Timeout, claimBatchSize)
+		if err != nil {
+			return attempted, err
+		}
+		if len(deliveries) == 0 {
+			break
+		}
+
+		batchAttempted, err := d.attemptAll(ctx, deliveries)
+		attempted += batchAttempted
+		if err != nil {
+			return attempted, err
+		}
+	}
+	return attempted, nil
+}
+
+// Attempts the deliveries, those of each endpoint in order and the endpoints concurrently,
+// so that a slow endpoint only holds up its own deliveries. Returns how many were attempted, and the first error met.
+func (d *Dispatcher) attemptAll(ctx context.Context, deliveries []Delivery) (int, error) {
+	byEndpoint := make(map[int][]*Delivery)
+	for i := range deliveries {
+		byEndpoint[deliveries[i].EndpointID] = append(byEndpoint[deliveries[i].EndpointID], &deliveries[i])
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		attempted int
+		firstErr  error
+	)
+	for _, endpointDeliveries := range byEndpoint {
+		wg.Add(1)
+		go func(endpointDeliveries []*Delivery) {
+			defer wg.Done()
+			for _, delivery := range endpointDeliveries {
+				err := d.attempt(ctx, delivery)
+
+				mu.Lock()
+				if err == nil {
+					attempted++
+				} else if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+
+				if err != nil {
+					return
+				}
+			}
+		}(endpointDeliveries)
+	}
+	wg.Wait()
+	return attempted, firstErr
+}
+
+// Sends the delivery to its endpoint and records the outcome, retrying from when the attempt was made.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *Delivery) error {
+	endpoint, err := d.Store.GetEndpoint(delivery.EndpointID)
+	if err != nil {
+		return err
+	}
+	if endpoint == nil {
+		// The endpoint was deleted since the delivery was claimed, its deliveries with it.
+		return nil
+	}
+
+	now := d.Now()
+	status, err := d.send(ctx, endpoint, delivery)
+	if ctx.Err() != nil {
+		// Stopped mid-attempt, which doesn't count. The delivery is attempted again once its lease has passed.
+		return ctx.Err()
+	}
+
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = status
+	delivery.LastError = ""
+	switch {
+	case err == nil:
+		delivery.Status = StatusSucceeded
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = StatusFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.NextAttemptAt = now.Add(d.backoff(delivery.Attempts))
+		delivery.LastError = err.Error()
+	}
+
+	if err != nil {
+		d.Logger.Warn("webhook delivery attempt failed", "delivery_id", delivery.ID, "endpoint_id", endpoint.ID,
+			"attempts", delivery.Attempts, "status", delivery.Status, "error", err)
+	}
+	return d.Store.UpdateDelivery(delivery)
+}
+
+// Posts the signed payload to the endpoint, returning the response status, if any,
+// and an error unless the endpoint accepted the delivery with a 2xx response.
+// The response body isn't kept: it would let users read whatever their endpoint URL points at.
+func (d *Dispatcher) send(ctx context.Context, endpoint *Endpoint, delivery *Delivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	// Signed with the time it is sent at, which receivers compare with their clocks.
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "book-tracker-webhooks")
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, timestamp, delivery.Payload))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(EventIDHeader, delivery.EventID)
+	req.Header.Set(DeliveryIDHeader, strconv.Itoa(delivery.ID))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Returns how long to wait before retrying a delivery attempted the given number of times.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.RetryBase
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= d.RetryMax {
+			return d.RetryMax
+		}
+	}
+	return min(wait, d.RetryMax)
+}
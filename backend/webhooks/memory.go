@@ -0,0 +1,165 @@
+package webhooks
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the endpoints and deliveries in process memory.
+// It is suitable for a single server instance and for tests.
+type MemoryStore struct {
+	mu         sync.Mutex
+	endpoints  []Endpoint
+	deliveries []Delivery
+	// The IDs given to the last endpoint and delivery created.
+	lastEndpointID int
+	lastDeliveryID int
+}
+
+// Constructs a new, empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) CreateEndpoint(endpoint *Endpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastEndpointID++
+	endpoint.ID = s.lastEndpointID
+	s.endpoints = append(s.endpoints, *endpoint)
+	return nil
+}
+
+func (s *MemoryStore) ListEndpoints(userID int) ([]Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoints := []Endpoint{}
+	for _, endpoint := range s.endpoints {
+		if endpoint.UserID == userID {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	return endpoints, nil
+}
+
+func (s *MemoryStore) GetEndpoint(endpointID int) (*Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, endpoint := range s.endpoints {
+		if endpoint.ID == endpointID {
+			return &endpoint, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) DeleteEndpoint(endpointID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoints := s.endpoints[:0]
+	for _, endpoint := range s.endpoints {
+		if endpoint.ID != endpointID {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+	s.endpoints = endpoints
+
+	deliveries := s.deliveries[:0]
+	for _, delivery := range s.deliveries {
+		if delivery.EndpointID != endpointID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	s.deliveries = deliveries
+	return nil
+}
+
+func (s *MemoryStore) CreateDeliveries(deliveries []Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range deliveries {
+		s.lastDeliveryID++
+		deliveries[i].ID = s.lastDeliveryID
+		s.deliveries = append(s.deliveries, deliveries[i])
+	}
+	return nil
+}
+
+func (s *MemoryStore) ListDeliveries(endpointID int, offset int, limit int) ([]Delivery, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deliveries := []Delivery{}
+	for _, delivery := range s.deliveries {
+		if delivery.EndpointID == endpointID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool { return deliveries[i].ID > deliveries[j].ID })
+
+	// Apply pagination after counting the deliveries.
+	total := int64(len(deliveries))
+	if offset > len(deliveries) {
+		offset = len(deliveries)
+	}
+	deliveries = deliveries[offset:]
+	if limit > 0 && limit < len(deliveries) {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, total, nil
+}
+
+func (s *MemoryStore) GetDelivery(deliveryID int) (*Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, delivery := range s.deliveries {
+		if delivery.ID == deliveryID {
+			return &delivery, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) ClaimDue(now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []*Delivery{}
+	for i := range s.deliveries {
+		delivery := &s.deliveries[i]
+		if delivery.Status == StatusPending && !delivery.NextAttemptAt.After(now) {
+			due = append(due, delivery)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].NextAttemptAt.Before(due[j].NextAttemptAt) })
+	if limit > 0 && limit < len(due) {
+		due = due[:limit]
+	}
+
+	claimed := make([]Delivery, len(due))
+	for i, delivery := range due {
+		delivery.NextAttemptAt = now.Add(lease)
+		claimed[i] = *delivery
+	}
+	return claimed, nil
+}
+
+func (s *MemoryStore) UpdateDelivery(delivery *Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.deliveries {
+		if s.deliveries[i].ID == delivery.ID {
+			s.deliveries[i] = *delivery
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package webhooks
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"gorm.io/gorm"
+)
+
+// PostgresStore keeps the endpoints and deliveries in Postgres, so that pending deliveries survive restarts
+// and are attempted by whichever server instance claims them first.
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+// Constructs a new PostgresStore, migrating its tables if needed.
+func NewPostgresStore(db *gorm.DB) (*PostgresStore, error) {
+	// The endpoints of users purged before the foreign keys existed would keep them from being added.
+	if db.Migrator().HasTable(&Endpoint{}) {
+		orphans := db.Model(&Endpoint{}).Select("id").Where("user_id NOT IN (?)", db.Model(&types.User{}).Unscoped().Select("id"))
+		if err := db.Where("endpoint_id IN (?)", orphans).Delete(&Delivery{}).Error; err != nil {
+			return nil, err
+		}
+		if err := db.Where("id IN (?)", orphans).Delete(&Endpoint{}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// Migrate the webhook endpoints and deliveries tables to the database.
+	if err := db.AutoMigrate(&Endpoint{}, &Delivery{}); err != nil {
+		return nil, err
+	}
+	return &PostgresStore{db: db}, nil
+}
+
+func (s *PostgresStore) WithDB(db *gorm.DB) Store {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) CreateEndpoint(endpoint *Endpoint) error {
+	return s.db.Create(endpoint).Error
+}
+
+func (s *PostgresStore) ListEndpoints(userID int) ([]Endpoint, error) {
+	endpoints := []Endpoint{}
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+func (s *PostgresStore) GetEndpoint(endpointID int) (*Endpoint, error) {
+	var endpoint Endpoint
+	err := s.db.First(&endpoint, endpointID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+func (s *PostgresStore) DeleteEndpoint(endpointID int) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("endpoint_id = ?", endpointID).Delete(&Delivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Endpoint{}, endpointID).Error
+	})
+}
+
+func (s *PostgresStore) CreateDeliveries(deliveries []Delivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	// The rows are inserted by one statement.
+	return s.db.Create(&deliveries).Error
+}
+
+func (s *PostgresStore) ListDeliveries(endpointID int, offset int, limit int) ([]Delivery, int64, error) {
+	query := s.db.Model(&Delivery{}).Where("endpoint_id = ?", endpointID)
+
+	// Count the deliveries before applying pagination.
+	var total int64
+	if result := query.Count(&total); result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	deliveries := []Delivery{}
+	query = query.Order("id DESC").Offset(offset)
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	if result := query.Find(&deliveries); result.Error != nil {
+		return nil, 0, result.Error
+	}
+	return deliveries, total, nil
+}
+
+func (s *PostgresStore) GetDelivery(deliveryID int) (*Delivery, error) {
+	var delivery Delivery
+	err := s.db.First(&delivery, deliveryID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &delivery, nil
+}
+
+// Claims the deliveries by pushing their next attempt past the lease. Rows claimed by a concurrent dispatcher
+// are locked and skipped, so each delivery is claimed once.
+func (s *PostgresStore) ClaimDue(now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	err := s.db.Raw(`UPDATE webhook_deliveries SET next_attempt_at = ? WHERE id IN (
+		SELECT id FROM webhook_deliveries WHERE status = ? AND next_attempt_at <= ?
+		ORDER BY next_attempt_at LIMIT ? FOR UPDATE SKIP LOCKED
+	) RETURNING *`, now.Add(lease), StatusPending, now, limit).Scan(&deliveries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn't keep the order of the subquery.
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, nil
+}
+
+func (s *PostgresStore) UpdateDelivery(delivery *Delivery) error {
+	return s.db.Save(delivery).Error
+}
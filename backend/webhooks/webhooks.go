@@ -0,0 +1,168 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"gorm.io/gorm"
+)
+
+// The event types endpoints may subscribe to.
+var EventTypes = []string{
+	events.BookCreated,
+	events.BookUpdated,
+	events.BookProgressed,
+	events.BookFinished,
+	events.BookDeleted,
+	events.BookRestored,
+	events.UserUpdated,
+	events.UserDeleted,
+	events.UserRestored,
+}
+
+// Reports whether endpoints may subscribe to the event type.
+func IsEventType(eventType string) bool {
+	return slices.Contains(EventTypes, eventType)
+}
+
+// Endpoint is a URL a user registered to be sent the events of the subscribed types.
+type Endpoint struct {
+	ID     int    `gorm:"primaryKey" json:"id"`
+	UserID int    `gorm:"not null;index" json:"user_id"`
+	URL    string `gorm:"not null" json:"url"`
+	// Deliveries are signed with the secret. It is only shown when the endpoint is registered.
+	Secret     string    `gorm:"not null" json:"secret,omitempty"`
+	EventTypes []string  `gorm:"serializer:json;not null" json:"event_types"`
+	CreatedAt  time.Time `gorm:"not null" json:"created_at"`
+
+	// The endpoints and their deliveries are removed with the user when they are purged.
+	User       *types.User `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Deliveries []Delivery  `gorm:"foreignKey:EndpointID;references:ID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+func (Endpoint) TableName() string {
+	return "webhook_endpoints"
+}
+
+// Reports whether the endpoint is sent events of the type.
+func (e *Endpoint) Subscribes(eventType string) bool {
+	return slices.Contains(e.EventTypes, eventType)
+}
+
+// The states of a delivery.
+const (
+	// Waiting to be attempted, for the first time or again.
+	StatusPending = "pending"
+	// The endpoint accepted the delivery.
+	StatusSucceeded = "succeeded"
+	// Every attempt failed, the delivery is no longer retried.
+	StatusFailed = "failed"
+)
+
+// Delivery is an event sent, or to be sent, to an endpoint. Pending deliveries form the outbox.
+type Delivery struct {
+	ID         int    `gorm:"primaryKey" json:"id"`
+	EndpointID int    `gorm:"not null;index" json:"endpoint_id"`
+	EventID    string `gorm:"not null" json:"event_id"`
+	EventType  string `gorm:"not null" json:"event_type"`
+	// The request body, the event as JSON.
+	Payload json.RawMessage `gorm:"type:jsonb;not null" json:"payload"`
+	Status  string          `gorm:"not null;index:idx_webhook_deliveries_due,priority:1" json:"status"`
+	// How many times the delivery was attempted, and when it is attempted next while pending.
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt time.Time  `gorm:"not null;index:idx_webhook_deliveries_due,priority:2" json:"next_attempt_at"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	// The outcome of the last attempt: the endpoint's response status, if it responded, and why it failed.
+	ResponseStatus int    `gorm:"not null;default:0" json:"response_status,omitempty"`
+	LastError      string `json:"last_error,omitempty"`
+	// The delivery this one manually redelivers, if any.
+	RedeliveryOf *int      `json:"redelivery_of,omitempty"`
+	CreatedAt    time.Time `gorm:"not null" json:"created_at"`
+}
+
+func (Delivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// Store keeps the endpoints and their deliveries.
+type Store interface {
+	CreateEndpoint(endpoint *Endpoint) error
+	// Returns the user's endpoints, oldest first.
+	ListEndpoints(userID int) ([]Endpoint, error)
+	// Returns the endpoint, or nil if there is none with the ID.
+	GetEndpoint(endpointID int) (*Endpoint, error)
+	// Deletes the endpoint and its deliveries.
+	DeleteEndpoint(endpointID int) error
+
+	// Saves the deliveries, all or none of them.
+	CreateDeliveries(deliveries []Delivery) error
+	// Returns the endpoint's deliveries, newest first, and the total number of them.
+	ListDeliveries(endpointID int, offset int, limit int) ([]Delivery, int64, error)
+	// Returns the delivery, or nil if there is none with the ID.
+	GetDelivery(deliveryID int) (*Delivery, error)
+	// Claims up to limit pending deliveries due at the given time, oldest first, and returns them.
+	// Claimed deliveries are not due again until the lease has passed, so that they are claimed by one dispatcher
+	// at a time, and retried if that dispatcher stops before recording the outcome.
+	ClaimDue(now time.Time, lease time.Duration, limit int) ([]Delivery, error)
+	// Records the outcome of an attempt.
+	UpdateDelivery(delivery *Delivery) error
+}
+
+// DatabaseStore is implemented by stores that keep the endpoints and deliveries in a gorm database.
+type DatabaseStore interface {
+	// Returns a copy of the store that runs its queries with the handle, e.g. that of a transaction.
+	WithDB(db *gorm.DB) Store
+}
+
+// The headers deliveries are sent with.
+const (
+	SignatureHeader  = "X-Webhook-Signature"
+	TimestampHeader  = "X-Webhook-Timestamp"
+	EventHeader      = "X-Webhook-Event"
+	EventIDHeader    = "X-Webhook-Event-ID"
+	DeliveryIDHeader = "X-Webhook-Delivery"
+)
+
+// Returns a new random endpoint secret.
+func NewSecret() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(buffer), nil
+}
+
+// Returns the signature of a delivery's body sent at the timestamp, in Unix seconds.
+// The timestamp is signed too, so that receivers can reject old deliveries being replayed.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verifies the signature of a delivery received at the given time, for receivers.
+// Deliveries signed more than the tolerance before or after then are rejected.
+func Verify(secret string, header http.Header, body []byte, now time.Time, tolerance time.Duration) error {
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid webhook timestamp")
+	}
+	if skew := now.Sub(time.Unix(timestamp, 0)); skew > tolerance || skew < -tolerance {
+		return errors.New("webhook timestamp outside of the tolerance")
+	}
+	if !hmac.Equal([]byte(header.Get(SignatureHeader)), []byte(Sign(secret, timestamp, body))) {
+		return errors.New("invalid webhook signature")
+	}
+	return nil
+}
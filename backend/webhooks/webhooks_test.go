@@ -0,0 +1,428 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/declanl482/go-book-tracker-app/backend/events"
+	"github.com/declanl482/go-book-tracker-app/backend/types"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm/schema"
+)
+
+// A request received by a receiver.
+type received struct {
+	Header http.Header
+	Body   []byte
+}
+
+// Receives deliveries, responding to each with the next status, or 200 once they run out.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []received
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, received{Header: req.Header, Body: body})
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	w.WriteHeader(status)
+	io.WriteString(w, "nope")
+}
+
+// Responds to the next deliveries with the statuses.
+func (r *receiver) respondWith(statuses ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses = statuses
+}
+
+func (r *receiver) received() []received {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]received(nil), r.requests...)
+}
+
+func TestSignature(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"id":"1"}`)
+	header := http.Header{}
+	header.Set(TimestampHeader, "1700000000")
+	header.Set(SignatureHeader, Sign("secret", 1700000000, body))
+
+	sent := time.Unix(1700000000, 0)
+	assert.NoError(t, Verify("secret", header, body, sent.Add(time.Minute), 5*time.Minute))
+
+	// Deliveries with another secret, body or timestamp, or sent too long ago, are rejected.
+	assert.Error(t, Verify("other", header, body, sent, 5*time.Minute))
+	assert.Error(t, Verify("secret", header, []byte(`{"id":"2"}`), sent, 5*time.Minute))
+	assert.Error(t, Verify("secret", header, body, now, 5*time.Minute))
+	header.Set(TimestampHeader, "1700000001")
+	assert.Error(t, Verify("secret", header, body, sent, 5*time.Minute))
+}
+
+func TestDispatcher(t *testing.T) {
+	hooks := &receiver{}
+	httpServer := httptest.NewServer(hooks)
+	defer httpServer.Close()
+
+	store := NewMemoryStore()
+	dispatcher := NewDispatcher(store)
+	dispatcher.AllowPrivateAddresses = true
+	dispatcher.MaxAttempts = 3
+	dispatcher.RetryBase = time.Minute
+	dispatcher.RetryMax = 90 * time.Second
+
+	endpoint := Endpoint{UserID: 1, URL: httpServer.URL, Secret: "secret", EventTypes: []string{events.BookCreated, events.BookFinished}}
+	assert.NoError(t, store.CreateEndpoint(&endpoint))
+	other := Endpoint{UserID: 2, URL: httpServer.URL, Secret: "other", EventTypes: []string{events.BookCreated}}
+	assert.NoError(t, store.CreateEndpoint(&other))
+
+	// Delivers what is due at the given time, as if it were now.
+	deliverDue := func(now time.Time) (int, error) {
+		dispatcher.Now = func() time.Time { return now }
+		defer func() { dispatcher.Now = time.Now }()
+		return dispatcher.DeliverDue(context.Background())
+	}
+
+	enqueue := func(eventType string) (events.Event, []Delivery) {
+		event, err := events.New(eventType, 1, map[string]int{"id": 1})
+		assert.NoError(t, err)
+		deliveries, err := dispatcher.Enqueue(event)
+		assert.NoError(t, err)
+		return event, deliveries
+	}
+
+	// Only the user's endpoints subscribed to the type are queued a delivery.
+	_, deliveries := enqueue(events.BookUpdated)
+	assert.Empty(t, deliveries)
+	event, deliveries := enqueue(events.BookCreated)
+	assert.Len(t, deliveries, 1)
+
+	t.Run("TestDelivery", func(t *testing.T) {
+		now := time.Now()
+		attempted, err := deliverDue(now)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, attempted)
+
+		// The event is posted, signed with the endpoint's secret.
+		requests := hooks.received()
+		assert.Len(t, requests, 1)
+		request := requests[0]
+		assert.NoError(t, Verify("secret", request.Header, request.Body, time.Now(), time.Minute))
+		assert.Equal(t, events.BookCreated, request.Header.Get(EventHeader))
+		assert.Equal(t, event.ID, request.Header.Get(EventIDHeader))
+		var payload events.Event
+		assert.NoError(t, json.Unmarshal(request.Body, &payload))
+		assert.Equal(t, event.ID, payload.ID)
+		assert.JSONEq(t, `{"id": 1}`, string(payload.Data))
+
+		delivery, err := store.GetDelivery(deliveries[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, StatusSucceeded, delivery.Status)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.Equal(t, http.StatusOK, delivery.ResponseStatus)
+
+		// Nothing is left to deliver.
+		attempted, err = deliverDue(now.Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Zero(t, attempted)
+	})
+
+	t.Run("TestRetry", func(t *testing.T) {
+		hooks.respondWith(http.StatusInternalServerError, http.StatusServiceUnavailable)
+		_, deliveries := enqueue(events.BookFinished)
+		now := time.Now()
+
+		// Failed attempts are retried after a wait doubling each time, up to the maximum.
+		_, err := deliverDue(now)
+		assert.NoError(t, err)
+		delivery, _ := store.GetDelivery(deliveries[0].ID)
+		assert.Equal(t, StatusPending, delivery.Status)
+		assert.Equal(t, http.StatusInternalServerError, delivery.ResponseStatus)
+		// What the endpoint responded isn't kept, only its status.
+		assert.Equal(t, "endpoint responded with 500 Internal Server Error", delivery.LastError)
+		assert.Equal(t, now.Add(time.Minute), delivery.NextAttemptAt)
+
+		attempted, err := deliverDue(now.Add(30 * time.Second))
+		assert.NoError(t, err)
+		assert.Zero(t, attempted)
+
+		now = now.Add(time.Minute)
+		_, err = deliverDue(now)
+		assert.NoError(t, err)
+		delivery, _ = store.GetDelivery(deliveries[0].ID)
+		assert.Equal(t, 2, delivery.Attempts)
+		assert.Equal(t, now.Add(90*time.Second), delivery.NextAttemptAt)
+
+		now = now.Add(90 * time.Second)
+		_, err = deliverDue(now)
+		assert.NoError(t, err)
+		delivery, _ = store.GetDelivery(deliveries[0].ID)
+		assert.Equal(t, StatusSucceeded, delivery.Status)
+		assert.Equal(t, 3, delivery.Attempts)
+		assert.Empty(t, delivery.LastError)
+	})
+
+	t.Run("TestFailure", func(t *testing.T) {
+		hooks.respondWith(http.StatusBadRequest, http.StatusBadRequest, http.StatusBadRequest)
+		_, deliveries := enqueue(events.BookCreated)
+		now := time.Now()
+
+		// Deliveries are given up on after the last attempt.
+		for i := 0; i < dispatcher.MaxAttempts; i++ {
+			now = now.Add(time.Hour)
+			_, err := deliverDue(now)
+			assert.NoError(t, err)
+		}
+		delivery, _ := store.GetDelivery(deliveries[0].ID)
+		assert.Equal(t, StatusFailed, delivery.Status)
+		assert.Equal(t, 3, delivery.Attempts)
+
+		// Redelivering queues a new delivery of the same event, with the full number of attempts.
+		redelivery, err := dispatcher.Redeliver(delivery)
+		assert.NoError(t, err)
+		assert.Equal(t, &delivery.ID, redelivery.RedeliveryOf)
+		assert.Equal(t, delivery.EventID, redelivery.EventID)
+		assert.Equal(t, StatusPending, redelivery.Status)
+
+		_, err = deliverDue(time.Now())
+		assert.NoError(t, err)
+		redelivery, _ = store.GetDelivery(redelivery.ID)
+		assert.Equal(t, StatusSucceeded, redelivery.Status)
+
+		logged, total, err := store.ListDeliveries(endpoint.ID, 0, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), total)
+		assert.Equal(t, []int{redelivery.ID, delivery.ID}, []int{logged[0].ID, logged[1].ID})
+	})
+
+	t.Run("TestDeleteEndpoint", func(t *testing.T) {
+		// Deleting an endpoint drops its deliveries.
+		enqueue(events.BookCreated)
+		assert.NoError(t, store.DeleteEndpoint(endpoint.ID))
+
+		attempted, err := deliverDue(time.Now())
+		assert.NoError(t, err)
+		assert.Zero(t, attempted)
+		_, total, err := store.ListDeliveries(endpoint.ID, 0, 0)
+		assert.NoError(t, err)
+		assert.Zero(t, total)
+	})
+}
+
+func TestMemoryStoreClaimDue(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Date(2023, 7, 1, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, store.CreateDeliveries([]Delivery{
+		{EndpointID: 1, Status: StatusPending, NextAttemptAt: now.Add(time.Second)},
+		{EndpointID: 1, Status: StatusPending, NextAttemptAt: now},
+		{EndpointID: 1, Status: StatusSucceeded, NextAttemptAt: now},
+	}))
+
+	// Claimed deliveries aren't claimed again until their lease has passed.
+	claimed, err := store.ClaimDue(now.Add(time.Second), time.Minute, 1)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 1)
+	assert.Equal(t, 2, claimed[0].ID)
+
+	claimed, err = store.ClaimDue(now.Add(time.Second), time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 1)
+	assert.Equal(t, 1, claimed[0].ID)
+
+	claimed, err = store.ClaimDue(now.Add(time.Second), time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+
+	claimed, err = store.ClaimDue(now.Add(2*time.Minute), time.Minute, 10)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
+}
+
+func TestPrivateAddresses(t *testing.T) {
+	hooks := &receiver{}
+	httpServer := httptest.NewServer(hooks)
+	defer httpServer.Close()
+
+	redirecting := httptest.NewServer(http.RedirectHandler(httpServer.URL, http.StatusFound))
+	defer redirecting.Close()
+
+	store := NewMemoryStore()
+	dispatcher := NewDispatcher(store)
+	dispatcher.MaxAttempts = 1
+
+	// Delivers an event to an endpoint with the URL, and returns the delivery once attempted.
+	deliver := func(url string) *Delivery {
+		endpoint := Endpoint{UserID: 1, URL: url, Secret: "secret", EventTypes: []string{events.BookCreated}}
+		assert.NoError(t, store.CreateEndpoint(&endpoint))
+		defer store.DeleteEndpoint(endpoint.ID)
+
+		event, err := events.New(events.BookCreated, 1, map[string]int{"id": 1})
+		assert.NoError(t, err)
+		deliveries, err := dispatcher.Enqueue(event)
+		assert.NoError(t, err)
+		_, err = dispatcher.DeliverDue(context.Background())
+		assert.NoError(t, err)
+		delivery, _ := store.GetDelivery(deliveries[0].ID)
+		return delivery
+	}
+
+	for _, url := range []string{"http://127.0.0.1", "http://localhost:5432", "http://10.0.0.1/", "http://169.254.169.254/latest/meta-data", "http://[::1]/", "http://0.0.0.0"} {
+		assert.ErrorIs(t, dispatcher.CheckURL(context.Background(), url), ErrPrivateAddress, url)
+	}
+	assert.NoError(t, dispatcher.CheckURL(context.Background(), "https://93.184.216.34/hook"))
+	assert.Error(t, dispatcher.CheckURL(context.Background(), "ftp://example.com"))
+
+	// Endpoints registered before their host resolved to a private address aren't connected to.
+	delivery := deliver(httpServer.URL)
+	assert.Equal(t, StatusFailed, delivery.Status)
+	assert.Contains(t, delivery.LastError, ErrPrivateAddress.Error())
+	assert.Empty(t, hooks.received())
+
+	// Redirects aren't followed.
+	dispatcher.AllowPrivateAddresses = true
+	delivery = deliver(redirecting.URL)
+	assert.Equal(t, StatusFailed, delivery.Status)
+	assert.Equal(t, http.StatusFound, delivery.ResponseStatus)
+	assert.Empty(t, hooks.received())
+}
+
+// Records when deliveries are claimed, and the lease they are claimed for.
+type leaseRecordingStore struct {
+	*MemoryStore
+	lease     time.Duration
+	claimedAt []time.Time
+}
+
+func (s *leaseRecordingStore) ClaimDue(now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	s.lease = lease
+	s.claimedAt = append(s.claimedAt, now)
+	return s.MemoryStore.ClaimDue(now, lease, limit)
+}
+
+func TestSlowEndpoint(t *testing.T) {
+	fast := &receiver{}
+	fastServer := httptest.NewServer(fast)
+	defer fastServer.Close()
+
+	// The slow endpoint only responds once the fast one has received its delivery.
+	fastReceived := make(chan struct{})
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-fastReceived:
+		case <-r.Context().Done():
+		}
+	}))
+	defer slowServer.Close()
+	fastServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fast.ServeHTTP(w, r)
+		close(fastReceived)
+	})
+
+	store := &leaseRecordingStore{MemoryStore: NewMemoryStore()}
+	dispatcher := NewDispatcher(store)
+	dispatcher.AllowPrivateAddresses = true
+	dispatcher.MaxAttempts = 1
+	dispatcher.Timeout = 2 * time.Second
+
+	var deliveries []Delivery
+	for userID, url := range []string{slowServer.URL, fastServer.URL} {
+		endpoint := Endpoint{UserID: userID, URL: url, Secret: "secret", EventTypes: []string{events.BookCreated}}
+		assert.NoError(t, store.CreateEndpoint(&endpoint))
+		event, err := events.New(events.BookCreated, userID, map[string]int{"id": 1})
+		assert.NoError(t, err)
+		queued, err := dispatcher.Enqueue(event)
+		assert.NoError(t, err)
+		deliveries = append(deliveries, queued...)
+	}
+
+	attempted, err := dispatcher.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempted)
+
+	// Both deliveries succeed: the slow endpoint didn't hold up the fast one until it timed out.
+	for _, queued := range deliveries {
+		delivery, err := store.GetDelivery(queued.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, StatusSucceeded, delivery.Status)
+	}
+
+	// Deliveries are leased for long enough to attempt a whole batch to the same endpoint.
+	assert.Greater(t, store.lease, claimBatchSize*dispatcher.Timeout)
+}
+
+func TestDeliveryTimes(t *testing.T) {
+	hooks := &receiver{}
+	hooks.respondWith(http.StatusServiceUnavailable)
+	httpServer := httptest.NewServer(hooks)
+	defer httpServer.Close()
+
+	store := &leaseRecordingStore{MemoryStore: NewMemoryStore()}
+	dispatcher := NewDispatcher(store)
+	dispatcher.AllowPrivateAddresses = true
+
+	endpoint := Endpoint{UserID: 1, URL: httpServer.URL, Secret: "secret", EventTypes: []string{events.BookCreated}}
+	assert.NoError(t, store.CreateEndpoint(&endpoint))
+	event, err := events.New(events.BookCreated, 1, map[string]int{"id": 1})
+	assert.NoError(t, err)
+	deliveries, err := dispatcher.Enqueue(event)
+	assert.NoError(t, err)
+
+	// A clock a second further on each time it is read.
+	clock := time.Now()
+	dispatcher.Now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	attempted, err := dispatcher.DeliverDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, attempted)
+
+	// Every batch is claimed at the time it is claimed, so that its lease runs from then.
+	assert.Len(t, store.claimedAt, 2)
+	assert.True(t, store.claimedAt[1].After(store.claimedAt[0]))
+
+	// The attempt is recorded, and retried, from when it was made.
+	delivery, err := store.GetDelivery(deliveries[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, store.claimedAt[0].Add(time.Second), *delivery.LastAttemptAt)
+	assert.Equal(t, delivery.LastAttemptAt.Add(dispatcher.RetryBase), delivery.NextAttemptAt)
+}
+
+func TestCascadingDeletes(t *testing.T) {
+	// Parse the tables as they are migrated, the users' by the storage first.
+	cache := &sync.Map{}
+	_, err := schema.Parse(&types.User{}, cache, schema.NamingStrategy{})
+	assert.NoError(t, err)
+	endpoints, err := schema.Parse(&Endpoint{}, cache, schema.NamingStrategy{})
+	assert.NoError(t, err)
+	deliveries, err := schema.Parse(&Delivery{}, cache, schema.NamingStrategy{})
+	assert.NoError(t, err)
+
+	// Purging a user removes their endpoints, whose secrets would otherwise outlive them.
+	constraint := endpoints.Relationships.Relations["User"].ParseConstraint()
+	assert.Same(t, endpoints, constraint.Schema)
+	assert.Equal(t, "users", constraint.ReferenceSchema.Table)
+	assert.Equal(t, "CASCADE", constraint.OnDelete)
+
+	// Removing an endpoint removes its deliveries.
+	constraint = endpoints.Relationships.Relations["Deliveries"].ParseConstraint()
+	assert.Same(t, deliveries, constraint.Schema)
+	assert.Equal(t, "webhook_endpoints", constraint.ReferenceSchema.Table)
+	assert.Equal(t, "CASCADE", constraint.OnDelete)
+}